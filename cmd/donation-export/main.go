@@ -0,0 +1,92 @@
+// Command donation-export writes the prime, token and other-method donations matching the filters
+// into a CSV or XLSX file for the finance, instead of querying the production database by hand.
+//
+// The values of the filters are separated by commas, and the dates are counted in Taipei:
+//
+//	go run ./cmd/donation-export -from 2025-01-01 -to 2025-01-31 -status paid,refunded -format xlsx -mask
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/donationexport"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+func main() {
+	filters := map[string]*string{
+		"from":         flag.String("from", "", "first date of the donations in YYYY-MM-DD"),
+		"to":           flag.String("to", "", "last date of the donations in YYYY-MM-DD"),
+		"type":         flag.String("type", "", "donation types, prime, token or others"),
+		"status":       flag.String("status", "", "statuses, paying, paid, fail or refunded"),
+		"pay_method":   flag.String("pay-method", "", "pay methods, e.g. credit_card,line"),
+		"merchant_id":  flag.String("merchant", "", "merchant ids"),
+		"campaign_id":  flag.String("campaign-id", "", "id of the donation campaign"),
+		"send_receipt": flag.String("send-receipt", "", "receipt types, e.g. paperback_receipt_by_year"),
+	}
+	format := flag.String("format", donationexport.FormatCSV, "format of the file, csv or xlsx")
+	mask := flag.Bool("mask", false, "mask the personal data of the cardholders and the receipts")
+	out := flag.String("out", "", "path of the file (default donations-<today>.<csv|xlsx>)")
+	flag.Parse()
+
+	values := url.Values{}
+	for param, v := range filters {
+		if *v != "" {
+			values.Set(param, *v)
+		}
+	}
+
+	if err := run(values, *format, *mask, *out); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+func run(values url.Values, format string, mask bool, out string) error {
+	if !donationexport.IsValidFormat(format) {
+		return errors.New(fmt.Sprintf("format(%s) not supported", format))
+	}
+	filter, err := donationexport.ParseFilter(values)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		out = fmt.Sprintf("donations-%s.%s", time.Now().Format("20060102"), format)
+	}
+
+	if globals.Conf, err = configs.LoadConf(""); err != nil {
+		return errors.Wrap(err, "Fatal error config file")
+	}
+
+	db, err := utils.InitDB(10, 5)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	count, err := donationexport.Write(f, storage.NewGormStorage(db), filter, format, mask)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Infof("%d donations are written into %s", count, out)
+	return nil
+}
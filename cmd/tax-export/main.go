@@ -0,0 +1,92 @@
+// Command tax-export writes the upload file of the donations in a calendar year
+// for the automatic tax deduction, along with the report of the excluded donations.
+//
+// It is run by the staff every January for the donations of the last year:
+//
+//	go run ./cmd/tax-export -year 2025 -format fixed
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/taxexport"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+func main() {
+	year := flag.Int("year", time.Now().Year()-1, "calendar year of the donations")
+	format := flag.String("format", taxexport.FormatFixedWidth, "format of the upload file, fixed or csv")
+	out := flag.String("out", "", "path of the upload file (default tax-deductions-<year>.<txt|csv>)")
+	report := flag.String("report", "", "path of the report of the excluded donations (default tax-deductions-<year>-excluded.csv)")
+	flag.Parse()
+
+	if err := run(*year, *format, *out, *report); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+func run(year int, format, out, report string) error {
+	if !taxexport.IsValidFormat(format) {
+		return errors.New(fmt.Sprintf("format(%s) not supported", format))
+	}
+	if out == "" {
+		out = fmt.Sprintf("tax-deductions-%d.%s", year, taxexport.FileExtension(format))
+	}
+	if report == "" {
+		report = fmt.Sprintf("tax-deductions-%d-excluded.csv", year)
+	}
+
+	var err error
+	if globals.Conf, err = configs.LoadConf(""); err != nil {
+		return errors.Wrap(err, "Fatal error config file")
+	}
+
+	db, err := utils.InitDB(10, 5)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r, err := taxexport.Build(storage.NewGormStorage(db), year)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(out, func(f *os.File) error {
+		return taxexport.WriteUploadFile(f, format, globals.Conf.Receipt.IssuerTaxID, r)
+	}); err != nil {
+		return err
+	}
+
+	if err = writeFile(report, func(f *os.File) error {
+		return taxexport.WriteReport(f, r)
+	}); err != nil {
+		return err
+	}
+
+	log.Infof("%d donors are written into %s, %d donations are excluded as reported in %s", len(r.Donors), out, len(r.Exclusions), report)
+	return nil
+}
+
+func writeFile(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return errors.WithStack(f.Close())
+}
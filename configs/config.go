@@ -24,6 +24,12 @@ app:
     domain: localhost
     jwt_secret: secret_token
     jwt_expiration: 604800
+    jwt_access_expiration: 1209600 # access token expiration (sec)
+    jwt_refresh_expiration: 2592000 # refresh token expiration (sec)
+    jwt_signing_method: HS256 # HS256, RS256 or ES256. switch in a deploy after the release supporting them, since the replicas of the last release only verify HS256
+    jwt_signing_keys: [] # 'kid:path/to/key.pem' for RS256/ES256. the first key signs, the others only verify the tokens issued before rotation
+    jwt_accept_hs256: false # accept the tokens signed by jwt_secret after switching to RS256/ES256
+    session_keys: [] # 'base64(authentication key):base64(encryption key)' for the oauth session cookie. the first pair encodes, the others only decode the sessions issued before rotation
     jwt_issuer: 'http://testtest.twreporter.org:8080' # used for issuer claim
     jwt_audience: 'http://testtest.twreporter.org:8080' # used for audience claim
 email:
@@ -59,11 +65,48 @@ oauth:
     google:
         id: "" # provide your own ID
         secret: "" # provide your own secret
+    oidc: # OpenID Connect providers, the provider is enabled once id is provided
+        apple:
+            type: Apple # stored in o_auth_accounts.type
+            issuer: 'https://appleid.apple.com'
+            id: "" # services ID
+            secret: "" # leave it empty to generate the client secret by the private key
+            scopes: [name, email]
+            response_mode: form_post
+            team_id: ""
+            key_id: ""
+            private_key_path: ""
+        line:
+            type: LINE
+            issuer: 'https://access.line.me'
+            id: "" # channel ID
+            secret: "" # channel secret
+            scopes: [openid, profile, email]
+            response_mode: ""
 donation:
     card_secret_key: test_card_secret_key
     tappay_url: 'https://sandbox.tappaysdk.com/tpc/payment/pay-by-prime'
     tappay_partner_key: 'partner_6ID1DoDlaPrfHw6HBZsULfTYtDmWs0q0ZZGKMBpp4YICWBxgK97eK3RM'
     tappay_record_url: 'https://sandbox.tappaysdk.com/tpc/transaction/query'
+    tappay_token_url: 'https://sandbox.tappaysdk.com/tpc/payment/pay-by-token'
+    tappay_bind_card_url: 'https://sandbox.tappaysdk.com/tpc/card/bind'
+    tappay_refund_url: 'https://sandbox.tappaysdk.com/tpc/transaction/refund'
+    idempotency_key_ttl: 24h # duration the response of the request sent with the Idempotency-Key header is kept
+    tappay_notify_allowed_ips: [] # IPs or CIDRs of tap pay allowed to send the line pay and offline payment notifications, required except in development where any IP is allowed if empty
+    tappay_notify_trusted_proxies: [] # IPs or CIDRs of the proxies in front of the server, whose X-Forwarded-For is taken as the IP sending the notifications
+    offline_payment_ttl: 72h # duration the virtual account or the payment code of convenience stores could be paid
+    campaign_progress_cache_ttl: 1m # duration the raised total and the donor count of a campaign are cached
+    currencies: # currencies accepted for the donations, keyed by ISO 4217 code
+        TWD:
+            twd_rate: 1 # TWD equivalent of one unit of the currency, stored with the donation for receipts and reporting
+            min_amount: 1
+            max_amount: 0 # no upper limit if 0
+            merchant_ids: {} # merchant IDs keyed by the pay method, the default merchants are used for credit_card and line if not provided, while atm and cvs are only accepted with the merchants
+        # USD:
+        #     twd_rate: 31.5
+        #     min_amount: 1
+        #     max_amount: 30000
+        #     merchant_ids: {credit_card: ""} # only the pay methods with the merchants are accepted
     line_pay_product_image_url: 'https://www.twreporter.org/images/linepay-logo-84x84.png'
     frontend_host: 'test.twreporter.org'
 algolia:
@@ -91,6 +134,58 @@ membercms:
 pubsub:
     project_id: "" # Google Cloud Pub/Sub project ID
     topic_name: "role-update" # Topic name (can be overridden by environment variables)
+ratelimit:
+    store: memory # memory or mysql
+    otp_signin_per_email:
+        limit: 5
+        window: 15m
+    otp_signin_per_ip:
+        limit: 30
+        window: 15m
+    otp_activate_per_ip:
+        limit: 30
+        window: 15m
+    otp_max_failed_attempts: 5 # lock the account after these failed otp attempts
+    otp_lockout_duration: 30m
+    account_deletion_per_email: # mails confirming the account deletion
+        limit: 5
+        window: 1h
+    email_link_per_email: # mails verifying the email linked to the account
+        limit: 5
+        window: 1h
+account_deletion:
+    confirm_expiration: 24h # expiration of the confirmation link in the email
+    grace_period: 336h # the account is erased after 14 days once the deletion is confirmed
+    purge_interval: 1h # interval to erase the accounts passing the grace period, the purger is disabled if it is not positive
+    confirm_url: 'http://localhost:3000/account/deletion/confirm' # accounts site page confirming the deletion
+data_export:
+    expiration: 168h # the archive could be downloaded within 7 days
+    processing_timeout: 30m # the unfinished export could be requested again after the timeout
+    purge_interval: 1h # interval to clear the expired archives, the purger is disabled if it is not positive
+    download_url: 'http://localhost:3000/account/data-export' # accounts site page downloading the archive
+periodic_charge:
+    interval: 0s # interval to charge the due periodic donations, the charger is disabled if it is not positive
+    batch_size: 100 # max number of periodic donations charged in a run
+    retry_backoff: 24h # the failed charge is retried after the backoff, doubled on every failure
+    max_failures: 3 # the periodic donation is stopped after these consecutive failures
+card_expiry_reminder:
+    interval: 24h # interval to remind the donors whose cards expire next month, the reminder is disabled if it is not positive
+    batch_size: 100 # number of periodic donations queried at a time
+token_purge:
+    interval: 1h # interval to delete the expired refresh tokens and revoked access tokens, the purger is disabled if it is not positive
+idempotency_key_purge:
+    interval: 1h # interval to delete the expired idempotency keys along with the recorded responses, the purger is disabled if it is not positive
+donation_reconciler:
+    interval: 10m # interval to reconcile the donations stuck in paying, the reconciler is disabled if it is not positive
+    stale_after: 30m # the donations paying longer than this are reconciled against the record api of tap pay
+    batch_size: 100 # max number of prime and token donations reconciled in a run respectively
+receipt:
+    font_path: "" # TrueType font with the CJK glyphs to render the receipts if member cms is disabled or unavailable, e.g. TW-Kai
+    issuer_name: '財團法人報導者文化基金會'
+    issuer_tax_id: "" # unified business number of the foundation printed on the receipts
+    series: # prefix of the receipt numbers by donation type, up to 3 characters
+        prime: A
+        token: B
 `)
 
 type ConfYaml struct {
@@ -107,6 +202,16 @@ type ConfYaml struct {
 	Features    FeaturesConfig  `yaml:"features"`
 	MemberCMS   MemberCMSConfig `yaml:"memberCMS"`
 	PubSub      PubSubConfig    `yaml:"pubsub"`
+	RateLimit   RateLimitConfig `yaml:"ratelimit"`
+
+	AccountDeletion     AccountDeletionConfig     `yaml:"account_deletion"`
+	DataExport          DataExportConfig          `yaml:"data_export"`
+	PeriodicCharge      PeriodicChargeConfig      `yaml:"periodic_charge"`
+	CardExpiryReminder  CardExpiryReminderConfig  `yaml:"card_expiry_reminder"`
+	TokenPurge          TokenPurgeConfig          `yaml:"token_purge"`
+	IdempotencyKeyPurge IdempotencyKeyPurgeConfig `yaml:"idempotency_key_purge"`
+	DonationReconciler  DonationReconcilerConfig  `yaml:"donation_reconciler"`
+	Receipt             ReceiptConfig             `yaml:"receipt"`
 }
 
 type CorsConfig struct {
@@ -114,14 +219,20 @@ type CorsConfig struct {
 }
 
 type AppConfig struct {
-	Protocol      string `yaml:"protocol"`
-	Host          string `yaml:"host"`
-	Port          string `yaml:"port"`
-	Domain        string `yaml:"domain"`
-	JwtSecret     string `yaml:"jwt_secret"`
-	JwtExpiration int    `yaml:"jwt_expiration"`
-	JwtIssuer     string `yaml:"jwt_issuer"`
-	JwtAudience   string `yaml:"jwt_audience"`
+	Protocol             string   `yaml:"protocol"`
+	Host                 string   `yaml:"host"`
+	Port                 string   `yaml:"port"`
+	Domain               string   `yaml:"domain"`
+	JwtSecret            string   `yaml:"jwt_secret"`
+	JwtExpiration        int      `yaml:"jwt_expiration"`
+	JwtAccessExpiration  int      `yaml:"jwt_access_expiration"`
+	JwtRefreshExpiration int      `yaml:"jwt_refresh_expiration"`
+	JwtSigningMethod     string   `yaml:"jwt_signing_method"`
+	JwtSigningKeys       []string `yaml:"jwt_signing_keys"`
+	JwtAcceptHS256       bool     `yaml:"jwt_accept_hs256"`
+	SessionKeys          []string `yaml:"session_keys"`
+	JwtIssuer            string   `yaml:"jwt_issuer"`
+	JwtAudience          string   `yaml:"jwt_audience"`
 }
 
 type EmailConfig struct {
@@ -167,8 +278,23 @@ type MongoConfig struct {
 }
 
 type OauthConfig struct {
-	Facebook FacebookConfig `yaml:"facebook"`
-	Google   GoogleConfig   `yaml:"google"`
+	Facebook FacebookConfig        `yaml:"facebook"`
+	Google   GoogleConfig          `yaml:"google"`
+	OIDC     map[string]OIDCConfig `yaml:"oidc"`
+}
+
+// OIDCConfig is the config of an OpenID Connect provider
+type OIDCConfig struct {
+	Type         string   `yaml:"type"`
+	Issuer       string   `yaml:"issuer"`
+	ID           string   `yaml:"id"`
+	Secret       string   `yaml:"secret"`
+	Scopes       []string `yaml:"scopes"`
+	ResponseMode string   `yaml:"response_mode"`
+	// used by Sign in with Apple to generate the client secret
+	TeamID         string `yaml:"team_id"`
+	KeyID          string `yaml:"key_id"`
+	PrivateKeyPath string `yaml:"private_key_path"`
 }
 
 type FacebookConfig struct {
@@ -187,8 +313,27 @@ type DonationConfig struct {
 	TapPayPartnerKey       string `yaml:"tappay_partner_key"`
 	ProxyServer            string `yaml:"proxy_server"`
 	TapPayRecordURL        string `yaml:"tappay_record_url"`
+	TapPayTokenURL         string `yaml:"tappay_token_url"`
+	TapPayBindCardURL      string `yaml:"tappay_bind_card_url"`
+	TapPayRefundURL        string `yaml:"tappay_refund_url"`
 	LinePayProductImageUrl string `yaml:"line_pay_product_image_url"`
 	FrontendHost           string `yaml:"frontend_host"`
+
+	IdempotencyKeyTTL          time.Duration `yaml:"idempotency_key_ttl"`
+	TapPayNotifyAllowedIPs     []string      `yaml:"tappay_notify_allowed_ips"`
+	TapPayNotifyTrustedProxies []string      `yaml:"tappay_notify_trusted_proxies"`
+	OfflinePaymentTTL          time.Duration `yaml:"offline_payment_ttl"`
+
+	Currencies map[string]CurrencyConfig `yaml:"currencies"`
+
+	CampaignProgressCacheTTL time.Duration `yaml:"campaign_progress_cache_ttl"`
+}
+
+type CurrencyConfig struct {
+	TWDRate     float64           `yaml:"twd_rate"`
+	MinAmount   uint              `yaml:"min_amount"`
+	MaxAmount   uint              `yaml:"max_amount"`
+	MerchantIDs map[string]string `yaml:"merchant_ids"`
 }
 
 type AlgoliaConfig struct {
@@ -229,6 +374,70 @@ type PubSubConfig struct {
 	TopicName string `yaml:"topic_name"`
 }
 
+type RateLimitConfig struct {
+	Store                string        `yaml:"store"`
+	OtpSignInPerEmail    RateLimitRule `yaml:"otp_signin_per_email"`
+	OtpSignInPerIP       RateLimitRule `yaml:"otp_signin_per_ip"`
+	OtpActivatePerIP     RateLimitRule `yaml:"otp_activate_per_ip"`
+	OtpMaxFailedAttempts int           `yaml:"otp_max_failed_attempts"`
+	OtpLockoutDuration   time.Duration `yaml:"otp_lockout_duration"`
+
+	AccountDeletionPerEmail RateLimitRule `yaml:"account_deletion_per_email"`
+	EmailLinkPerEmail       RateLimitRule `yaml:"email_link_per_email"`
+}
+
+type AccountDeletionConfig struct {
+	ConfirmExpiration time.Duration `yaml:"confirm_expiration"`
+	GracePeriod       time.Duration `yaml:"grace_period"`
+	PurgeInterval     time.Duration `yaml:"purge_interval"`
+	ConfirmURL        string        `yaml:"confirm_url"`
+}
+
+type DataExportConfig struct {
+	Expiration        time.Duration `yaml:"expiration"`
+	ProcessingTimeout time.Duration `yaml:"processing_timeout"`
+	PurgeInterval     time.Duration `yaml:"purge_interval"`
+	DownloadURL       string        `yaml:"download_url"`
+}
+
+type PeriodicChargeConfig struct {
+	Interval     time.Duration `yaml:"interval"`
+	BatchSize    int           `yaml:"batch_size"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	MaxFailures  uint          `yaml:"max_failures"`
+}
+
+type CardExpiryReminderConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size"`
+}
+
+type TokenPurgeConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type IdempotencyKeyPurgeConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type DonationReconcilerConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	StaleAfter time.Duration `yaml:"stale_after"`
+	BatchSize  int           `yaml:"batch_size"`
+}
+
+type ReceiptConfig struct {
+	FontPath    string            `yaml:"font_path"`
+	IssuerName  string            `yaml:"issuer_name"`
+	IssuerTaxID string            `yaml:"issuer_tax_id"`
+	Series      map[string]string `yaml:"series"` // prefix of the receipt numbers by donation type
+}
+
+type RateLimitRule struct {
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
 func init() {
 	viper.SetConfigType("yaml")
 	viper.AutomaticEnv()        // read in environment variables that match
@@ -248,6 +457,12 @@ func buildConf() ConfYaml {
 	conf.App.Domain = viper.GetString("app.domain")
 	conf.App.JwtSecret = viper.GetString("app.jwt_secret")
 	conf.App.JwtExpiration = viper.GetInt("app.jwt_expiration")
+	conf.App.JwtAccessExpiration = viper.GetInt("app.jwt_access_expiration")
+	conf.App.JwtRefreshExpiration = viper.GetInt("app.jwt_refresh_expiration")
+	conf.App.JwtSigningMethod = viper.GetString("app.jwt_signing_method")
+	conf.App.JwtSigningKeys = viper.GetStringSlice("app.jwt_signing_keys")
+	conf.App.JwtAcceptHS256 = viper.GetBool("app.jwt_accept_hs256")
+	conf.App.SessionKeys = viper.GetStringSlice("app.session_keys")
 	conf.App.JwtAudience = viper.GetString("app.jwt_audience")
 	conf.App.JwtIssuer = viper.GetString("app.jwt_issuer")
 
@@ -290,12 +505,48 @@ func buildConf() ConfYaml {
 	conf.Oauth.Google.ID = viper.GetString("oauth.google.id")
 	conf.Oauth.Google.Secret = viper.GetString("oauth.google.secret")
 
+	// Oauth - OIDC
+	conf.Oauth.OIDC = make(map[string]OIDCConfig)
+	for name := range viper.GetStringMap("oauth.oidc") {
+		key := "oauth.oidc." + name
+		conf.Oauth.OIDC[name] = OIDCConfig{
+			Type:           viper.GetString(key + ".type"),
+			Issuer:         viper.GetString(key + ".issuer"),
+			ID:             viper.GetString(key + ".id"),
+			Secret:         viper.GetString(key + ".secret"),
+			Scopes:         viper.GetStringSlice(key + ".scopes"),
+			ResponseMode:   viper.GetString(key + ".response_mode"),
+			TeamID:         viper.GetString(key + ".team_id"),
+			KeyID:          viper.GetString(key + ".key_id"),
+			PrivateKeyPath: viper.GetString(key + ".private_key_path"),
+		}
+	}
+
 	// TapPay
 	conf.Donation.CardSecretKey = viper.GetString("donation.card_secret_key")
 	conf.Donation.TapPayURL = viper.GetString("donation.tappay_url")
 	conf.Donation.TapPayPartnerKey = viper.GetString("donation.tappay_partner_key")
 	conf.Donation.ProxyServer = viper.GetString("donation.proxy_server")
 	conf.Donation.TapPayRecordURL = viper.GetString("donation.tappay_record_url")
+	conf.Donation.TapPayTokenURL = viper.GetString("donation.tappay_token_url")
+	conf.Donation.TapPayBindCardURL = viper.GetString("donation.tappay_bind_card_url")
+	conf.Donation.TapPayRefundURL = viper.GetString("donation.tappay_refund_url")
+	conf.Donation.IdempotencyKeyTTL = viper.GetDuration("donation.idempotency_key_ttl")
+	conf.Donation.TapPayNotifyAllowedIPs = viper.GetStringSlice("donation.tappay_notify_allowed_ips")
+	conf.Donation.TapPayNotifyTrustedProxies = viper.GetStringSlice("donation.tappay_notify_trusted_proxies")
+	conf.Donation.OfflinePaymentTTL = viper.GetDuration("donation.offline_payment_ttl")
+	conf.Donation.CampaignProgressCacheTTL = viper.GetDuration("donation.campaign_progress_cache_ttl")
+	conf.Donation.Currencies = make(map[string]CurrencyConfig)
+	for code := range viper.GetStringMap("donation.currencies") {
+		key := "donation.currencies." + code
+		// keys are case insensitive in viper
+		conf.Donation.Currencies[strings.ToUpper(code)] = CurrencyConfig{
+			TWDRate:     viper.GetFloat64(key + ".twd_rate"),
+			MinAmount:   uint(viper.GetInt(key + ".min_amount")),
+			MaxAmount:   uint(viper.GetInt(key + ".max_amount")),
+			MerchantIDs: viper.GetStringMapString(key + ".merchant_ids"),
+		}
+	}
 	conf.Donation.LinePayProductImageUrl = viper.GetString("donation.line_pay_product_image_url")
 	conf.Donation.FrontendHost = viper.GetString("donation.frontend_host")
 
@@ -329,6 +580,60 @@ func buildConf() ConfYaml {
 	conf.PubSub.ProjectID = viper.GetString("pubsub.project_id")
 	conf.PubSub.TopicName = viper.GetString("pubsub.topic_name")
 
+	// Rate limit config
+	conf.RateLimit.Store = viper.GetString("ratelimit.store")
+	conf.RateLimit.OtpSignInPerEmail.Limit = viper.GetInt("ratelimit.otp_signin_per_email.limit")
+	conf.RateLimit.OtpSignInPerEmail.Window = viper.GetDuration("ratelimit.otp_signin_per_email.window")
+	conf.RateLimit.OtpSignInPerIP.Limit = viper.GetInt("ratelimit.otp_signin_per_ip.limit")
+	conf.RateLimit.OtpSignInPerIP.Window = viper.GetDuration("ratelimit.otp_signin_per_ip.window")
+	conf.RateLimit.OtpActivatePerIP.Limit = viper.GetInt("ratelimit.otp_activate_per_ip.limit")
+	conf.RateLimit.OtpActivatePerIP.Window = viper.GetDuration("ratelimit.otp_activate_per_ip.window")
+	conf.RateLimit.OtpMaxFailedAttempts = viper.GetInt("ratelimit.otp_max_failed_attempts")
+	conf.RateLimit.OtpLockoutDuration = viper.GetDuration("ratelimit.otp_lockout_duration")
+	conf.RateLimit.AccountDeletionPerEmail.Limit = viper.GetInt("ratelimit.account_deletion_per_email.limit")
+	conf.RateLimit.AccountDeletionPerEmail.Window = viper.GetDuration("ratelimit.account_deletion_per_email.window")
+	conf.RateLimit.EmailLinkPerEmail.Limit = viper.GetInt("ratelimit.email_link_per_email.limit")
+	conf.RateLimit.EmailLinkPerEmail.Window = viper.GetDuration("ratelimit.email_link_per_email.window")
+
+	// Account deletion config
+	conf.AccountDeletion.ConfirmExpiration = viper.GetDuration("account_deletion.confirm_expiration")
+	conf.AccountDeletion.GracePeriod = viper.GetDuration("account_deletion.grace_period")
+	conf.AccountDeletion.PurgeInterval = viper.GetDuration("account_deletion.purge_interval")
+	conf.AccountDeletion.ConfirmURL = viper.GetString("account_deletion.confirm_url")
+
+	// Data export config
+	conf.DataExport.Expiration = viper.GetDuration("data_export.expiration")
+	conf.DataExport.ProcessingTimeout = viper.GetDuration("data_export.processing_timeout")
+	conf.DataExport.PurgeInterval = viper.GetDuration("data_export.purge_interval")
+	conf.DataExport.DownloadURL = viper.GetString("data_export.download_url")
+
+	// Periodic charge config
+	conf.PeriodicCharge.Interval = viper.GetDuration("periodic_charge.interval")
+	conf.PeriodicCharge.BatchSize = viper.GetInt("periodic_charge.batch_size")
+	conf.PeriodicCharge.RetryBackoff = viper.GetDuration("periodic_charge.retry_backoff")
+	conf.PeriodicCharge.MaxFailures = uint(viper.GetInt("periodic_charge.max_failures"))
+
+	// Card expiry reminder config
+	conf.CardExpiryReminder.Interval = viper.GetDuration("card_expiry_reminder.interval")
+	conf.CardExpiryReminder.BatchSize = viper.GetInt("card_expiry_reminder.batch_size")
+
+	// Token purge config
+	conf.TokenPurge.Interval = viper.GetDuration("token_purge.interval")
+
+	// Idempotency key purge config
+	conf.IdempotencyKeyPurge.Interval = viper.GetDuration("idempotency_key_purge.interval")
+
+	// Donation reconciler config
+	conf.DonationReconciler.Interval = viper.GetDuration("donation_reconciler.interval")
+	conf.DonationReconciler.StaleAfter = viper.GetDuration("donation_reconciler.stale_after")
+	conf.DonationReconciler.BatchSize = viper.GetInt("donation_reconciler.batch_size")
+
+	// Receipt config
+	conf.Receipt.FontPath = viper.GetString("receipt.font_path")
+	conf.Receipt.IssuerName = viper.GetString("receipt.issuer_name")
+	conf.Receipt.IssuerTaxID = viper.GetString("receipt.issuer_tax_id")
+	conf.Receipt.Series = viper.GetStringMapString("receipt.series")
+
 	return conf
 }
 
@@ -41,4 +41,37 @@ func TestLoadConf(t *testing.T) {
 			"http://testhost2",
 		})
 	})
+	t.Run("OIDC providers", func(t *testing.T) {
+		const testLineID = "test_line_channel_id"
+
+		os.Setenv("GOAPI_OAUTH_OIDC_LINE_ID", testLineID)
+
+		testConf, _ := configs.LoadConf("")
+
+		assert.Equal(t, testConf.Oauth.OIDC["line"].ID, testLineID)
+		assert.Equal(t, testConf.Oauth.OIDC["line"].Type, "LINE")
+		assert.Equal(t, testConf.Oauth.OIDC["apple"].Issuer, "https://appleid.apple.com")
+		assert.Equal(t, testConf.Oauth.OIDC["apple"].Scopes, []string{"name", "email"})
+	})
+	t.Run("Donation currencies", func(t *testing.T) {
+		os.Setenv("GOAPI_DONATION_CURRENCIES_TWD_MAX_AMOUNT", "1000000")
+
+		testConf, _ := configs.LoadConf("")
+
+		twd, ok := testConf.Donation.Currencies["TWD"]
+		assert.True(t, ok)
+		assert.Equal(t, twd.TWDRate, float64(1))
+		assert.Equal(t, twd.MinAmount, uint(1))
+		assert.Equal(t, twd.MaxAmount, uint(1000000))
+	})
+	t.Run("Rate limit rules", func(t *testing.T) {
+		os.Setenv("GOAPI_RATELIMIT_OTP_SIGNIN_PER_EMAIL_LIMIT", "1")
+
+		testConf, _ := configs.LoadConf("")
+
+		// the rules of the flows sending mails are tuned separately
+		assert.Equal(t, testConf.RateLimit.OtpSignInPerEmail.Limit, 1)
+		assert.Equal(t, testConf.RateLimit.AccountDeletionPerEmail.Limit, 5)
+		assert.Equal(t, testConf.RateLimit.EmailLinkPerEmail.Limit, 5)
+	})
 }
@@ -1,17 +1,19 @@
 package controllers
 
 import (
+	"crypto/hmac"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"net/mail"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/guregu/null.v3"
 
 	"github.com/twreporter/go-api/configs/constants"
 	"github.com/twreporter/go-api/globals"
@@ -21,6 +23,7 @@ import (
 )
 
 const idTokenExpiration = 60 * 60 * 24 * 30 * 6
+const otpCodeDigits = 6
 
 var defaultRedirectPage = "https://www.twreporter.org/"
 var defaultPath = "/"
@@ -135,6 +138,11 @@ func (mc *MembershipController) AuthByEmail(c *gin.Context, sendMailRoutePath st
 			if err != nil {
 				return http.StatusInternalServerError, gin.H{"status": "error", "message": "Inserting new record into DB occurs error"}, nil
 			}
+
+			// keep the record of merging the identity into the existing user
+			if err = mc.Storage.CreateIdentityAuditLog(newIdentityAuditLog(c, matchedUser.ID, models.IdentityActionAutoMerge, models.IdentityProviderEmail, email)); err != nil {
+				log.Errorf("%+v", err)
+			}
 		}
 		statusCode = http.StatusCreated
 	}
@@ -257,7 +265,7 @@ func (mc *MembershipController) ActivateV2(c *gin.Context) {
 	}
 
 	// Create id token for jwt endpoint retrival
-	idToken, err := utils.RetrieveV2IDToken(user.ID, user.Email.ValueOrZero(), user.FirstName.ValueOrZero(), user.LastName.ValueOrZero(), idTokenExpiration)
+	idToken, err := issueIDToken(c, mc.Storage, user)
 	if nil != err {
 		idToken = "twreporter-id-token"
 	}
@@ -286,11 +294,9 @@ func (mc *MembershipController) ActivateV2(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, destination)
 }
 
-// TokenDispatch returns the `access_token` in payload for frontend server
+// TokenDispatch returns the `access_token` and the `refresh_token` in payload for frontend server
 func (mc *MembershipController) TokenDispatch(c *gin.Context) (int, gin.H, error) {
-	const acccessTokenExpiration = 60 * 60 * 24 * 14 // 2week
 	const idTokenKey = "id_token"
-	var accessToken string
 	var claims = new(utils.IDTokenJWTClaims)
 	var err error
 	var idToken string
@@ -309,28 +315,32 @@ func (mc *MembershipController) TokenDispatch(c *gin.Context) (int, gin.H, error
 		return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot get user data"}, err
 	}
 
-	roles := make([]map[string]interface{}, len(user.Roles))
-	for i, role := range user.Roles {
-		roles[i] = map[string]interface{}{
-			"id":      role.ID,
-			"name":    role.Name,
-			"name_en": role.NameEn,
-			"key":     role.Key,
-		}
+	// the user has logged out of all devices after the id token is issued
+	if user.TokensRevokedAt.Valid && claims.IssuedAt <= user.TokensRevokedAt.Time.Unix() {
+		return http.StatusUnauthorized, gin.H{"status": "fail", "data": gin.H{"req.Headers.Cookies.id_token": "id_token is revoked"}}, nil
 	}
 
-	var activated *time.Time
-	if user.Activated.Valid && !user.Activated.Time.IsZero() {
-		activated = &user.Activated.Time
+	familyID, err := utils.GenerateRandomString(refreshTokenBytes)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during generating refresh token family"}, err
 	}
 
-	accessToken, err = utils.RetrieveV2AccessToken(user.ID, user.Email.ValueOrZero(), roles, activated, acccessTokenExpiration)
+	accessToken, refreshToken, rt, err := newTokenPair(user, familyID)
 	if err != nil {
 		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during generating access_token JWT"}, err
 	}
 
+	// the refresh token family is revoked along with the session of the id token
+	rt.SessionJTI = claims.Id
+	if err = mc.Storage.CreateRefreshToken(&rt); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during storing refresh_token"}, err
+	}
+
+	mc.touchSession(rt.SessionJTI)
+
 	return http.StatusOK, gin.H{"status": "success", "data": gin.H{
-		"jwt": accessToken,
+		"jwt":           accessToken,
+		"refresh_token": refreshToken,
 	}}, nil
 }
 
@@ -356,6 +366,19 @@ func (mc *MembershipController) TokenInvalidate(c *gin.Context) {
 		u, _ = url.Parse(destination)
 	}
 
+	// end the session of the id token
+	if idToken, err := c.Cookie(cookieName1); err == nil {
+		var claims utils.IDTokenJWTClaims
+		_, err = jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+			return utils.GetVerificationKey(token, globals.Conf.App.JwtSecret)
+		})
+		if err == nil && claims.Id != "" {
+			if err = mc.Storage.RevokeUserSessionByJTI(claims.Id); err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+
 	c.SetCookie(cookieName1, "", invalidateExp, defaultPath, defaultDomain, u.Scheme == "https", true)
 	c.SetCookie(cookieName2, "", invalidateExp, defaultPath, defaultDomain, u.Scheme == "https", true)
 	c.Redirect(http.StatusTemporaryRedirect, destination)
@@ -434,16 +457,46 @@ func (mc *MembershipController) AuthByOtp(c *gin.Context, sendMailRoutePath stri
 		}}, nil
 	}
 
-	// Generate a 6-digit OTP code
-	otpCode := generateOTPCode()
+	// throttle the requests to prevent from flooding the inbox
+	rl := globals.Conf.RateLimit
+	if ok, retryAfter, err := mc.allowRequest("otp_signin", rateLimitKeyIP, c.ClientIP(), rl.OtpSignInPerIP); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Checking rate limit occurs error"}, err
+	} else if !ok {
+		statusCode, resp := tooManyRequests(c, retryAfter, gin.H{"email": "too many sign-in requests, please try again later"})
+		return statusCode, resp, nil
+	}
+	if ok, retryAfter, err := mc.allowRequest("otp_signin", rateLimitKeyEmail, strings.ToLower(email), rl.OtpSignInPerEmail); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Checking rate limit occurs error"}, err
+	} else if !ok {
+		statusCode, resp := tooManyRequests(c, retryAfter, gin.H{"email": "too many sign-in requests, please try again later"})
+		return statusCode, resp, nil
+	}
+
+	// Generate a 6-digit OTP code, only its hash is stored
+	otpCode, err := utils.GenerateOTPCode(otpCodeDigits)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Generating OTP code occurs error"}, err
+	}
+	otpCodeHash := null.StringFrom(utils.HashOTPCode(email, otpCode))
+	// expire time is 15 minute
+	otpExpTime := null.TimeFrom(time.Now().Add(time.Duration(15) * time.Minute))
 
 	// get reporter account by email from reporter_account table
 	ra, err = mc.Storage.GetReporterAccountData(email)
 	// account is already signed in before
 	if err == nil {
-		// update active token and token expire time
-		ra.ActivateToken = otpCode
-		ra.ActExpTime = time.Now().Add(time.Duration(15) * time.Minute)
+		// do not send a new code until the lockout ends
+		if now := time.Now(); ra.IsLocked(now) {
+			statusCode, resp := tooManyRequests(c, retryAfterSeconds(ra.LockedUntil.Time.Sub(now)), gin.H{
+				"email":        "account is locked due to too many failed attempts",
+				"locked_until": ra.LockedUntil.Time.Format(time.RFC3339),
+			})
+			return statusCode, resp, nil
+		}
+
+		// update otp code and its expire time
+		ra.OtpCodeHash = otpCodeHash
+		ra.OtpExpTime = otpExpTime
 		if err = mc.Storage.UpdateReporterAccount(ra); err != nil {
 			return http.StatusInternalServerError, gin.H{"status": "error", "message": "Updating DB occurs error"}, err
 		}
@@ -456,10 +509,11 @@ func (mc *MembershipController) AuthByOtp(c *gin.Context, sendMailRoutePath stri
 		}
 
 		ra = models.ReporterAccount{
-			Email:         email,
-			ActivateToken: otpCode,
-			// expire time is 15 minute
-			ActExpTime: time.Now().Add(time.Duration(15) * time.Minute),
+			Email:       email,
+			OtpCodeHash: otpCodeHash,
+			OtpExpTime:  otpExpTime,
+			// no activate token for the magic link is issued
+			ActExpTime: time.Now(),
 		}
 
 		// try to find record by email in users table
@@ -480,6 +534,11 @@ func (mc *MembershipController) AuthByOtp(c *gin.Context, sendMailRoutePath stri
 			if err != nil {
 				return http.StatusInternalServerError, gin.H{"status": "error", "message": "Inserting new record into DB occurs error"}, nil
 			}
+
+			// keep the record of merging the identity into the existing user
+			if err = mc.Storage.CreateIdentityAuditLog(newIdentityAuditLog(c, matchedUser.ID, models.IdentityActionAutoMerge, models.IdentityProviderEmail, email)); err != nil {
+				log.Errorf("%+v", err)
+			}
 		}
 		statusCode = http.StatusCreated
 	}
@@ -496,16 +555,10 @@ func (mc *MembershipController) AuthByOtp(c *gin.Context, sendMailRoutePath stri
 
 	return statusCode, gin.H{"status": "success", "data": SignInBody{
 		Email:     email,
-		ExpiredAt: ra.ActExpTime.Format(time.RFC3339),
+		ExpiredAt: ra.OtpExpTime.Time.Format(time.RFC3339),
 	}}, nil
 }
 
-// generateOTPCode generates a random 6-digit OTP code
-func generateOTPCode() string {
-	rand.Seed(time.Now().UnixNano())
-	return fmt.Sprintf("%06d", rand.Intn(1000000))
-}
-
 // ActivateV3 - send email containing authenticate information to the client
 func (mc *MembershipController) ActivateV3(c *gin.Context) (int, gin.H, error) {
 	type SignInBody struct {
@@ -537,6 +590,14 @@ func (mc *MembershipController) ActivateV3(c *gin.Context) (int, gin.H, error) {
 	email = signIn.Email
 	otpCode = signIn.OtpCode
 
+	// throttle the guesses from the same client
+	if ok, retryAfter, err := mc.allowRequest("otp_activate", rateLimitKeyIP, c.ClientIP(), globals.Conf.RateLimit.OtpActivatePerIP); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Checking rate limit occurs error"}, err
+	} else if !ok {
+		statusCode, resp := tooManyRequests(c, retryAfter, gin.H{"email": email})
+		return statusCode, resp, errors.New("too many activate requests")
+	}
+
 	// get reporter account by email from reporter_account table
 	if ra, err = mc.Storage.GetReporterAccountData(email); err != nil {
 		errMsg := "email not found"
@@ -549,8 +610,19 @@ func (mc *MembershipController) ActivateV3(c *gin.Context) (int, gin.H, error) {
 		}, errors.New(errMsg)
 	}
 
+	// reject the guesses during the lockout
+	if now := time.Now(); ra.IsLocked(now) {
+		errMsg := "account is locked due to too many failed attempts"
+		statusCode, resp := tooManyRequests(c, retryAfterSeconds(ra.LockedUntil.Time.Sub(now)), gin.H{
+			"email":        email,
+			"locked_until": ra.LockedUntil.Time.Format(time.RFC3339),
+		})
+		resp["error"] = errMsg
+		return statusCode, resp, errors.New(errMsg)
+	}
+
 	// check expire time
-	if ra.ActExpTime.Before(time.Now()) {
+	if !ra.OtpExpTime.Valid || ra.OtpExpTime.Time.Before(time.Now()) {
 		errMsg := "otp code expired"
 		return http.StatusForbidden, gin.H{
 			"status": "expired",
@@ -562,7 +634,23 @@ func (mc *MembershipController) ActivateV3(c *gin.Context) (int, gin.H, error) {
 	}
 
 	// validate token
-	if ra.ActivateToken != otpCode {
+	if !ra.OtpCodeHash.Valid || !hmac.Equal([]byte(ra.OtpCodeHash.String), []byte(utils.HashOTPCode(email, otpCode))) {
+		rl := globals.Conf.RateLimit
+		ra, err = mc.Storage.IncreaseFailedAttemptsOfReporterAccount(ra.ID, rl.OtpMaxFailedAttempts, time.Now().Add(rl.OtpLockoutDuration))
+		if err != nil {
+			return http.StatusInternalServerError, gin.H{"status": "error", "message": "Updating DB occurs error"}, err
+		}
+
+		if now := time.Now(); ra.IsLocked(now) {
+			errMsg := "account is locked due to too many failed attempts"
+			statusCode, resp := tooManyRequests(c, retryAfterSeconds(ra.LockedUntil.Time.Sub(now)), gin.H{
+				"email":        email,
+				"locked_until": ra.LockedUntil.Time.Format(time.RFC3339),
+			})
+			resp["error"] = errMsg
+			return statusCode, resp, errors.New(errMsg)
+		}
+
 		errMsg := "otp code invalid"
 		return http.StatusForbidden, gin.H{
 			"status": "fail",
@@ -573,6 +661,27 @@ func (mc *MembershipController) ActivateV3(c *gin.Context) (int, gin.H, error) {
 		}, errors.New(errMsg)
 	}
 
+	// invalidate the code, so that the replayed requests fail
+	if err = mc.Storage.ConsumeOtpCodeOfReporterAccount(email, ra.OtpCodeHash.String); err != nil {
+		if storage.IsNotFound(err) {
+			errMsg := "otp code invalid"
+			return http.StatusForbidden, gin.H{
+				"status": "fail",
+				"error":  errMsg,
+				"data": SignInBody{
+					Email: email,
+				},
+			}, errors.New(errMsg)
+		}
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Updating DB occurs error"}, err
+	}
+
+	if ra.FailedAttempts > 0 || ra.LockedUntil.Valid {
+		if err = mc.Storage.ResetFailedAttemptsOfReporterAccount(ra.ID); err != nil {
+			return http.StatusInternalServerError, gin.H{"status": "error", "message": "Updating DB occurs error"}, err
+		}
+	}
+
 	if user, err = mc.Storage.GetUserDataByReporterAccount(ra); err != nil {
 		errMsg := "Error occurs during querying the record from users table"
 		return http.StatusInternalServerError, gin.H{
@@ -585,7 +694,7 @@ func (mc *MembershipController) ActivateV3(c *gin.Context) (int, gin.H, error) {
 	}
 
 	// Create id token for jwt endpoint retrival
-	idToken, err := utils.RetrieveV2IDToken(user.ID, user.Email.ValueOrZero(), user.FirstName.ValueOrZero(), user.LastName.ValueOrZero(), idTokenExpiration)
+	idToken, err := issueIDToken(c, mc.Storage, user)
 	if nil != err {
 		idToken = "twreporter-id-token"
 	}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/globals"
+	member "github.com/twreporter/go-api/internal/member_cms"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+// accountDeletionBatchSize is the max number of accounts erased in a purge
+const accountDeletionBatchSize = 100
+
+// accountDeletionPurgeJobName names the lease of the job erasing the accounts passing the grace period
+const accountDeletionPurgeJobName = "account_deletion_purge"
+
+func hashAccountDeletionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func accountDeletionResponse(req models.AccountDeletionRequest) gin.H {
+	data := gin.H{
+		"user_id": req.UserID,
+		"status":  req.Status(),
+	}
+
+	if req.ConfirmedAt.Valid {
+		data["scheduled_at"] = req.ScheduledAt.Time.Format(time.RFC3339)
+	} else {
+		data["confirm_expired_at"] = req.ConfirmExpTime.Format(time.RFC3339)
+	}
+
+	return data
+}
+
+// RequestAccountDeletionOfAUser sends the link confirming the account deletion to the email of the user.
+// The account is erased after the grace period once the deletion is confirmed.
+func (mc *MembershipController) RequestAccountDeletionOfAUser(c *gin.Context) (int, gin.H, error) {
+	conf := globals.Conf.AccountDeletion
+
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	user, err := mc.Storage.GetUserByID(c.Param("userID"))
+	if err != nil {
+		return toResponse(err)
+	}
+
+	if !user.Email.Valid || user.Email.String == "" {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"email": "email of the user is required to confirm the deletion"}}, nil
+	}
+
+	if req, err := mc.Storage.GetAccountDeletionRequest(userID); err == nil && req.ConfirmedAt.Valid {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "account deletion is already scheduled"}}, nil
+	} else if err != nil && !storage.IsNotFound(err) {
+		return toResponse(err)
+	}
+
+	if ok, retryAfter, err := mc.allowRequest("account_deletion", rateLimitKeyEmail, user.Email.String, globals.Conf.RateLimit.AccountDeletionPerEmail); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Checking rate limit occurs error"}, err
+	} else if !ok {
+		statusCode, resp := tooManyRequests(c, retryAfter, gin.H{"email": "too many requests, please try again later"})
+		return statusCode, resp, nil
+	}
+
+	token, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Generating confirmation token occurs error"}, err
+	}
+
+	req := models.AccountDeletionRequest{
+		UserID:         userID,
+		TokenHash:      hashAccountDeletionToken(token),
+		ConfirmExpTime: time.Now().Add(conf.ConfirmExpiration),
+	}
+	if err = mc.Storage.CreateAccountDeletionRequest(req); err != nil {
+		return toResponse(err)
+	}
+
+	err = postMailServiceEndpoint(accountDeletionReqBody{
+		Email:       user.Email.String,
+		ConfirmLink: fmt.Sprintf("%s?user_id=%d&token=%s", conf.ConfirmURL, userID, url.QueryEscape(token)),
+		ExpireHours: int(conf.ConfirmExpiration.Hours()),
+		GraceDays:   int(conf.GracePeriod.Hours() / 24),
+	}, fmt.Sprintf("http://localhost:%s/v1/%s", globals.LocalhostPort, globals.SendAccountDeletionRoutePath))
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Sending account deletion email occurs error"}, err
+	}
+
+	return http.StatusAccepted, gin.H{"status": "success", "data": accountDeletionResponse(req)}, nil
+}
+
+// ConfirmAccountDeletionOfAUser confirms the deletion by the token in the email,
+// and schedules the erasure after the grace period.
+// The token proves the ownership of the email, so the request is not authenticated.
+func (mc *MembershipController) ConfirmAccountDeletionOfAUser(c *gin.Context) (int, gin.H, error) {
+	var body struct {
+		Token string `json:"token" form:"token" binding:"required"`
+	}
+
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	if err = c.Bind(&body); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"token": "token is required"}}, nil
+	}
+
+	scheduledAt := time.Now().Add(globals.Conf.AccountDeletion.GracePeriod)
+	err = mc.Storage.ConfirmAccountDeletionRequest(userID, hashAccountDeletionToken(body.Token), scheduledAt)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return http.StatusForbidden, gin.H{"status": "fail", "data": gin.H{"token": "token is invalid or expired"}}, nil
+		}
+		return toResponse(err)
+	}
+
+	req, err := mc.Storage.GetAccountDeletionRequest(userID)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": accountDeletionResponse(req)}, nil
+}
+
+// GetAccountDeletionOfAUser returns the status of the deletion request
+func (mc *MembershipController) GetAccountDeletionOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	req, err := mc.Storage.GetAccountDeletionRequest(userID)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": accountDeletionResponse(req)}, nil
+}
+
+// CancelAccountDeletionOfAUser cancels the deletion request within the grace period
+func (mc *MembershipController) CancelAccountDeletionOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	if err = mc.Storage.CancelAccountDeletionRequest(userID); err != nil {
+		return toResponse(err)
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
+
+// AccountDeletionPurgeJob returns the job erasing the accounts passing the grace period
+func (mc *MembershipController) AccountDeletionPurgeJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     accountDeletionPurgeJobName,
+		Interval: globals.Conf.AccountDeletion.PurgeInterval,
+		Run:      mc.PurgeDueAccounts,
+	}
+}
+
+// PurgeDueAccounts erases the accounts passing the grace period, at most accountDeletionBatchSize of them a time.
+// The account failing to be erased is left for the next run.
+func (mc *MembershipController) PurgeDueAccounts(ctx context.Context) error {
+	now := time.Now()
+	// the donors of the erased accounts kept for the tax agency are erased once they are uploaded
+	if err := mc.Storage.EraseUploadedTaxDeductionData(now); err != nil {
+		return err
+	}
+
+	reqs, err := mc.Storage.GetDueAccountDeletionRequests(now, accountDeletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, req := range reqs {
+		if err = ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		user, err := mc.Storage.EraseAccount(req.UserID)
+		if err != nil {
+			// the deletion is cancelled after the requests are queried
+			if !storage.IsNotFound(err) {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
+
+		log.WithField("user_id", user.ID).Info("account is erased")
+		mc.notifyAccountErasure(user)
+	}
+
+	return nil
+}
+
+// notifyAccountErasure asks member cms and the subscribers of role updates to erase the user as well
+func (mc *MembershipController) notifyAccountErasure(user models.User) {
+	if globals.Conf.Features.MemberCMS {
+		if err := member.PostUserDeletion(user.ID); err != nil {
+			log.WithField("user_id", user.ID).Errorf("Failed to notify member cms of user deletion: %v", err)
+		}
+	}
+
+	if globals.Conf.Features.EnableRoleUpdatePubSub {
+		if mc.RoleUpdateService == nil {
+			log.Errorf("RoleUpdateService is not available, cannot send user deletion message for user: %d", user.ID)
+			return
+		}
+		if err := mc.RoleUpdateService.PublishUserDeletion(user.ID, user.Email.String); err != nil {
+			log.WithField("user_id", user.ID).Errorf("Failed to publish user deletion message: %v", err)
+		}
+	}
+}
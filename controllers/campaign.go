@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+const defaultCampaignProgressCacheTTL = time.Minute
+
+type (
+	cachedCampaignProgress struct {
+		progress  models.CampaignProgress
+		expiresAt time.Time
+	}
+
+	// campaignProgressCache caches the progress of the campaigns,
+	// since aggregating the donations on every request of the public endpoint is expensive
+	campaignProgressCache struct {
+		mu       sync.Mutex
+		progress map[uint]cachedCampaignProgress
+	}
+
+	campaignResp struct {
+		models.DonationCampaign
+		models.CampaignProgress
+		Currency string `json:"currency"`
+	}
+)
+
+func (pc *campaignProgressCache) get(campaignID uint) (models.CampaignProgress, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	cached, ok := pc.progress[campaignID]
+	if !ok || time.Now().After(cached.expiresAt) {
+		return models.CampaignProgress{}, false
+	}
+
+	return cached.progress, true
+}
+
+func (pc *campaignProgressCache) set(campaignID uint, progress models.CampaignProgress) {
+	ttl := globals.Conf.Donation.CampaignProgressCacheTTL
+	if ttl <= 0 {
+		ttl = defaultCampaignProgressCacheTTL
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.progress == nil {
+		pc.progress = make(map[uint]cachedCampaignProgress)
+	}
+	pc.progress[campaignID] = cachedCampaignProgress{progress: progress, expiresAt: time.Now().Add(ttl)}
+}
+
+// GetACampaign returns the campaign of the slug along with the amount raised by its paid donations in TWD
+// and the number of the donors
+func (mc *MembershipController) GetACampaign(c *gin.Context) (int, gin.H, error) {
+	campaign, err := mc.Storage.GetCampaignBySlug(c.Param("slug"))
+	if err != nil {
+		return toResponse(err)
+	}
+
+	progress, ok := mc.campaignProgress.get(campaign.ID)
+	if !ok {
+		if progress, err = mc.Storage.GetCampaignProgress(campaign.ID); err != nil {
+			return toResponse(err)
+		}
+		mc.campaignProgress.set(campaign.ID, progress)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": campaignResp{
+		DonationCampaign: campaign,
+		CampaignProgress: progress,
+		Currency:         defaultCurrency,
+	}}, nil
+}
+
+// getCampaignOfDonation returns the id of the open campaign which the new donation is earmarked for.
+// It returns the fail data of the JSend response if the campaign could not be donated to.
+func (mc *MembershipController) getCampaignOfDonation(slug string) (null.Int, gin.H, error) {
+	if slug == "" {
+		return null.Int{}, nil, nil
+	}
+
+	campaign, err := mc.Storage.GetCampaignBySlug(slug)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return null.Int{}, gin.H{"req.Body.campaign": "campaign is not found"}, nil
+		}
+		return null.Int{}, nil, err
+	}
+
+	if !campaign.IsOpen(time.Now()) {
+		return null.Int{}, gin.H{"req.Body.campaign": "campaign is not open for donations"}, nil
+	}
+
+	return null.IntFrom(int64(campaign.ID)), nil, nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/twreporter/go-api/internal/news"
+	"github.com/twreporter/go-api/internal/ratelimit"
 
 	"github.com/globalsign/mgo"
 	"github.com/jinzhu/gorm"
@@ -23,6 +24,7 @@ type ControllerFactory struct {
 	mailService services.MailService
 	mongoClient *mongo.Client
 	indexClient news.AlgoliaSearcher
+	rateLimiter *ratelimit.Limiter
 }
 
 // GetOAuthController returns OAuth struct
@@ -38,10 +40,24 @@ func (cf *ControllerFactory) GetOAuthController(oauthType string) (oauth *OAuth)
 	return oauth
 }
 
+// GetOIDCController returns the controller of the OIDC provider in `oauth.oidc` config.
+// It returns nil if the provider is not configured.
+func (cf *ControllerFactory) GetOIDCController(name string) (*OIDC, error) {
+	conf, ok := globals.Conf.Oauth.OIDC[name]
+	if !ok || conf.ID == "" {
+		return nil, nil
+	}
+
+	gs := storage.NewGormStorage(cf.gormDB)
+	return NewOIDC(name, conf, gs)
+}
+
 // GetMembershipController returns *MembershipController struct
 func (cf *ControllerFactory) GetMembershipController() *MembershipController {
 	gs := storage.NewGormStorage(cf.gormDB)
-	return NewMembershipController(gs)
+	mc := NewMembershipController(gs)
+	mc.RateLimiter = cf.rateLimiter
+	return mc
 }
 
 // GetAnalyticsController returns *AnalyticsController struct
@@ -51,6 +67,13 @@ func (cf *ControllerFactory) GetAnalyticsController() *AnalyticsController {
 	return NewAnalyticsController(gs, ms)
 }
 
+// GetDataExportController returns *DataExportController struct
+func (cf *ControllerFactory) GetDataExportController() *DataExportController {
+	s := storage.NewGormStorage(cf.gormDB)
+	gs := storage.NewAnalyticsGormStorage(cf.gormDB)
+	return NewDataExportController(s, gs)
+}
+
 func (cf *ControllerFactory) GetNewsV2Controller() *newsV2Controller {
 	return NewNewsV2Controller(storage.NewMongoV2Storage(cf.mongoClient), cf.indexClient, storage.NewNewsV2SqlStorage(cf.gormDB))
 }
@@ -77,6 +100,11 @@ func (cf *ControllerFactory) GetMailController() *MailController {
 		fmt.Sprintf("%s/role-actiontaker.tmpl", templateDir),
 		fmt.Sprintf("%s/role-trailblazer.tmpl", templateDir),
 		fmt.Sprintf("%s/role-downgrade.tmpl", templateDir),
+		fmt.Sprintf("%s/account-deletion.tmpl", templateDir),
+		fmt.Sprintf("%s/data-export.tmpl", templateDir),
+		fmt.Sprintf("%s/periodic-donation-change.tmpl", templateDir),
+		fmt.Sprintf("%s/card-expiry-reminder.tmpl", templateDir),
+		fmt.Sprintf("%s/offline-payment-instructions.tmpl", templateDir),
 	)
 
 	return contrl
@@ -99,11 +127,22 @@ func (cf *ControllerFactory) GetGormDB() *gorm.DB {
 
 // NewControllerFactory generate *ControllerFactory struct
 func NewControllerFactory(gormDB *gorm.DB, mgoSession *mgo.Session, mailSvc services.MailService, client *mongo.Client, sClient news.AlgoliaSearcher) *ControllerFactory {
+	// rate limiter is shared by the controllers,
+	// so that the in-memory counters are not reset per controller
+	var store ratelimit.Store
+	switch globals.Conf.RateLimit.Store {
+	case ratelimit.StoreMySQL:
+		store = ratelimit.NewMySQLStore(gormDB)
+	default:
+		store = ratelimit.NewMemoryStore()
+	}
+
 	return &ControllerFactory{
 		gormDB:      gormDB,
 		mgoSession:  mgoSession,
 		mailService: mailSvc,
 		mongoClient: client,
 		indexClient: sClient,
+		rateLimiter: ratelimit.NewLimiter(store),
 	}
 }
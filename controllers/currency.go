@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+// normalizeCurrency returns the upper-case currency code, and TWD if it is not provided
+func normalizeCurrency(currency string) string {
+	if currency == "" {
+		return defaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+// getCurrencyConfig returns the config of the accepted currency.
+// TWD is always accepted by the default merchants even if it is not configured.
+func getCurrencyConfig(currency string) (configs.CurrencyConfig, bool) {
+	conf, ok := globals.Conf.Donation.Currencies[currency]
+	if !ok && currency == defaultCurrency {
+		return configs.CurrencyConfig{TWDRate: 1}, true
+	}
+	return conf, ok
+}
+
+// getMerchantID returns the merchant charging the pay method in the currency
+func getMerchantID(currency, payMethod string) string {
+	conf, _ := getCurrencyConfig(currency)
+	if merchantID := conf.MerchantIDs[payMethod]; merchantID != "" {
+		return merchantID
+	}
+
+	if currency == defaultCurrency {
+		return methodToMerchant[payMethod]
+	}
+	return ""
+}
+
+// validateDonationCurrency checks the currency is accepted by the merchant of the pay method,
+// and the amount is within the limits of the currency.
+// The merchant given by the client, if any, should be the configured one of the pay method in the currency,
+// while it is still accepted for TWD if no merchant is configured, since the default merchants are for testing.
+// It returns the fail data of the JSend response if the validation fails.
+func validateDonationCurrency(currency, payMethod, merchantID string, amount uint) gin.H {
+	conf, ok := getCurrencyConfig(currency)
+	if !ok {
+		return gin.H{"req.Body.currency": fmt.Sprintf("currency %s is not supported", currency)}
+	}
+
+	if getMerchantID(currency, payMethod) == "" {
+		return gin.H{"req.Body.currency": fmt.Sprintf("currency %s is not supported by pay_method %s", currency, payMethod)}
+	}
+
+	if configured := conf.MerchantIDs[payMethod]; merchantID != "" && configured != "" && merchantID != configured {
+		return gin.H{"req.Body.merchant_id": fmt.Sprintf("merchant_id %s does not charge pay_method %s in %s", merchantID, payMethod, currency)}
+	}
+
+	if msg := validateDonationAmount(currency, amount); msg != "" {
+		return gin.H{"req.Body.amount": msg}
+	}
+
+	return nil
+}
+
+// validateDonationAmount checks the amount is within the limits of the currency,
+// and returns why it is not
+func validateDonationAmount(currency string, amount uint) string {
+	conf, _ := getCurrencyConfig(currency)
+
+	if amount < conf.MinAmount {
+		return fmt.Sprintf("amount should be at least %d %s", conf.MinAmount, currency)
+	}
+
+	if conf.MaxAmount > 0 && amount > conf.MaxAmount {
+		return fmt.Sprintf("amount should be at most %d %s", conf.MaxAmount, currency)
+	}
+
+	return ""
+}
+
+// toTWDEquivalent converts the amount in the currency into TWD by the configured rate
+func toTWDEquivalent(currency string, amount uint) models.TWDEquivalent {
+	conf, ok := getCurrencyConfig(currency)
+	if !ok || conf.TWDRate <= 0 {
+		return models.TWDEquivalent{}
+	}
+
+	return models.TWDEquivalent{
+		TWDAmount:    null.IntFrom(int64(math.Round(float64(amount) * conf.TWDRate))),
+		ExchangeRate: null.FloatFrom(conf.TWDRate),
+	}
+}
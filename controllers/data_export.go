@@ -0,0 +1,393 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+// dataExportPageSize is the number of records read at a time while generating the archive
+const dataExportPageSize = 100
+
+// dataExportPurgeJobName names the lease of the job clearing the expired archives
+const dataExportPurgeJobName = "data_export_purge"
+
+// NewDataExportController returns the controller exporting the personal data of users
+func NewDataExportController(s storage.MembershipStorage, gs storage.AnalyticsGormStorage) *DataExportController {
+	return &DataExportController{Storage: s, AnalyticsStorage: gs}
+}
+
+// DataExportController generates the archives of the personal data of users
+type DataExportController struct {
+	Storage          storage.MembershipStorage
+	AnalyticsStorage storage.AnalyticsGormStorage
+}
+
+// ExportUserData responds the archive of the personal data of the user if it is ready.
+// Otherwise, the archive is generated asynchronously, and an email is sent when it is ready.
+func (dc *DataExportController) ExportUserData(c *gin.Context) {
+	conf := globals.Conf.DataExport
+
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}})
+		return
+	}
+
+	export, err := dc.Storage.GetUserDataExport(userID)
+	if err != nil && !storage.IsNotFound(err) {
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	now := time.Now()
+	if err == nil && export.IsReady(now) {
+		filename := fmt.Sprintf("twreporter-data-%d-%s.zip", userID, export.UpdatedAt.Format("20060102"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+		c.Data(http.StatusOK, "application/zip", export.Archive)
+		return
+	}
+
+	started, err := dc.Storage.StartUserDataExport(userID, now.Add(-conf.ProcessingTimeout))
+	if err != nil {
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	if started {
+		go dc.generateArchive(userID)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "success", "data": gin.H{
+		"user_id": userID,
+		"status":  models.DataExportStatusProcessing,
+	}})
+}
+
+// generateArchive runs in its own goroutine, so the panic is recovered here
+// and the export is marked as failed instead of being stuck in processing
+func (dc *DataExportController) generateArchive(userID uint) {
+	conf := globals.Conf.DataExport
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("%+v", errors.New(fmt.Sprintf("panic while generating data export of user(id: %d): %v", userID, r)))
+			if err := dc.Storage.FailUserDataExport(userID); err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}()
+
+	user, archive, err := dc.buildArchive(userID)
+	if err != nil {
+		log.Errorf("%+v", errors.Wrap(err, fmt.Sprintf("can not generate data export of user(id: %d)", userID)))
+		if err = dc.Storage.FailUserDataExport(userID); err != nil {
+			log.Errorf("%+v", err)
+		}
+		return
+	}
+
+	if err = dc.Storage.CompleteUserDataExport(userID, archive, time.Now().Add(conf.Expiration)); err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+
+	if !user.Email.Valid || user.Email.String == "" {
+		return
+	}
+
+	err = postMailServiceEndpoint(dataExportReqBody{
+		Email:        user.Email.String,
+		DownloadLink: conf.DownloadURL,
+		ExpireDays:   int(conf.Expiration.Hours() / 24),
+	}, fmt.Sprintf("http://localhost:%s/v1/%s", globals.LocalhostPort, globals.SendDataExportRoutePath))
+	if err != nil {
+		log.Errorf("%+v", errors.Wrap(err, fmt.Sprintf("can not send data export mail to user(id: %d)", userID)))
+	}
+}
+
+// DataExportPurgeJob returns the job clearing the archives passing the expiration,
+// which are no longer downloadable but hold the personal data
+func (dc *DataExportController) DataExportPurgeJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     dataExportPurgeJobName,
+		Interval: globals.Conf.DataExport.PurgeInterval,
+		Run: func(ctx context.Context) error {
+			return dc.Storage.ClearExpiredUserDataExports(time.Now())
+		},
+	}
+}
+
+// buildArchive collects the personal data of the user and compresses them into a zip archive.
+// The nested records are exported as JSON, and the flat ones are exported as CSV as well.
+func (dc *DataExportController) buildArchive(userID uint) (models.User, []byte, error) {
+	id := fmt.Sprint(userID)
+
+	user, err := dc.Storage.GetUserByID(id)
+	if err != nil {
+		return user, nil, err
+	}
+
+	// GetRoles fails if the user has no roles
+	roles, err := dc.Storage.GetRoles(user)
+	if err != nil {
+		if len(user.Roles) > 0 {
+			return user, nil, err
+		}
+		roles = []models.Role{}
+	}
+
+	oauthAccounts, err := dc.Storage.GetOAuthAccountsOfUser(userID)
+	if err != nil {
+		return user, nil, err
+	}
+
+	webPushSubs, err := dc.Storage.GetWebPushSubscriptionsOfAUser(userID)
+	if err != nil {
+		return user, nil, err
+	}
+
+	var bookmarks []models.UserBookmark
+	for offset := 0; ; offset += dataExportPageSize {
+		page, total, err := dc.Storage.GetBookmarksOfAUser(id, dataExportPageSize, offset)
+		if err != nil {
+			return user, nil, err
+		}
+		bookmarks = append(bookmarks, page...)
+		if len(page) == 0 || offset+len(page) >= total {
+			break
+		}
+	}
+
+	footprints := make([][]string, 0)
+	for offset := 0; ; offset += dataExportPageSize {
+		page, total, err := dc.AnalyticsStorage.GetFootprintsOfAUser(id, dataExportPageSize, offset)
+		if err != nil {
+			return user, nil, errors.WithStack(err)
+		}
+		for _, f := range page {
+			footprints = append(footprints, []string{f.PostID, f.UpdatedAt.Format(time.RFC3339)})
+		}
+		if len(page) == 0 || offset+len(page) >= total {
+			break
+		}
+	}
+
+	var readingTimes []models.UsersPostsReadingTime
+	for offset := 0; ; offset += dataExportPageSize {
+		page, total, err := dc.AnalyticsStorage.GetReadingTimesOfAUser(id, dataExportPageSize, offset)
+		if err != nil {
+			return user, nil, errors.WithStack(err)
+		}
+		readingTimes = append(readingTimes, page...)
+		if len(page) == 0 || offset+len(page) >= total {
+			break
+		}
+	}
+
+	donations, err := dc.getDonations(id)
+	if err != nil {
+		return user, nil, err
+	}
+
+	payments := make(map[string][]models.Payment)
+	for _, d := range donations {
+		if d.Type != "periodic" || d.ID == 0 {
+			continue
+		}
+		for offset := 0; ; offset += dataExportPageSize {
+			page, total, err := dc.Storage.GetPaymentsOfAPeriodicDonation(d.ID, dataExportPageSize, offset)
+			if err != nil {
+				return user, nil, errors.WithStack(err)
+			}
+			payments[d.OrderNumber] = append(payments[d.OrderNumber], page...)
+			if len(page) == 0 || offset+len(page) >= total {
+				break
+			}
+		}
+	}
+
+	w := newArchiveWriter()
+
+	w.writeJSON("profile.json", exportedProfile(user))
+	w.writeJSON("roles.json", roles)
+	w.writeJSON("oauth_accounts.json", oauthAccounts)
+	w.writeJSON("web_push_subscriptions.json", webPushSubs)
+	w.writeJSON("bookmarks.json", bookmarks)
+	w.writeJSON("donations.json", gin.H{"donations": donations, "payments": payments})
+
+	bookmarkRows := make([][]string, 0, len(bookmarks))
+	for _, b := range bookmarks {
+		bookmarkRows = append(bookmarkRows, []string{b.Slug, b.Title, b.Host, b.Category, b.AddedAt.Format(time.RFC3339)})
+	}
+	w.writeCSV("bookmarks.csv", []string{"slug", "title", "host", "category", "added_at"}, bookmarkRows)
+
+	w.writeCSV("reading_footprints.csv", []string{"post_id", "read_at"}, footprints)
+
+	readingTimeRows := make([][]string, 0, len(readingTimes))
+	for _, r := range readingTimes {
+		readingTimeRows = append(readingTimeRows, []string{r.PostID, strconv.Itoa(r.Seconds), r.CreatedAt.Format(time.RFC3339)})
+	}
+	w.writeCSV("reading_times.csv", []string{"post_id", "seconds", "read_at"}, readingTimeRows)
+
+	donationRows := make([][]string, 0, len(donations))
+	for _, d := range donations {
+		donationRows = append(donationRows, []string{d.Type, d.OrderNumber, strconv.FormatUint(uint64(d.Amount), 10), d.Status, d.PayMethod, d.SendReceipt, d.CreatedAt.Format(time.RFC3339)})
+	}
+	w.writeCSV("donations.csv", []string{"type", "order_number", "amount", "status", "pay_method", "send_receipt", "created_at"}, donationRows)
+
+	paymentRows := make([][]string, 0)
+	for orderNumber, ps := range payments {
+		for _, p := range ps {
+			paymentRows = append(paymentRows, []string{orderNumber, p.OrderNumber, strconv.FormatUint(uint64(p.Amount), 10), p.Status, p.CreatedAt.Format(time.RFC3339)})
+		}
+	}
+	w.writeCSV("payments.csv", []string{"periodic_order_number", "order_number", "amount", "status", "created_at"}, paymentRows)
+
+	archive, err := w.close()
+	return user, archive, err
+}
+
+// getDonations reads the donations of the user, including the offline ones if member cms is integrated.
+// The donations from member cms have no ids, so their payments are not exported.
+func (dc *DataExportController) getDonations(userID string) ([]models.GeneralDonation, error) {
+	var donations []models.GeneralDonation
+
+	for offset := 0; ; offset += dataExportPageSize {
+		var page []models.GeneralDonation
+		var total int
+		var err error
+
+		if !globals.Conf.Features.MemberCMS || !globals.Conf.Features.OfflineDonation {
+			page, total, err = dc.Storage.GetDonationsOfAUser(userID, dataExportPageSize, offset)
+		} else {
+			page, total, err = dc.Storage.GetDonationsOfAUserFromMemberCMS(userID, dataExportPageSize, offset, true)
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		donations = append(donations, page...)
+		if len(page) == 0 || offset+len(page) >= total {
+			break
+		}
+	}
+
+	return donations, nil
+}
+
+// exportedProfile returns the profile of the user without the credentials and the associations
+func exportedProfile(user models.User) gin.H {
+	return gin.H{
+		"id":                    user.ID,
+		"created_at":            user.CreatedAt,
+		"email":                 user.Email,
+		"name":                  user.Name,
+		"firstname":             user.FirstName,
+		"lastname":              user.LastName,
+		"nickname":              user.Nickname,
+		"title":                 user.Title,
+		"legal_name":            user.LegalName,
+		"security_id":           user.SecurityID,
+		"passport_id":           user.PassportID,
+		"city":                  user.City,
+		"state":                 user.State,
+		"country":               user.Country,
+		"zip":                   user.Zip,
+		"address":               user.Address,
+		"phone":                 user.Phone,
+		"birthday":              user.Birthday,
+		"gender":                user.Gender,
+		"age_range":             user.AgeRange,
+		"education":             user.Education,
+		"read_preference":       user.ReadPreference,
+		"words_for_twreporter":  user.WordsForTwreporter,
+		"enable_email":          user.EnableEmail,
+		"activated":             user.Activated,
+		"source":                user.Source,
+		"read_posts_count":      user.ReadPostsCount,
+		"read_posts_sec":        user.ReadPostsSec,
+		"registration_date":     user.RegistrationDate,
+		"agree_data_collection": user.AgreeDataCollection,
+		"should_merge_offline_donation_by_identity": user.ShouldMergeOfflineDonation,
+	}
+}
+
+// archiveWriter writes files into a zip archive, and keeps the first error occurred
+type archiveWriter struct {
+	buf bytes.Buffer
+	zw  *zip.Writer
+	err error
+}
+
+func newArchiveWriter() *archiveWriter {
+	w := &archiveWriter{}
+	w.zw = zip.NewWriter(&w.buf)
+	return w
+}
+
+func (w *archiveWriter) writeJSON(name string, v interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	f, err := w.zw.Create(name)
+	if err != nil {
+		w.err = errors.WithStack(err)
+		return
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(v); err != nil {
+		w.err = errors.Wrap(err, fmt.Sprintf("can not write %s", name))
+	}
+}
+
+func (w *archiveWriter) writeCSV(name string, header []string, rows [][]string) {
+	if w.err != nil {
+		return
+	}
+
+	f, err := w.zw.Create(name)
+	if err != nil {
+		w.err = errors.WithStack(err)
+		return
+	}
+
+	cw := csv.NewWriter(f)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	if err = cw.Error(); err != nil {
+		w.err = errors.Wrap(err, fmt.Sprintf("can not write %s", name))
+	}
+}
+
+func (w *archiveWriter) close() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	if err := w.zw.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return w.buf.Bytes(), nil
+}
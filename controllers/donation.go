@@ -37,15 +37,18 @@ const (
 	defaultCreditCardMerchant = "GlobalTesting_CTBC"
 	defaultLineMerchant       = "GlobalTesting_LINEPAY" // TODO: Need to revise after the application is done
 
+	defaultOfflinePaymentTTL = 72 * time.Hour
+
 	invalidPayMethodID = -1
 
 	orderPrefix = "twreporter"
 
-	statusPaying  = "paying"
-	statusPaid    = "paid"
-	statusFail    = "fail"
-	statusStopped = "stopped"
-	statusInvalid = "invalid"
+	statusPaying   = "paying"
+	statusPaid     = "paid"
+	statusFail     = "fail"
+	statusStopped  = "stopped"
+	statusInvalid  = "invalid"
+	statusRefunded = "refunded"
 
 	tapPayRespStatusSuccess     = 0
 	tapPayRespStatusCardError   = 10003
@@ -66,6 +69,7 @@ const (
 	payMethodApple      = "apple"
 	payMethodSamsung    = "samsung"
 	payMethodATM        = "atm"
+	payMethodCVS        = "cvs"
 
 	linePayMethodCreditCard = "CREDIT_CARD"
 	linePayMethodBalance    = "BALANCE"
@@ -88,6 +92,13 @@ var payMethodCollections = []string{
 	payMethodApple,
 	payMethodSamsung,
 	payMethodATM,
+	payMethodCVS,
+}
+
+// pay methods paid by the donor after the virtual account or the payment code is issued
+var offlinePayMethods = []string{
+	payMethodATM,
+	payMethodCVS,
 }
 
 var payMethodMap = map[string]string{
@@ -96,6 +107,8 @@ var payMethodMap = map[string]string{
 	payMethodGoogle:     "Google Pay",
 	payMethodApple:      "Apple Pay",
 	payMethodSamsung:    "Samsung Pay",
+	payMethodATM:        "ATM 轉帳",
+	payMethodCVS:        "超商代碼繳費",
 }
 
 var methodToMerchant = map[string]string{
@@ -130,6 +143,7 @@ type (
 		Prime        string            `json:"prime" binding:"required"`
 		UserID       uint              `json:"user_id" binding:"required"`
 		MaxPaidTimes uint              `json:"max_paid_times"`
+		Campaign     string            `json:"campaign"`
 	}
 
 	clientResp struct {
@@ -149,6 +163,10 @@ type (
 		IsAnonymous      bool              `json:"is_anonymous"`
 		PaymentUrl       string            `json:"payment_url"`
 		AutoTaxDeduction bool              `json:"auto_tax_deduction"`
+
+		PaymentCode      null.String `json:"payment_code"`
+		PaymentBankCode  null.String `json:"payment_bank_code"`
+		PaymentExpiresAt null.Time   `json:"payment_expires_at"`
 	}
 
 	bankTransactionTime struct {
@@ -178,6 +196,7 @@ type (
 		Remember               bool              `json:"remember"`
 		ResultUrl              linePayResultUrl  `json:"result_url"`
 		LinePayProductImageUrl null.String       `json:"line_pay_product_image_url"`
+		ExpireTimeMillis       int64             `json:"expire_time_millis,omitempty"`
 	}
 
 	tapPayTransactionResp struct {
@@ -191,6 +210,10 @@ type (
 		PaymentUrl            string              `json:"payment_url"`
 		Amount                int                 `json:"amount"`
 		OrderNumber           string              `json:"order_number"`
+		RefundID              string              `json:"refund_id"`
+		PaymentCode           string              `json:"payment_code"`
+		BankCode              string              `json:"bank_code"`
+		ExpireTimeMillis      int64               `json:"expire_time_millis"`
 	}
 
 	tapPayMinTransactionResp struct {
@@ -198,8 +221,16 @@ type (
 		Msg    string `json:"msg"`
 	}
 
+	// https://docs.tappaysdk.com/tutorial/zh/back.html#record-api trade_records
 	tradeRecord struct {
-		RecordStatus int `json:"record_status"`
+		RecordStatus          int    `json:"record_status"`
+		RecTradeID            string `json:"rec_trade_id"`
+		BankTransactionID     string `json:"bank_transaction_id"`
+		OrderNumber           string `json:"order_number"`
+		Amount                uint   `json:"amount"`
+		RefundedAmount        uint   `json:"refunded_amount"`
+		Currency              string `json:"currency"`
+		TransactionTimeMillis int64  `json:"time"`
 	}
 
 	tapPayTransactionRecordResp struct {
@@ -284,10 +315,11 @@ func (req clientReq) BuildTapPayReq(orderNumber, details, payMethod string) tapP
 
 	primeReq.Details = details
 
+	// the merchant given by the client is validated against the configured one
 	if req.MerchantID != "" {
 		primeReq.MerchantID = req.MerchantID
 	} else {
-		primeReq.MerchantID = methodToMerchant[payMethod]
+		primeReq.MerchantID = getMerchantID(primeReq.Currency, payMethod)
 	}
 
 	primeReq.Cardholder = req.Cardholder
@@ -315,26 +347,43 @@ func (req clientReq) BuildTapPayReq(orderNumber, details, payMethod string) tapP
 	if payMethod == payMethodLine {
 		frontendRedirectUrl := "https://" + globals.Conf.Donation.FrontendHost + "/contribute/line/" + f + "/" + orderNumber
 
-		// Tappay server will validate the hosts provided in the result_url
-		// Wrap the backendHost to be test.twreporter.org if not in the staging or production environment
-		backendHost := ""
-		if globals.Conf.Environment == "production" || globals.Conf.Environment == "staging" {
-			backendHost = globals.Conf.App.Host
-		} else {
-			backendHost = "test.twreporter.org"
-		}
-
 		primeReq.ResultUrl = linePayResultUrl{
 			FrontendRedirectUrl: frontendRedirectUrl,
-			BackendNotifyUrl:    "https://" + backendHost + "/v1/donations/prime/line-notify",
+			BackendNotifyUrl:    "https://" + getTapPayNotifyHost() + "/v1/donations/prime/line-notify",
 		}
 
 		primeReq.LinePayProductImageUrl = null.StringFrom(globals.Conf.Donation.LinePayProductImageUrl)
 	}
 
+	// The virtual account or the payment code is paid after the transaction is created,
+	// and tap pay notifies the result before it expires
+	if hasPayMethod(offlinePayMethods, payMethod) {
+		primeReq.ResultUrl = linePayResultUrl{
+			BackendNotifyUrl: "https://" + getTapPayNotifyHost() + "/v1/donations/prime/offline-notify",
+		}
+		primeReq.ExpireTimeMillis = time.Now().Add(getOfflinePaymentTTL()).Unix() * secToMsec
+	}
+
 	return *primeReq
 }
 
+// getTapPayNotifyHost returns the host of the endpoints notified by tap pay
+func getTapPayNotifyHost() string {
+	// Tappay server will validate the hosts provided in the result_url
+	// Wrap the backendHost to be test.twreporter.org if not in the staging or production environment
+	if globals.Conf.Environment == "production" || globals.Conf.Environment == "staging" {
+		return globals.Conf.App.Host
+	}
+	return "test.twreporter.org"
+}
+
+func getOfflinePaymentTTL() time.Duration {
+	if ttl := globals.Conf.Donation.OfflinePaymentTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultOfflinePaymentTTL
+}
+
 func (req clientReq) BuildDraftPeriodicDonation(orderNumber string) models.PeriodicDonation {
 	const defaultDetails = "一般線上定期定額捐款"
 	const defaultMaxPaidTimes = 2147483647
@@ -361,6 +410,7 @@ func (req clientReq) BuildDraftPeriodicDonation(orderNumber string) models.Perio
 
 	m.OrderNumber = orderNumber
 	m.Status = statusPaying
+	m.TWDEquivalent = toTWDEquivalent(normalizeCurrency(req.Currency), req.Amount)
 
 	// If MaxPaidTimes is not specified or zero value, set it to default maximum paid times.
 	if req.MaxPaidTimes != 0 {
@@ -391,6 +441,7 @@ func (req clientReq) BuildPrimeDraftRecord(orderNumber string, payMethod string)
 	m.PayMethod = payMethod
 	m.OrderNumber = orderNumber
 	m.Status = statusPaying
+	m.TWDEquivalent = toTWDEquivalent(normalizeCurrency(req.Currency), req.Amount)
 
 	return *m
 }
@@ -411,6 +462,7 @@ func (req clientReq) BuildTokenDraftRecord(orderNumber string) models.PayByCardT
 	m.MerchantID = req.MerchantID
 	m.OrderNumber = orderNumber
 	m.Status = statusPaying
+	m.TWDEquivalent = toTWDEquivalent(normalizeCurrency(req.Currency), req.Amount)
 
 	return *m
 }
@@ -449,6 +501,9 @@ func (cr *clientResp) BuildFromPrimeDonationModel(d models.PayByPrimeDonation) {
 	cr.Frequency = oneTimeFrequency
 	cr.IsAnonymous = d.IsAnonymous.ValueOrZero()
 	cr.AutoTaxDeduction = d.AutoTaxDeduction.ValueOrZero()
+	cr.PaymentCode = d.PaymentCode
+	cr.PaymentBankCode = d.PaymentBankCode
+	cr.PaymentExpiresAt = d.PaymentExpiresAt
 }
 
 func (cr *clientResp) BuildFromOtherMethodDonationModel(d models.PayByOtherMethodDonation) {
@@ -501,20 +556,22 @@ func bindRequestJSONBody(c *gin.Context, reqBody interface{}) (gin.H, error) {
 	return gin.H{}, nil
 }
 
-func (mc *MembershipController) sendDonationThankYouMail(body clientResp) {
-	var origin string
+// getSupportSiteOrigin returns the origin of the support site of the environment
+func getSupportSiteOrigin() string {
 	switch globals.Conf.Environment {
 	case globals.DevelopmentEnvironment:
-		origin = globals.SupportSiteDevOrigin
+		return globals.SupportSiteDevOrigin
 	case globals.StagingEnvironment:
-		origin = globals.SupportSiteStagingOrigin
+		return globals.SupportSiteStagingOrigin
 	case globals.ProductionEnvironment:
-		origin = globals.SupportSiteOrigin
+		return globals.SupportSiteOrigin
 	default:
-		origin = globals.SupportSiteOrigin
+		return globals.SupportSiteOrigin
 	}
+}
 
-	var donationLink string = origin + "/contribute/" + body.Frequency + "/" + body.OrderNumber + "?utm_source=supportsuccess&utm_medium=email"
+func (mc *MembershipController) sendDonationThankYouMail(body clientResp) {
+	var donationLink string = getSupportSiteOrigin() + "/contribute/" + body.Frequency + "/" + body.OrderNumber + "?utm_source=supportsuccess&utm_medium=email"
 
 	var donationType string
 	switch body.Frequency {
@@ -552,6 +609,39 @@ func (mc *MembershipController) sendDonationThankYouMail(body clientResp) {
 
 }
 
+// sendOfflinePaymentInstructionsMail sends the donor the virtual account or the payment code to pay before it expires
+func (mc *MembershipController) sendOfflinePaymentInstructionsMail(d models.PayByPrimeDonation) {
+	const taipeiLocationName = "Asia/Taipei"
+	location, _ := time.LoadLocation(taipeiLocationName)
+
+	currency := d.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	reqBody := offlinePaymentReqBody{
+		Amount:      d.Amount,
+		BankCode:    d.PaymentBankCode.ValueOrZero(),
+		Currency:    currency,
+		Email:       d.Cardholder.Email,
+		ExpiresAt:   d.PaymentExpiresAt.Time.In(location).Format("2006-01-02 15:04 UTC+8"),
+		Name:        d.Cardholder.Name.ValueOrZero(),
+		OrderNumber: d.OrderNumber,
+		PayMethod:   d.PayMethod,
+		PaymentCode: d.PaymentCode.ValueOrZero(),
+	}
+
+	if err := postMailServiceEndpoint(reqBody, fmt.Sprintf("http://localhost:%s/v1/%s", globals.LocalhostPort, globals.SendOfflinePaymentRoutePath)); err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("fail to send offline payment instructions of donation(order_number: %s)", d.OrderNumber))
+
+		if globals.Conf.Environment == "development" {
+			log.Errorf("%+v", err)
+		} else {
+			log.WithField("detail", err).Errorf("%s", f.FormatStack(err))
+		}
+	}
+}
+
 // sendRoleUpdateMessage sends a role update message via pub/sub
 func (mc *MembershipController) sendRoleUpdateMessage(email string) {
 	if mc.RoleUpdateService == nil {
@@ -625,10 +715,24 @@ func (mc *MembershipController) CreateAPeriodicDonationOfAUser(c *gin.Context) (
 		}}, nil
 	}
 
+	reqBody.Currency = normalizeCurrency(reqBody.Currency)
+	if failData := validateDonationCurrency(reqBody.Currency, payMethodCreditCard, reqBody.MerchantID, reqBody.Amount); failData != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	campaignID, failData, err := mc.getCampaignOfDonation(reqBody.Campaign)
+	if err != nil {
+		return toResponse(err)
+	}
+	if failData != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
 	// generate periodic donation order number
 	pdOrderNumber := generateOrderNumber(periodic, getPayMethodID(payMethodCollections[0]))
 	// Build a draft periodic donation record
 	periodicDonation := reqBody.BuildDraftPeriodicDonation(pdOrderNumber)
+	periodicDonation.CampaignID = campaignID
 
 	// generate token donation order number
 	dOrderNumber := generateOrderNumber(token, getPayMethodID(payMethodCollections[0]))
@@ -682,6 +786,8 @@ func (mc *MembershipController) CreateAPeriodicDonationOfAUser(c *gin.Context) (
 	// since the donation already succeeded, return transaction success even if the information patch fails
 	if err = mc.Storage.UpdatePeriodicAndCardTokenDonationInTRX(periodicDonation.ID, periodicDonation, tokenDonation); nil != err {
 		log.Infof("%v", err)
+	} else {
+		go mc.issueReceiptNumber(globals.TokenDonationType, tokenDonation.ID)
 	}
 
 	// build response for clients
@@ -760,10 +866,24 @@ func (mc *MembershipController) CreateADonationOfAUser(c *gin.Context) (int, gin
 		}}, nil
 	}
 
+	reqBody.Currency = normalizeCurrency(reqBody.Currency)
+	if failData := validateDonationCurrency(reqBody.Currency, payMethod, reqBody.MerchantID, reqBody.Amount); failData != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	campaignID, failData, err := mc.getCampaignOfDonation(reqBody.Campaign)
+	if err != nil {
+		return toResponse(err)
+	}
+	if failData != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
 	// generate token donation order number
 	dOrderNumber := generateOrderNumber(prime, getPayMethodID(payMethod))
 	// Build a draft card prime donation record
 	primeDonation := reqBody.BuildPrimeDraftRecord(dOrderNumber, payMethod)
+	primeDonation.CampaignID = campaignID
 
 	// Start Tappay transaction
 	// Build Tappay pay by prime request
@@ -803,6 +923,13 @@ func (mc *MembershipController) CreateADonationOfAUser(c *gin.Context) (int, gin
 	// wait for the line-notify endpoint to update the final transaction status
 	if primeDonation.PayMethod == payMethodLine {
 		tapPayResp.AppendRespOnPrimeDonation(&primeDonation, statusPaying)
+	} else if hasPayMethod(offlinePayMethods, primeDonation.PayMethod) {
+		// wait for the offline-notify endpoint after the donor pays
+		if tapPayResp.ExpireTimeMillis == 0 {
+			tapPayResp.ExpireTimeMillis = tapPayReq.ExpireTimeMillis
+		}
+		tapPayResp.AppendRespOnPrimeDonation(&primeDonation, statusPaying)
+		tapPayResp.AppendOfflinePaymentOnPrimeDonation(&primeDonation)
 	} else {
 		tapPayResp.AppendRespOnPrimeDonation(&primeDonation, statusPaid)
 	}
@@ -819,18 +946,19 @@ func (mc *MembershipController) CreateADonationOfAUser(c *gin.Context) (int, gin
 	resp.BuildFromPrimeDonationModel(primeDonation)
 	resp.PaymentUrl = tapPayResp.PaymentUrl
 
+	if primeDonation.PaymentCode.Valid {
+		go mc.sendOfflinePaymentInstructionsMail(primeDonation)
+	}
+
 	// only send mail if the transaction completed.
 	// send success mail asynchronously
 	if primeDonation.Status == statusPaid {
-		// generate receipt serial number
-		go func(id uint, transactionTime null.Time) {
-			receiptNumber, err := mc.Storage.GenerateReceiptSerialNumber(id, transactionTime)
-			if err != nil {
-				log.WithField("err", err).Errorf("failed to generate receipt number. primeID: %d, err: %s", id, f.FormatStack(err))
-			}
+		// issue receipt number
+		go func(id uint) {
+			receiptNumber := mc.issueReceiptNumber(globals.PrimeDonationType, id)
 			// post member cms to create receipt
 			go member.PostPrimeDonationReceipt(receiptNumber, "")
-		}(primeDonation.ID, primeDonation.TransactionTime)
+		}(primeDonation.ID)
 
 		// send donation successful email
 		go mc.sendDonationThankYouMail(*resp)
@@ -1146,28 +1274,19 @@ func (mc *MembershipController) GetPrimeDonationReceipt(c *gin.Context) {
 		return
 	}
 
-	req, err := member.GetPrimeDonationReceiptRequest(d.ReceiptNumber.ValueOrZero())
-	if err != nil {
-		status, obj, _ := toResponse(err)
-		c.JSON(status, obj)
-		return
-	}
+	// Set headers to indicate this is a file download
+	filename := fmt.Sprintf("%s.pdf", d.OrderNumber)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := getReceiptFromMemberCMS(member.GetPrimeDonationReceiptRequest(d.ReceiptNumber.ValueOrZero()))
 	if err != nil {
-		status, obj, _ := toResponse(err)
-		c.JSON(status, obj)
+		log.Infof("render receipt of donation %s since %v", d.OrderNumber, err)
+		renderReceipt(c, filename, func(w io.Writer) error {
+			return mc.renderPrimeDonationReceipt(w, d)
+		})
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		c.JSON(resp.StatusCode, gin.H{"status": "fail", "message": "cannot get receipt from member cms"})
-		return
-	}
-
-	// Set headers to indicate this is a file download
-	filename := fmt.Sprintf("%s.pdf", d.OrderNumber)
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Header("Content-Type", resp.Header.Get("Content-Type"))
 
@@ -1218,28 +1337,19 @@ func (mc *MembershipController) GetYearlyDonationReceipt(c *gin.Context) {
 		return
 	}
 
-	req, err := member.GetYearlyReceiptRequest(email, year)
-	if err != nil {
-		status, obj, _ := toResponse(err)
-		c.JSON(status, obj)
-		return
-	}
+	// Set headers to indicate this is a file download
+	filename := fmt.Sprintf("《報導者》%s年度贊助收據.pdf", year)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := getReceiptFromMemberCMS(member.GetYearlyReceiptRequest(email, year))
 	if err != nil {
-		status, obj, _ := toResponse(err)
-		c.JSON(status, obj)
+		log.Infof("render yearly receipt of user %d since %v", u.ID, err)
+		renderReceipt(c, filename, func(w io.Writer) error {
+			return mc.renderYearlyReceipt(w, u, yearInt)
+		})
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		c.JSON(resp.StatusCode, gin.H{"status": "fail", "message": "cannot get receipt from member cms"})
-		return
-	}
-
-	// Set headers to indicate this is a file download
-	filename := fmt.Sprintf("《報導者》%s年度贊助收據.pdf", year)
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Header("Content-Type", resp.Header.Get("Content-Type"))
 
@@ -1286,42 +1396,130 @@ func (mc *MembershipController) GetVerificationInfoOfADonation(c *gin.Context) (
 	}}, nil
 }
 
+// PatchLinePayOfAUser handles the notification of the line pay transaction sent by tap pay.
 func (mc *MembershipController) PatchLinePayOfAUser(c *gin.Context) (int, gin.H, error) {
+	return mc.notifyTapPayTransaction(c, []string{payMethodLine})
+}
+
+// PatchOfflinePaymentOfAUser handles the notification sent by tap pay
+// after the donor pays the ATM virtual account or the payment code at the convenience store.
+func (mc *MembershipController) PatchOfflinePaymentOfAUser(c *gin.Context) (int, gin.H, error) {
+	return mc.notifyTapPayTransaction(c, offlinePayMethods)
+}
+
+// notifyTapPayTransaction keeps every callback along with its response for disputes.
+func (mc *MembershipController) notifyTapPayTransaction(c *gin.Context, payMethods []string) (int, gin.H, error) {
+	n := models.TapPayNotification{RemoteIP: tapPayNotifyRemoteIP(c.Request)}
+
+	statusCode, obj, err := mc.handleTapPayNotification(c, &n, payMethods)
+
+	// the body is cached by the binding of the callback payload
+	if body, readErr := getRequestBody(c); readErr == nil {
+		n.Payload = string(body)
+	}
+	n.ResponseStatus = statusCode
+
+	if createErr := mc.Storage.CreateTapPayNotification(&n); createErr != nil {
+		log.Errorf("%+v", createErr)
+	}
+
+	return statusCode, obj, err
+}
+
+// handleTapPayNotification finalises the paying donation of payMethods notified by the callback.
+// Since the endpoint is unauthenticated, the callback is only accepted from the allowed IPs,
+// and the final status is taken from the record of tap pay rather than the callback.
+// The finalised donation is not updated again, so that the replayed callback takes no effect.
+func (mc *MembershipController) handleTapPayNotification(c *gin.Context, n *models.TapPayNotification, payMethods []string) (int, gin.H, error) {
 	var callbackPayload tapPayTransactionResp
 
+	if !isTapPayNotifyIPAllowed(n.RemoteIP) {
+		log.Infof("Tap pay notification from %s is not allowed", n.RemoteIP)
+		n.Note = "ip is not allowed"
+		return http.StatusForbidden, gin.H{}, nil
+	}
+
 	if failData, err := bindRequestJSONBody(c, &callbackPayload); err != nil {
 		log.Infof("Fail to bind callback payload, %v", failData)
+		n.Note = "invalid payload"
 		return http.StatusBadRequest, gin.H{}, nil
 	}
 
+	n.OrderNumber = callbackPayload.OrderNumber
+	n.RecTradeID = callbackPayload.TappayResp.RecTradeID
+
 	// Validate Line Pay Method if PayInfo is set
-	if callbackPayload.PayInfo.Method.IsZero() == false {
+	if hasPayMethod(payMethods, payMethodLine) && callbackPayload.PayInfo.Method.IsZero() == false {
 		if valid := validateLinePayMethod(callbackPayload.PayInfo.Method.String); valid == false {
 			log.Infof("Invalid line pay method %s, should be %s", callbackPayload.PayInfo.Method.String, strings.Join(linePayMethods, ","))
+			n.Note = "invalid line pay method"
 			return http.StatusBadRequest, gin.H{}, nil
 		}
 	}
 
-	updateData := models.PayByPrimeDonation{}
-	if tapPayRespStatusSuccess == callbackPayload.Status {
-		callbackPayload.AppendLinePayOnPrimeDonation(&updateData, statusPaid)
-	} else {
-		callbackPayload.AppendLinePayOnPrimeDonation(&updateData, statusFail)
-	}
 	conditions := map[string]interface{}{
 		"order_number":        callbackPayload.OrderNumber,
 		"rec_trade_id":        callbackPayload.TappayResp.RecTradeID,
 		"bank_transaction_id": callbackPayload.TappayResp.BankTransactionID,
 		"amount":              callbackPayload.Amount,
 	}
+
+	var d models.PayByPrimeDonation
+	if err := mc.Storage.GetByConditions(conditions, &d); err != nil {
+		if storage.IsNotFound(err) {
+			log.Infof("No corresponding record to patch, condition: %v", conditions)
+			n.Note = "donation is not found"
+			return http.StatusUnprocessableEntity, gin.H{}, nil
+		}
+		return http.StatusInternalServerError, gin.H{}, err
+	}
+
+	if !hasPayMethod(payMethods, d.PayMethod) {
+		log.Infof("Donation paid by %s is not notified by this endpoint, condition: %v", d.PayMethod, conditions)
+		n.Note = fmt.Sprintf("donation is paid by %s", d.PayMethod)
+		return http.StatusUnprocessableEntity, gin.H{}, nil
+	}
+
+	if d.Status != statusPaying {
+		n.Note = fmt.Sprintf("donation is already %s", d.Status)
+		return http.StatusOK, gin.H{}, nil
+	}
+
+	record, found, err := queryTapPayRecord(d.OrderNumber, d.CreatedAt)
+	if err != nil {
+		n.Note = "can not query record of tap pay"
+		return http.StatusInternalServerError, gin.H{}, err
+	}
+
+	if !found || record.RecTradeID != d.RecTradeID || record.Amount != d.Amount {
+		log.Infof("Tap pay notification mismatches the record of tap pay, condition: %v", conditions)
+		n.Note = "callback mismatches the record of tap pay"
+		return http.StatusUnprocessableEntity, gin.H{}, nil
+	}
+
+	status, finished := record.donationStatus()
+	if !finished {
+		n.Note = "transaction is not finished on tap pay"
+		return http.StatusUnprocessableEntity, gin.H{}, nil
+	}
+
+	if (tapPayRespStatusSuccess == callbackPayload.Status) != (statusPaid == status) {
+		n.Note = fmt.Sprintf("callback status(%d) mismatches record status(%d) of tap pay", callbackPayload.Status, record.RecordStatus)
+	}
+
+	updateData := models.PayByPrimeDonation{}
+	callbackPayload.AppendLinePayOnPrimeDonation(&updateData, status)
+
+	// the donation might be finalised by the reconciler meanwhile
+	conditions["status"] = statusPaying
 	err, rowsAffected := mc.Storage.UpdateByConditions(conditions, updateData)
 
 	switch {
 	case err != nil:
 		return http.StatusInternalServerError, gin.H{}, err
 	case rowsAffected == 0:
-		log.Infof("No corresponding record to patch, condition: %v", conditions)
-		return http.StatusUnprocessableEntity, gin.H{}, nil
+		n.Note = "donation is finalised by others"
+		return http.StatusOK, gin.H{}, nil
 	}
 
 	if updateData.Status == statusPaid {
@@ -1332,15 +1530,12 @@ func (mc *MembershipController) PatchLinePayOfAUser(c *gin.Context) (int, gin.H,
 			"order_number": callbackPayload.OrderNumber,
 		}, &d)
 
-		// generate receipt serial number
-		go func(id uint, transactionTime null.Time) {
-			receiptNumber, err := mc.Storage.GenerateReceiptSerialNumber(id, transactionTime)
-			if err != nil {
-				log.WithField("err", err).Errorf("failed to generate receipt number. primeID: %d, err: %s", id, f.FormatStack(err))
-			}
+		// issue receipt number
+		go func(id uint) {
+			receiptNumber := mc.issueReceiptNumber(globals.PrimeDonationType, id)
 			// post member cms to create receipt
 			go member.PostPrimeDonationReceipt(receiptNumber, "")
-		}(d.ID, d.TransactionTime)
+		}(d.ID)
 
 		// send donation successful email
 		mail.BuildFromPrimeDonationModel(d)
@@ -1546,6 +1741,13 @@ func (resp tapPayTransactionResp) AppendRespOnTokenDonation(m *models.PayByCardT
 	m.Status = status
 }
 
+// AppendOfflinePaymentOnPrimeDonation records the virtual account or the payment code issued by tap pay
+func (resp tapPayTransactionResp) AppendOfflinePaymentOnPrimeDonation(m *models.PayByPrimeDonation) {
+	m.PaymentCode = null.NewString(resp.PaymentCode, resp.PaymentCode != "")
+	m.PaymentBankCode = null.NewString(resp.BankCode, resp.BankCode != "")
+	m.PaymentExpiresAt = null.TimeFrom(time.Unix(resp.ExpireTimeMillis/secToMsec, (resp.ExpireTimeMillis%secToMsec)*msecToNanosec))
+}
+
 func (resp tapPayTransactionResp) AppendLinePayOnPrimeDonation(m *models.PayByPrimeDonation, status string) {
 	m.PayInfo = resp.PayInfo
 	m.TappayApiStatus = null.IntFrom(resp.Status)
@@ -1574,6 +1776,10 @@ func decrypt(data string, key string) string {
 	nonceSize := gcm.NonceSize()
 
 	byteData := []byte(data)
+	if len(byteData) < nonceSize {
+		log.Infof("%+v", errors.New("ciphertext is shorter than the nonce"))
+		return ""
+	}
 	nonce, ciphertext := byteData[:nonceSize], byteData[nonceSize:]
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if nil != err {
@@ -1659,9 +1865,14 @@ func handleTapPayBodyParseError(body []byte) (tapPayTransactionResp, error) {
 }
 
 func serveHttp(key string, reqBodyJson []byte) (tapPayTransactionResp, error) {
+	return serveTapPay(globals.Conf.Donation.TapPayURL, key, reqBodyJson)
+}
+
+// serveTapPay posts the request to the tap pay api of tapPayURL
+func serveTapPay(tapPayURL string, key string, reqBodyJson []byte) (tapPayTransactionResp, error) {
 	client := getProxyHttpClient()
 
-	req, _ := http.NewRequest("POST", globals.Conf.Donation.TapPayURL, bytes.NewBuffer(reqBodyJson))
+	req, _ := http.NewRequest("POST", tapPayURL, bytes.NewBuffer(reqBodyJson))
 	req.Header.Add("x-api-key", key)
 	req.Header.Add("Content-Type", "application/json")
 
@@ -1705,6 +1916,17 @@ func validatePayMethod(payMethod string) error {
 	return errors.New(errMsg)
 }
 
+// hasPayMethod checks whether payMethod is one of payMethods
+func hasPayMethod(payMethods []string, payMethod string) bool {
+	for _, m := range payMethods {
+		if m == payMethod {
+			return true
+		}
+	}
+
+	return false
+}
+
 func validateLinePayMethod(method string) bool {
 	valid := false
 
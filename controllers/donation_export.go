@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/internal/donationexport"
+)
+
+// ExportDonations streams the prime, token and other-method donations matching the filters in the query
+// to the finance in CSV by default or in XLSX, with the personal data masked if mask is true
+func (mc *MembershipController) ExportDonations(c *gin.Context) {
+	format := c.DefaultQuery("format", donationexport.FormatCSV)
+	if !donationexport.IsValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Query.format": fmt.Sprintf("format: %s is not supported", format)}})
+		return
+	}
+
+	mask, err := strconv.ParseBool(c.DefaultQuery("mask", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Query.mask": fmt.Sprintf("mask: %s is not a boolean", c.Query("mask"))}})
+		return
+	}
+
+	filter, err := donationexport.ParseFilter(c.Request.URL.Query())
+	if err != nil {
+		e := err.(*donationexport.InvalidParamError)
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Query." + e.Param: e.Reason}})
+		return
+	}
+
+	filename := fmt.Sprintf("donations-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", donationexport.ContentType(format))
+
+	count, err := donationexport.Write(c.Writer, mc.Storage, filter, format, mask)
+	if err != nil {
+		// the export is broken off if the donations are being written
+		if c.Writer.Written() {
+			log.Errorf("export is broken off after %d donations: %+v", count, err)
+			return
+		}
+
+		c.Writer.Header().Del("Content-Disposition")
+		c.Writer.Header().Del("Content-Type")
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+	}
+}
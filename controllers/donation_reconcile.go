@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	member "github.com/twreporter/go-api/internal/member_cms"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+)
+
+// donationReconcileJobName names the lease of the job reconciling the donations stuck in paying
+const donationReconcileJobName = "donation_reconcile"
+
+// record statuses of the record api of tap pay
+// https://docs.tappaysdk.com/tutorial/zh/back.html#record-api
+const (
+	tapPayRecordStatusError           = -1
+	tapPayRecordStatusAuth            = 0
+	tapPayRecordStatusOK              = 1
+	tapPayRecordStatusPartialRefunded = 2
+	tapPayRecordStatusRefunded        = 3
+	tapPayRecordStatusPending         = 4
+	tapPayRecordStatusCancel          = 5
+)
+
+// donationStatus maps the record status of tap pay to the status of the donation.
+// It reports false if the transaction is not finished yet.
+func (r tradeRecord) donationStatus() (string, bool) {
+	switch r.RecordStatus {
+	case tapPayRecordStatusAuth, tapPayRecordStatusOK, tapPayRecordStatusPartialRefunded:
+		return statusPaid, true
+	case tapPayRecordStatusRefunded:
+		return statusRefunded, true
+	case tapPayRecordStatusError, tapPayRecordStatusCancel:
+		return statusFail, true
+	default:
+		return "", false
+	}
+}
+
+// tappayResp returns the fields of the tap pay response recorded on the donation
+func (r tradeRecord) tappayResp() models.TappayResp {
+	resp := models.TappayResp{
+		RecTradeID:         r.RecTradeID,
+		BankTransactionID:  r.BankTransactionID,
+		TappayRecordStatus: null.IntFrom(int64(r.RecordStatus)),
+	}
+
+	if r.TransactionTimeMillis > 0 {
+		resp.TransactionTime = null.TimeFrom(time.Unix(r.TransactionTimeMillis/secToMsec, (r.TransactionTimeMillis%secToMsec)*msecToNanosec))
+	}
+
+	return resp
+}
+
+// queryTapPayRecord queries the record of the order created at createdAt through the record api of tap pay.
+// It reports false if tap pay has no record of the order, which means the transaction never reached tap pay.
+func queryTapPayRecord(orderNumber string, createdAt time.Time) (tradeRecord, bool, error) {
+	q := queryReq{
+		Filters: queryFilter{
+			OrderNumber: orderNumber,
+			Time: &queryFilterTime{
+				StartTime: null.IntFrom(createdAt.Add(-time.Hour).Unix() * secToMsec),
+				EndTime:   null.IntFrom(time.Now().Unix() * secToMsec),
+			},
+		},
+	}
+
+	resp, err := q.QueryServer()
+	if err != nil {
+		return tradeRecord{}, false, errors.Wrap(err, fmt.Sprintf("can not query record of order(%s)", orderNumber))
+	}
+
+	if resp.Status != tapPayRespStatusSuccess {
+		return tradeRecord{}, false, errors.New(fmt.Sprintf("can not query record of order(%s), status: %d, msg: %s", orderNumber, resp.Status, resp.Msg))
+	}
+
+	if len(resp.TradeRecords) == 0 {
+		return tradeRecord{}, false, nil
+	}
+
+	return resp.TradeRecords[0], true, nil
+}
+
+// reportTapPayRecordMismatch reports the donation whose amount or currency differs from the record of tap pay,
+// or which is partially refunded on tap pay
+func reportTapPayRecordMismatch(orderNumber string, amount uint, currency string, r tradeRecord) {
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	amountMismatched := r.Amount != amount
+	currencyMismatched := r.Currency != "" && r.Currency != currency
+	partialRefunded := r.RecordStatus == tapPayRecordStatusPartialRefunded
+
+	if !amountMismatched && !currencyMismatched && !partialRefunded {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"order_number":           orderNumber,
+		"amount":                 amount,
+		"currency":               currency,
+		"tappay_amount":          r.Amount,
+		"tappay_currency":        r.Currency,
+		"tappay_refunded_amount": r.RefundedAmount,
+		"tappay_record_status":   r.RecordStatus,
+	}).Errorf("donation(order: %s) mismatches the record of tap pay", orderNumber)
+}
+
+// DonationReconcileJob returns the job reconciling the donations stuck in paying
+func (mc *MembershipController) DonationReconcileJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     donationReconcileJobName,
+		Interval: globals.Conf.DonationReconciler.Interval,
+		Run:      mc.ReconcilePayingDonations,
+	}
+}
+
+// ReconcilePayingDonations finalises the prime and card token donations which are paying longer than StaleAfter
+// by their records of tap pay, e.g. the process dies before the response of tap pay is recorded.
+// The donations whose transactions are still pending on tap pay are left paying,
+// unless the virtual account or the payment code of the offline payment expires.
+func (mc *MembershipController) ReconcilePayingDonations(ctx context.Context) error {
+	conf := globals.Conf.DonationReconciler
+	before := time.Now().Add(-conf.StaleAfter)
+
+	primeDonations, err := mc.Storage.GetStalePayingPrimeDonations(before, conf.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range primeDonations {
+		if err = ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		mc.reconcilePrimeDonation(d)
+	}
+
+	tokenDonations, err := mc.Storage.GetStalePayingTokenDonations(before, conf.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, td := range tokenDonations {
+		if err = ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		mc.reconcileTokenDonation(td)
+	}
+
+	return nil
+}
+
+func (mc *MembershipController) reconcilePrimeDonation(d models.PayByPrimeDonation) {
+	logger := log.WithField("order_number", d.OrderNumber)
+
+	record, found, err := queryTapPayRecord(d.OrderNumber, d.CreatedAt)
+	if err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+
+	u := models.PayByPrimeDonation{ID: d.ID, OrderNumber: d.OrderNumber, Status: statusFail}
+	if found {
+		status, finished := record.donationStatus()
+		switch {
+		case finished:
+			reportTapPayRecordMismatch(d.OrderNumber, d.Amount, d.Currency, record)
+			u.Status = status
+			u.TappayResp = record.tappayResp()
+		case !d.PaymentExpiresAt.Valid:
+			return
+		default:
+			// the expired virtual account or payment code could not be paid anymore
+			u.Msg = "offline payment expired"
+		}
+	}
+
+	reconciled, err := mc.Storage.ReconcilePrimeDonation(u)
+	if err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+
+	// the donation is finalised by others, e.g. the line pay notification
+	if !reconciled {
+		return
+	}
+
+	logger.Infof("prime donation is reconciled, status: %s", u.Status)
+
+	if u.Status != statusPaid {
+		return
+	}
+
+	d.Status = u.Status
+	d.TappayResp = u.TappayResp
+
+	go func(id uint) {
+		receiptNumber := mc.issueReceiptNumber(globals.PrimeDonationType, id)
+		// post member cms to create receipt
+		go member.PostPrimeDonationReceipt(receiptNumber, "")
+	}(d.ID)
+
+	resp := new(clientResp)
+	resp.BuildFromPrimeDonationModel(d)
+	go mc.sendDonationThankYouMail(*resp)
+
+	if globals.Conf.Features.EnableRoleUpdatePubSub {
+		mc.sendRoleUpdateMessage(d.Cardholder.Email)
+	}
+}
+
+func (mc *MembershipController) reconcileTokenDonation(td models.PayByCardTokenDonation) {
+	logger := log.WithFields(log.Fields{"order_number": td.OrderNumber, "periodic_id": td.PeriodicID})
+
+	var pd models.PeriodicDonation
+	if err := mc.Storage.GetByConditions(map[string]interface{}{"id": td.PeriodicID}, &pd); err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+
+	record, found, err := queryTapPayRecord(td.OrderNumber, td.CreatedAt)
+	if err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+
+	u := models.PayByCardTokenDonation{ID: td.ID, OrderNumber: td.OrderNumber, PeriodicID: td.PeriodicID, Status: statusFail}
+	if found {
+		status, finished := record.donationStatus()
+		if !finished {
+			return
+		}
+		reportTapPayRecordMismatch(td.OrderNumber, td.Amount, td.Currency, record)
+		u.Status = status
+		u.TappayResp = record.tappayResp()
+	}
+
+	// the periodic donation is updated only if it is left paying by the charge
+	now := time.Now()
+	firstCharge := !pd.LastSuccessAt.Valid
+	var columns map[string]interface{}
+	switch {
+	case u.Status != statusFail:
+		lastSuccessAt := now
+		if u.TransactionTime.Valid {
+			lastSuccessAt = u.TransactionTime.Time
+		}
+		columns = map[string]interface{}{
+			"status":          statusPaid,
+			"last_success_at": lastSuccessAt,
+			"charge_failures": 0,
+			"next_retry_at":   nil,
+			"updated_at":      now,
+		}
+	case firstCharge:
+		// the first charge of the new periodic donation fails
+		columns = map[string]interface{}{
+			"status":     statusInvalid,
+			"updated_at": now,
+		}
+	default:
+		columns = failedPeriodicChargeColumns(pd, now)
+	}
+
+	reconciled, err := mc.Storage.ReconcileTokenDonation(u, columns)
+	if err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+
+	if !reconciled {
+		return
+	}
+
+	logger.Infof("card token donation is reconciled, status: %s", u.Status)
+
+	if u.Status == statusPaid {
+		mc.issueReceiptNumber(globals.TokenDonationType, u.ID)
+	}
+
+	if u.Status != statusPaid || !firstCharge {
+		return
+	}
+
+	// the card secret is only responded when the donation is created,
+	// so the periodic donation could not be charged again until the donor updates the card
+	logger.Warnf("card of periodic donation(order: %s) is not stored", pd.OrderNumber)
+
+	pd.Status = statusPaid
+	resp := new(clientResp)
+	resp.BuildFromPeriodicDonationModel(pd)
+	go mc.sendDonationThankYouMail(*resp)
+
+	if globals.Conf.Features.EnableRoleUpdatePubSub {
+		mc.sendRoleUpdateMessage(pd.Cardholder.Email)
+	}
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/donorsummary"
+)
+
+// donationSummaryPageSize is the number of donations read from member cms at a time
+const donationSummaryPageSize = 100
+
+// GetDonationSummaryOfAUser returns the totals of the donations of the user over the lifetime and by year,
+// the active periodic donations, the current role and the progress toward the next role.
+// The offline donations recorded in member cms are included if the user chooses to merge them.
+func (mc *MembershipController) GetDonationSummaryOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID := c.Param("userID")
+
+	user, err := mc.Storage.GetUserByID(userID)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	amounts, err := mc.Storage.GetDonationAmountsOfAUser(user.ID)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	donations := make([]donorsummary.Donation, len(amounts))
+	for i, a := range amounts {
+		donations[i] = donorsummary.Donation{Amount: a.TWDAmount, At: a.TransactionTime}
+	}
+
+	offlineMerged := false
+	if user.ShouldMergeOfflineDonation && globals.Conf.Features.MemberCMS && globals.Conf.Features.OfflineDonation {
+		offline, err := mc.getOfflineDonations(userID)
+		if err != nil {
+			// the online donations are still summarised if member cms is unavailable
+			log.Errorf("%+v", err)
+		} else {
+			donations = append(donations, offline...)
+			offlineMerged = true
+		}
+	}
+
+	periodic, err := mc.Storage.GetPeriodicDonationsOfAUser(user.ID)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	// the role of the highest weight is preloaded
+	var role gin.H
+	var roleKey string
+	if len(user.Roles) > 0 {
+		roleKey = user.Roles[0].Key
+		role = gin.H{
+			"key":     user.Roles[0].Key,
+			"name":    user.Roles[0].Name,
+			"name_en": user.Roles[0].NameEn,
+		}
+	}
+
+	s := donorsummary.Build(donations, periodic, roleKey, time.Now())
+
+	return http.StatusOK, gin.H{"status": "success", "data": gin.H{
+		"lifetime":           s.Lifetime,
+		"years":              s.Years,
+		"periodic_donations": s.PeriodicDonations,
+		"role":               role,
+		"next_role":          s.NextRole,
+		"offline_merged":     offlineMerged,
+	}}, nil
+}
+
+// getOfflineDonations reads the donations of the user from member cms, and keeps the offline ones
+// since the online ones are summarised from the database
+func (mc *MembershipController) getOfflineDonations(userID string) ([]donorsummary.Donation, error) {
+	var donations []donorsummary.Donation
+
+	for offset := 0; ; offset += donationSummaryPageSize {
+		page, total, err := mc.Storage.GetDonationsOfAUserFromMemberCMS(userID, donationSummaryPageSize, offset, true)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, d := range page {
+			switch {
+			case d.Type == globals.PrimeDonationType || d.Type == "periodic":
+				continue
+			case d.Status == statusFail || d.Status == statusRefunded:
+				continue
+			}
+			donations = append(donations, donorsummary.Donation{Amount: toReceiptAmount(d.Amount, d.TWDAmount, 0), At: d.CreatedAt})
+		}
+
+		if len(page) == 0 || offset+len(page) >= total {
+			break
+		}
+	}
+
+	return donations, nil
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	defaultIdempotencyKeyTTL  = 24 * time.Hour
+	idempotencyKeyFailDataKey = "req.Headers.Idempotency-Key"
+)
+
+// idempotencyKeyPurgeJobName names the lease of the job deleting the expired idempotency keys
+const idempotencyKeyPurgeJobName = "idempotency_key_purge"
+
+// validateIdempotencyKey checks the key is composed of 1 to 255 printable ASCII characters.
+// The trailing spaces are rejected since they are ignored by the comparison of mysql.
+func validateIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength || key[len(key)-1] == ' ' {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hashIdempotentRequest hashes the method, the route and the body of the request,
+// which should be the same among the requests sent with the same key
+func hashIdempotentRequest(method, route string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + route + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getRequestBody reads the body of the request and keeps it for the later binding
+func getRequestBody(c *gin.Context) ([]byte, error) {
+	if cb, ok := c.Get(gin.BodyBytesKey); ok {
+		if body, ok := cb.([]byte); ok {
+			return body, nil
+		}
+	}
+
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// Idempotent makes the handler creating donations idempotent with the Idempotency-Key header.
+// The response of the request is recorded along with the hash of the request,
+// and the request retried with the same key before the key expires is responded with the recorded response
+// without being processed again. The request sent with the same key but a different body is responded 409.
+//
+// The key of the request rejected with 4xx status code is released,
+// since nothing is charged and the client could retry with the same key after fixing the request.
+// Requests without the header are processed as usual.
+func (mc *MembershipController) Idempotent(fn func(c *gin.Context) (int, gin.H, error)) func(c *gin.Context) (int, gin.H, error) {
+	return func(c *gin.Context) (int, gin.H, error) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			return fn(c)
+		}
+
+		if !validateIdempotencyKey(key) {
+			return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{
+				idempotencyKeyFailDataKey: fmt.Sprintf("idempotency key should be 1 to %d printable ASCII characters not ending with a space", maxIdempotencyKeyLength),
+			}}, nil
+		}
+
+		body, err := getRequestBody(c)
+		if err != nil {
+			return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot read the request body"}, err
+		}
+
+		// the numbers in jwt claims are decoded as float64
+		userID, err := strconv.ParseFloat(fmt.Sprint(c.Request.Context().Value(globals.AuthUserIDProperty)), 64)
+		if err != nil {
+			return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot get the user of the request"}, errors.WithStack(err)
+		}
+
+		ttl := globals.Conf.Donation.IdempotencyKeyTTL
+		if ttl <= 0 {
+			ttl = defaultIdempotencyKeyTTL
+		}
+
+		route := c.FullPath()
+		k := models.IdempotencyKey{
+			UserID:      uint(userID),
+			Route:       route,
+			Key:         key,
+			RequestHash: hashIdempotentRequest(c.Request.Method, route, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+
+		existing, claimed, err := mc.Storage.ClaimIdempotencyKey(&k)
+		if err != nil {
+			return toResponse(err)
+		}
+
+		if !claimed {
+			return replayIdempotentRequest(c, k, existing)
+		}
+
+		statusCode, obj, err := fn(c)
+
+		if statusCode >= http.StatusBadRequest && statusCode < http.StatusInternalServerError {
+			if releaseErr := mc.Storage.ReleaseIdempotencyKey(k.ID); releaseErr != nil {
+				log.Errorf("%+v", releaseErr)
+			}
+			return statusCode, obj, err
+		}
+
+		// the request is already processed, so the response is returned even if it fails to be recorded
+		if resp, marshalErr := json.Marshal(obj); marshalErr != nil {
+			log.Errorf("%+v", errors.Wrap(marshalErr, fmt.Sprintf("can not marshal response of idempotency key(id: %d)", k.ID)))
+		} else if completeErr := mc.Storage.CompleteIdempotencyKey(k.ID, statusCode, string(resp)); completeErr != nil {
+			log.Errorf("%+v", completeErr)
+		}
+
+		return statusCode, obj, err
+	}
+}
+
+// replayIdempotentRequest responds the request retried with the recorded key
+func replayIdempotentRequest(c *gin.Context, k, existing models.IdempotencyKey) (int, gin.H, error) {
+	if existing.RequestHash != k.RequestHash {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{
+			idempotencyKeyFailDataKey: "idempotency key is already used by a different request",
+		}}, nil
+	}
+
+	if !existing.IsProcessed() {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{
+			idempotencyKeyFailDataKey: "request of the idempotency key is being processed, please try again later",
+		}}, nil
+	}
+
+	var obj gin.H
+	if err := json.Unmarshal([]byte(existing.Response.ValueOrZero()), &obj); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot replay the response of the idempotency key"},
+			errors.Wrap(err, fmt.Sprintf("can not unmarshal response of idempotency key(id: %d)", existing.ID))
+	}
+
+	c.Header(idempotentReplayedHeader, "true")
+
+	return int(existing.StatusCode.Int64), obj, nil
+}
+
+// IdempotencyKeyPurgeJob returns the job deleting the expired idempotency keys,
+// so that the recorded responses are not kept longer than needed
+func (mc *MembershipController) IdempotencyKeyPurgeJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     idempotencyKeyPurgeJobName,
+		Interval: globals.Conf.IdempotencyKeyPurge.Interval,
+		Run: func(ctx context.Context) error {
+			return mc.Storage.DeleteExpiredIdempotencyKeys(time.Now())
+		},
+	}
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+// identityProviders maps the provider in url path to the provider stored in database
+var identityProviders = map[string]string{
+	"google":   globals.GoogleOAuth,
+	"facebook": globals.FacebookOAuth,
+	"email":    models.IdentityProviderEmail,
+}
+
+// identityProvider returns the provider stored in database,
+// including the OIDC providers in config
+func identityProvider(name string) (string, bool) {
+	if provider, ok := identityProviders[name]; ok {
+		return provider, true
+	}
+
+	if conf, ok := globals.Conf.Oauth.OIDC[name]; ok && conf.Type != "" {
+		return conf.Type, true
+	}
+
+	return "", false
+}
+
+// newIdentityAuditLog returns the audit log of the request.
+// c could be nil if the change is not triggered by a request.
+func newIdentityAuditLog(c *gin.Context, userID uint, action, provider, identity string) models.IdentityAuditLog {
+	log := models.IdentityAuditLog{
+		UserID:   userID,
+		Action:   action,
+		Provider: provider,
+		Identity: null.NewString(identity, identity != ""),
+	}
+
+	if c != nil {
+		log.IP = null.StringFrom(c.ClientIP())
+		ua := c.Request.UserAgent()
+		if len(ua) > 255 {
+			ua = ua[:255]
+		}
+		log.UserAgent = null.NewString(ua, ua != "")
+	}
+
+	return log
+}
+
+func parseUserID(userID string) (uint, error) {
+	id, err := strconv.ParseUint(userID, 10, 0)
+	return uint(id), err
+}
+
+// GetIdentitiesOfAUser lists the login methods linked to the user
+func (mc *MembershipController) GetIdentitiesOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	accounts, err := mc.Storage.GetOAuthAccountsOfUser(userID)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	identities := make([]gin.H, 0, len(accounts)+1)
+	for _, account := range accounts {
+		identities = append(identities, gin.H{
+			"provider":  strings.ToLower(account.Type),
+			"email":     account.Email,
+			"name":      account.Name,
+			"linked_at": account.CreatedAt,
+		})
+	}
+
+	ra, err := mc.Storage.GetReporterAccountOfUser(userID)
+	if err == nil {
+		identities = append(identities, gin.H{
+			"provider":  "email",
+			"email":     ra.Email,
+			"name":      nil,
+			"linked_at": ra.CreatedAt,
+		})
+	} else if !storage.IsNotFound(err) {
+		return toResponse(err)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": gin.H{
+		"user_id":    userID,
+		"identities": identities,
+	}}, nil
+}
+
+// UnlinkAnIdentityOfAUser removes the login method of the provider from the user.
+// The last login method of the user could not be removed.
+func (mc *MembershipController) UnlinkAnIdentityOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	provider, ok := identityProvider(c.Param("provider"))
+	if !ok {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.provider": "provider is not supported"}}, nil
+	}
+
+	err = mc.Storage.UnlinkIdentity(userID, provider, newIdentityAuditLog(c, userID, models.IdentityActionUnlink, provider, ""))
+	switch {
+	case err == nil:
+	case storage.IsNotFound(err):
+		return http.StatusNotFound, gin.H{"status": "fail", "data": gin.H{"req.Params.provider": fmt.Sprintf("%s is not linked", c.Param("provider"))}}, nil
+	case storage.IsLastLoginMethod(err):
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.provider": "the last login method could not be unlinked"}}, nil
+	default:
+		return toResponse(err)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": gin.H{
+		"user_id":  userID,
+		"provider": c.Param("provider"),
+	}}, nil
+}
+
+// RequestEmailLinkOfAUser sends the otp code to the email which the user wants to sign in with
+func (mc *MembershipController) RequestEmailLinkOfAUser(c *gin.Context) (int, gin.H, error) {
+	var body struct {
+		Email string `json:"email" form:"email" binding:"required"`
+	}
+
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	if err = c.Bind(&body); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"email": "email is required"}}, nil
+	}
+
+	if _, err = mail.ParseAddress(body.Email); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"email": "email is malformed"}}, nil
+	}
+
+	if ok, retryAfter, err := mc.allowRequest("email_link", rateLimitKeyEmail, strings.ToLower(body.Email), globals.Conf.RateLimit.EmailLinkPerEmail); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Checking rate limit occurs error"}, err
+	} else if !ok {
+		statusCode, resp := tooManyRequests(c, retryAfter, gin.H{"email": "too many requests, please try again later"})
+		return statusCode, resp, nil
+	}
+
+	// a user has one email sign-in at most
+	if _, err = mc.Storage.GetReporterAccountOfUser(userID); err == nil {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"email": "email sign-in is already linked"}}, nil
+	} else if !storage.IsNotFound(err) {
+		return toResponse(err)
+	}
+
+	if _, err = mc.Storage.GetReporterAccountData(body.Email); err == nil {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"email": "email is linked to another account"}}, nil
+	} else if !storage.IsNotFound(err) {
+		return toResponse(err)
+	}
+
+	otpCode, err := utils.GenerateOTPCode(otpCodeDigits)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Generating OTP code occurs error"}, err
+	}
+
+	req := models.EmailLinkRequest{
+		UserID:      userID,
+		Email:       body.Email,
+		OtpCodeHash: utils.HashOTPCode(body.Email, otpCode),
+		OtpExpTime:  time.Now().Add(time.Duration(15) * time.Minute),
+	}
+	if err = mc.Storage.CreateEmailLinkRequest(req); err != nil {
+		return toResponse(err)
+	}
+
+	err = postMailServiceEndpoint(otpReqBody{
+		Email:   body.Email,
+		OtpCode: otpCode,
+	}, fmt.Sprintf("http://localhost:%s/v1/%s", globals.LocalhostPort, globals.SendOtpRoutePath))
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Sending OTP email occurs error"}, err
+	}
+
+	return http.StatusCreated, gin.H{"status": "success", "data": gin.H{
+		"email":      body.Email,
+		"expired_at": req.OtpExpTime.Format(time.RFC3339),
+	}}, nil
+}
+
+// VerifyEmailLinkOfAUser links the email sign-in to the user once the otp code is verified
+func (mc *MembershipController) VerifyEmailLinkOfAUser(c *gin.Context) (int, gin.H, error) {
+	var body struct {
+		Email   string `json:"email" form:"email" binding:"required"`
+		OtpCode string `json:"otp_code" form:"otp_code" binding:"required"`
+	}
+
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	if err = c.Bind(&body); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{
+			"email":    "email is required",
+			"otp_code": "otp code is required",
+		}}, nil
+	}
+
+	req, err := mc.Storage.GetEmailLinkRequest(userID)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return http.StatusNotFound, gin.H{"status": "fail", "data": gin.H{"email": "email link request is not found"}}, nil
+		}
+		return toResponse(err)
+	}
+
+	if !strings.EqualFold(req.Email, body.Email) || req.OtpExpTime.Before(time.Now()) {
+		return http.StatusForbidden, gin.H{"status": "fail", "data": gin.H{"email": "email link request is expired or mismatched"}}, nil
+	}
+
+	if !hmac.Equal([]byte(req.OtpCodeHash), []byte(utils.HashOTPCode(req.Email, body.OtpCode))) {
+		if err = mc.Storage.IncreaseFailedAttemptsOfEmailLinkRequest(userID, globals.Conf.RateLimit.OtpMaxFailedAttempts); err != nil {
+			return toResponse(err)
+		}
+		return http.StatusForbidden, gin.H{"status": "fail", "data": gin.H{"otp_code": "otp code invalid"}}, nil
+	}
+
+	ra := models.ReporterAccount{
+		UserID: userID,
+		Email:  req.Email,
+		// no activate token for the magic link is issued
+		ActExpTime: time.Now(),
+	}
+	err = mc.Storage.LinkReporterAccount(ra, newIdentityAuditLog(c, userID, models.IdentityActionLink, models.IdentityProviderEmail, req.Email))
+	if err != nil {
+		if storage.IsConflict(err) {
+			return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"email": "email is linked to another account"}}, nil
+		}
+		return toResponse(err)
+	}
+
+	return http.StatusCreated, gin.H{"status": "success", "data": gin.H{
+		"user_id":  userID,
+		"provider": "email",
+		"email":    req.Email,
+	}}, nil
+}
@@ -42,6 +42,55 @@ type donationSuccessReqBody struct {
 	OrderNumber       string   `json:"order_number" binding:"required"`
 }
 
+type accountDeletionReqBody struct {
+	Email       string `json:"email" binding:"required"`
+	ConfirmLink string `json:"confirm_link" binding:"required"`
+	ExpireHours int    `json:"expire_hours" binding:"required"`
+	GraceDays   int    `json:"grace_days" binding:"required"`
+}
+
+type dataExportReqBody struct {
+	Email        string `json:"email" binding:"required"`
+	DownloadLink string `json:"download_link" binding:"required"`
+	ExpireDays   int    `json:"expire_days" binding:"required"`
+}
+
+type periodicDonationChangeReqBody struct {
+	Action       string `json:"action" binding:"required,oneof=pause resume change cancel"`
+	Amount       uint   `json:"amount" binding:"required"`
+	Currency     string `json:"currency"`
+	DonationLink string `json:"donation_link" binding:"required"`
+	Email        string `json:"email" binding:"required"`
+	Frequency    string `json:"frequency" binding:"required"`
+	Name         string `json:"name"`
+	OrderNumber  string `json:"order_number" binding:"required"`
+	PausedUntil  string `json:"paused_until"`
+}
+
+type cardExpiryReminderReqBody struct {
+	Amount      uint   `json:"amount" binding:"required"`
+	Currency    string `json:"currency"`
+	Email       string `json:"email" binding:"required"`
+	ExpiryDate  string `json:"expiry_date" binding:"required"`
+	Frequency   string `json:"frequency" binding:"required"`
+	LastFour    string `json:"last_four"`
+	Name        string `json:"name"`
+	OrderNumber string `json:"order_number" binding:"required"`
+	UpdateLink  string `json:"update_link" binding:"required"`
+}
+
+type offlinePaymentReqBody struct {
+	Amount      uint   `json:"amount" binding:"required"`
+	BankCode    string `json:"bank_code"`
+	Currency    string `json:"currency"`
+	Email       string `json:"email" binding:"required"`
+	ExpiresAt   string `json:"expires_at" binding:"required"`
+	Name        string `json:"name"`
+	OrderNumber string `json:"order_number" binding:"required"`
+	PayMethod   string `json:"pay_method" binding:"required"`
+	PaymentCode string `json:"payment_code" binding:"required"`
+}
+
 type assignRoleReqBody struct {
 	RoleKey string `json:"role" binding:"required"`
 	Email   string `json:"email" binding:"required"`
@@ -162,6 +211,205 @@ func (contrl *MailController) SendAuthentication(c *gin.Context) (int, gin.H, er
 	return http.StatusNoContent, gin.H{}, nil
 }
 
+// SendAccountDeletion retrieves email and confirmation link from request body,
+// and invoke MailService to send the mail confirming the account deletion
+func (contrl *MailController) SendAccountDeletion(c *gin.Context) (int, gin.H, error) {
+	const subject = "請確認刪除您的報導者帳號"
+	var err error
+	var mailBody string
+	var out bytes.Buffer
+	var reqBody accountDeletionReqBody
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	if err = contrl.HTMLTemplate.ExecuteTemplate(&out, "account-deletion.tmpl", struct {
+		Href        string
+		ExpireHours int
+		GraceDays   int
+	}{
+		reqBody.ConfirmLink,
+		reqBody.ExpireHours,
+		reqBody.GraceDays,
+	}); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "can not create account deletion mail body"}, errors.WithStack(err)
+	}
+
+	mailBody = out.String()
+
+	if err = contrl.MailService.Send(reqBody.Email, subject, mailBody); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("can not send account deletion mail to %s", reqBody.Email)}, err
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
+
+// SendDataExport retrieves email and download link from request body,
+// and invoke MailService to send the mail notifying the archive of personal data is ready
+func (contrl *MailController) SendDataExport(c *gin.Context) (int, gin.H, error) {
+	const subject = "您的報導者個人資料已可下載"
+	var err error
+	var mailBody string
+	var out bytes.Buffer
+	var reqBody dataExportReqBody
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	if err = contrl.HTMLTemplate.ExecuteTemplate(&out, "data-export.tmpl", struct {
+		Href       string
+		ExpireDays int
+	}{
+		reqBody.DownloadLink,
+		reqBody.ExpireDays,
+	}); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "can not create data export mail body"}, errors.WithStack(err)
+	}
+
+	mailBody = out.String()
+
+	if err = contrl.MailService.Send(reqBody.Email, subject, mailBody); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("can not send data export mail to %s", reqBody.Email)}, err
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
+
+// SendPeriodicDonationChange retrieves email and the changed periodic donation from request body,
+// and invoke MailService to send the mail confirming the change made by the donor
+func (contrl *MailController) SendPeriodicDonationChange(c *gin.Context) (int, gin.H, error) {
+	var err error
+	var mailBody string
+	var out bytes.Buffer
+	var reqBody periodicDonationChangeReqBody
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	if reqBody.Currency == "" {
+		// give default Currency
+		reqBody.Currency = "TWD"
+	}
+
+	subjects := map[string]string{
+		"pause":  "您的定期定額捐款已暫停扣款",
+		"resume": "您的定期定額捐款已恢復扣款",
+		"change": "您的定期定額捐款已變更",
+		"cancel": "您的定期定額捐款已取消",
+	}
+	subject := subjects[reqBody.Action]
+
+	frequencyText := "每月"
+	if reqBody.Frequency == "yearly" {
+		frequencyText = "每年"
+	}
+
+	if err = contrl.HTMLTemplate.ExecuteTemplate(&out, "periodic-donation-change.tmpl", struct {
+		periodicDonationChangeReqBody
+		FrequencyText string
+	}{
+		reqBody,
+		frequencyText,
+	}); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "can not create periodic donation change mail body"}, errors.WithStack(err)
+	}
+
+	mailBody = out.String()
+
+	if err = contrl.MailService.Send(reqBody.Email, subject, mailBody); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("can not send periodic donation change mail to %s", reqBody.Email)}, err
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
+
+// SendCardExpiryReminder retrieves email and the expiring card from request body,
+// and invoke MailService to send the mail reminding the donor to update the card of the periodic donation
+func (contrl *MailController) SendCardExpiryReminder(c *gin.Context) (int, gin.H, error) {
+	const subject = "您定期定額捐款的信用卡即將到期"
+	var err error
+	var mailBody string
+	var out bytes.Buffer
+	var reqBody cardExpiryReminderReqBody
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	if reqBody.Currency == "" {
+		// give default Currency
+		reqBody.Currency = "TWD"
+	}
+
+	frequencyText := "每月"
+	if reqBody.Frequency == "yearly" {
+		frequencyText = "每年"
+	}
+
+	if err = contrl.HTMLTemplate.ExecuteTemplate(&out, "card-expiry-reminder.tmpl", struct {
+		cardExpiryReminderReqBody
+		FrequencyText string
+	}{
+		reqBody,
+		frequencyText,
+	}); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "can not create card expiry reminder mail body"}, errors.WithStack(err)
+	}
+
+	mailBody = out.String()
+
+	if err = contrl.MailService.Send(reqBody.Email, subject, mailBody); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("can not send card expiry reminder mail to %s", reqBody.Email)}, err
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
+
+// SendOfflinePaymentInstructions retrieves email and the issued virtual account or payment code from request body,
+// and invoke MailService to send the mail instructing the donor to pay at the ATM or the convenience store
+func (contrl *MailController) SendOfflinePaymentInstructions(c *gin.Context) (int, gin.H, error) {
+	const subject = "感謝您支持報導者，請於期限內完成繳費"
+	var err error
+	var mailBody string
+	var out bytes.Buffer
+	var reqBody offlinePaymentReqBody
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	if reqBody.Currency == "" {
+		// give default Currency
+		reqBody.Currency = "TWD"
+	}
+
+	payMethodText := "超商代碼繳費"
+	if reqBody.PayMethod == payMethodATM {
+		payMethodText = "ATM 轉帳"
+	}
+
+	if err = contrl.HTMLTemplate.ExecuteTemplate(&out, "offline-payment-instructions.tmpl", struct {
+		offlinePaymentReqBody
+		PayMethodText string
+	}{
+		reqBody,
+		payMethodText,
+	}); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "can not create offline payment instructions mail body"}, errors.WithStack(err)
+	}
+
+	mailBody = out.String()
+
+	if err = contrl.MailService.Send(reqBody.Email, subject, mailBody); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("can not send offline payment instructions mail to %s", reqBody.Email)}, err
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
+
 func (contrl *MailController) SendDonationSuccessMail(c *gin.Context) (int, gin.H, error) {
 	const taipeiLocationName = "Asia/Taipei"
 	const subject = "扣款成功，感謝您支持報導者持續追蹤重要議題"
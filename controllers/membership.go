@@ -2,6 +2,9 @@ package controllers
 
 import (
 	log "github.com/sirupsen/logrus"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/ratelimit"
+	"github.com/twreporter/go-api/internal/receipt"
 	"github.com/twreporter/go-api/services"
 	"github.com/twreporter/go-api/storage"
 )
@@ -24,6 +27,10 @@ func NewMembershipController(s storage.MembershipStorage) *MembershipController
 		Storage:           s,
 		PubSubService:     pubSubService,
 		RoleUpdateService: roleUpdateService,
+		receiptRenderer: receipt.NewRenderer(globals.Conf.Receipt.FontPath, receipt.Issuer{
+			Name:  globals.Conf.Receipt.IssuerName,
+			TaxID: globals.Conf.Receipt.IssuerTaxID,
+		}),
 	}
 }
 
@@ -32,6 +39,10 @@ type MembershipController struct {
 	Storage           storage.MembershipStorage
 	PubSubService     *services.PubSubService
 	RoleUpdateService *services.RoleUpdateService
+	RateLimiter       *ratelimit.Limiter
+
+	campaignProgress campaignProgressCache
+	receiptRenderer  *receipt.Renderer
 }
 
 // Close is the method of Controller interface
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,6 +9,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
@@ -20,6 +22,7 @@ import (
 	"gopkg.in/guregu/null.v3"
 
 	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/oidc"
 	"github.com/twreporter/go-api/models"
 	"github.com/twreporter/go-api/storage"
 	"github.com/twreporter/go-api/utils"
@@ -27,6 +30,14 @@ import (
 
 const defaultDestination = "https://www.twreporter.org/"
 
+const (
+	// linkUserIDSessionKey is the session key of the user who is linking a new login method
+	linkUserIDSessionKey   = "link_user_id"
+	stateSessionKey        = "state"
+	codeVerifierSessionKey = "code_verifier"
+	nonceSessionKey        = "nonce"
+)
+
 type basicInfo struct {
 	Email  null.String `json:"email"`
 	Name   null.String `json:"name"`
@@ -68,27 +79,39 @@ func (info *googleOauthInfoRaw) Gender() null.String {
 
 // beginAuth uses sessions to store users'
 // 1. state
-// 2. destination(go to page)
-// and redirect users to oauth server.
-func beginAuth(c *gin.Context, conf *oauth2.Config) {
+// 2. PKCE code verifier
+// 3. destination(go to page)
+// and redirect users to the url of oauth server returned by authCodeURL.
+func beginAuth(c *gin.Context, authCodeURL func(state, codeVerifier string) (string, error)) {
 	var state string
+	var codeVerifier string
 	var err error
 
 	destination := c.Query("destination")
-	if destination == "" {
+	if !utils.IsAllowedRedirectURL(destination, utils.AllowedOrigins()) {
 		destination = defaultDestination
 	}
 
-	if state, err = utils.GenerateRandomString(32); err != nil {
-		state = "twreporter-oauth-state"
+	if state, err = oidc.GenerateRandom(32); err != nil {
+		log.Errorf("%+v", err)
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
+	}
+
+	if codeVerifier, err = oidc.GenerateCodeVerifier(); err != nil {
+		log.Errorf("%+v", err)
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
 	}
 
 	session := sessions.Default(c)
-	session.Set("state", state)
+	session.Set(stateSessionKey, state)
+	session.Set(codeVerifierSessionKey, codeVerifier)
 	session.Set("destination", destination)
 
 	// set onboading if exist
-	if onBoarding := c.Query("onboarding"); onBoarding != "" {
+	session.Delete("onboarding")
+	if onBoarding := c.Query("onboarding"); utils.IsAllowedRedirectURL(onBoarding, utils.AllowedOrigins()) {
 		session.Set("onboarding", onBoarding)
 	}
 
@@ -100,27 +123,69 @@ func beginAuth(c *gin.Context, conf *oauth2.Config) {
 		} else {
 			log.WithField("detail", err).Errorf("%s", f.FormatStack(err))
 		}
+		// the callback could not be verified without state
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
 	}
 
-	url := conf.AuthCodeURL(state)
+	url, err := authCodeURL(state, codeVerifier)
+	if err != nil {
+		log.Errorf("%+v", err)
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
+	}
 
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
+// oauth2AuthCodeURL returns the authCodeURL function of beginAuth for oauth2 config
+func oauth2AuthCodeURL(conf *oauth2.Config) func(state, codeVerifier string) (string, error) {
+	return func(state, codeVerifier string) (string, error) {
+		return conf.AuthCodeURL(state,
+			oauth2.SetAuthURLParam("code_challenge", oidc.CodeChallenge(codeVerifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", oidc.CodeChallengeMethod),
+		), nil
+	}
+}
+
+// verifyState compares the state in the callback with the one stored by beginAuth,
+// and returns the PKCE code verifier.
+// The state and the code verifier are removed from session, so that the callback could not be replayed.
+func verifyState(c *gin.Context) (codeVerifier string, err error) {
+	session := sessions.Default(c)
+	retrievedState, _ := session.Get(stateSessionKey).(string)
+	codeVerifier, _ = session.Get(codeVerifierSessionKey).(string)
+
+	session.Delete(stateSessionKey)
+	session.Delete(codeVerifierSessionKey)
+	if err = session.Save(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	state := formValue(c, "state")
+	if retrievedState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(retrievedState)) != 1 {
+		return "", errors.New(fmt.Sprintf("expect state is %s, but actual state is %s", retrievedState, state))
+	}
+
+	if codeVerifier == "" {
+		return "", errors.New("code verifier is not found in session")
+	}
+
+	return codeVerifier, nil
+}
+
 // getOauthUserInfo does the following three things
 // 1. validate state
-// 2. exchange code to token
+// 2. exchange code to token with PKCE code verifier
 // 3. get user info from oauth server by token
 func getOauthUserInfo(c *gin.Context, conf *oauth2.Config, userInfoEndpoint string, oauthUser interface{}) error {
-	session := sessions.Default(c)
-	retrievedState := session.Get("state")
-	state := c.Query("state")
-	if state != retrievedState {
-		return errors.New(fmt.Sprintf("expect state is %s, but actual state is %s", retrievedState, state))
+	codeVerifier, err := verifyState(c)
+	if err != nil {
+		return err
 	}
 
 	code := c.Query("code")
-	token, err := conf.Exchange(oauth2.NoContext, code)
+	token, err := conf.Exchange(oauth2.NoContext, code, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -185,6 +250,12 @@ func findOrCreateUser(oauthUser models.OAuthAccount, ms storage.MembershipStorag
 				if err = ms.InsertOAuthAccount(oauthUser); err != nil {
 					return user, err
 				}
+
+				// keep the record of merging the identity into the existing user
+				if err = ms.CreateIdentityAuditLog(newIdentityAuditLog(nil, user.ID, models.IdentityActionAutoMerge, oauthUser.Type, oauthUser.Email.String)); err != nil {
+					log.Errorf("%+v", err)
+					err = nil
+				}
 			}
 		} else {
 			// email is not provided in oAuth response
@@ -247,21 +318,94 @@ func (o *OAuth) InitGoogleConfig() {
 
 // BeginAuth redirects user to the [facebook|google] authentication(login) page
 func (o *OAuth) BeginOAuth(c *gin.Context) {
-	beginAuth(c, o.oauthConf)
+	// clear the unfinished link flow
+	sessions.Default(c).Delete(linkUserIDSessionKey)
+	beginAuth(c, oauth2AuthCodeURL(o.oauthConf))
 	return
 }
 
+// BeginLinkOAuth redirects the signed-in user to the [facebook|google] authentication page,
+// and links the oauth account to the user in Authenticate.
+// It should be used after middlewares.ValidateAuthentication.
+func (o *OAuth) BeginLinkOAuth(c *gin.Context) {
+	if !setLinkUserID(c) {
+		return
+	}
+
+	beginAuth(c, oauth2AuthCodeURL(o.oauthConf))
+}
+
+// setLinkUserID stores the signed-in user in the session to begin the link flow.
+// It responds 401 and returns false if the id_token is invalid.
+func setLinkUserID(c *gin.Context) bool {
+	var claims utils.IDTokenJWTClaims
+
+	// id_token is already verified by ValidateAuthentication middleware
+	tokenString, _ := c.Cookie("id_token")
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, &claims); err != nil || claims.UserID == 0 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "fail", "data": gin.H{"req.Headers.Cookies.id_token": "id_token is invalid"}})
+		return false
+	}
+
+	session := sessions.Default(c)
+	session.Set(linkUserIDSessionKey, claims.UserID)
+
+	return true
+}
+
+// linkOAuthAccount links the oauth account to the user who begins the link flow,
+// and redirects the user to the destination with the result in `link` or `link_error` query param.
+func linkOAuthAccount(c *gin.Context, ms storage.MembershipStorage, userID uint, oauthUser models.OAuthAccount, destination string) {
+	var linkErr string
+
+	account, err := ms.GetOAuthData(oauthUser.AId, oauthUser.Type)
+	switch {
+	case err == nil && account.UserID != userID:
+		linkErr = "identity_in_use"
+	case err == nil:
+		// already linked to the user
+	case storage.IsNotFound(err):
+		oauthUser.UserID = userID
+		err = ms.LinkOAuthAccount(oauthUser, newIdentityAuditLog(c, userID, models.IdentityActionLink, oauthUser.Type, oauthUser.Email.String))
+		if storage.IsConflict(err) {
+			linkErr = "identity_in_use"
+		} else if err != nil {
+			linkErr = "server_error"
+		}
+	default:
+		linkErr = "server_error"
+	}
+
+	if err != nil {
+		log.Infof("%v", errors.Wrap(err, "oauth link fails:"))
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, defaultDestination)
+		return
+	}
+
+	parameters := u.Query()
+	if linkErr != "" {
+		parameters.Add("link_error", linkErr)
+	} else {
+		parameters.Add("link", oauthUser.Type)
+	}
+	u.RawQuery = parameters.Encode()
+
+	c.Redirect(http.StatusTemporaryRedirect, u.String())
+}
+
 // Authenticate handles [google|facebook] oauth of users and redirect them to specific URL they want
 // with Set-Cookie response header which contains JWT
 func (o *OAuth) Authenticate(c *gin.Context) {
 	var destination string
 	var err error
-	var matchUser models.User
 	var oauthType string
 	var oauthUser models.OAuthAccount
 	var retrievedDestination interface{}
 	var session sessions.Session
-	var token string
 	var userInfoEndpoint string
 
 	defer func() {
@@ -302,7 +446,29 @@ func (o *OAuth) Authenticate(c *gin.Context) {
 
 	oauthUser.Type = oauthType
 
-	if matchUser, err = findOrCreateUser(oauthUser, o.Storage); err != nil {
+	err = completeOAuth(c, o.Storage, oauthUser, destination)
+}
+
+// completeOAuth links the oauth account to the user in the link flow,
+// or signs in the oauth user and redirects to the destination with id_token cookie.
+func completeOAuth(c *gin.Context, ms storage.MembershipStorage, oauthUser models.OAuthAccount, destination string) (err error) {
+	var matchUser models.User
+	var token string
+
+	session := sessions.Default(c)
+	oauthType := oauthUser.Type
+
+	// the user is linking a new login method rather than signing in
+	if linkUserID, ok := session.Get(linkUserIDSessionKey).(uint); ok {
+		session.Delete(linkUserIDSessionKey)
+		if err = session.Save(); err != nil {
+			err = errors.WithStack(err)
+		}
+		linkOAuthAccount(c, ms, linkUserID, oauthUser, destination)
+		return
+	}
+
+	if matchUser, err = findOrCreateUser(oauthUser, ms); err != nil {
 		err = errors.Wrap(err, "oauth fails due to database operation error:")
 		c.Redirect(http.StatusTemporaryRedirect, destination)
 		return
@@ -319,7 +485,7 @@ func (o *OAuth) Authenticate(c *gin.Context) {
 		)
 	}
 
-	if token, err = utils.RetrieveV2IDToken(matchUser.ID, matchUser.Email.ValueOrZero(), matchUser.FirstName.ValueOrZero(), matchUser.LastName.ValueOrZero(), idTokenExpiration); err != nil {
+	if token, err = issueIDToken(c, ms, matchUser); err != nil {
 		err = errors.Wrap(err, "oauth fails due to generate JWT error:")
 		c.Redirect(http.StatusTemporaryRedirect, destination)
 		return
@@ -354,4 +520,6 @@ func (o *OAuth) Authenticate(c *gin.Context) {
 	c.SetCookie("activated", activatedString, maxAge, "/", "."+globals.Conf.App.Domain, secure, true)
 	c.SetCookie("id_token", token, maxAge, "/", "."+globals.Conf.App.Domain, secure, true)
 	c.Redirect(http.StatusTemporaryRedirect, destination)
+
+	return nil
 }
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/oidc"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+// OIDC handles the sign-in of an OpenID Connect provider, like Apple and LINE
+type OIDC struct {
+	Storage storage.MembershipStorage
+	// Type is stored in o_auth_accounts.type
+	Type     string
+	provider *oidc.Provider
+}
+
+// NewOIDC returns the controller of the OIDC provider in config.
+// The callback path is /v2/auth/{name}/callback.
+func NewOIDC(name string, conf configs.OIDCConfig, ms storage.MembershipStorage) (*OIDC, error) {
+	appsettings := globals.Conf.App
+
+	providerConf := oidc.Config{
+		Issuer:       conf.Issuer,
+		ClientID:     conf.ID,
+		ClientSecret: conf.Secret,
+		RedirectURL:  fmt.Sprintf("%s://%s:%s/v2/auth/%s/callback", appsettings.Protocol, appsettings.Host, appsettings.Port, name),
+		Scopes:       conf.Scopes,
+		ResponseMode: conf.ResponseMode,
+	}
+
+	if conf.Secret == "" && conf.PrivateKeyPath != "" {
+		secretFunc, err := oidc.AppleClientSecret(conf.TeamID, conf.KeyID, conf.ID, conf.PrivateKeyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("can not init oidc provider %s", name))
+		}
+		providerConf.ClientSecretFunc = secretFunc
+	}
+
+	oauthType := conf.Type
+	if oauthType == "" {
+		oauthType = name
+	}
+
+	return &OIDC{
+		Storage:  ms,
+		Type:     oauthType,
+		provider: oidc.NewProvider(providerConf),
+	}, nil
+}
+
+// BeginOAuth redirects user to the authentication page of the provider
+func (o *OIDC) BeginOAuth(c *gin.Context) {
+	// clear the unfinished link flow
+	sessions.Default(c).Delete(linkUserIDSessionKey)
+	o.beginAuth(c)
+}
+
+// BeginLinkOAuth redirects the signed-in user to the authentication page of the provider,
+// and links the account to the user in Authenticate.
+// It should be used after middlewares.ValidateAuthentication.
+func (o *OIDC) BeginLinkOAuth(c *gin.Context) {
+	if !setLinkUserID(c) {
+		return
+	}
+	o.beginAuth(c)
+}
+
+func (o *OIDC) beginAuth(c *gin.Context) {
+	nonce, err := oidc.GenerateRandom(32)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": "Generating nonce occurs error"})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(nonceSessionKey, nonce)
+
+	beginAuth(c, func(state, codeVerifier string) (string, error) {
+		return o.provider.AuthCodeURL(state, nonce, codeVerifier)
+	})
+}
+
+// Authenticate handles the callback of the provider.
+// The authorization response is in query string, or in the form if response_mode is form_post.
+func (o *OIDC) Authenticate(c *gin.Context) {
+	var err error
+
+	defer func() {
+		if err != nil {
+			log.Infof("%v", err)
+		}
+	}()
+
+	session := sessions.Default(c)
+
+	destination, _ := session.Get("destination").(string)
+	if destination == "" {
+		destination = defaultDestination
+	}
+
+	// nonce is for single use, and is removed along with state in verifyState
+	nonce, _ := session.Get(nonceSessionKey).(string)
+	session.Delete(nonceSessionKey)
+
+	codeVerifier, err := verifyState(c)
+	if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
+	}
+
+	if e := formValue(c, "error"); e != "" {
+		err = errors.Errorf("%s oauth fails with error: %s", o.Type, e)
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
+	}
+
+	claims, err := o.provider.Exchange(c.Request.Context(), formValue(c, "code"), codeVerifier, nonce)
+	if err != nil {
+		err = errors.Wrap(err, "oauth fails while verifying id token, error message:")
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+		return
+	}
+
+	oauthUser := models.OAuthAccount{
+		Type:      o.Type,
+		AId:       null.StringFrom(claims.Subject),
+		Name:      null.NewString(claims.Name, claims.Name != ""),
+		FirstName: null.NewString(claims.GivenName, claims.GivenName != ""),
+		LastName:  null.NewString(claims.FamilyName, claims.FamilyName != ""),
+		Picture:   null.NewString(claims.Picture, claims.Picture != ""),
+	}
+
+	// the email is used to merge the account into the existing user,
+	// so only the verified one is trusted
+	if email, ok := claims.VerifiedEmail(); ok {
+		oauthUser.Email = null.StringFrom(email)
+	}
+
+	// Apple posts the name of the user at the first authorization only
+	if raw := c.PostForm("user"); raw != "" {
+		var appleUser oidc.AppleUser
+		if json.Unmarshal([]byte(raw), &appleUser) == nil {
+			first, last := appleUser.Name.FirstName, appleUser.Name.LastName
+			oauthUser.FirstName = null.NewString(first, first != "")
+			oauthUser.LastName = null.NewString(last, last != "")
+			if name := strings.TrimSpace(first + " " + last); name != "" {
+				oauthUser.Name = null.StringFrom(name)
+			}
+		}
+	}
+
+	err = completeOAuth(c, o.Storage, oauthUser, destination)
+}
+
+// formValue returns the value in the form posted by the provider, or in the query string
+func formValue(c *gin.Context, key string) string {
+	if v := c.PostForm(key); v != "" {
+		return v
+	}
+	return c.Query(key)
+}
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	f "github.com/twreporter/logformatter"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+const (
+	// cardExpiryReminderJobName names the lease of the job reminding the donors of their expiring cards
+	cardExpiryReminderJobName = "card_expiry_reminder"
+
+	// cardExpiryDateLayout is the layout of the expiry date in the card info of tap pay
+	cardExpiryDateLayout = "200601"
+
+	// defaultCardExpiryReminderBatchSize is used if the batch size is not configured
+	defaultCardExpiryReminderBatchSize = 100
+)
+
+type (
+	updatePeriodicDonationCardReq struct {
+		Prime string `json:"prime" binding:"required"`
+	}
+
+	// https://docs.tappaysdk.com/tutorial/zh/back.html#bind-card-api
+	tapPayBindCardReq struct {
+		Prime      string            `json:"prime"`
+		PartnerKey string            `json:"partner_key"`
+		MerchantID string            `json:"merchant_id"`
+		Currency   string            `json:"currency"`
+		Cardholder models.Cardholder `json:"cardholder"`
+	}
+)
+
+// tapPayCardholder fills the fields of the cardholder required by tap pay with empty strings
+func tapPayCardholder(c models.Cardholder) models.Cardholder {
+	if !c.Name.Valid {
+		c.Name = null.StringFrom("")
+	}
+
+	if !c.PhoneNumber.Valid {
+		c.PhoneNumber = null.StringFrom("")
+	}
+
+	return c
+}
+
+// cardColumns returns the columns of the periodic donation charged by the card in the response of tap pay
+func (resp tapPayTransactionResp) cardColumns() map[string]interface{} {
+	return map[string]interface{}{
+		"card_token":             encrypt(resp.CardSecret.CardToken, globals.Conf.Donation.CardSecretKey),
+		"card_key":               encrypt(resp.CardSecret.CardKey, globals.Conf.Donation.CardSecretKey),
+		"card_info_bin_code":     resp.CardInfo.BinCode,
+		"card_info_country":      resp.CardInfo.Country,
+		"card_info_country_code": resp.CardInfo.CountryCode,
+		"card_info_expiry_date":  resp.CardInfo.ExpiryDate,
+		"card_info_funding":      resp.CardInfo.Funding,
+		"card_info_issuer":       resp.CardInfo.Issuer,
+		"card_info_last_four":    resp.CardInfo.LastFour,
+		"card_info_level":        resp.CardInfo.Level,
+		"card_info_type":         resp.CardInfo.Type,
+		"updated_at":             time.Now(),
+	}
+}
+
+// UpdatePeriodicDonationCard replaces the card charged by the periodic donation with the card of the prime.
+// The card of the paid donation is bound without charge, while the failed or invalid donation
+// is charged by the new card at once, and the card is replaced only if the charge succeeds.
+func (mc *MembershipController) UpdatePeriodicDonationCard(c *gin.Context) (int, gin.H, error) {
+	var reqBody updatePeriodicDonationCardReq
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	d, err := mc.getPeriodicDonationOfAuthUser(c)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	if d.Currency == "" {
+		d.Currency = defaultCurrency
+	}
+
+	switch d.Status {
+	case statusPaid:
+		return mc.bindPeriodicDonationCard(d, reqBody.Prime)
+	case statusFail, statusInvalid:
+		return mc.chargePeriodicDonationCard(d, reqBody.Prime)
+	default:
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.order": "card of the periodic donation could not be updated"}}, nil
+	}
+}
+
+// bindPeriodicDonationCard binds the card of the prime without charge, and swaps it into the paid periodic donation
+func (mc *MembershipController) bindPeriodicDonationCard(d models.PeriodicDonation, prime string) (int, gin.H, error) {
+	tapPayReqJson, _ := json.Marshal(tapPayBindCardReq{
+		Prime:      prime,
+		PartnerKey: globals.Conf.Donation.TapPayPartnerKey,
+		MerchantID: getMerchantID(d.Currency, payMethodCreditCard),
+		Currency:   d.Currency,
+		Cardholder: tapPayCardholder(d.Cardholder),
+	})
+
+	tapPayResp, err := serveTapPay(globals.Conf.Donation.TapPayBindCardURL, globals.Conf.Donation.TapPayPartnerKey, tapPayReqJson)
+	if err != nil {
+		return tapPayCardErrorResponse(tapPayResp, err)
+	}
+
+	h := models.PeriodicDonationHistory{
+		PeriodicID: d.ID,
+		UserID:     d.UserID,
+		Action:     periodicActionUpdateCard,
+	}
+
+	if err = mc.Storage.ReplacePeriodicDonationCardInTRX(&h, statusPaid, tapPayResp.cardColumns(), nil); err != nil {
+		// the donation is being charged by the previous card
+		if storage.IsNotFound(err) {
+			return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.order": "periodic donation is being charged, please try again later"}}, nil
+		}
+		return toResponse(err)
+	}
+
+	d.CardInfo = tapPayResp.CardInfo
+	resp := new(clientResp)
+	resp.BuildFromPeriodicDonationModel(d)
+
+	return http.StatusOK, gin.H{"status": "success", "data": resp}, nil
+}
+
+// chargePeriodicDonationCard charges the failed or invalid periodic donation by the card of the prime,
+// and swaps the card into the donation if the charge succeeds
+func (mc *MembershipController) chargePeriodicDonationCard(d models.PeriodicDonation, prime string) (int, gin.H, error) {
+	td := models.PayByCardTokenDonation{
+		TWDEquivalent: toTWDEquivalent(d.Currency, d.Amount),
+		Amount:        d.Amount,
+		Currency:      d.Currency,
+		Details:       d.Details,
+		MerchantID:    getMerchantID(d.Currency, payMethodCreditCard),
+		OrderNumber:   generateOrderNumber(token, getPayMethodID(payMethodCreditCard)),
+		PeriodicID:    d.ID,
+		Status:        statusPaying,
+	}
+
+	if err := mc.Storage.ClaimPeriodicDonationCardCharge(&td); err != nil {
+		if storage.IsNotFound(err) {
+			return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.order": "periodic donation is being charged, please try again later"}}, nil
+		}
+		return toResponse(err)
+	}
+
+	tapPayReqJson, _ := json.Marshal(tapPayTransactionReq{
+		Amount:      td.Amount,
+		Cardholder:  tapPayCardholder(d.Cardholder),
+		Currency:    td.Currency,
+		Details:     td.Details,
+		MerchantID:  td.MerchantID,
+		OrderNumber: td.OrderNumber,
+		PartnerKey:  globals.Conf.Donation.TapPayPartnerKey,
+		Prime:       prime,
+		Remember:    true,
+	})
+
+	tapPayResp, err := serveHttp(globals.Conf.Donation.TapPayPartnerKey, tapPayReqJson)
+	if err != nil {
+		// the result is unknown if tap pay does not respond,
+		// so the donation is left paying rather than being charged twice
+		if tapPayRespStatusSuccess != tapPayResp.Status {
+			tapPayResp.AppendRespOnTokenDonation(&td, statusFail)
+			if updateErr := mc.Storage.UpdatePeriodicDonationCharge(td, map[string]interface{}{
+				"status":     d.Status,
+				"updated_at": time.Now(),
+			}); updateErr != nil {
+				log.Errorf("%+v", updateErr)
+			}
+		}
+		return tapPayCardErrorResponse(tapPayResp, err)
+	}
+
+	tapPayResp.AppendRespOnTokenDonation(&td, statusPaid)
+
+	now := time.Now()
+	columns := tapPayResp.cardColumns()
+	columns["status"] = statusPaid
+	columns["last_success_at"] = now
+	columns["charge_failures"] = 0
+	columns["next_retry_at"] = nil
+
+	h := models.PeriodicDonationHistory{
+		PeriodicID: d.ID,
+		UserID:     d.UserID,
+		Action:     periodicActionUpdateCard,
+	}
+
+	// since the charge already succeeded, respond success even if the update fails
+	if err = mc.Storage.ReplacePeriodicDonationCardInTRX(&h, statusPaying, columns, &td); err != nil {
+		log.Errorf("%+v", err)
+	} else {
+		go mc.issueReceiptNumber(globals.TokenDonationType, td.ID)
+	}
+
+	d.CardInfo = tapPayResp.CardInfo
+	resp := new(clientResp)
+	resp.BuildFromPeriodicDonationModel(d)
+
+	go mc.sendDonationThankYouMail(*resp)
+
+	if globals.Conf.Features.EnableRoleUpdatePubSub {
+		mc.sendRoleUpdateMessage(d.Cardholder.Email)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": resp}, nil
+}
+
+// tapPayCardErrorResponse responds the error of tap pay on the new card
+func tapPayCardErrorResponse(tapPayResp tapPayTransactionResp, err error) (int, gin.H, error) {
+	switch tapPayResp.Status {
+	case tapPayRespStatusCardError, tapPayRespStatusCardExpired:
+		return http.StatusBadRequest, gin.H{"status": "error", "message": err.Error()}, err
+	default:
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()}, err
+	}
+}
+
+// CardExpiryReminderJob returns the job reminding the donors whose cards expire next month
+func (mc *MembershipController) CardExpiryReminderJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     cardExpiryReminderJobName,
+		Interval: globals.Conf.CardExpiryReminder.Interval,
+		Run:      mc.RemindExpiringCards,
+	}
+}
+
+// RemindExpiringCards sends reminder mails to the donors of the active periodic donations
+// whose cards expire next month. Each donor is reminded once for a card.
+func (mc *MembershipController) RemindExpiringCards(ctx context.Context) error {
+	const taipeiLocationName = "Asia/Taipei"
+
+	location, _ := time.LoadLocation(taipeiLocationName)
+	now := time.Now().In(location)
+	firstDayOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	expiryDate := firstDayOfMonth.AddDate(0, 1, 0).Format(cardExpiryDateLayout)
+
+	limit := globals.Conf.CardExpiryReminder.BatchSize
+	if limit <= 0 {
+		limit = defaultCardExpiryReminderBatchSize
+	}
+
+	for {
+		donations, err := mc.Storage.GetPeriodicDonationsWithExpiringCards(expiryDate, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range donations {
+			if err = ctx.Err(); err != nil {
+				return errors.WithStack(err)
+			}
+
+			// mark the donation before sending the mail,
+			// so that the donor is not reminded repeatedly even if the mail fails
+			if err = mc.Storage.MarkCardExpiryReminded(d.ID, expiryDate); err != nil {
+				return err
+			}
+			mc.sendCardExpiryReminderMail(d)
+		}
+
+		if len(donations) == 0 || len(donations) < limit {
+			return nil
+		}
+	}
+}
+
+func (mc *MembershipController) sendCardExpiryReminderMail(d models.PeriodicDonation) {
+	var expiryDate string
+	if t, err := time.Parse(cardExpiryDateLayout, d.CardInfo.ExpiryDate.ValueOrZero()); err == nil {
+		expiryDate = t.Format("2006/01")
+	}
+
+	currency := d.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	reqBody := cardExpiryReminderReqBody{
+		Amount:      d.Amount,
+		Currency:    currency,
+		Email:       d.Cardholder.Email,
+		ExpiryDate:  expiryDate,
+		Frequency:   d.Frequency,
+		LastFour:    d.CardInfo.LastFour.ValueOrZero(),
+		Name:        d.Cardholder.Name.ValueOrZero(),
+		OrderNumber: d.OrderNumber,
+		UpdateLink:  getSupportSiteOrigin() + "/contribute/" + d.Frequency + "/" + d.OrderNumber + "?utm_source=cardexpiry&utm_medium=email",
+	}
+
+	if err := postMailServiceEndpoint(reqBody, fmt.Sprintf("http://localhost:%s/v1/%s", globals.LocalhostPort, globals.SendCardExpiryReminderRoutePath)); err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("fail to send card expiry reminder of periodic donation(order_number: %s)", d.OrderNumber))
+
+		if globals.Conf.Environment == "development" {
+			log.Errorf("%+v", err)
+		} else {
+			log.WithField("detail", err).Errorf("%s", f.FormatStack(err))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+// periodicChargeJobName names the lease of the job charging the due periodic donations
+const periodicChargeJobName = "periodic_donation_charge"
+
+// https://docs.tappaysdk.com/tutorial/zh/back.html#pay-by-token-api
+type tapPayTokenReq struct {
+	CardKey     string `json:"card_key"`
+	CardToken   string `json:"card_token"`
+	PartnerKey  string `json:"partner_key"`
+	MerchantID  string `json:"merchant_id"`
+	Amount      uint   `json:"amount"`
+	Currency    string `json:"currency"`
+	Details     string `json:"details"`
+	OrderNumber string `json:"order_number"`
+}
+
+// PeriodicDonationChargeJob returns the job charging the due periodic donations.
+// The job is disabled unless the interval of periodic charge is configured.
+func (mc *MembershipController) PeriodicDonationChargeJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     periodicChargeJobName,
+		Interval: globals.Conf.PeriodicCharge.Interval,
+		Run:      mc.ChargeDuePeriodicDonations,
+	}
+}
+
+// ChargeDuePeriodicDonations charges the stored card tokens of the due periodic donations
+// through the pay-by-token api of tap pay
+func (mc *MembershipController) ChargeDuePeriodicDonations(ctx context.Context) error {
+	donations, err := mc.Storage.GetDuePeriodicDonations(time.Now(), globals.Conf.PeriodicCharge.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range donations {
+		if err = ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		mc.chargePeriodicDonation(d)
+	}
+
+	return nil
+}
+
+// chargePeriodicDonation charges the periodic donation once, and records the result as a card token donation.
+// The failed charge is retried with backoff until the donation is stopped after MaxFailures consecutive failures,
+// while the donation with an expired card is marked as invalid at once.
+func (mc *MembershipController) chargePeriodicDonation(pd models.PeriodicDonation) {
+	logger := log.WithField("periodic_id", pd.ID)
+
+	currency := pd.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	// the exchange rate is taken at the time of each charge
+	td := models.PayByCardTokenDonation{
+		TWDEquivalent: toTWDEquivalent(currency, pd.Amount),
+		Amount:        pd.Amount,
+		Currency:      currency,
+		Details:       pd.Details,
+		MerchantID:    getMerchantID(currency, payMethodCreditCard),
+		OrderNumber:   generateOrderNumber(token, getPayMethodID(payMethodCreditCard)),
+		PeriodicID:    pd.ID,
+		Status:        statusPaying,
+	}
+
+	// the donation is claimed by another run, or is changed since it is queried
+	if err := mc.Storage.ClaimPeriodicDonationCharge(&td); err != nil {
+		if !storage.IsNotFound(err) {
+			logger.Errorf("%+v", err)
+		}
+		return
+	}
+
+	tapPayReqJson, _ := json.Marshal(tapPayTokenReq{
+		CardKey:     decrypt(pd.CardKey, globals.Conf.Donation.CardSecretKey),
+		CardToken:   decrypt(pd.CardToken, globals.Conf.Donation.CardSecretKey),
+		PartnerKey:  globals.Conf.Donation.TapPayPartnerKey,
+		MerchantID:  td.MerchantID,
+		Amount:      td.Amount,
+		Currency:    td.Currency,
+		Details:     td.Details,
+		OrderNumber: td.OrderNumber,
+	})
+
+	tapPayResp, err := serveTapPay(globals.Conf.Donation.TapPayTokenURL, globals.Conf.Donation.TapPayPartnerKey, tapPayReqJson)
+
+	now := time.Now()
+	columns := map[string]interface{}{"updated_at": now}
+
+	switch {
+	case nil == err:
+		tapPayResp.AppendRespOnTokenDonation(&td, statusPaid)
+		columns["status"] = statusPaid
+		columns["last_success_at"] = now
+		columns["charge_failures"] = 0
+		columns["next_retry_at"] = nil
+	case tapPayRespStatusSuccess == tapPayResp.Status:
+		// the result is unknown if tap pay does not respond,
+		// so the donation is left paying rather than being charged twice
+		logger.Errorf("%+v", errors.Wrap(err, fmt.Sprintf("result of the charge(order: %s) is unknown", td.OrderNumber)))
+		return
+	case tapPayRespStatusCardExpired == tapPayResp.Status:
+		tapPayResp.AppendRespOnTokenDonation(&td, statusFail)
+		columns["status"] = statusInvalid
+		columns["next_retry_at"] = nil
+	default:
+		tapPayResp.AppendRespOnTokenDonation(&td, statusFail)
+		columns = failedPeriodicChargeColumns(pd, now)
+	}
+
+	if err = mc.Storage.UpdatePeriodicDonationCharge(td, columns); err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+
+	if td.Status == statusPaid {
+		mc.issueReceiptNumber(globals.TokenDonationType, td.ID)
+	}
+
+	logger.WithField("order_number", td.OrderNumber).Infof("periodic donation is charged, status: %s", columns["status"])
+}
+
+// failedPeriodicChargeColumns returns the columns of the periodic donation whose charge fails.
+// The charge is retried with backoff until the donation is stopped after MaxFailures consecutive failures.
+func failedPeriodicChargeColumns(pd models.PeriodicDonation, now time.Time) map[string]interface{} {
+	conf := globals.Conf.PeriodicCharge
+	failures := pd.ChargeFailures + 1
+
+	columns := map[string]interface{}{
+		"charge_failures": failures,
+		"updated_at":      now,
+	}
+
+	if failures >= conf.MaxFailures {
+		columns["status"] = statusStopped
+		columns["next_retry_at"] = nil
+	} else {
+		columns["status"] = statusFail
+		columns["next_retry_at"] = now.Add(conf.RetryBackoff << (failures - 1))
+	}
+
+	return columns
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	f "github.com/twreporter/logformatter"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+// actions of the donors on their periodic donations
+const (
+	periodicActionPause      = "pause"
+	periodicActionResume     = "resume"
+	periodicActionChange     = "change"
+	periodicActionCancel     = "cancel"
+	periodicActionUpdateCard = "update_card"
+)
+
+// maxPeriodicPauseDuration limits how long a periodic donation could be paused at a time
+const maxPeriodicPauseDuration = 366 * 24 * time.Hour
+
+type (
+	pausePeriodicDonationReq struct {
+		Until  time.Time `json:"until" binding:"required"`
+		Reason string    `json:"reason" binding:"max=255"`
+	}
+
+	changePeriodicDonationReq struct {
+		Amount    uint   `json:"amount"`
+		Frequency string `json:"frequency" binding:"omitempty,oneof=monthly yearly"`
+	}
+
+	cancelPeriodicDonationReq struct {
+		Reason string `json:"reason" binding:"required,max=255"`
+	}
+
+	periodicDonationState struct {
+		OrderNumber string    `json:"order_number"`
+		Status      string    `json:"status"`
+		Amount      uint      `json:"amount"`
+		Currency    string    `json:"currency"`
+		Frequency   string    `json:"frequency"`
+		PausedUntil null.Time `json:"paused_until"`
+	}
+)
+
+// getPeriodicDonationOfAuthUser gets the periodic donation of the order in the url,
+// which belongs to the authenticated user
+func (mc *MembershipController) getPeriodicDonationOfAuthUser(c *gin.Context) (models.PeriodicDonation, error) {
+	var d models.PeriodicDonation
+
+	authUserID := c.Request.Context().Value(globals.AuthUserIDProperty)
+	err := mc.Storage.GetByConditions(map[string]interface{}{
+		"user_id":      authUserID,
+		"order_number": c.Param("order"),
+	}, &d)
+
+	return d, err
+}
+
+// PausePeriodicDonation pauses the charges of the periodic donation until the given date
+func (mc *MembershipController) PausePeriodicDonation(c *gin.Context) (int, gin.H, error) {
+	var reqBody pausePeriodicDonationReq
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	now := time.Now()
+	if !reqBody.Until.After(now) || reqBody.Until.After(now.Add(maxPeriodicPauseDuration)) {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"until": "until should be a future date within a year"}}, nil
+	}
+
+	d, err := mc.getPeriodicDonationOfAuthUser(c)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	h := models.PeriodicDonationHistory{
+		PeriodicID:  d.ID,
+		UserID:      d.UserID,
+		Action:      periodicActionPause,
+		PausedUntil: null.TimeFrom(reqBody.Until),
+		Reason:      null.NewString(reqBody.Reason, reqBody.Reason != ""),
+	}
+	d.PausedUntil = h.PausedUntil
+
+	return mc.changePeriodicDonation(d, h, map[string]interface{}{
+		"paused_until": reqBody.Until,
+	})
+}
+
+// ResumePeriodicDonation resumes the charges of the paused periodic donation
+func (mc *MembershipController) ResumePeriodicDonation(c *gin.Context) (int, gin.H, error) {
+	d, err := mc.getPeriodicDonationOfAuthUser(c)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	if !d.PausedUntil.Valid || !d.PausedUntil.Time.After(time.Now()) {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.order": "periodic donation is not paused"}}, nil
+	}
+
+	h := models.PeriodicDonationHistory{
+		PeriodicID: d.ID,
+		UserID:     d.UserID,
+		Action:     periodicActionResume,
+	}
+	d.PausedUntil = null.Time{}
+
+	return mc.changePeriodicDonation(d, h, map[string]interface{}{
+		"paused_until": nil,
+	})
+}
+
+// ChangePeriodicDonation changes the amount or the frequency of the periodic donation.
+// The change takes effect from the next charge.
+func (mc *MembershipController) ChangePeriodicDonation(c *gin.Context) (int, gin.H, error) {
+	var reqBody changePeriodicDonationReq
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	d, err := mc.getPeriodicDonationOfAuthUser(c)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	h := models.PeriodicDonationHistory{
+		PeriodicID: d.ID,
+		UserID:     d.UserID,
+		Action:     periodicActionChange,
+	}
+	columns := make(map[string]interface{})
+
+	if reqBody.Amount > 0 && reqBody.Amount != d.Amount {
+		currency := normalizeCurrency(d.Currency)
+		if msg := validateDonationAmount(currency, reqBody.Amount); msg != "" {
+			return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"amount": msg}}, nil
+		}
+
+		e := toTWDEquivalent(currency, reqBody.Amount)
+		columns["twd_amount"] = e.TWDAmount
+		columns["exchange_rate"] = e.ExchangeRate
+		h.OldAmount = null.IntFrom(int64(d.Amount))
+		h.NewAmount = null.IntFrom(int64(reqBody.Amount))
+		columns["amount"] = reqBody.Amount
+		d.Amount = reqBody.Amount
+	}
+
+	if reqBody.Frequency != "" && reqBody.Frequency != d.Frequency {
+		h.OldFrequency = null.StringFrom(d.Frequency)
+		h.NewFrequency = null.StringFrom(reqBody.Frequency)
+		columns["frequency"] = reqBody.Frequency
+		d.Frequency = reqBody.Frequency
+	}
+
+	if len(columns) == 0 {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"amount": "amount or frequency should be changed"}}, nil
+	}
+
+	return mc.changePeriodicDonation(d, h, columns)
+}
+
+// CancelPeriodicDonation stops the periodic donation for good,
+// and removes the card token which could charge the card
+func (mc *MembershipController) CancelPeriodicDonation(c *gin.Context) (int, gin.H, error) {
+	var reqBody cancelPeriodicDonationReq
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	d, err := mc.getPeriodicDonationOfAuthUser(c)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	h := models.PeriodicDonationHistory{
+		PeriodicID: d.ID,
+		UserID:     d.UserID,
+		Action:     periodicActionCancel,
+		Reason:     null.StringFrom(reqBody.Reason),
+	}
+	d.Status = statusStopped
+	d.PausedUntil = null.Time{}
+
+	return mc.changePeriodicDonation(d, h, map[string]interface{}{
+		"status":        statusStopped,
+		"card_token":    "",
+		"card_key":      "",
+		"paused_until":  nil,
+		"next_retry_at": nil,
+	})
+}
+
+// GetHistoriesOfAPeriodicDonation returns the changes made by the donor to the periodic donation
+func (mc *MembershipController) GetHistoriesOfAPeriodicDonation(c *gin.Context) (int, gin.H, error) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	if limit == 0 {
+		limit = 10
+	}
+
+	d, err := mc.getPeriodicDonationOfAuthUser(c)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	histories, total, err := mc.Storage.GetHistoriesOfAPeriodicDonation(d.ID, limit, offset)
+	if err != nil {
+		return toResponse(err)
+	}
+
+	return http.StatusOK, gin.H{"status": "ok", "records": histories, "meta": models.MetaOfResponse{
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	}}, nil
+}
+
+// changePeriodicDonation applies the change to the active periodic donation, and responds with its new state.
+// The role of the donor is re-evaluated and the donor is notified once the change is made.
+func (mc *MembershipController) changePeriodicDonation(d models.PeriodicDonation, h models.PeriodicDonationHistory, columns map[string]interface{}) (int, gin.H, error) {
+	columns["updated_at"] = time.Now()
+
+	if err := mc.Storage.ChangePeriodicDonationInTRX(&h, columns); err != nil {
+		if storage.IsNotFound(err) {
+			return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.order": "periodic donation is not active"}}, nil
+		}
+		return toResponse(err)
+	}
+
+	if globals.Conf.Features.EnableRoleUpdatePubSub {
+		mc.sendRoleUpdateMessage(d.Cardholder.Email)
+	}
+
+	go mc.sendPeriodicDonationChangeMail(d, h.Action)
+
+	return http.StatusOK, gin.H{"status": "success", "data": periodicDonationState{
+		OrderNumber: d.OrderNumber,
+		Status:      d.Status,
+		Amount:      d.Amount,
+		Currency:    d.Currency,
+		Frequency:   d.Frequency,
+		PausedUntil: d.PausedUntil,
+	}}, nil
+}
+
+func (mc *MembershipController) sendPeriodicDonationChangeMail(d models.PeriodicDonation, action string) {
+	const taipeiLocationName = "Asia/Taipei"
+
+	reqBody := periodicDonationChangeReqBody{
+		Action:       action,
+		Amount:       d.Amount,
+		Currency:     d.Currency,
+		DonationLink: getSupportSiteOrigin() + "/contribute/" + d.Frequency + "/" + d.OrderNumber,
+		Email:        d.Cardholder.Email,
+		Frequency:    d.Frequency,
+		Name:         d.Cardholder.Name.ValueOrZero(),
+		OrderNumber:  d.OrderNumber,
+	}
+
+	if d.PausedUntil.Valid {
+		location, _ := time.LoadLocation(taipeiLocationName)
+		reqBody.PausedUntil = d.PausedUntil.Time.In(location).Format("2006-01-02")
+	}
+
+	if err := postMailServiceEndpoint(reqBody, fmt.Sprintf("http://localhost:%s/v1/%s", globals.LocalhostPort, globals.SendPeriodicDonationChangeRoutePath)); err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("fail to send %s mail of periodic donation(order_number: %s)", action, d.OrderNumber))
+
+		if globals.Conf.Environment == "development" {
+			log.Errorf("%+v", err)
+		} else {
+			log.WithField("detail", err).Errorf("%s", f.FormatStack(err))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/internal/ratelimit"
+)
+
+const (
+	rateLimitKeyEmail = "email"
+	rateLimitKeyIP    = "ip"
+)
+
+// allowRequest records a hit of the request against rule.
+// It returns the seconds the client should wait if the rule is exceeded.
+func (mc *MembershipController) allowRequest(route, keyType, key string, rule configs.RateLimitRule) (bool, int, error) {
+	if mc.RateLimiter == nil {
+		return true, 0, nil
+	}
+
+	result, err := mc.RateLimiter.Allow(fmt.Sprintf("%s:%s:%s", route, keyType, key), ratelimit.Rule{
+		Limit:  rule.Limit,
+		Window: rule.Window,
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	if result.Allowed {
+		return true, 0, nil
+	}
+
+	return false, retryAfterSeconds(result.RetryAfter), nil
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// tooManyRequests sets Retry-After header and returns the JSend fail response
+func tooManyRequests(c *gin.Context, retryAfter int, data gin.H) (int, gin.H) {
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	data["retry_after"] = retryAfter
+	return http.StatusTooManyRequests, gin.H{"status": "fail", "data": data}
+}
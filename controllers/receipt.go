@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/receipt"
+	"github.com/twreporter/go-api/models"
+)
+
+// yearlyReceiptNumberFormat numbers the yearly receipt by the year and the donor,
+// so that the same number is printed every time the receipt is downloaded
+const yearlyReceiptNumberFormat = "Y%d-%06d"
+
+var errReceiptNotIssued = errors.New("receipt is only issued for the paid donations")
+
+// getReceiptFromMemberCMS sends the request built for the receipt to member cms,
+// and returns the response only if the receipt is served
+func getReceiptFromMemberCMS(req *http.Request, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("cannot get receipt from member cms. status: %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// renderReceipt renders the receipt in process and responds it as the file download
+func renderReceipt(c *gin.Context, filename string, render func(io.Writer) error) {
+	// render into the buffer to respond the error in json if the rendering fails halfway
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		if errors.Cause(err) == errReceiptNotIssued {
+			c.JSON(http.StatusNotFound, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// renderPrimeDonationReceipt renders the receipt of the paid prime donation for the amount left after the partial refunds.
+// The donation is numbered first if it has not been numbered yet.
+func (mc *MembershipController) renderPrimeDonationReceipt(w io.Writer, d models.PayByPrimeDonation) error {
+	if d.Status != statusPaid || !d.TransactionTime.Valid {
+		return errReceiptNotIssued
+	}
+
+	refunded, err := mc.Storage.GetRefundedAmountOfADonation(globals.PrimeDonationType, d.ID)
+	if err != nil {
+		return err
+	}
+	if refunded >= d.Amount {
+		return errReceiptNotIssued
+	}
+
+	receiptNumber := d.ReceiptNumber.ValueOrZero()
+	if receiptNumber == "" {
+		if receiptNumber, err = mc.Storage.IssueReceiptNumber(globals.PrimeDonationType, d.ID, receiptSeries(globals.PrimeDonationType)); err != nil {
+			return err
+		}
+	}
+
+	return mc.receiptRenderer.Render(w, receipt.Receipt{
+		Title:    "捐款收據",
+		Number:   receiptNumber,
+		IssuedAt: d.TransactionTime.Time,
+		Donor:    toReceiptDonor(d.Receipt, d.Cardholder.Name),
+		Items: []receipt.Item{{
+			Date:      d.TransactionTime.Time,
+			Number:    receiptNumber,
+			PayMethod: d.PayMethod,
+			Amount:    toReceiptAmount(d.Amount, d.TWDAmount, refunded),
+		}},
+	})
+}
+
+// renderYearlyReceipt renders the receipt of all the donations paid by the user in the year,
+// whose donor is the one on the latest donation
+func (mc *MembershipController) renderYearlyReceipt(w io.Writer, u models.User, year int) error {
+	const taipeiLocationName = "Asia/Taipei"
+	location, _ := time.LoadLocation(taipeiLocationName)
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, location)
+
+	items, err := mc.Storage.GetReceiptItemsOfAUser(u.ID, from, from.AddDate(1, 0, 0))
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return errReceiptNotIssued
+	}
+
+	rc := receipt.Receipt{
+		Title:  fmt.Sprintf("%d 年度捐款收據", year),
+		Number: fmt.Sprintf(yearlyReceiptNumberFormat, year, u.ID),
+	}
+	for _, item := range items {
+		number := item.ReceiptNumber.ValueOrZero()
+		if number == "" {
+			number = item.OrderNumber
+		}
+		rc.Items = append(rc.Items, receipt.Item{
+			Date:      item.TransactionTime.Time,
+			Number:    number,
+			PayMethod: item.PayMethod,
+			Amount:    toReceiptAmount(item.Amount, item.TWDAmount, item.RefundedAmount),
+		})
+	}
+
+	latest := items[len(items)-1]
+	rc.IssuedAt = latest.TransactionTime.Time
+	rc.Donor = toReceiptDonor(latest.Receipt, latest.CardholderName)
+	if rc.Donor.Name == "" {
+		rc.Donor.Name = u.Name.ValueOrZero()
+	}
+
+	return mc.receiptRenderer.Render(w, rc)
+}
+
+// toReceiptDonor prints the receipt header as the donor, or the cardholder if the header is not provided
+func toReceiptDonor(r models.Receipt, cardholderName null.String) receipt.Donor {
+	name := r.Header.ValueOrZero()
+	if name == "" {
+		name = cardholderName.ValueOrZero()
+	}
+
+	var address []string
+	for _, s := range []null.String{r.AddressZipCode, r.AddressCountry, r.AddressState, r.AddressCity, r.AddressDetail} {
+		if s.ValueOrZero() != "" {
+			address = append(address, s.String)
+		}
+	}
+
+	return receipt.Donor{
+		Name:       name,
+		SecurityID: r.SecurityID.ValueOrZero(),
+		Address:    strings.Join(address, " "),
+	}
+}
+
+// toReceiptAmount returns the amount left after the refunds in TWD which the receipts are issued in.
+// The TWD equivalent is refunded in proportion to the amount.
+func toReceiptAmount(amount uint, twdAmount null.Int, refunded uint) uint {
+	if refunded == 0 {
+		if twdAmount.Valid {
+			return uint(twdAmount.Int64)
+		}
+		return amount
+	}
+
+	if refunded >= amount {
+		return 0
+	}
+
+	net := amount - refunded
+	if !twdAmount.Valid {
+		return net
+	}
+
+	return uint(math.Round(float64(twdAmount.Int64) * float64(net) / float64(amount)))
+}
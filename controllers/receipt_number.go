@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	log "github.com/sirupsen/logrus"
+	f "github.com/twreporter/logformatter"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+// defaultReceiptSeries are the prefixes of the receipt numbers if the series of the donation type is not configured
+var defaultReceiptSeries = map[string]string{
+	globals.PrimeDonationType: "A",
+	globals.TokenDonationType: "B",
+}
+
+// receiptSeries returns the prefix of the receipt numbers of the donation type
+func receiptSeries(donationType string) string {
+	if prefix := globals.Conf.Receipt.Series[donationType]; prefix != "" {
+		return prefix
+	}
+	return defaultReceiptSeries[donationType]
+}
+
+// issueReceiptNumber numbers the paid donation in the series of its donation type.
+// An empty string is returned if the donation could not be numbered.
+func (mc *MembershipController) issueReceiptNumber(donationType string, id uint) string {
+	receiptNumber, err := mc.Storage.IssueReceiptNumber(donationType, id, receiptSeries(donationType))
+	if err != nil {
+		log.WithField("err", err).Errorf("failed to issue receipt number. %s donation id: %d, err: %s", donationType, id, f.FormatStack(err))
+	}
+	return receiptNumber
+}
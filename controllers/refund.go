@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	member "github.com/twreporter/go-api/internal/member_cms"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+const (
+	refundStatusRefunding = "refunding"
+	refundStatusFail      = "fail"
+)
+
+type (
+	refundReq struct {
+		// the rest of the donation is refunded if amount is not provided
+		Amount uint   `json:"amount"`
+		Reason string `json:"reason" binding:"required,max=255"`
+	}
+
+	// https://docs.tappaysdk.com/tutorial/zh/back.html#refund-api
+	tapPayRefundReq struct {
+		PartnerKey string `json:"partner_key"`
+		RecTradeID string `json:"rec_trade_id"`
+		Amount     uint   `json:"amount"`
+	}
+)
+
+// refundedDonation is the donation made through tap pay to refund
+type refundedDonation struct {
+	ID              uint
+	Amount          uint
+	Currency        string
+	OrderNumber     string
+	RecTradeID      string
+	Status          string
+	ReceiptNumber   null.String
+	TransactionTime null.Time
+}
+
+// getRefundedDonation gets the donation of the order to refund by donation type
+func (mc *MembershipController) getRefundedDonation(donationType, orderNumber string) (refundedDonation, error) {
+	var rd refundedDonation
+
+	switch donationType {
+	case globals.PrimeDonationType:
+		var d models.PayByPrimeDonation
+		if err := mc.Storage.GetByConditions(map[string]interface{}{"order_number": orderNumber}, &d); err != nil {
+			return rd, err
+		}
+		rd = refundedDonation{d.ID, d.Amount, d.Currency, d.OrderNumber, d.RecTradeID, d.Status, d.ReceiptNumber, d.TransactionTime}
+	case globals.TokenDonationType:
+		var d models.PayByCardTokenDonation
+		if err := mc.Storage.GetByConditions(map[string]interface{}{"order_number": orderNumber}, &d); err != nil {
+			return rd, err
+		}
+		rd = refundedDonation{d.ID, d.Amount, d.Currency, d.OrderNumber, d.RecTradeID, d.Status, d.ReceiptNumber, d.TransactionTime}
+	default:
+		return rd, errors.New(fmt.Sprintf("donation type(%s) not supported", donationType))
+	}
+
+	if rd.Currency == "" {
+		rd.Currency = defaultCurrency
+	}
+
+	return rd, nil
+}
+
+// RefundADonation refunds the whole or part of the paid donation through the refund api of tap pay.
+// The receipt number of the refunded donation is voided, and a new one is reissued
+// for the rest of the partially refunded donation.
+func (mc *MembershipController) RefundADonation(c *gin.Context, donationType string) (int, gin.H, error) {
+	var reqBody refundReq
+
+	if failData, err := bindRequestJSONBody(c, &reqBody); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": failData}, nil
+	}
+
+	d, err := mc.getRefundedDonation(donationType, c.Param("order"))
+	if err != nil {
+		return toResponse(err)
+	}
+
+	if d.Status != statusPaid || d.RecTradeID == "" {
+		return http.StatusConflict, gin.H{"status": "fail", "data": gin.H{"req.Params.order": "only the paid donation made through tap pay could be refunded"}}, nil
+	}
+
+	operatorID, _ := strconv.ParseFloat(fmt.Sprint(c.Request.Context().Value(globals.AuthUserIDProperty)), 64)
+
+	r := models.DonationRefund{
+		DonationType: donationType,
+		DonationID:   d.ID,
+		OrderNumber:  d.OrderNumber,
+		Amount:       reqBody.Amount,
+		Currency:     d.Currency,
+		Reason:       null.StringFrom(reqBody.Reason),
+		Status:       refundStatusRefunding,
+		OperatorID:   uint(operatorID),
+	}
+
+	if err = mc.Storage.CreateDonationRefund(&r, d.Amount); err != nil {
+		if storage.IsRefundExceeded(err) {
+			return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"amount": fmt.Sprintf("amount exceeds the refundable amount of the donation(%d)", d.Amount)}}, nil
+		}
+		return toResponse(err)
+	}
+
+	tapPayReqJson, _ := json.Marshal(tapPayRefundReq{
+		PartnerKey: globals.Conf.Donation.TapPayPartnerKey,
+		RecTradeID: d.RecTradeID,
+		Amount:     r.Amount,
+	})
+
+	tapPayResp, err := serveTapPay(globals.Conf.Donation.TapPayRefundURL, globals.Conf.Donation.TapPayPartnerKey, tapPayReqJson)
+	if err != nil {
+		// the result is unknown if tap pay does not respond,
+		// so the refund is left refunding rather than being refunded twice
+		if tapPayRespStatusSuccess != tapPayResp.Status {
+			r.Status = refundStatusFail
+			r.TappayApiStatus = null.IntFrom(tapPayResp.Status)
+			r.Msg = null.NewString(tapPayResp.Msg, tapPayResp.Msg != "")
+			if updateErr := mc.Storage.UpdateDonationRefund(r); updateErr != nil {
+				log.Errorf("%+v", updateErr)
+			}
+		}
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()}, err
+	}
+
+	r.TappayApiStatus = null.IntFrom(tapPayResp.Status)
+	r.TappayRefundID = null.NewString(tapPayResp.RefundID, tapPayResp.RefundID != "")
+	r.Msg = null.NewString(tapPayResp.Msg, tapPayResp.Msg != "")
+
+	fully, err := mc.Storage.CompleteDonationRefund(&r, d.Amount)
+	if err != nil {
+		// since the refund already succeeded, the record is fixed by hand
+		log.Errorf("%+v", errors.Wrap(err, fmt.Sprintf("refund(id: %d, refund_id: %s) succeeded but is not recorded", r.ID, tapPayResp.RefundID)))
+		return http.StatusCreated, gin.H{"status": "success", "data": r}, nil
+	}
+
+	mc.reissueRefundedReceipt(&r, d, fully)
+
+	return http.StatusCreated, gin.H{"status": "success", "data": r}, nil
+}
+
+// reissueRefundedReceipt voids the receipt number of the refunded donation,
+// reissues a new one if the donation is refunded partially, and notifies member cms of the prime donation
+func (mc *MembershipController) reissueRefundedReceipt(r *models.DonationRefund, d refundedDonation, fully bool) {
+	if !d.ReceiptNumber.Valid {
+		return
+	}
+
+	operatorID := null.IntFrom(int64(r.OperatorID))
+
+	var voided, reissued string
+	var err error
+	if fully {
+		voided, err = mc.Storage.VoidReceiptNumber(r.DonationType, d.ID, r.Reason, operatorID)
+	} else {
+		voided, reissued, err = mc.Storage.ReissueReceiptNumber(r.DonationType, d.ID, receiptSeries(r.DonationType), r.Reason, operatorID)
+	}
+	if err != nil {
+		log.Errorf("%+v", errors.Wrap(err, fmt.Sprintf("can not reissue receipt of %s donation(order_number: %s)", r.DonationType, d.OrderNumber)))
+		return
+	}
+
+	r.VoidedReceiptNumber = null.NewString(voided, voided != "")
+	r.ReissuedReceiptNumber = null.NewString(reissued, reissued != "")
+
+	if err = mc.Storage.UpdateDonationRefund(*r); err != nil {
+		log.Errorf("%+v", err)
+	}
+
+	if r.DonationType == globals.PrimeDonationType {
+		// post member cms to update the receipt of the order
+		go member.PostPrimeDonationReceipt(reissued, d.OrderNumber)
+	}
+}
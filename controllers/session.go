@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+// maxUserAgentLength is the max length of the user agent recorded in a session
+const maxUserAgentLength = 512
+
+// issueIDToken generates the id token of the user,
+// and records the session of it with the device which the user signs in
+func issueIDToken(c *gin.Context, ms storage.MembershipStorage, user models.User) (string, error) {
+	jti, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	idToken, err := utils.RetrieveV2IDToken(user.ID, user.Email.ValueOrZero(), user.FirstName.ValueOrZero(), user.LastName.ValueOrZero(), idTokenExpiration, jti)
+	if err != nil {
+		return "", err
+	}
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	now := time.Now()
+	err = ms.CreateUserSession(&models.UserSession{
+		UserID:     user.ID,
+		JTI:        jti,
+		UserAgent:  userAgent,
+		IP:         c.ClientIP(),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Second * idTokenExpiration),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return idToken, nil
+}
+
+// touchSession updates the last seen time of the session of the id token.
+// The id tokens issued before sessions are recorded have no jti, and are skipped.
+func (mc *MembershipController) touchSession(jti string) {
+	if jti == "" {
+		return
+	}
+
+	if err := mc.Storage.TouchUserSession(jti, time.Now()); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// GetSessionsOfAUser returns the devices which the user signs in
+func (mc *MembershipController) GetSessionsOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	sessions, err := mc.Storage.GetActiveUserSessions(userID, time.Now())
+	if err != nil {
+		return toResponse(err)
+	}
+
+	return http.StatusOK, gin.H{"status": "success", "data": sessions}, nil
+}
+
+// RevokeASessionOfAUser signs the user out of the device.
+// The id token, the refresh tokens and the access tokens of the session are rejected afterwards.
+func (mc *MembershipController) RevokeASessionOfAUser(c *gin.Context) (int, gin.H, error) {
+	userID, err := parseUserID(c.Param("userID"))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.userID": "userID is invalid"}}, nil
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 0)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.sessionID": "sessionID is invalid"}}, nil
+	}
+
+	if err = mc.Storage.RevokeUserSession(userID, uint(sessionID)); err != nil {
+		return toResponse(err)
+	}
+
+	return http.StatusNoContent, gin.H{}, nil
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+// ValidateTapPayNotifyIPs checks the IPs allowed to send the notifications of tap pay and the trusted proxies.
+// Since the notify endpoints are unauthenticated, the allowed IPs should be configured except in development.
+func ValidateTapPayNotifyIPs() error {
+	conf := globals.Conf.Donation
+	if len(conf.TapPayNotifyAllowedIPs) == 0 && globals.Conf.Environment != "development" {
+		return errors.New("tap pay notify allowed ips are not configured")
+	}
+
+	for _, entries := range [][]string{conf.TapPayNotifyAllowedIPs, conf.TapPayNotifyTrustedProxies} {
+		for _, entry := range entries {
+			if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+				return errors.Errorf("%s is neither an ip nor a cidr", entry)
+			}
+		}
+	}
+
+	return nil
+}
+
+// containsIP checks the ip against the IPs or CIDRs
+func containsIP(entries []string, ip net.IP) bool {
+	for _, entry := range entries {
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		} else if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tapPayNotifyRemoteIP returns the IP sending the notification.
+// X-Forwarded-For is only taken from the trusted proxies and from the nearest hop,
+// since the addresses on the left could be given by anyone.
+func tapPayNotifyRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(host)
+		if ip == nil || !containsIP(globals.Conf.Donation.TapPayNotifyTrustedProxies, ip) {
+			return host
+		}
+
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			return host
+		}
+		host = hop
+	}
+
+	return host
+}
+
+// isTapPayNotifyIPAllowed checks the ip against the IPs or CIDRs allowed to send the notifications.
+// Any IP is allowed if none is configured, which is only the case in development.
+func isTapPayNotifyIPAllowed(ip string) bool {
+	allowedIPs := globals.Conf.Donation.TapPayNotifyAllowedIPs
+	if len(allowedIPs) == 0 {
+		return true
+	}
+
+	remoteIP := net.ParseIP(ip)
+	if remoteIP == nil {
+		return false
+	}
+
+	return containsIP(allowedIPs, remoteIP)
+}
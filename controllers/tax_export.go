@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/taxexport"
+)
+
+// getTaxExportYear returns the calendar year in the path, which should not be later than the current year
+func getTaxExportYear(c *gin.Context) (int, bool) {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil || year > time.Now().Year() {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Params.year": fmt.Sprintf("year: %s is invalid", c.Param("year"))}})
+		return 0, false
+	}
+	return year, true
+}
+
+// ExportTaxDeductions responds the file of the donations in the year uploaded to the tax agency
+// for the automatic tax deduction, in the fixed width format by default or in CSV
+func (mc *MembershipController) ExportTaxDeductions(c *gin.Context) {
+	year, ok := getTaxExportYear(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", taxexport.FormatFixedWidth)
+	if !taxexport.IsValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Query.format": fmt.Sprintf("format: %s is not supported", format)}})
+		return
+	}
+
+	r, err := taxexport.Build(mc.Storage, year)
+	if err != nil {
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = taxexport.WriteUploadFile(&buf, format, globals.Conf.Receipt.IssuerTaxID, r); err != nil {
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	filename := fmt.Sprintf("tax-deductions-%d.%s", year, taxexport.FileExtension(format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+// ExportTaxDeductionExclusions responds the report in CSV of the donations in the year
+// left out of the upload file and the reasons
+func (mc *MembershipController) ExportTaxDeductionExclusions(c *gin.Context) {
+	year, ok := getTaxExportYear(c)
+	if !ok {
+		return
+	}
+
+	r, err := taxexport.Build(mc.Storage, year)
+	if err != nil {
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = taxexport.WriteReport(&buf, r); err != nil {
+		status, obj, _ := toResponse(err)
+		c.JSON(status, obj)
+		return
+	}
+
+	filename := fmt.Sprintf("tax-deductions-%d-excluded.csv", year)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+const refreshTokenBytes = 32
+
+// tokenPurgeJobName names the lease of the job deleting the expired tokens
+const tokenPurgeJobName = "token_purge"
+
+// hashRefreshToken returns the hex encoded SHA-256 of the refresh token.
+// The refresh token has enough entropy, so no salt is needed.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTokenPair generates an access token and a refresh token of the family for the user.
+// The returned RefreshToken model should be persisted by the caller.
+func newTokenPair(user models.User, familyID string) (string, string, models.RefreshToken, error) {
+	var rt models.RefreshToken
+
+	roles := make([]map[string]interface{}, len(user.Roles))
+	for i, role := range user.Roles {
+		roles[i] = map[string]interface{}{
+			"id":      role.ID,
+			"name":    role.Name,
+			"name_en": role.NameEn,
+			"key":     role.Key,
+		}
+	}
+
+	var activated *time.Time
+	if user.Activated.Valid && !user.Activated.Time.IsZero() {
+		activated = &user.Activated.Time
+	}
+
+	jti, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return "", "", rt, err
+	}
+
+	accessToken, err := utils.RetrieveV2AccessToken(user.ID, user.Email.ValueOrZero(), roles, activated, globals.Conf.App.JwtAccessExpiration, jti)
+	if err != nil {
+		return "", "", rt, err
+	}
+
+	refreshToken, err := utils.GenerateRandomString(refreshTokenBytes)
+	if err != nil {
+		return "", "", rt, err
+	}
+
+	rt = models.RefreshToken{
+		UserID:         user.ID,
+		FamilyID:       familyID,
+		TokenHash:      hashRefreshToken(refreshToken),
+		AccessTokenJTI: jti,
+		ExpiresAt:      time.Now().Add(time.Second * time.Duration(globals.Conf.App.JwtRefreshExpiration)),
+	}
+
+	return accessToken, refreshToken, rt, nil
+}
+
+// TokenRefresh exchanges a refresh token for a new access token and a new refresh token.
+// The exchanged refresh token could not be used again.
+// Once a used refresh token is presented, the whole family of it is revoked
+// since either the client or the attacker holds a leaked token.
+func (mc *MembershipController) TokenRefresh(c *gin.Context) (int, gin.H, error) {
+	var body struct {
+		RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+	}
+
+	if err := c.Bind(&body); err != nil {
+		return http.StatusBadRequest, gin.H{"status": "fail", "data": gin.H{"req.Body.refresh_token": "refresh_token is required"}}, nil
+	}
+
+	rt, err := mc.Storage.GetRefreshTokenByHash(hashRefreshToken(body.RefreshToken))
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return http.StatusUnauthorized, gin.H{"status": "fail", "data": gin.H{"req.Body.refresh_token": "refresh_token is invalid"}}, nil
+		}
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot get refresh_token"}, err
+	}
+
+	if rt.ReplacedAt.Valid && !rt.RevokedAt.Valid {
+		return mc.revokeReusedRefreshToken(rt)
+	}
+
+	if !rt.IsActive(time.Now()) {
+		return http.StatusUnauthorized, gin.H{"status": "fail", "data": gin.H{"req.Body.refresh_token": "refresh_token is expired or revoked"}}, nil
+	}
+
+	user, err := mc.Storage.GetUserByID(fmt.Sprint(rt.UserID))
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot get user data"}, err
+	}
+
+	accessToken, refreshToken, next, err := newTokenPair(user, rt.FamilyID)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during generating access_token JWT"}, err
+	}
+
+	next.SessionJTI = rt.SessionJTI
+	if err = mc.Storage.RotateRefreshToken(rt.ID, &next); err != nil {
+		// the token is exchanged by the concurrent request
+		if storage.IsNotFound(err) {
+			return mc.revokeReusedRefreshToken(rt)
+		}
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during rotating refresh_token"}, err
+	}
+
+	mc.touchSession(rt.SessionJTI)
+
+	return http.StatusOK, gin.H{"status": "success", "data": gin.H{
+		"jwt":           accessToken,
+		"refresh_token": refreshToken,
+	}}, nil
+}
+
+func (mc *MembershipController) revokeReusedRefreshToken(rt models.RefreshToken) (int, gin.H, error) {
+	if err := mc.Storage.RevokeRefreshTokenFamily(rt.FamilyID); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during revoking refresh_token"}, err
+	}
+
+	return http.StatusUnauthorized, gin.H{"status": "fail", "data": gin.H{"req.Body.refresh_token": "refresh_token is reused, the tokens issued along with it are revoked"}},
+		errors.New(fmt.Sprintf("refresh token(family: %s) of user(id: %d) is reused", rt.FamilyID, rt.UserID))
+}
+
+// TokenRevokeAll logs the user out of all devices.
+// It revokes the refresh tokens and the access tokens of the user,
+// and rejects the id tokens issued before.
+func (mc *MembershipController) TokenRevokeAll(c *gin.Context) (int, gin.H, error) {
+	authUserID := c.Request.Context().Value(globals.AuthUserIDProperty)
+
+	userID, err := strconv.ParseUint(fmt.Sprint(authUserID), 10, 0)
+	if err != nil {
+		return http.StatusUnauthorized, gin.H{"status": "fail", "data": gin.H{"req.Headers.Authorization": "user_id claim is invalid"}}, nil
+	}
+
+	if err = mc.Storage.RevokeTokensOfUser(uint(userID)); err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "Error occurs during revoking tokens"}, err
+	}
+
+	secure := globals.Conf.Environment != globals.DevelopmentEnvironment
+	c.SetCookie("id_token", "", -1, defaultPath, globals.Conf.App.Domain, secure, true)
+	c.SetCookie("activated", "", -1, defaultPath, globals.Conf.App.Domain, secure, true)
+
+	return http.StatusOK, gin.H{"status": "success", "data": gin.H{"user_id": userID}}, nil
+}
+
+// TokenPurgeJob returns the job deleting the expired refresh tokens and revoked access tokens,
+// so that the lookup of the revoked tokens on every request stays cheap
+func (mc *MembershipController) TokenPurgeJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     tokenPurgeJobName,
+		Interval: globals.Conf.TokenPurge.Interval,
+		Run: func(ctx context.Context) error {
+			return mc.Storage.DeleteExpiredTokens(time.Now())
+		},
+	}
+}
+
+// GetJSONWebKeySet returns the public keys to verify the tokens,
+// so that the downstream services need not hold the signing secret
+func GetJSONWebKeySet(c *gin.Context) (int, gin.H, error) {
+	ks, err := utils.GetKeySet()
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"status": "error", "message": "cannot load signing keys"}, err
+	}
+
+	return http.StatusOK, gin.H{"keys": ks.JSONWebKeys()}, nil
+}
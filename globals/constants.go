@@ -20,14 +20,19 @@ const (
 	AccountsSiteStagingOrigin = "https://staging-accounts.twreporter.org"
 
 	// route path
-	SendOtpRoutePath             = "mail/send_otp"
-	SendActivationRoutePath      = "mail/send_activation"
-	SendAuthenticationRoutePath  = "mail/send_authentication"
-	SendSuccessDonationRoutePath = "mail/send_success_donation"
-	SendRoleExplorerRoutePath    = "mail/send_role_explorer"
-	SendRoleActiontakerRoutePath = "mail/send_role_actiontaker"
-	SendRoleTrailblazerRoutePath = "mail/send_role_trailblazer"
-	SendRoleDowngradeRoutePath   = "mail/send_role_downgrade"
+	SendOtpRoutePath                    = "mail/send_otp"
+	SendActivationRoutePath             = "mail/send_activation"
+	SendAuthenticationRoutePath         = "mail/send_authentication"
+	SendSuccessDonationRoutePath        = "mail/send_success_donation"
+	SendRoleExplorerRoutePath           = "mail/send_role_explorer"
+	SendRoleActiontakerRoutePath        = "mail/send_role_actiontaker"
+	SendRoleTrailblazerRoutePath        = "mail/send_role_trailblazer"
+	SendRoleDowngradeRoutePath          = "mail/send_role_downgrade"
+	SendAccountDeletionRoutePath        = "mail/send_account_deletion"
+	SendDataExportRoutePath             = "mail/send_data_export"
+	SendPeriodicDonationChangeRoutePath = "mail/send_periodic_donation_change"
+	SendCardExpiryReminderRoutePath     = "mail/send_card_expiry_reminder"
+	SendOfflinePaymentRoutePath         = "mail/send_offline_payment_instructions"
 
 	// controller name
 	MembershipController = "membership_controller"
@@ -0,0 +1,222 @@
+package donationexport
+
+// package donationexport streams the donations to the finance in CSV or XLSX,
+// with the personal data of the cardholders and the receipts masked optionally.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// taipei is where the time of the donations is printed in
+var taipei = time.FixedZone("Asia/Taipei", 8*60*60)
+
+// Source streams the donations matching the filter
+type Source interface {
+	ExportDonations(models.DonationExportFilter, func(models.DonationExportRow) error) error
+}
+
+// column is a column of the export, whose values are numbers in XLSX if numeric
+type column struct {
+	name    string
+	numeric bool
+}
+
+var columns = []column{
+	{"donation_type", false},
+	{"order_number", false},
+	{"status", false},
+	{"amount", true},
+	{"currency", false},
+	{"twd_amount", true},
+	{"pay_method", false},
+	{"merchant_id", false},
+	{"campaign_id", true},
+	{"send_receipt", false},
+	{"receipt_number", false},
+	{"donated_at", false},
+	{"user_id", true},
+	{"cardholder_email", false},
+	{"cardholder_name", false},
+	{"cardholder_phone_number", false},
+	{"cardholder_national_id", false},
+	{"receipt_header", false},
+	{"receipt_security_id", false},
+	{"receipt_email", false},
+	{"receipt_address_country", false},
+	{"receipt_address_state", false},
+	{"receipt_address_city", false},
+	{"receipt_address_detail", false},
+	{"receipt_address_zip_code", false},
+}
+
+// IsValidFormat checks the format of the export is supported
+func IsValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatXLSX
+}
+
+// ContentType returns the media type of the export in the format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// rowWriter writes the records of the export one by one
+type rowWriter interface {
+	Write(record []string) error
+	Close() error
+	// Abort marks the export broken off after count donations, so that it is not taken as a complete one
+	Abort(count int) error
+}
+
+func newRowWriter(w io.Writer, format string) (rowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{csv.NewWriter(w)}, nil
+	case FormatXLSX:
+		numeric := make(map[int]bool)
+		for i, c := range columns {
+			numeric[i] = c.numeric
+		}
+		return newXLSXWriter(w, numeric)
+	default:
+		return nil, errors.New(fmt.Sprintf("format(%s) not supported", format))
+	}
+}
+
+// csvWriter writes the records in CSV
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// Write escapes the values which spreadsheets would take as formulas, e.g. the names given by the donors
+func (cw *csvWriter) Write(record []string) error {
+	escaped := make([]string, len(record))
+	for i, v := range record {
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			v = "'" + v
+		}
+		escaped[i] = v
+	}
+	return errors.WithStack(cw.w.Write(escaped))
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return errors.WithStack(cw.w.Error())
+}
+
+// Abort writes the error as the last record, which is not escaped to be noticed
+func (cw *csvWriter) Abort(count int) error {
+	record := make([]string, len(columns))
+	record[0] = fmt.Sprintf("ERROR: export is broken off after %d donations, please export again", count)
+	if err := cw.w.Write(record); err != nil {
+		return errors.WithStack(err)
+	}
+	return cw.Close()
+}
+
+// Write streams the donations of s matching the filter to w in the format, and returns the number of them.
+// Nothing is written to w until the donations are read, so the error of the query could still be responded.
+// If the donations fail to be read halfway, the export written is marked broken, i.e. a CSV ending with an error record
+// or an XLSX which fails to be opened.
+func Write(w io.Writer, s Source, f models.DonationExportFilter, format string, mask bool) (int, error) {
+	var rw rowWriter
+	open := func() error {
+		if rw != nil {
+			return nil
+		}
+
+		var err error
+		if rw, err = newRowWriter(w, format); err != nil {
+			return err
+		}
+
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.name
+		}
+		return rw.Write(header)
+	}
+
+	count := 0
+	err := s.ExportDonations(f, func(r models.DonationExportRow) error {
+		if err := open(); err != nil {
+			return err
+		}
+		count++
+		return rw.Write(Record(r, mask))
+	})
+	if err != nil {
+		if rw != nil {
+			// the error of the export is returned instead, since w is likely broken as well
+			rw.Abort(count)
+		}
+		return count, err
+	}
+
+	if err = open(); err != nil {
+		return count, err
+	}
+	return count, rw.Close()
+}
+
+// Record returns the values of the donation in the order of the columns
+func Record(r models.DonationExportRow, mask bool) []string {
+	pii := func(s string, m func(string) string) string {
+		if mask {
+			return m(s)
+		}
+		return s
+	}
+
+	return []string{
+		r.DonationType,
+		r.OrderNumber,
+		r.Status,
+		strconv.FormatUint(uint64(r.Amount), 10),
+		r.Currency,
+		formatNullInt(r.TWDAmount.Valid, r.TWDAmount.Int64),
+		r.PayMethod.ValueOrZero(),
+		r.MerchantID,
+		formatNullInt(r.CampaignID.Valid, r.CampaignID.Int64),
+		r.SendReceipt.ValueOrZero(),
+		r.ReceiptNumber.ValueOrZero(),
+		r.DonatedAt.In(taipei).Format("2006-01-02 15:04:05"),
+		strconv.FormatUint(uint64(r.UserID), 10),
+		pii(r.CardholderEmail.ValueOrZero(), maskEmail),
+		pii(r.CardholderName.ValueOrZero(), maskName),
+		pii(r.CardholderPhoneNumber.ValueOrZero(), maskPhoneNumber),
+		pii(r.CardholderNationalID.ValueOrZero(), maskID),
+		pii(r.Receipt.Header.ValueOrZero(), maskName),
+		pii(r.Receipt.SecurityID.ValueOrZero(), maskID),
+		pii(r.Receipt.Email.ValueOrZero(), maskEmail),
+		r.Receipt.AddressCountry.ValueOrZero(),
+		r.Receipt.AddressState.ValueOrZero(),
+		r.Receipt.AddressCity.ValueOrZero(),
+		pii(r.Receipt.AddressDetail.ValueOrZero(), maskAddress),
+		r.Receipt.AddressZipCode.ValueOrZero(),
+	}
+}
+
+func formatNullInt(valid bool, i int64) string {
+	if !valid {
+		return ""
+	}
+	return strconv.FormatInt(i, 10)
+}
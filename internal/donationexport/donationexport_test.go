@@ -0,0 +1,243 @@
+package donationexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+)
+
+func TestMask(t *testing.T) {
+	cases := []struct {
+		mask func(string) string
+		s    string
+		want string
+	}{
+		{maskName, "王小明", "王*明"},
+		{maskName, "王明", "王*"},
+		{maskName, "", ""},
+		{maskEmail, "donor@twreporter.org", "d****@twreporter.org"},
+		{maskEmail, "d@twreporter.org", "*@twreporter.org"},
+		{maskPhoneNumber, "0912345678", "0912***678"},
+		{maskID, "A123456789", "A12*****89"},
+		{maskID, "12345678", "123***78"},
+		{maskAddress, "中正路100號", "中正路****"},
+	}
+
+	for _, c := range cases {
+		if got := c.mask(c.s); got != c.want {
+			t.Errorf("mask(%q) = %q, want %q", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(url.Values{
+		"from":         {"2025-01-01"},
+		"to":           {"2025-01-31"},
+		"type":         {"prime,token"},
+		"status":       {"paid", "refunded"},
+		"merchant_id":  {"merchant-1"},
+		"campaign_id":  {"3"},
+		"send_receipt": {"paperback_receipt_by_year"},
+	})
+	if err != nil {
+		t.Fatalf("ParseFilter() = %v", err)
+	}
+
+	if want := time.Date(2025, 1, 1, 0, 0, 0, 0, taipei); !f.From.Time.Equal(want) {
+		t.Errorf("from = %v, want %v", f.From.Time, want)
+	}
+	// the end date is included
+	if want := time.Date(2025, 2, 1, 0, 0, 0, 0, taipei); !f.To.Time.Equal(want) {
+		t.Errorf("to = %v, want %v", f.To.Time, want)
+	}
+	if strings.Join(f.Types, ",") != "prime,token" || strings.Join(f.Statuses, ",") != "paid,refunded" {
+		t.Errorf("types = %v, statuses = %v", f.Types, f.Statuses)
+	}
+	if len(f.PayMethods) != 0 || strings.Join(f.MerchantIDs, ",") != "merchant-1" || f.CampaignID.Int64 != 3 {
+		t.Errorf("filter = %+v", f)
+	}
+
+	invalids := []struct {
+		values url.Values
+		param  string
+	}{
+		{url.Values{"from": {"2025/01/01"}}, "from"},
+		{url.Values{"from": {"2025-02-01"}, "to": {"2025-01-01"}}, "to"},
+		{url.Values{"type": {"prime,periodic"}}, "type"},
+		{url.Values{"status": {"stopped"}}, "status"},
+		{url.Values{"send_receipt": {"weekly"}}, "send_receipt"},
+		{url.Values{"campaign_id": {"0"}}, "campaign_id"},
+	}
+	for _, c := range invalids {
+		_, err := ParseFilter(c.values)
+		if e, ok := err.(*InvalidParamError); !ok || e.Param != c.param {
+			t.Errorf("ParseFilter(%v) = %v, want invalid %s", c.values, err, c.param)
+		}
+	}
+}
+
+type fakeSource struct {
+	rows []models.DonationExportRow
+	err  error
+	// brokenErr is returned after the rows are read
+	brokenErr error
+}
+
+func (s *fakeSource) ExportDonations(f models.DonationExportFilter, fn func(models.DonationExportRow) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, r := range s.rows {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return s.brokenErr
+}
+
+func testRows() []models.DonationExportRow {
+	r := models.DonationExportRow{
+		DonationType:    "prime",
+		OrderNumber:     "prime-1",
+		Status:          "paid",
+		Amount:          500,
+		Currency:        "TWD",
+		DonatedAt:       time.Date(2025, 1, 1, 16, 30, 0, 0, time.UTC),
+		UserID:          1,
+		CardholderName:  null.StringFrom("=王小明"),
+		CardholderEmail: null.StringFrom("donor@twreporter.org"),
+	}
+	r.Receipt.Header = null.StringFrom("王小明")
+	return []models.DonationExportRow{r}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := Write(&buf, &fakeSource{rows: testRows()}, models.DonationExportFilter{}, FormatCSV, false)
+	if err != nil || n != 1 {
+		t.Fatalf("Write() = (%d, %v)", n, err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0][0] != "donation_type" {
+		t.Fatalf("records = %v", records)
+	}
+
+	r := records[1]
+	if r[11] != "2025-01-02 00:30:00" {
+		t.Errorf("donated_at = %s, want in Taipei", r[11])
+	}
+	// the formula is escaped
+	if r[14] != "'=王小明" {
+		t.Errorf("cardholder_name = %s", r[14])
+	}
+	if r[5] != "" || r[8] != "" {
+		t.Errorf("twd_amount = %s, campaign_id = %s, want empty", r[5], r[8])
+	}
+}
+
+func TestWriteMasked(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Write(&buf, &fakeSource{rows: testRows()}, models.DonationExportFilter{}, FormatCSV, true); err != nil {
+		t.Fatal(err)
+	}
+
+	records, _ := csv.NewReader(&buf).ReadAll()
+	if r := records[1]; r[13] != "d****@twreporter.org" || r[17] != "王*明" {
+		t.Errorf("cardholder_email = %s, receipt_header = %s", r[13], r[17])
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Write(&buf, &fakeSource{rows: testRows()}, models.DonationExportFilter{}, FormatXLSX, false); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			b, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			sheet = string(b)
+		}
+	}
+	if len(zr.File) != 5 {
+		t.Errorf("%d parts, want 5", len(zr.File))
+	}
+	if !strings.Contains(sheet, `<c r="D2"><v>500</v></c>`) {
+		t.Errorf("amount is not a number in %s", sheet)
+	}
+	if !strings.Contains(sheet, `<c r="O2" t="inlineStr"><is><t xml:space="preserve">=王小明</t></is></c>`) {
+		t.Errorf("cardholder_name is not a string in %s", sheet)
+	}
+	if !strings.HasSuffix(sheet, `</sheetData></worksheet>`) {
+		t.Errorf("worksheet is not closed")
+	}
+}
+
+func TestWriteNothingOnError(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Write(&buf, &fakeSource{err: errors.New("connection lost")}, models.DonationExportFilter{}, FormatXLSX, false); err == nil {
+		t.Fatal("Write() = nil, want error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes are written", buf.Len())
+	}
+}
+
+func TestWriteBrokenOff(t *testing.T) {
+	source := &fakeSource{rows: testRows(), brokenErr: errors.New("connection lost")}
+
+	var buf bytes.Buffer
+	if n, err := Write(&buf, source, models.DonationExportFilter{}, FormatCSV, false); err == nil || n != 1 {
+		t.Fatalf("Write() = (%d, %v), want error", n, err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][1] != "prime-1" {
+		t.Fatalf("records = %v", records)
+	}
+	if last := records[2][0]; !strings.HasPrefix(last, "ERROR: export is broken off after 1 donations") {
+		t.Errorf("last record = %s, want the error", last)
+	}
+
+	buf.Reset()
+	if _, err := Write(&buf, source, models.DonationExportFilter{}, FormatXLSX, false); err == nil {
+		t.Fatal("Write() = nil, want error")
+	}
+	if _, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Error("broken workbook is opened")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
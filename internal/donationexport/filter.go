@@ -0,0 +1,114 @@
+package donationexport
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+)
+
+var (
+	validTypes        = []string{"prime", "token", "others"}
+	validStatuses     = []string{"paying", "paid", "fail", "refunded"}
+	validSendReceipts = []string{"yearly", "monthly", "no", "no_receipt", "digital_receipt_by_month", "digital_receipt_by_year", "paperback_receipt_by_month", "paperback_receipt_by_year"}
+)
+
+// InvalidParamError tells which parameter of the filter is invalid and why
+type InvalidParamError struct {
+	Param  string
+	Reason string
+}
+
+func (e *InvalidParamError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Param, e.Reason)
+}
+
+// splitValues returns the values of the parameter, which is either repeated or separated by commas
+func splitValues(values url.Values, param string) []string {
+	var s []string
+	for _, v := range values[param] {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				s = append(s, p)
+			}
+		}
+	}
+	return s
+}
+
+func parseEnum(values url.Values, param string, valid []string) ([]string, error) {
+	s := splitValues(values, param)
+	for _, v := range s {
+		ok := false
+		for _, e := range valid {
+			if v == e {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, &InvalidParamError{param, fmt.Sprintf("%s is not one of %s", v, strings.Join(valid, ", "))}
+		}
+	}
+	return s, nil
+}
+
+func parseDate(values url.Values, param string) (null.Time, error) {
+	v := values.Get(param)
+	if v == "" {
+		return null.Time{}, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", v, taipei)
+	if err != nil {
+		return null.Time{}, &InvalidParamError{param, fmt.Sprintf("%s is not a date in YYYY-MM-DD", v)}
+	}
+	return null.TimeFrom(t), nil
+}
+
+// ParseFilter parses the filter of the export from the parameters.
+// The dates of from and to are both included and counted in Taipei.
+func ParseFilter(values url.Values) (models.DonationExportFilter, error) {
+	var f models.DonationExportFilter
+	var err error
+
+	if f.From, err = parseDate(values, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseDate(values, "to"); err != nil {
+		return f, err
+	}
+	if f.To.Valid {
+		f.To = null.TimeFrom(f.To.Time.AddDate(0, 0, 1))
+	}
+	if f.From.Valid && f.To.Valid && !f.From.Time.Before(f.To.Time) {
+		return f, &InvalidParamError{"to", "to should not be earlier than from"}
+	}
+
+	if f.Types, err = parseEnum(values, "type", validTypes); err != nil {
+		return f, err
+	}
+	if f.Statuses, err = parseEnum(values, "status", validStatuses); err != nil {
+		return f, err
+	}
+	if f.SendReceipts, err = parseEnum(values, "send_receipt", validSendReceipts); err != nil {
+		return f, err
+	}
+	f.PayMethods = splitValues(values, "pay_method")
+	f.MerchantIDs = splitValues(values, "merchant_id")
+
+	if v := values.Get("campaign_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return f, &InvalidParamError{"campaign_id", fmt.Sprintf("%s is not a campaign id", v)}
+		}
+		f.CampaignID = null.IntFrom(id)
+	}
+
+	return f, nil
+}
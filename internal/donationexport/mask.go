@@ -0,0 +1,45 @@
+package donationexport
+
+import "strings"
+
+// maskRunes keeps the first head and the last tail runes of s, and masks the rest with asterisks.
+// Only the first rune is kept if s is too short.
+func maskRunes(s string, head, tail int) string {
+	r := []rune(s)
+	if len(r) <= head+tail {
+		if len(r) <= 1 {
+			return strings.Repeat("*", len(r))
+		}
+		return string(r[0]) + strings.Repeat("*", len(r)-1)
+	}
+	return string(r[:head]) + strings.Repeat("*", len(r)-head-tail) + string(r[len(r)-tail:])
+}
+
+// maskName masks the name but the first and the last characters, e.g. 王*明
+func maskName(s string) string {
+	return maskRunes(s, 1, 1)
+}
+
+// maskEmail masks the local part of the email but the first character
+func maskEmail(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return maskRunes(s, 1, 0)
+	}
+	return maskRunes(s[:at], 1, 0) + s[at:]
+}
+
+// maskPhoneNumber keeps the prefix of the carrier and the last 3 digits
+func maskPhoneNumber(s string) string {
+	return maskRunes(s, 4, 3)
+}
+
+// maskID keeps the first 3 and the last 2 characters of the national id or the tax id
+func maskID(s string) string {
+	return maskRunes(s, 3, 2)
+}
+
+// maskAddress keeps the beginning of the address, which is usually the road
+func maskAddress(s string) string {
+	return maskRunes(s, 3, 0)
+}
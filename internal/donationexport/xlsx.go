@@ -0,0 +1,117 @@
+package donationexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// xlsxParts are the parts of the workbook other than the worksheet
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="donations" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxWriter writes the records into the only worksheet of the workbook as they come,
+// with the strings inlined in the cells, so that the records are not kept in memory
+type xlsxWriter struct {
+	zw      *zip.Writer
+	sheet   io.Writer
+	numeric map[int]bool
+	row     int
+	buf     bytes.Buffer
+}
+
+func newXLSXWriter(w io.Writer, numeric map[int]bool) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+
+	for _, p := range xlsxParts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err = io.WriteString(f, xml.Header+p.content); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	// the worksheet is the last part, so it is written until the writer is closed
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err = io.WriteString(sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &xlsxWriter{zw: zw, sheet: sheet, numeric: numeric}, nil
+}
+
+// columnName returns the name of the i-th column, e.g. A, Z, AA
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Write writes the record as a row, in which the values of the numeric columns are numbers except the header
+func (x *xlsxWriter) Write(record []string) error {
+	x.row++
+	row := strconv.Itoa(x.row)
+
+	x.buf.Reset()
+	x.buf.WriteString(`<row r="` + row + `">`)
+	for i, v := range record {
+		if v == "" {
+			continue
+		}
+
+		ref := columnName(i) + row
+		if x.row > 1 && x.numeric[i] {
+			x.buf.WriteString(`<c r="` + ref + `"><v>` + v + `</v></c>`)
+			continue
+		}
+
+		x.buf.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(&x.buf, []byte(v))
+		x.buf.WriteString(`</t></is></c>`)
+	}
+	x.buf.WriteString(`</row>`)
+
+	_, err := x.sheet.Write(x.buf.Bytes())
+	return errors.WithStack(err)
+}
+
+// Abort leaves the zip of the workbook without the central directory, so that the workbook fails to be opened
+func (x *xlsxWriter) Abort(count int) error {
+	return nil
+}
+
+func (x *xlsxWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(x.zw.Close())
+}
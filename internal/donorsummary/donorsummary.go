@@ -0,0 +1,186 @@
+package donorsummary
+
+// package donorsummary summarises the donations of a donor for the dashboard of the supporters site,
+// which used to be stitched together from the donation lists on the client.
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/models"
+)
+
+// taipei is where the calendar year of the donations is counted in
+var taipei = time.FixedZone("Asia/Taipei", 8*60*60)
+
+// statuses of the periodic donations
+const (
+	statusPaying = "paying"
+	statusPaid   = "paid"
+	statusFail   = "fail"
+)
+
+// receiptYears is how many calendar years the yearly receipts could be downloaded for, including the current one
+const receiptYears = 2
+
+// trailblazerMonths is how many recent months the periodic donations are counted in for the trailblazer role,
+// which is the same as storage.IsTrailblazer
+const trailblazerMonths = 2
+
+// Donation is a paid donation of the donor in TWD, made either online or offline
+type Donation struct {
+	Amount uint
+	At     time.Time
+}
+
+// Total is the amount in TWD and the number of the donations
+type Total struct {
+	Amount uint `json:"amount"`
+	Count  int  `json:"count"`
+}
+
+// YearTotal is the total of the donations in a calendar year of Taiwan
+type YearTotal struct {
+	Year int `json:"year"`
+	Total
+	ReceiptAvailable bool `json:"receipt_available"` // whether the yearly receipt could be downloaded
+}
+
+// PeriodicDonation is an active periodic donation and when it is charged next
+type PeriodicDonation struct {
+	OrderNumber   string    `json:"order_number"`
+	Amount        uint      `json:"amount"`
+	Currency      string    `json:"currency"`
+	Frequency     string    `json:"frequency"`
+	Status        string    `json:"status"`
+	LastSuccessAt null.Time `json:"last_success_at"`
+	PausedUntil   null.Time `json:"paused_until"`
+	NextChargeAt  null.Time `json:"next_charge_at"`
+}
+
+// RoleProgress is how far the donor is from the next role
+type RoleProgress struct {
+	Key            string `json:"key"`
+	CurrentAmount  uint   `json:"current_amount"`
+	RequiredAmount uint   `json:"required_amount"`
+}
+
+// Summary is the donations of the donor summarised
+type Summary struct {
+	Lifetime          Total              `json:"lifetime"`
+	Years             []YearTotal        `json:"years"`
+	PeriodicDonations []PeriodicDonation `json:"periodic_donations"`
+	NextRole          *RoleProgress      `json:"next_role"`
+}
+
+// Build summarises the donations and the periodic donations of the donor whose current role is roleKey,
+// which is empty if the donor has no role
+func Build(donations []Donation, periodic []models.PeriodicDonationOverview, roleKey string, now time.Time) Summary {
+	s := Summary{
+		Years:             make([]YearTotal, 0),
+		PeriodicDonations: make([]PeriodicDonation, 0),
+	}
+
+	years := make(map[int]*YearTotal)
+	for _, d := range donations {
+		s.Lifetime.Amount += d.Amount
+		s.Lifetime.Count++
+
+		year := d.At.In(taipei).Year()
+		y, ok := years[year]
+		if !ok {
+			y = &YearTotal{Year: year}
+			years[year] = y
+		}
+		y.Amount += d.Amount
+		y.Count++
+	}
+
+	currentYear := now.In(taipei).Year()
+	for _, y := range years {
+		y.ReceiptAvailable = y.Year > currentYear-receiptYears
+		s.Years = append(s.Years, *y)
+	}
+	sort.Slice(s.Years, func(i, j int) bool {
+		return s.Years[i].Year > s.Years[j].Year
+	})
+
+	for _, p := range periodic {
+		if !isActive(p) {
+			continue
+		}
+		s.PeriodicDonations = append(s.PeriodicDonations, PeriodicDonation{
+			OrderNumber:   p.OrderNumber,
+			Amount:        p.Amount,
+			Currency:      p.Currency,
+			Frequency:     p.Frequency,
+			Status:        p.Status,
+			LastSuccessAt: p.LastSuccessAt,
+			PausedUntil:   p.PausedUntil,
+			NextChargeAt:  nextChargeAt(p),
+		})
+	}
+
+	s.NextRole = nextRole(roleKey, s.Lifetime, periodic, now)
+
+	return s
+}
+
+// isActive reports whether the periodic donation is still charged
+func isActive(p models.PeriodicDonationOverview) bool {
+	switch p.Status {
+	case statusPaid, statusPaying, statusFail:
+		return p.PaidTimes < p.MaxPaidTimes
+	default:
+		return false
+	}
+}
+
+// nextChargeAt returns when the periodic donation is due by the periodic charge job,
+// which is null if it is being charged or has not been charged successfully
+func nextChargeAt(p models.PeriodicDonationOverview) null.Time {
+	var next time.Time
+
+	switch {
+	case p.Status == statusFail && p.NextRetryAt.Valid:
+		next = p.NextRetryAt.Time
+	case p.Status == statusPaid && p.LastSuccessAt.Valid:
+		if p.Frequency == "yearly" {
+			next = p.LastSuccessAt.Time.AddDate(1, 0, 0)
+		} else {
+			next = p.LastSuccessAt.Time.AddDate(0, 1, 0)
+		}
+	default:
+		return null.Time{}
+	}
+
+	if p.PausedUntil.Valid && p.PausedUntil.Time.After(next) {
+		next = p.PausedUntil.Time
+	}
+
+	return null.TimeFrom(next)
+}
+
+// nextRole returns the progress toward the role next to roleKey, which is nil for the top role.
+// The donor becomes an action taker after any donation,
+// and a trailblazer if the periodic donations charged recently add up to constants.RoleTrailblazerAmount.
+func nextRole(roleKey string, lifetime Total, periodic []models.PeriodicDonationOverview, now time.Time) *RoleProgress {
+	switch roleKey {
+	case constants.RoleTrailblazer:
+		return nil
+	case constants.RoleActionTaker:
+		var amount uint
+		since := now.AddDate(0, -trailblazerMonths, 0)
+		for _, p := range periodic {
+			if p.LastSuccessAt.Valid && !p.LastSuccessAt.Time.Before(since) {
+				amount += p.Amount
+			}
+		}
+		return &RoleProgress{Key: constants.RoleTrailblazer, CurrentAmount: amount, RequiredAmount: constants.RoleTrailblazerAmount}
+	default:
+		return &RoleProgress{Key: constants.RoleActionTaker, CurrentAmount: lifetime.Amount, RequiredAmount: 1}
+	}
+}
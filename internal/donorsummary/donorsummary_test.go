@@ -0,0 +1,92 @@
+package donorsummary
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestBuild(t *testing.T) {
+	now := time.Date(2026, 3, 10, 0, 0, 0, 0, taipei)
+	lastSuccessAt := time.Date(2026, 2, 20, 0, 0, 0, 0, taipei)
+
+	donations := []Donation{
+		// the first day of 2024 in Taiwan
+		{Amount: 300, At: time.Date(2023, 12, 31, 16, 0, 0, 0, time.UTC)},
+		{Amount: 1000, At: time.Date(2025, 6, 1, 0, 0, 0, 0, taipei)},
+		{Amount: 500, At: time.Date(2026, 1, 20, 0, 0, 0, 0, taipei)},
+		{Amount: 500, At: lastSuccessAt},
+	}
+	periodic := []models.PeriodicDonationOverview{
+		{OrderNumber: "monthly", Amount: 300, Frequency: "monthly", Status: "paid", LastSuccessAt: null.TimeFrom(lastSuccessAt), MaxPaidTimes: math.MaxInt32, PaidTimes: 2},
+		{OrderNumber: "paused", Amount: 100, Frequency: "monthly", Status: "paid", LastSuccessAt: null.TimeFrom(lastSuccessAt), PausedUntil: null.TimeFrom(time.Date(2026, 6, 1, 0, 0, 0, 0, taipei)), MaxPaidTimes: math.MaxInt32, PaidTimes: 1},
+		{OrderNumber: "retrying", Amount: 200, Frequency: "yearly", Status: "fail", NextRetryAt: null.TimeFrom(time.Date(2026, 3, 11, 0, 0, 0, 0, taipei)), MaxPaidTimes: math.MaxInt32},
+		{OrderNumber: "finished", Amount: 500, Frequency: "monthly", Status: "paid", LastSuccessAt: null.TimeFrom(lastSuccessAt), MaxPaidTimes: 3, PaidTimes: 3},
+		{OrderNumber: "stopped", Amount: 1000, Frequency: "monthly", Status: "stopped", MaxPaidTimes: math.MaxInt32},
+	}
+
+	s := Build(donations, periodic, constants.RoleActionTaker, now)
+
+	if s.Lifetime != (Total{Amount: 2300, Count: 4}) {
+		t.Errorf("lifetime = %+v", s.Lifetime)
+	}
+
+	wantYears := []YearTotal{
+		{Year: 2026, Total: Total{Amount: 1000, Count: 2}, ReceiptAvailable: true},
+		{Year: 2025, Total: Total{Amount: 1000, Count: 1}, ReceiptAvailable: true},
+		{Year: 2024, Total: Total{Amount: 300, Count: 1}},
+	}
+	if len(s.Years) != len(wantYears) {
+		t.Fatalf("got %d years, want %d", len(s.Years), len(wantYears))
+	}
+	for i, want := range wantYears {
+		if s.Years[i] != want {
+			t.Errorf("year %d = %+v, want %+v", i, s.Years[i], want)
+		}
+	}
+
+	wantNext := map[string]time.Time{
+		"monthly":  time.Date(2026, 3, 20, 0, 0, 0, 0, taipei),
+		"paused":   time.Date(2026, 6, 1, 0, 0, 0, 0, taipei),
+		"retrying": time.Date(2026, 3, 11, 0, 0, 0, 0, taipei),
+	}
+	if len(s.PeriodicDonations) != len(wantNext) {
+		t.Fatalf("got %d active periodic donations, want %d", len(s.PeriodicDonations), len(wantNext))
+	}
+	for _, p := range s.PeriodicDonations {
+		if !p.NextChargeAt.Time.Equal(wantNext[p.OrderNumber]) {
+			t.Errorf("%s is charged next at %s, want %s", p.OrderNumber, p.NextChargeAt.Time, wantNext[p.OrderNumber])
+		}
+	}
+
+	// the finished donation charged recently still counts toward the trailblazer role
+	want := RoleProgress{Key: constants.RoleTrailblazer, CurrentAmount: 900, RequiredAmount: constants.RoleTrailblazerAmount}
+	if s.NextRole == nil || *s.NextRole != want {
+		t.Errorf("next role = %+v, want %+v", s.NextRole, want)
+	}
+}
+
+func TestNextRole(t *testing.T) {
+	now := time.Now()
+
+	if p := nextRole(constants.RoleTrailblazer, Total{}, nil, now); p != nil {
+		t.Errorf("next role of trailblazer = %+v, want nil", p)
+	}
+
+	want := RoleProgress{Key: constants.RoleActionTaker, CurrentAmount: 0, RequiredAmount: 1}
+	for _, roleKey := range []string{"", constants.RoleExplorer} {
+		if p := nextRole(roleKey, Total{}, nil, now); p == nil || *p != want {
+			t.Errorf("next role of %q = %+v, want %+v", roleKey, p, want)
+		}
+	}
+
+	s := Build(nil, nil, "", now)
+	if s.Years == nil || s.PeriodicDonations == nil {
+		t.Errorf("empty summary should be responded as empty arrays")
+	}
+}
@@ -0,0 +1,51 @@
+package member_cms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+const userDeletionEndpoint = "/user/deletion"
+
+// PostUserDeletion notifies member cms to erase the personal data of the user
+func PostUserDeletion(userID uint) error {
+	if !globals.Conf.Features.MemberCMS {
+		return errors.New("disable intergrating with member cms")
+	}
+
+	url, err := GetApiBaseUrl()
+	if err != nil {
+		return err
+	}
+	url = url + userDeletionEndpoint
+
+	payload := map[string]uint{"user_id": userID}
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	AppendRequiredHeader(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("member cms responds with status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
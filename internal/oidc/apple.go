@@ -0,0 +1,53 @@
+package oidc
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// AppleIssuer is the issuer of Sign in with Apple
+const AppleIssuer = "https://appleid.apple.com"
+
+// appleClientSecretExpiration is kept short since the secret is generated for each token request
+const appleClientSecretExpiration = 5 * time.Minute
+
+// AppleClientSecret returns a ClientSecretFunc signing the client secret by the private key
+// downloaded from Apple developer account.
+// See https://developer.apple.com/documentation/sign_in_with_apple/generate_and_validate_tokens
+func AppleClientSecret(teamID, keyID, clientID, privateKeyPath string) (func() (string, error), error) {
+	pem, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read apple private key")
+	}
+
+	key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid apple private key")
+	}
+
+	return func() (string, error) {
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+			Issuer:    teamID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(appleClientSecretExpiration).Unix(),
+			Audience:  AppleIssuer,
+			Subject:   clientID,
+		})
+		token.Header["kid"] = keyID
+		return token.SignedString(key)
+	}, nil
+}
+
+// AppleUser is the `user` form value posted by Apple at the first authorization.
+// Apple never releases the name of the user in ID token.
+type AppleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
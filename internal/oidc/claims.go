@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Claims are the claims of an ID token used by us
+type Claims struct {
+	Issuer        string        `json:"iss"`
+	Subject       string        `json:"sub"`
+	Audience      audience      `json:"aud"`
+	ExpiresAt     int64         `json:"exp"`
+	IssuedAt      int64         `json:"iat"`
+	Nonce         string        `json:"nonce"`
+	Email         string        `json:"email"`
+	EmailVerified *flexibleBool `json:"email_verified"`
+	Name          string        `json:"name"`
+	GivenName     string        `json:"given_name"`
+	FamilyName    string        `json:"family_name"`
+	Picture       string        `json:"picture"`
+}
+
+// Valid implements jwt.Claims interface
+func (c Claims) Valid() error {
+	now := time.Now().Unix()
+
+	if c.ExpiresAt == 0 || now > c.ExpiresAt+leeway {
+		return errors.New("token is expired")
+	}
+
+	if c.IssuedAt > now+leeway {
+		return errors.New("token used before issued")
+	}
+
+	return nil
+}
+
+// VerifiedEmail returns the email unless the provider marks it as unverified.
+// email_verified claim is optional, the providers without it only release verified emails.
+func (c Claims) VerifiedEmail() (string, bool) {
+	if c.Email == "" || (c.EmailVerified != nil && !bool(*c.EmailVerified)) {
+		return "", false
+	}
+	return c.Email, true
+}
+
+// audience could be a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return errors.WithStack(err)
+	}
+	*a = audience(ss)
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, v := range a {
+		if v == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// flexibleBool accepts both boolean and string values,
+// since Apple sends email_verified as "true" or "false".
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch v := v.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		*b = flexibleBool(parsed)
+	default:
+		return errors.Errorf("can not unmarshal %s into bool", data)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// minRefreshInterval prevents from fetching the keys on every token with unknown kid
+const minRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keyCache caches the public keys of the provider,
+// and refetches them when a token is signed by an unknown key
+type keyCache struct {
+	uri    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func newKeyCache(uri string, client *http.Client) *keyCache {
+	return &keyCache{uri: uri, client: client}
+}
+
+func (kc *keyCache) get(kid string) (interface{}, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if key, ok := kc.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(kc.fetchedAt) < minRefreshInterval {
+		return nil, errors.Errorf("unknown kid: %s", kid)
+	}
+
+	keys, err := kc.fetch()
+	if err != nil {
+		return nil, err
+	}
+	kc.keys, kc.fetchedAt = keys, time.Now()
+
+	if key, ok := kc.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, errors.Errorf("unknown kid: %s", kid)
+}
+
+func (kc *keyCache) fetch() (map[string]interface{}, error) {
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	resp, err := kc.client.Get(kc.uri)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not fetch jwks(%s)", kc.uri))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("jwks(%s) responds with status code %d", kc.uri, resp.StatusCode)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not decode jwks(%s)", kc.uri))
+	}
+
+	keys := make(map[string]interface{}, len(body.Keys))
+	for _, jwk := range body.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// skip the keys we do not understand rather than failing the others
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, errors.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.Errorf("key(kid: %s) is not on P-256 curve", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, errors.Errorf("unsupported key type: %s", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
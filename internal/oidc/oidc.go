@@ -0,0 +1,268 @@
+package oidc
+
+// package oidc implements the authorization code flow of OpenID Connect providers,
+// including discovery, PKCE, nonce and ID token validation.
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	// ResponseModeFormPost makes the provider POST the authorization response to the callback,
+	// which is required by Sign in with Apple when name or email scope is requested.
+	ResponseModeFormPost = "form_post"
+
+	// leeway tolerates the clock skew between the provider and us
+	leeway = 60
+)
+
+// Config describes an OpenID Connect provider and our client registration
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	ResponseMode string
+
+	// ClientSecretFunc generates the client secret for each token request.
+	// It is used by providers like Apple which expects a signed JWT as the client secret.
+	ClientSecretFunc func() (string, error)
+}
+
+// Metadata is the provider metadata fetched from the discovery endpoint
+type Metadata struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JwksURI                          string   `json:"jwks_uri"`
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Provider is an OpenID Connect provider.
+// The metadata and the keys are fetched lazily and cached,
+// so that an unreachable provider does not stop the server from starting.
+type Provider struct {
+	conf   Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *keyCache
+}
+
+// NewProvider returns a provider of the config
+func NewProvider(conf Config) *Provider {
+	return &Provider{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Metadata returns the provider metadata, and does the discovery at the first call
+func (p *Provider) Metadata() (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.conf.Issuer, "/") + discoveryPath
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not fetch oidc discovery document(%s)", endpoint))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("oidc discovery document(%s) responds with status code %d", endpoint, resp.StatusCode)
+	}
+
+	var m Metadata
+	if err = json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not decode oidc discovery document(%s)", endpoint))
+	}
+
+	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if m.Issuer != p.conf.Issuer {
+		return nil, errors.Errorf("issuer in discovery document is %s, but %s is expected", m.Issuer, p.conf.Issuer)
+	}
+
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JwksURI == "" {
+		return nil, errors.Errorf("oidc discovery document(%s) lacks required endpoints", endpoint)
+	}
+
+	p.metadata = &m
+	p.keys = newKeyCache(m.JwksURI, p.client)
+
+	return p.metadata, nil
+}
+
+func (p *Provider) oauth2Config(m *Metadata, secret string) *oauth2.Config {
+	scopes := p.conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.conf.ClientID,
+		ClientSecret: secret,
+		RedirectURL:  p.conf.RedirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   m.AuthorizationEndpoint,
+			TokenURL:  m.TokenEndpoint,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// AuthCodeURL returns the url of the authorization endpoint.
+// nonce is bound to the ID token, and codeVerifier is used for PKCE.
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	m, err := p.Metadata()
+	if err != nil {
+		return "", err
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("code_challenge", CodeChallenge(codeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", CodeChallengeMethod),
+	}
+	if p.conf.ResponseMode != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("response_mode", p.conf.ResponseMode))
+	}
+
+	return p.oauth2Config(m, "").AuthCodeURL(state, opts...), nil
+}
+
+// Exchange exchanges the authorization code for the tokens,
+// and returns the validated claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	m, err := p.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := p.conf.ClientSecret
+	if p.conf.ClientSecretFunc != nil {
+		if secret, err = p.conf.ClientSecretFunc(); err != nil {
+			return nil, errors.Wrap(err, "can not generate client secret")
+		}
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth2Config(m, secret).Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("id_token is not found in token response")
+	}
+
+	return p.VerifyIDToken(rawIDToken, nonce)
+}
+
+// VerifyIDToken validates the signature, issuer, audience, expiration and nonce of the ID token
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string) (*Claims, error) {
+	m, err := p.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	_, err = jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		return p.verificationKey(m, token)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "id_token is invalid")
+	}
+
+	if claims.Issuer != m.Issuer {
+		return nil, errors.Errorf("id_token is issued by %s, but %s is expected", claims.Issuer, m.Issuer)
+	}
+
+	if !claims.Audience.contains(p.conf.ClientID) {
+		return nil, errors.Errorf("id_token is not issued to client %s", p.conf.ClientID)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("sub claim of id_token is empty")
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("nonce of id_token mismatches")
+	}
+
+	return &claims, nil
+}
+
+func (p *Provider) verificationKey(m *Metadata, token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		// some providers, like LINE, sign ID tokens with the client secret
+		if alg != jwt.SigningMethodHS256.Alg() || !supports(m, alg) || p.conf.ClientSecret == "" {
+			return nil, errors.Errorf("unexpected signing method: %s", alg)
+		}
+		return []byte(p.conf.ClientSecret), nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, errors.Errorf("unexpected signing method: %s", alg)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, err := p.keys.get(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// prevent from verifying ES256 token with RSA key, and vice versa
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, errors.Errorf("signing method %s mismatches key(kid: %s)", alg, kid)
+		}
+	default:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.Errorf("signing method %s mismatches key(kid: %s)", alg, kid)
+		}
+	}
+
+	return key, nil
+}
+
+// supports reports whether the alg is listed in the metadata.
+// RS256 must be supported by every provider.
+func supports(m *Metadata, alg string) bool {
+	if len(m.IDTokenSigningAlgValuesSupported) == 0 {
+		return alg == jwt.SigningMethodRS256.Alg()
+	}
+
+	for _, v := range m.IDTokenSigningAlgValuesSupported {
+		if v == alg {
+			return true
+		}
+	}
+
+	return false
+}
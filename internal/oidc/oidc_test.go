@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientID     = "client-id"
+	testClientSecret = "client-secret"
+	testNonce        = "nonce"
+	testCode         = "code"
+)
+
+type testServer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	algs    []string
+	idToken string
+}
+
+func newTestServer(t *testing.T, algs []string) *testServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{key: key, algs: algs}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                           ts.URL,
+			AuthorizationEndpoint:            ts.URL + "/authorize",
+			TokenEndpoint:                    ts.URL + "/token",
+			JwksURI:                          ts.URL + "/jwks",
+			IDTokenSigningAlgValuesSupported: ts.algs,
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kty: "RSA",
+				Kid: "key-1",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != testCode || r.Form.Get("code_verifier") == "" || r.Form.Get("client_secret") != testClientSecret {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     ts.idToken,
+		})
+	})
+	ts.Server = httptest.NewServer(mux)
+
+	return ts
+}
+
+func (ts *testServer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            ts.URL,
+		"sub":            "subject",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          testNonce,
+		"email":          "oidc@twreporter.org",
+		"email_verified": "true",
+	}
+}
+
+func (ts *testServer) sign(t *testing.T, claims jwt.MapClaims, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(ts.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (ts *testServer) provider() *Provider {
+	return NewProvider(Config{
+		Issuer:       ts.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  "http://localhost/callback",
+		ResponseMode: ResponseModeFormPost,
+	})
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// example in RFC 7636 Appendix B
+	if got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("unexpected code challenge: %s", got)
+	}
+
+	verifier, _ := GenerateCodeVerifier()
+	if len(verifier) != 43 {
+		t.Errorf("code verifier should be 43 characters, but got %d", len(verifier))
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+
+	authURL, err := ts.provider().AuthCodeURL("state", testNonce, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if q.Get("state") != "state" || q.Get("nonce") != testNonce || q.Get("response_mode") != ResponseModeFormPost ||
+		q.Get("code_challenge") != CodeChallenge("verifier") || q.Get("code_challenge_method") != CodeChallengeMethod {
+		t.Errorf("unexpected auth code url: %s", authURL)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+	p := ts.provider()
+
+	ts.idToken = ts.sign(t, ts.claims(), "key-1")
+	claims, err := p.Exchange(context.Background(), testCode, "verifier", testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email, ok := claims.VerifiedEmail(); claims.Subject != "subject" || !ok || email != "oidc@twreporter.org" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	if _, err = p.Exchange(context.Background(), "wrong", "verifier", testNonce); err == nil {
+		t.Errorf("invalid code should be rejected")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+	p := ts.provider()
+
+	claims := ts.claims()
+	claims["aud"] = []string{"another-client", testClientID}
+	claims["email_verified"] = false
+	verified, err := p.VerifyIDToken(ts.sign(t, claims, "key-1"), testNonce)
+	if err != nil {
+		t.Errorf("token with audience array should be verified: %v", err)
+	} else if _, ok := verified.VerifiedEmail(); ok {
+		t.Errorf("unverified email should not be returned")
+	}
+
+	cases := map[string]func(jwt.MapClaims){
+		"nonce mismatch":  func(c jwt.MapClaims) { c["nonce"] = "another" },
+		"audience":        func(c jwt.MapClaims) { c["aud"] = "another-client" },
+		"issuer":          func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":         func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"missing subject": func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, modify := range cases {
+		claims := ts.claims()
+		modify(claims)
+		if _, err := p.VerifyIDToken(ts.sign(t, claims, "key-1"), testNonce); err == nil {
+			t.Errorf("%s: token should be rejected", name)
+		}
+	}
+
+	if _, err := p.VerifyIDToken(ts.sign(t, ts.claims(), "unknown"), testNonce); err == nil {
+		t.Errorf("token with unknown kid should be rejected")
+	}
+
+	// HS256 is only accepted if the provider supports it
+	hsToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, ts.claims()).SignedString([]byte(testClientSecret))
+	if _, err := p.VerifyIDToken(hsToken, testNonce); err == nil {
+		t.Errorf("HS256 token should be rejected")
+	}
+
+	hsServer := newTestServer(t, []string{"HS256", "ES256"})
+	defer hsServer.Close()
+	hsToken, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, hsServer.claims()).SignedString([]byte(testClientSecret))
+	if _, err := hsServer.provider().VerifyIDToken(hsToken, testNonce); err != nil {
+		t.Errorf("HS256 token should be verified by client secret: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// CodeChallengeMethod is the PKCE code challenge method described in RFC 7636
+const CodeChallengeMethod = "S256"
+
+// GenerateRandom returns a url safe random string of n bytes entropy.
+// It is used to generate state, nonce and PKCE code verifier.
+func GenerateRandom(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateCodeVerifier returns a PKCE code verifier of 43 characters
+func GenerateCodeVerifier() (string, error) {
+	return GenerateRandom(32)
+}
+
+// CodeChallenge returns the S256 code challenge of the verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryCounter struct {
+	windowStart time.Time
+	expiredAt   time.Time
+	count       int
+}
+
+// MemoryStore keeps counters in process memory.
+// It is meant for tests and single replica deployments.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*memoryCounter)}
+}
+
+// Incr method of Store interface
+func (s *MemoryStore) Incr(key string, windowStart time.Time, expiredAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(windowStart)
+
+	c, ok := s.counters[key]
+	if !ok || !c.windowStart.Equal(windowStart) {
+		c = &memoryCounter{windowStart: windowStart, expiredAt: expiredAt}
+		s.counters[key] = c
+	}
+	c.count++
+
+	return c.count, nil
+}
+
+// evict drops the counters expired before t
+func (s *MemoryStore) evict(t time.Time) {
+	for k, c := range s.counters {
+		if !c.expiredAt.After(t) {
+			delete(s.counters, k)
+		}
+	}
+}
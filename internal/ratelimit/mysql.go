@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// MySQLStore keeps counters in `rate_limit_counters` table,
+// so that the limits are shared among replicas.
+type MySQLStore struct {
+	db *gorm.DB
+}
+
+// NewMySQLStore returns a MySQLStore connected by gorm
+func NewMySQLStore(db *gorm.DB) *MySQLStore {
+	return &MySQLStore{db: db}
+}
+
+type counterRow struct {
+	Count int
+}
+
+// Incr method of Store interface
+func (s *MySQLStore) Incr(key string, windowStart time.Time, expiredAt time.Time) (int, error) {
+	var row counterRow
+
+	// the statements should be run in the same connection,
+	// otherwise the SELECT might not see the UPSERT
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	err := tx.Exec("DELETE FROM rate_limit_counters WHERE `key` = ? AND expired_at <= ?", key, windowStart).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, fmt.Sprintf("can not purge expired counters(key: %s)", key))
+	}
+
+	err = tx.Exec("INSERT INTO rate_limit_counters (`key`, window_start, expired_at, count) VALUES (?, ?, ?, 1) ON DUPLICATE KEY UPDATE count = count + 1", key, windowStart, expiredAt).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, fmt.Sprintf("can not increase counter(key: %s)", key))
+	}
+
+	err = tx.Raw("SELECT count FROM rate_limit_counters WHERE `key` = ? AND window_start = ?", key, windowStart).Scan(&row).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, fmt.Sprintf("can not get counter(key: %s)", key))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return row.Count, nil
+}
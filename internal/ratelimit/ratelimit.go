@@ -0,0 +1,73 @@
+package ratelimit
+
+// package ratelimit provides fixed window rate limiting
+// backed by a pluggable counter store.
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	StoreMemory = "memory"
+	StoreMySQL  = "mysql"
+)
+
+// Store persists the hit counters of fixed windows
+type Store interface {
+	// Incr increases the counter of key in the window starting at windowStart
+	// and returns the counter after increment.
+	// The counter could be discarded after expiredAt.
+	Incr(key string, windowStart time.Time, expiredAt time.Time) (int, error)
+}
+
+// Rule describes how many hits are allowed within a window
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Result is the outcome of Limiter.Allow
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter checks hits against rules with fixed windows
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewLimiter returns a Limiter storing counters in store
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Allow records a hit of key and reports whether the hit is within rule.
+// A rule with non-positive limit or window is treated as unlimited.
+func (l *Limiter) Allow(key string, rule Rule) (Result, error) {
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	now := l.now()
+	windowStart := now.Truncate(rule.Window)
+	windowEnd := windowStart.Add(rule.Window)
+
+	count, err := l.store.Incr(key, windowStart, windowEnd)
+	if err != nil {
+		return Result{}, errors.WithStack(err)
+	}
+
+	if count > rule.Limit {
+		return Result{Allowed: false, RetryAfter: windowEnd.Sub(now)}, nil
+	}
+
+	return Result{Allowed: true, Remaining: rule.Limit - count}, nil
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(NewMemoryStore())
+	l.now = func() time.Time { return now }
+
+	rule := Rule{Limit: 2, Window: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		r, err := l.Allow("email:a@twreporter.org", rule)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !r.Allowed {
+			t.Fatalf("hit %d should be allowed", i+1)
+		}
+		if r.Remaining != 1-i {
+			t.Errorf("hit %d remaining = %d, want %d", i+1, r.Remaining, 1-i)
+		}
+	}
+
+	// the other key has its own counter
+	if r, _ := l.Allow("email:b@twreporter.org", rule); !r.Allowed {
+		t.Errorf("hit of another key should be allowed")
+	}
+
+	now = now.Add(20 * time.Second)
+	r, _ := l.Allow("email:a@twreporter.org", rule)
+	if r.Allowed {
+		t.Fatalf("hit exceeding the limit should be rejected")
+	}
+	if r.RetryAfter != 40*time.Second {
+		t.Errorf("retry after = %v, want %v", r.RetryAfter, 40*time.Second)
+	}
+
+	// counter is reset in the next window
+	now = now.Add(40 * time.Second)
+	if r, _ := l.Allow("email:a@twreporter.org", rule); !r.Allowed {
+		t.Errorf("hit in the next window should be allowed")
+	}
+}
+
+func TestLimiterUnlimitedRule(t *testing.T) {
+	l := NewLimiter(NewMemoryStore())
+
+	for i := 0; i < 10; i++ {
+		if r, _ := l.Allow("ip:127.0.0.1", Rule{}); !r.Allowed {
+			t.Fatalf("zero rule should not limit hits")
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package receipt
+
+var (
+	chineseDigits = []string{"零", "壹", "貳", "參", "肆", "伍", "陸", "柒", "捌", "玖"}
+	// units of the digits within a group of four digits
+	chineseUnits = []string{"", "拾", "佰", "仟"}
+	// units of the groups of four digits
+	chineseGroupUnits = []string{"", "萬", "億", "兆"}
+)
+
+// toChineseAmount spells out the amount in the financial numerals required on the receipts,
+// e.g. 10500 is spelled as 壹萬零伍佰元整
+func toChineseAmount(amount uint) string {
+	if amount == 0 {
+		return "零元整"
+	}
+
+	var groups []uint
+	for n := amount; n > 0; n /= 10000 {
+		groups = append(groups, n%10000)
+	}
+
+	s := ""
+	skipped := false
+	for i := len(groups) - 1; i >= 0; i-- {
+		g := groups[i]
+		if g == 0 {
+			skipped = true
+			continue
+		}
+		// a zero is read between the groups if the digits in between are all zeros
+		if s != "" && (skipped || g < 1000) {
+			s += chineseDigits[0]
+		}
+		skipped = false
+		s += spellGroup(g) + chineseGroupUnits[i]
+	}
+
+	return s + "元整"
+}
+
+// spellGroup spells a group of four digits, reading the consecutive zeros in the middle as a single zero
+func spellGroup(g uint) string {
+	s := ""
+	zero := false
+	for pos, base := 3, uint(1000); pos >= 0; pos, base = pos-1, base/10 {
+		d := g / base % 10
+		if d == 0 {
+			zero = s != ""
+			continue
+		}
+		if zero {
+			s += chineseDigits[0]
+			zero = false
+		}
+		s += chineseDigits[d] + chineseUnits[pos]
+	}
+	return s
+}
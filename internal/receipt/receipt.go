@@ -0,0 +1,202 @@
+package receipt
+
+// package receipt renders the receipts of the donations in PDF,
+// which are served when the receipts could not be fetched from member cms.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pkg/errors"
+)
+
+const (
+	fontFamily = "receipt"
+	pageMargin = 20.0
+	lineHeight = 8.0
+)
+
+// ErrFontNotConfigured is returned if no font is provided to render the CJK texts
+var ErrFontNotConfigured = errors.New("font of the receipts is not configured")
+
+// taipei is where the dates on the receipts are printed in.
+// The fixed zone keeps the rendering independent of the tz database of the host.
+var taipei = time.FixedZone("Asia/Taipei", 8*60*60)
+
+var payMethodNames = map[string]string{
+	"credit_card": "信用卡",
+	"line":        "LINE Pay",
+	"apple":       "Apple Pay",
+	"google":      "Google Pay",
+	"samsung":     "Samsung Pay",
+	"atm":         "ATM 轉帳",
+	"cvs":         "超商代碼繳費",
+}
+
+// Issuer is the organization receiving the donations
+type Issuer struct {
+	Name  string
+	TaxID string
+}
+
+// Donor is whom the receipt is issued to
+type Donor struct {
+	Name       string
+	SecurityID string
+	Address    string
+}
+
+// Item is a paid donation listed on the receipt
+type Item struct {
+	Date time.Time
+	// receipt number of the prime donation, or order number of the charge of the periodic donation
+	Number    string
+	PayMethod string
+	Amount    uint // in TWD
+}
+
+// Receipt is the content of a receipt of one or more donations
+type Receipt struct {
+	Title  string
+	Number string
+	// IssuedAt is also written into the metadata of the PDF,
+	// so it should be derived from the donations for the receipt to render identically every time
+	IssuedAt time.Time
+	Donor    Donor
+	Items    []Item
+}
+
+// Total sums up the amounts of the items
+func (r Receipt) Total() uint {
+	var total uint
+	for _, item := range r.Items {
+		total += item.Amount
+	}
+	return total
+}
+
+// Renderer renders the receipts with the TrueType font containing the CJK glyphs
+type Renderer struct {
+	issuer   Issuer
+	fontPath string
+
+	once sync.Once
+	font []byte
+	err  error
+}
+
+// NewRenderer returns the renderer loading the font from fontPath on the first render
+func NewRenderer(fontPath string, issuer Issuer) *Renderer {
+	return &Renderer{issuer: issuer, fontPath: fontPath}
+}
+
+func (r *Renderer) loadFont() ([]byte, error) {
+	r.once.Do(func() {
+		if r.fontPath == "" {
+			r.err = ErrFontNotConfigured
+			return
+		}
+		if r.font, r.err = ioutil.ReadFile(r.fontPath); r.err != nil {
+			r.err = errors.Wrap(r.err, fmt.Sprintf("can not load font of the receipts from %s", r.fontPath))
+		}
+	})
+	return r.font, r.err
+}
+
+// Render writes the receipt in PDF to w
+func (r *Renderer) Render(w io.Writer, rc Receipt) error {
+	font, err := r.loadFont()
+	if err != nil {
+		return err
+	}
+
+	issuedAt := rc.IssuedAt.In(taipei)
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin)
+	// gofpdf stamps the current time and writes the objects in map order unless told otherwise
+	pdf.SetCreationDate(issuedAt)
+	pdf.SetModificationDate(issuedAt)
+	pdf.SetCatalogSort(true)
+	pdf.SetTitle(rc.Title, true)
+	pdf.SetCreator(r.issuer.Name, true)
+	pdf.AddUTF8FontFromBytes(fontFamily, "", font)
+	pdf.AddPage()
+
+	pdf.SetFont(fontFamily, "", 18)
+	pdf.CellFormat(0, 12, rc.Title, "", 1, "C", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont(fontFamily, "", 11)
+	field := func(label, value string) {
+		if value == "" {
+			return
+		}
+		pdf.CellFormat(45, lineHeight, label, "", 0, "L", false, 0, "")
+		pdf.MultiCell(0, lineHeight, value, "", "L", false)
+	}
+	field("收據編號", rc.Number)
+	field("開立日期", formatDate(issuedAt))
+	field("捐款人", rc.Donor.Name)
+	field("身分證字號／統一編號", rc.Donor.SecurityID)
+	field("地址", rc.Donor.Address)
+	pdf.Ln(4)
+
+	widths := []float64{35, 55, 40, 40}
+	pdf.SetFillColor(230, 230, 230)
+	for i, header := range []string{"捐款日期", "編號", "付款方式", "金額"} {
+		pdf.CellFormat(widths[i], lineHeight, header, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	for _, item := range rc.Items {
+		pdf.CellFormat(widths[0], lineHeight, formatDate(item.Date.In(taipei)), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[1], lineHeight, item.Number, "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[2], lineHeight, payMethodName(item.PayMethod), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[3], lineHeight, formatAmount(item.Amount), "1", 1, "R", false, 0, "")
+	}
+
+	total := rc.Total()
+	pdf.CellFormat(widths[0]+widths[1]+widths[2], lineHeight, "合計", "1", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[3], lineHeight, formatAmount(total), "1", 1, "R", false, 0, "")
+	pdf.Ln(2)
+	pdf.MultiCell(0, lineHeight, "新臺幣 "+toChineseAmount(total), "", "L", false)
+	pdf.Ln(8)
+
+	field("受贈單位", r.issuer.Name)
+	field("統一編號", r.issuer.TaxID)
+	pdf.Ln(4)
+	pdf.SetFont(fontFamily, "", 9)
+	pdf.MultiCell(0, 6, "本收據可作為申報綜合所得稅捐贈列舉扣除額之憑證。", "", "L", false)
+
+	if err := pdf.Output(w); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not render receipt %s", rc.Number))
+	}
+
+	return nil
+}
+
+func payMethodName(m string) string {
+	if name, ok := payMethodNames[m]; ok {
+		return name
+	}
+	return m
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// formatAmount formats the amount in TWD with the thousands separators, e.g. NT$ 12,000
+func formatAmount(amount uint) string {
+	s := fmt.Sprint(amount)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return "NT$ " + s
+}
@@ -0,0 +1,99 @@
+package receipt
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// testFontPath could be overridden by RECEIPT_TEST_FONT
+// to render with a font containing the CJK glyphs
+const testFontPath = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+
+func TestToChineseAmount(t *testing.T) {
+	cases := map[uint]string{
+		0:         "零元整",
+		5:         "伍元整",
+		100:       "壹佰元整",
+		1005:      "壹仟零伍元整",
+		1050:      "壹仟零伍拾元整",
+		10000:     "壹萬元整",
+		10500:     "壹萬零伍佰元整",
+		12000:     "壹萬貳仟元整",
+		100000005: "壹億零伍元整",
+		123456789: "壹億貳仟參佰肆拾伍萬陸仟柒佰捌拾玖元整",
+	}
+
+	for amount, want := range cases {
+		if got := toChineseAmount(amount); got != want {
+			t.Errorf("toChineseAmount(%d) = %s, want %s", amount, got, want)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := map[uint]string{
+		0:       "NT$ 0",
+		500:     "NT$ 500",
+		1000:    "NT$ 1,000",
+		1234567: "NT$ 1,234,567",
+	}
+
+	for amount, want := range cases {
+		if got := formatAmount(amount); got != want {
+			t.Errorf("formatAmount(%d) = %s, want %s", amount, got, want)
+		}
+	}
+}
+
+func TestRenderWithoutFont(t *testing.T) {
+	r := NewRenderer("", Issuer{Name: "財團法人報導者文化基金會"})
+
+	var buf bytes.Buffer
+	if err := r.Render(&buf, Receipt{}); err != ErrFontNotConfigured {
+		t.Errorf("Render() error = %v, want %v", err, ErrFontNotConfigured)
+	}
+}
+
+func TestRenderDeterministically(t *testing.T) {
+	fontPath := os.Getenv("RECEIPT_TEST_FONT")
+	if fontPath == "" {
+		fontPath = testFontPath
+	}
+	if _, err := os.Stat(fontPath); err != nil {
+		t.Skipf("font %s is not available: %v", fontPath, err)
+	}
+
+	r := NewRenderer(fontPath, Issuer{Name: "財團法人報導者文化基金會", TaxID: "12345678"})
+	paidAt := time.Date(2026, 1, 1, 16, 30, 0, 0, time.UTC)
+	rc := Receipt{
+		Title:    "2026 年度捐款收據",
+		Number:   "Y2026-000001",
+		IssuedAt: paidAt.AddDate(0, 1, 0),
+		Donor: Donor{
+			Name:    "報導者讀者",
+			Address: "台北市中正區",
+		},
+		Items: []Item{
+			{Date: paidAt, Number: "A202601-00001", PayMethod: "credit_card", Amount: 500},
+			{Date: paidAt.AddDate(0, 1, 0), Number: "twreporter-26020100000", PayMethod: "line", Amount: 1000},
+		},
+	}
+
+	render := func() []byte {
+		var buf bytes.Buffer
+		if err := r.Render(&buf, rc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	first := render()
+	if !bytes.HasPrefix(first, []byte("%PDF-")) {
+		t.Fatalf("rendered receipt is not a pdf")
+	}
+	if second := render(); !bytes.Equal(first, second) {
+		t.Errorf("the same receipt is rendered differently")
+	}
+}
@@ -0,0 +1,37 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryLease struct {
+	holder    string
+	expiredAt time.Time
+}
+
+// MemoryLeaseStore keeps leases in process memory.
+// It is meant for tests and single replica deployments.
+type MemoryLeaseStore struct {
+	mu     sync.Mutex
+	leases map[string]memoryLease
+}
+
+// NewMemoryLeaseStore returns an empty MemoryLeaseStore
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{leases: make(map[string]memoryLease)}
+}
+
+// Acquire method of LeaseStore interface
+func (s *MemoryLeaseStore) Acquire(name, holder string, now, expiredAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.leases[name]
+	if ok && l.holder != holder && l.expiredAt.After(now) {
+		return false, nil
+	}
+
+	s.leases[name] = memoryLease{holder: holder, expiredAt: expiredAt}
+	return true, nil
+}
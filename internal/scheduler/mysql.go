@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// MySQLLeaseStore keeps leases in `job_leases` table,
+// so that the leases are shared among replicas.
+type MySQLLeaseStore struct {
+	db *gorm.DB
+}
+
+// NewMySQLLeaseStore returns a MySQLLeaseStore connected by gorm
+func NewMySQLLeaseStore(db *gorm.DB) *MySQLLeaseStore {
+	return &MySQLLeaseStore{db: db}
+}
+
+type leaseRow struct {
+	Holder string
+}
+
+// Acquire method of LeaseStore interface
+func (s *MySQLLeaseStore) Acquire(name, holder string, now, expiredAt time.Time) (bool, error) {
+	var row leaseRow
+
+	// the statements should be run in the same connection,
+	// otherwise the SELECT might not see the UPDATE
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	err := tx.Exec("INSERT IGNORE INTO job_leases (name, holder, expired_at, updated_at) VALUES (?, ?, ?, ?)", name, holder, expiredAt, now).Error
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not create lease(name: %s)", name))
+	}
+
+	err = tx.Exec("UPDATE job_leases SET holder = ?, expired_at = ?, updated_at = ? WHERE name = ? AND (holder = ? OR expired_at <= ?)", holder, expiredAt, now, name, holder, now).Error
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not renew lease(name: %s)", name))
+	}
+
+	err = tx.Raw("SELECT holder FROM job_leases WHERE name = ?", name).Scan(&row).Error
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not get lease(name: %s)", name))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return row.Holder == holder, nil
+}
@@ -0,0 +1,102 @@
+package scheduler
+
+// package scheduler runs periodic jobs on one replica at a time.
+// The replica running a job holds the lease of it in a store shared among replicas.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// LeaseStore persists the leases of jobs
+type LeaseStore interface {
+	// Acquire takes the lease of name for holder until expiredAt
+	// if the lease is free, expired at now, or held by holder already.
+	// It reports whether holder holds the lease afterwards.
+	Acquire(name, holder string, now, expiredAt time.Time) (bool, error)
+}
+
+// Job is run every Interval by the replica holding its lease
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs with the leases in store on behalf of holder
+type Scheduler struct {
+	store  LeaseStore
+	holder string
+	now    func() time.Time
+}
+
+// New returns a Scheduler identified by holder among replicas
+func New(store LeaseStore, holder string) *Scheduler {
+	return &Scheduler{
+		store:  store,
+		holder: holder,
+		now:    time.Now,
+	}
+}
+
+// DefaultHolder identifies the running process by its hostname and pid
+func DefaultHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// RunOnce runs the job if the lease of it is acquired, and reports whether the job is run.
+// The lease lasts an interval, so that the job is run at most once an interval among replicas,
+// and is taken over by another replica if the holder stops renewing it.
+// The run is cancelled once the lease expires.
+func (s *Scheduler) RunOnce(ctx context.Context, job Job) (bool, error) {
+	now := s.now()
+	expiredAt := now.Add(job.Interval)
+
+	ok, err := s.store.Acquire(job.Name, s.holder, now, expiredAt)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("can not acquire lease of job(%s)", job.Name))
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, expiredAt)
+	defer cancel()
+
+	if err = job.Run(ctx); err != nil {
+		return true, errors.Wrap(err, fmt.Sprintf("job(%s) fails", job.Name))
+	}
+
+	return true, nil
+}
+
+// Start runs the job every interval until ctx is done.
+// The job with non-positive interval is disabled.
+func (s *Scheduler) Start(ctx context.Context, job Job) {
+	if job.Interval <= 0 {
+		log.Infof("job(%s) is disabled", job.Name)
+		return
+	}
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunOnce(ctx, job); err != nil {
+			log.Errorf("%+v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunOnce(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryLeaseStore()
+
+	a := New(store, "replica-a")
+	a.now = func() time.Time { return now }
+	b := New(store, "replica-b")
+	b.now = func() time.Time { return now }
+
+	runs := 0
+	job := Job{
+		Name:     "job",
+		Interval: time.Minute,
+		Run: func(ctx context.Context) error {
+			runs++
+			return nil
+		},
+	}
+
+	if ran, err := a.RunOnce(context.Background(), job); err != nil || !ran {
+		t.Fatalf("the free lease should be acquired, ran: %v, err: %v", ran, err)
+	}
+
+	// the other replica could not run the job while the lease is held
+	if ran, _ := b.RunOnce(context.Background(), job); ran {
+		t.Errorf("the held lease should not be acquired by another replica")
+	}
+
+	// the holder renews the lease
+	now = now.Add(time.Minute)
+	if ran, _ := a.RunOnce(context.Background(), job); !ran {
+		t.Errorf("the holder should renew the lease")
+	}
+
+	// the lease is taken over once the holder stops renewing it
+	now = now.Add(time.Minute)
+	if ran, _ := b.RunOnce(context.Background(), job); !ran {
+		t.Errorf("the expired lease should be taken over")
+	}
+	if ran, _ := a.RunOnce(context.Background(), job); ran {
+		t.Errorf("the lease taken over should not be acquired by the previous holder")
+	}
+
+	if runs != 3 {
+		t.Errorf("runs = %d, want 3", runs)
+	}
+}
+
+func TestSchedulerRunOnceError(t *testing.T) {
+	s := New(NewMemoryLeaseStore(), "replica-a")
+
+	job := Job{
+		Name:     "job",
+		Interval: time.Minute,
+		Run: func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("the run should be bounded by the lease")
+			}
+			return errors.New("failure")
+		},
+	}
+
+	if ran, err := s.RunOnce(context.Background(), job); !ran || err == nil {
+		t.Errorf("the error of the job should be returned, ran: %v, err: %v", ran, err)
+	}
+}
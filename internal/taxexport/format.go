@@ -0,0 +1,79 @@
+package taxexport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FormatFixedWidth = "fixed"
+	FormatCSV        = "csv"
+)
+
+// rocYearOffset converts the year into the year of the Republic of China used by the tax agency
+const rocYearOffset = 1911
+
+var ErrIssuerNotConfigured = errors.New("tax id of the foundation is not configured")
+
+// FileExtension returns the extension of the upload file in the format
+func FileExtension(format string) string {
+	if format == FormatCSV {
+		return "csv"
+	}
+	return "txt"
+}
+
+// IsValidFormat checks the format of the upload file is supported
+func IsValidFormat(format string) bool {
+	return format == FormatFixedWidth || format == FormatCSV
+}
+
+// WriteUploadFile writes the donors in the format uploaded to the tax agency.
+//
+// Each line of the fixed width format is a donor ended with CRLF, made of
+//   - year of the Republic of China, 3 digits
+//   - tax id of the foundation, 8 characters
+//   - id of the donor, 10 characters
+//   - amount in TWD, 12 digits padded with zeros
+func WriteUploadFile(w io.Writer, format string, issuerTaxID string, r Result) error {
+	if issuerTaxID == "" {
+		return ErrIssuerNotConfigured
+	}
+
+	rocYear := r.Year - rocYearOffset
+
+	switch format {
+	case FormatFixedWidth:
+		for _, d := range r.Donors {
+			if _, err := fmt.Fprintf(w, "%03d%-8s%-10s%012d\r\n", rocYear, issuerTaxID, d.ID, d.Amount); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"捐贈年度", "受贈單位統一編號", "捐贈者身分證統一編號", "捐贈者姓名", "捐贈金額", "捐贈筆數"})
+		for _, d := range r.Donors {
+			cw.Write([]string{strconv.Itoa(rocYear), issuerTaxID, d.ID, d.Name, strconv.FormatUint(uint64(d.Amount), 10), strconv.Itoa(d.Count)})
+		}
+		cw.Flush()
+		return errors.WithStack(cw.Error())
+	default:
+		return errors.New(fmt.Sprintf("format(%s) not supported", format))
+	}
+}
+
+// WriteReport writes the excluded donations and the reasons in CSV to reconcile with the upload file
+func WriteReport(w io.Writer, r Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"donation_type", "order_number", "user_id", "reason"})
+	for _, e := range r.Exclusions {
+		cw.Write([]string{e.DonationType, e.OrderNumber, strconv.FormatUint(uint64(e.UserID), 10), e.Reason})
+	}
+	cw.Flush()
+	return errors.WithStack(cw.Error())
+}
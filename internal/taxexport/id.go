@@ -0,0 +1,65 @@
+package taxexport
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMissingID      = errors.New("national id is missing")
+	ErrBusinessID     = errors.New("unified business number is not deductible from the individual income tax")
+	ErrInvalidIDForm  = errors.New("national id is malformed")
+	ErrInvalidIDCheck = errors.New("national id fails the check digit")
+)
+
+var (
+	// national id, or the resident certificate of the foreigners issued since 2021
+	personalIDPattern = regexp.MustCompile(`^[A-Z][1289][0-9]{8}$`)
+	// resident certificate of the foreigners issued before 2021
+	legacyResidentIDPattern = regexp.MustCompile(`^[A-Z][A-D][0-9]{8}$`)
+	businessIDPattern       = regexp.MustCompile(`^[0-9]{8}$`)
+)
+
+// letterCodes are the codes of the issuing area letters to compute the check digit
+var letterCodes = map[byte]int{
+	'A': 10, 'B': 11, 'C': 12, 'D': 13, 'E': 14, 'F': 15, 'G': 16, 'H': 17, 'I': 34,
+	'J': 18, 'K': 19, 'L': 20, 'M': 21, 'N': 22, 'O': 35, 'P': 23, 'Q': 24, 'R': 25,
+	'S': 26, 'T': 27, 'U': 28, 'V': 29, 'W': 32, 'X': 30, 'Y': 31, 'Z': 33,
+}
+
+// ValidateID normalizes the national id or the resident certificate number of the donor,
+// and returns why it could not be uploaded to the tax agency
+func ValidateID(id string) (string, error) {
+	id = strings.ToUpper(strings.TrimSpace(id))
+
+	switch {
+	case id == "":
+		return id, ErrMissingID
+	case businessIDPattern.MatchString(id):
+		return id, ErrBusinessID
+	case !personalIDPattern.MatchString(id) && !legacyResidentIDPattern.MatchString(id):
+		return id, ErrInvalidIDForm
+	}
+
+	code := letterCodes[id[0]]
+	sum := code/10 + code%10*9
+
+	// the gender letter of the legacy resident certificate is counted by the last digit of its code
+	second := int(id[1] - '0')
+	if legacyResidentIDPattern.MatchString(id) {
+		second = letterCodes[id[1]] % 10
+	}
+	sum += second * 8
+
+	for i, weight := range []int{7, 6, 5, 4, 3, 2, 1, 1} {
+		sum += int(id[i+2]-'0') * weight
+	}
+
+	if sum%10 != 0 {
+		return id, ErrInvalidIDCheck
+	}
+
+	return id, nil
+}
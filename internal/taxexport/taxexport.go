@@ -0,0 +1,135 @@
+package taxexport
+
+// package taxexport builds the yearly upload file of the donations deductible from the individual income tax,
+// which is uploaded to the tax agency by the foundation every January.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// taipei is where the calendar year of the donations is counted in
+var taipei = time.FixedZone("Asia/Taipei", 8*60*60)
+
+// ErrNoAmount excludes the donation refunded as a whole
+var ErrNoAmount = errors.New("nothing is left after the refunds")
+
+// Source loads the paid donations whose donors opt in to the automatic tax deduction
+type Source interface {
+	GetTaxDeductionDonations(from time.Time, to time.Time) ([]models.TaxDeductionDonation, error)
+}
+
+// Donor is the record uploaded for the donations of a donor in the year
+type Donor struct {
+	ID     string
+	Name   string
+	Amount uint // in TWD
+	Count  int
+}
+
+// Exclusion is the donation left out of the upload file for the reason
+type Exclusion struct {
+	DonationType string
+	OrderNumber  string
+	UserID       uint
+	Reason       string
+}
+
+// Result is the donors to upload and the donations excluded in the year
+type Result struct {
+	Year       int
+	Donors     []Donor
+	Exclusions []Exclusion
+}
+
+// Build aggregates the donations transacted in the calendar year of Taiwan
+func Build(s Source, year int) (Result, error) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, taipei)
+
+	donations, err := s.GetTaxDeductionDonations(from, from.AddDate(1, 0, 0))
+	if err != nil {
+		return Result{}, errors.Wrap(err, fmt.Sprintf("can not get tax deduction donations of %d", year))
+	}
+
+	return Aggregate(year, donations), nil
+}
+
+// Aggregate sums up the amounts of the donations by the id of the donor.
+// The donations are expected in the order of the transaction time,
+// so that the name on the latest donation is uploaded.
+func Aggregate(year int, donations []models.TaxDeductionDonation) Result {
+	r := Result{Year: year}
+	donors := make(map[string]*Donor)
+
+	for _, d := range donations {
+		exclude := func(err error) {
+			r.Exclusions = append(r.Exclusions, Exclusion{
+				DonationType: d.DonationType,
+				OrderNumber:  d.OrderNumber,
+				UserID:       d.UserID,
+				Reason:       err.Error(),
+			})
+		}
+
+		id := d.NationalID.ValueOrZero()
+		if id == "" {
+			id = d.SecurityID.ValueOrZero()
+		}
+		id, err := ValidateID(id)
+		if err != nil {
+			exclude(err)
+			continue
+		}
+
+		amount := netTWDAmount(d)
+		if amount == 0 {
+			exclude(ErrNoAmount)
+			continue
+		}
+
+		donor, ok := donors[id]
+		if !ok {
+			donor = &Donor{ID: id}
+			donors[id] = donor
+		}
+		donor.Amount += amount
+		donor.Count++
+		if name := donorName(d); name != "" {
+			donor.Name = name
+		}
+	}
+
+	for _, donor := range donors {
+		r.Donors = append(r.Donors, *donor)
+	}
+	sort.Slice(r.Donors, func(i, j int) bool { return r.Donors[i].ID < r.Donors[j].ID })
+
+	return r
+}
+
+// netTWDAmount returns the TWD equivalent of the amount left after the partial refunds
+func netTWDAmount(d models.TaxDeductionDonation) uint {
+	if d.RefundedAmount >= d.Amount {
+		return 0
+	}
+
+	net := d.Amount - d.RefundedAmount
+	if !d.TWDAmount.Valid || d.Amount == 0 {
+		return net
+	}
+
+	return uint(math.Round(float64(d.TWDAmount.Int64) * float64(net) / float64(d.Amount)))
+}
+
+func donorName(d models.TaxDeductionDonation) string {
+	if name := d.CardholderLegalName.ValueOrZero(); name != "" {
+		return name
+	}
+	return d.CardholderName.ValueOrZero()
+}
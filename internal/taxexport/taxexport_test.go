@@ -0,0 +1,141 @@
+package taxexport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+)
+
+func TestValidateID(t *testing.T) {
+	cases := []struct {
+		id   string
+		want string
+		err  error
+	}{
+		{"A123456789", "A123456789", nil},
+		{" a123456789 ", "A123456789", nil},
+		{"A800000014", "A800000014", nil}, // resident certificate issued since 2021
+		{"AC01234567", "AC01234567", nil}, // resident certificate issued before 2021
+		{"", "", ErrMissingID},
+		{"12345678", "12345678", ErrBusinessID},
+		{"A323456789", "A323456789", ErrInvalidIDForm},
+		{"A12345678", "A12345678", ErrInvalidIDForm},
+		{"A123456788", "A123456788", ErrInvalidIDCheck},
+	}
+
+	for _, c := range cases {
+		got, err := ValidateID(c.id)
+		if got != c.want || err != c.err {
+			t.Errorf("ValidateID(%q) = (%s, %v), want (%s, %v)", c.id, got, err, c.want, c.err)
+		}
+	}
+}
+
+type fakeSource struct {
+	from, to  time.Time
+	donations []models.TaxDeductionDonation
+}
+
+func (s *fakeSource) GetTaxDeductionDonations(from time.Time, to time.Time) ([]models.TaxDeductionDonation, error) {
+	s.from, s.to = from, to
+	return s.donations, nil
+}
+
+func TestBuild(t *testing.T) {
+	s := &fakeSource{donations: []models.TaxDeductionDonation{
+		{DonationType: "prime", OrderNumber: "prime-1", UserID: 1, NationalID: null.StringFrom("A123456789"), CardholderName: null.StringFrom("王小明"), Amount: 500},
+		// the charge of the periodic donation of the same donor
+		{DonationType: "token", OrderNumber: "token-1", UserID: 1, SecurityID: null.StringFrom("a123456789"), CardholderLegalName: null.StringFrom("王曉明"), Amount: 300},
+		// partially refunded donation in USD
+		{DonationType: "prime", OrderNumber: "prime-2", UserID: 2, NationalID: null.StringFrom("AC01234567"), Amount: 100, TWDAmount: null.IntFrom(3150), RefundedAmount: 20},
+		{DonationType: "prime", OrderNumber: "prime-3", UserID: 3, Amount: 500},
+		{DonationType: "prime", OrderNumber: "prime-4", UserID: 4, NationalID: null.StringFrom("A123456788"), Amount: 500},
+		{DonationType: "token", OrderNumber: "token-2", UserID: 5, NationalID: null.StringFrom("A800000014"), Amount: 500, RefundedAmount: 500},
+	}}
+
+	r, err := Build(s, 2025)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := time.Date(2025, 1, 1, 0, 0, 0, 0, taipei); !s.from.Equal(want) || !s.to.Equal(want.AddDate(1, 0, 0)) {
+		t.Errorf("donations are loaded from %s to %s", s.from, s.to)
+	}
+
+	wantDonors := []Donor{
+		{ID: "A123456789", Name: "王曉明", Amount: 800, Count: 2},
+		{ID: "AC01234567", Amount: 2520, Count: 1},
+	}
+	if len(r.Donors) != len(wantDonors) {
+		t.Fatalf("got %d donors, want %d", len(r.Donors), len(wantDonors))
+	}
+	for i, want := range wantDonors {
+		if r.Donors[i] != want {
+			t.Errorf("donor %d = %+v, want %+v", i, r.Donors[i], want)
+		}
+	}
+
+	wantExclusions := map[string]string{
+		"prime-3": ErrMissingID.Error(),
+		"prime-4": ErrInvalidIDCheck.Error(),
+		"token-2": ErrNoAmount.Error(),
+	}
+	if len(r.Exclusions) != len(wantExclusions) {
+		t.Fatalf("got %d exclusions, want %d", len(r.Exclusions), len(wantExclusions))
+	}
+	for _, e := range r.Exclusions {
+		if e.Reason != wantExclusions[e.OrderNumber] {
+			t.Errorf("%s is excluded for %q, want %q", e.OrderNumber, e.Reason, wantExclusions[e.OrderNumber])
+		}
+	}
+}
+
+func TestWriteUploadFile(t *testing.T) {
+	r := Result{
+		Year: 2025,
+		Donors: []Donor{
+			{ID: "A123456789", Name: "王小明", Amount: 800, Count: 2},
+			{ID: "AC01234567", Amount: 2520, Count: 1},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteUploadFile(&buf, FormatFixedWidth, "12345678", r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "11412345678A123456789000000000800\r\n11412345678AC01234567000000002520\r\n"
+	if buf.String() != want {
+		t.Errorf("fixed width file = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := WriteUploadFile(&buf, FormatCSV, "12345678", r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[1] != "114,12345678,A123456789,王小明,800,2" {
+		t.Errorf("csv file = %q", buf.String())
+	}
+
+	if err := WriteUploadFile(&buf, FormatFixedWidth, "", r); err != ErrIssuerNotConfigured {
+		t.Errorf("WriteUploadFile() error = %v, want %v", err, ErrIssuerNotConfigured)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	r := Result{Exclusions: []Exclusion{{DonationType: "prime", OrderNumber: "prime-3", UserID: 3, Reason: ErrMissingID.Error()}}}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "donation_type,order_number,user_id,reason\nprime,prime-3,3,national id is missing\n"
+	if buf.String() != want {
+		t.Errorf("report = %q, want %q", buf.String(), want)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"github.com/twreporter/go-api/globals"
 	member "github.com/twreporter/go-api/internal/member_cms"
 	"github.com/twreporter/go-api/internal/mongo"
+	"github.com/twreporter/go-api/internal/scheduler"
+	"github.com/twreporter/go-api/middlewares"
 	"github.com/twreporter/go-api/routers"
 	"github.com/twreporter/go-api/services"
 	"github.com/twreporter/go-api/utils"
@@ -47,6 +49,24 @@ func main() {
 
 	configLogger()
 
+	// fail fast if the jwt signing keys are misconfigured
+	if _, err = utils.GetKeySet(); err != nil {
+		err = errors.Wrap(err, "Fatal error jwt signing keys")
+		return
+	}
+
+	// fail fast if the session keys are misconfigured
+	if _, err = utils.SessionKeyPairs(); err != nil {
+		err = errors.Wrap(err, "Fatal error session keys")
+		return
+	}
+
+	// fail fast if the notifications of tap pay are accepted from any IP
+	if err = controllers.ValidateTapPayNotifyIPs(); err != nil {
+		err = errors.Wrap(err, "Fatal error tap pay notify ips")
+		return
+	}
+
 	// set up database connection
 	log.Info("Connecting to MySQL cloud")
 	db, err := utils.InitDB(10, 5)
@@ -85,6 +105,16 @@ func main() {
 	sClient := search.NewClient(globals.Conf.Algolia.ApplicationID, globals.Conf.Algolia.APIKey)
 	cf = controllers.NewControllerFactory(db, session, mailSvc, client, sClient.InitIndex("contacts-index-v3"))
 
+	// run the periodic jobs on one of the replicas at a time
+	sch := scheduler.New(scheduler.NewMySQLLeaseStore(db), scheduler.DefaultHolder())
+	go sch.Start(ctx, cf.GetMembershipController().AccountDeletionPurgeJob())
+	go sch.Start(ctx, cf.GetMembershipController().PeriodicDonationChargeJob())
+	go sch.Start(ctx, cf.GetMembershipController().CardExpiryReminderJob())
+	go sch.Start(ctx, cf.GetMembershipController().DonationReconcileJob())
+	go sch.Start(ctx, cf.GetMembershipController().TokenPurgeJob())
+	go sch.Start(ctx, cf.GetMembershipController().IdempotencyKeyPurgeJob())
+	go sch.Start(ctx, cf.GetDataExportController().DataExportPurgeJob())
+
 	// set up the router
 	router := routers.SetupRouter(cf)
 
@@ -92,12 +122,14 @@ func main() {
 
 	// Set writeTimeout bigger than 30 secs.
 	// 30 secs is to ensure donation request is handled correctly.
+	// The routes streaming large responses, e.g. the donation export, clear the deadline by middlewares.ClearWriteDeadline.
 	writeTimeout := 40 * time.Second
 	s := &http.Server{
 		Addr:         fmt.Sprintf(":%s", globals.LocalhostPort),
 		Handler:      router,
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
+		ConnContext:  middlewares.ConnContext,
 	}
 
 	if err = s.ListenAndServe(); err != nil {
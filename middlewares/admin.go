@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+// UserPrivilegeStorage looks up the users whose privileges are checked
+type UserPrivilegeStorage interface {
+	GetUserByID(string) (models.User, error)
+}
+
+var userPrivilegeStorage UserPrivilegeStorage
+
+// SetUserPrivilegeStorage sets the storage which the privileges of users are looked up from
+func SetUserPrivilegeStorage(s UserPrivilegeStorage) {
+	userPrivilegeStorage = s
+}
+
+// ValidateAdmin checks the user authorized by ValidateAuthorization has the admin privilege.
+// If not, return the 403 response.
+func ValidateAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var userID string
+
+		switch id := c.Request.Context().Value(globals.AuthUserIDProperty).(type) {
+		case float64:
+			// the numbers in jwt claims are decoded as float64
+			userID = strconv.FormatFloat(id, 'f', -1, 64)
+		default:
+			userID = fmt.Sprint(id)
+		}
+
+		if userPrivilegeStorage == nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"status":  "error",
+				"message": "cannot check the privilege of the user",
+			})
+			return
+		}
+
+		user, err := userPrivilegeStorage.GetUserByID(userID)
+		if err != nil && !storage.IsNotFound(err) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"status":  "error",
+				"message": "cannot check the privilege of the user",
+			})
+			return
+		}
+
+		if err != nil || user.Privilege < constants.PrivilegeAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"status": "fail",
+				"data": gin.H{
+					"req.Headers.Authorization": "the request is not permitted to reach the resource",
+				},
+			})
+			return
+		}
+	}
+}
@@ -20,10 +20,10 @@ const authUserProperty = "app-auth-jwt"
 
 var jwtMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
 	ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-		return []byte(globals.Conf.App.JwtSecret), nil
+		return utils.GetVerificationKey(token, globals.Conf.App.JwtSecret)
 	},
-	UserProperty:  authUserProperty,
-	SigningMethod: jwt.SigningMethodHS256,
+	UserProperty: authUserProperty,
+	// SigningMethod is omitted since the algorithm is checked by the key set
 	ErrorHandler: func(w http.ResponseWriter, r *http.Request, err string) {
 		var res = map[string]interface{}{
 			"status": "fail",
@@ -36,6 +36,33 @@ var jwtMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
 	},
 })
 
+// TokenRevocationStorage looks up the revoked tokens
+type TokenRevocationStorage interface {
+	IsTokenRevoked(jti string) (bool, error)
+}
+
+var tokenRevocationStorage TokenRevocationStorage
+
+// SetTokenRevocationStorage sets the storage which the revoked tokens are checked against
+func SetTokenRevocationStorage(s TokenRevocationStorage) {
+	tokenRevocationStorage = s
+}
+
+// isTokenRevoked checks jti claim of the token against the revocation storage.
+// Tokens without jti claim, such as the ones issued before revocation is supported, are not revocable.
+func isTokenRevoked(claims jwt.MapClaims) (bool, error) {
+	jti, _ := claims["jti"].(string)
+	return isJTIRevoked(jti)
+}
+
+func isJTIRevoked(jti string) (bool, error) {
+	if jti == "" || tokenRevocationStorage == nil {
+		return false, nil
+	}
+
+	return tokenRevocationStorage.IsTokenRevoked(jti)
+}
+
 func PassAuthUserID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Header["Authorization"] == nil {
@@ -46,6 +73,9 @@ func PassAuthUserID() gin.HandlerFunc {
 		}
 		userProperty := c.Request.Context().Value(authUserProperty)
 		claims := userProperty.(*jwt.Token).Claims.(jwt.MapClaims)
+		if revoked, err := isTokenRevoked(claims); err != nil || revoked {
+			return
+		}
 		// Set user_id with key "auth-user-id" in context to avoid hierarchy access
 		newRequest := c.Request.WithContext(context.WithValue(c.Request.Context(), globals.AuthUserIDProperty, claims["user_id"]))
 		*c.Request = *newRequest
@@ -78,6 +108,22 @@ func ValidateAuthorization() gin.HandlerFunc {
 			return
 		}
 
+		if revoked, err := isTokenRevoked(claims); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"status":  "error",
+				"message": "cannot check the revocation of the token",
+			})
+			return
+		} else if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"status": "fail",
+				"data": gin.H{
+					"req.Headers.Authorization": "token is revoked",
+				},
+			})
+			return
+		}
+
 		var newRequest *http.Request
 
 		// Set user_id with key "auth-user-id" in context to avoid hierarchy access
@@ -142,7 +188,7 @@ func ValidateUserIDInReqBody() gin.HandlerFunc {
 }
 
 // ValidateAuthentication validates `req.Cookies.id_token`
-// if id_token, which is a JWT, is invalid, and then return 401 status code
+// if id_token, which is a JWT, is invalid or its session is revoked, and then return 401 status code
 func ValidateAuthentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var tokenString string
@@ -166,7 +212,7 @@ func ValidateAuthentication() gin.HandlerFunc {
 		}
 
 		if token, err = jwt.ParseWithClaims(tokenString, &utils.IDTokenJWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(globals.Conf.App.JwtSecret), nil
+			return utils.GetVerificationKey(token, globals.Conf.App.JwtSecret)
 		}); err != nil {
 			panic(err)
 		}
@@ -175,5 +221,17 @@ func ValidateAuthentication() gin.HandlerFunc {
 			err = errors.New("id_token is invalid")
 			panic(err)
 		}
+
+		claims := token.Claims.(*utils.IDTokenJWTClaims)
+		if revoked, rerr := isJTIRevoked(claims.Id); rerr != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"status":  "error",
+				"message": "cannot check the revocation of the id_token",
+			})
+			return
+		} else if revoked {
+			err = errors.New("id_token is revoked")
+			panic(err)
+		}
 	}
 }
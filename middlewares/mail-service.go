@@ -7,6 +7,7 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/utils"
 )
 
 const jwtUserPropertyForMailService = "mail-service-jwt"
@@ -23,7 +24,32 @@ func (m mailServiceMiddleware) ValidateAuthorization() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if err := m.JWTMiddleware.CheckJWT(c.Writer, c.Request); err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
+
+		token := c.Request.Context().Value(jwtUserPropertyForMailService).(*jwt.Token)
+		claims := token.Claims.(jwt.MapClaims)
+		if !claims.VerifyAudience(globals.Conf.App.JwtAudience, true) ||
+			!claims.VerifyIssuer(globals.Conf.App.JwtIssuer, true) ||
+			!isMailServiceSubject(token, claims) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	}
+}
+
+// isMailServiceSubject tells the mail service tokens apart from the user tokens,
+// since both of them could be signed by the same asymmetric key.
+// The tokens issued by the replicas before the mail service subject is introduced carry the access token subject,
+// which are accepted during the rolling deploy only if they are signed by the secret of the mail service.
+// TODO: remove the legacy subject in the next release
+func isMailServiceSubject(token *jwt.Token, claims jwt.MapClaims) bool {
+	switch claims["sub"] {
+	case utils.MailServiceSubject:
+		return true
+	case utils.AccessTokenSubject:
+		return token.Method == jwt.SigningMethodHS256
+	default:
+		return false
 	}
 }
 
@@ -31,10 +57,9 @@ func GetMailServiceMiddleware() JWTMiddleware {
 	return mailServiceMiddleware{
 		JWTMiddleware: jwtmiddleware.New(jwtmiddleware.Options{
 			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-				return []byte(globals.MailServiceJWTPrefix + globals.Conf.App.JwtSecret), nil
+				return utils.GetVerificationKey(token, globals.MailServiceJWTPrefix+globals.Conf.App.JwtSecret)
 			},
-			UserProperty:  jwtUserPropertyForMailService,
-			SigningMethod: jwt.SigningMethodHS256,
+			UserProperty: jwtUserPropertyForMailService,
 		}),
 	}
 }
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type connContextKey struct{}
+
+// ConnContext keeps the connection in the context of its requests, which is set as the ConnContext of the server,
+// so that the write deadline of the connection could be cleared by ClearWriteDeadline
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ClearWriteDeadline clears the write deadline set by the WriteTimeout of the server,
+// so that the response streamed longer than the timeout is not cut off.
+// The deadline is set again by the server when the next request of the connection is read.
+func ClearWriteDeadline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn)
+		if !ok {
+			return
+		}
+
+		if err := conn.SetWriteDeadline(time.Time{}); err != nil {
+			log.Errorf("%+v", errors.Wrap(err, "can not clear write deadline of the connection"))
+		}
+	}
+}
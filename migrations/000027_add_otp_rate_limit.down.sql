@@ -0,0 +1,6 @@
+-- drop tables
+DROP TABLE IF EXISTS `rate_limit_counters`;
+
+-- drop columns
+ALTER TABLE `reporter_accounts` DROP `failed_attempts`;
+ALTER TABLE `reporter_accounts` DROP `locked_until`;
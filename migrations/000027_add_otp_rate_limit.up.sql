@@ -0,0 +1,13 @@
+-- add failed otp attempts and lockout to reporter_accounts
+ALTER TABLE `reporter_accounts` ADD `failed_attempts` int(10) unsigned NOT NULL DEFAULT 0;
+ALTER TABLE `reporter_accounts` ADD `locked_until` timestamp NULL DEFAULT NULL;
+
+-- add counters shared by rate limiters among replicas
+CREATE TABLE IF NOT EXISTS `rate_limit_counters` (
+  `key` varchar(191) NOT NULL,
+  `window_start` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expired_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `count` int(10) unsigned NOT NULL DEFAULT 0,
+  PRIMARY KEY (`key`, `window_start`),
+  KEY `idx_rate_limit_counters_expired_at` (`expired_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
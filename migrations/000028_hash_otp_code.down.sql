@@ -0,0 +1,3 @@
+-- drop columns
+ALTER TABLE `reporter_accounts` DROP `otp_code_hash`;
+ALTER TABLE `reporter_accounts` DROP `otp_exp_time`;
@@ -0,0 +1,9 @@
+-- add hashed otp code and its expire time
+ALTER TABLE `reporter_accounts` ADD `otp_code_hash` varchar(64) NULL DEFAULT NULL;
+ALTER TABLE `reporter_accounts` ADD `otp_exp_time` timestamp NULL DEFAULT NULL;
+
+-- remove the plaintext 6-digit otp codes stored in activate_token.
+-- the hash is keyed by the application salt which is not available here,
+-- and the codes expire in 15 minutes, so the pending codes are invalidated
+-- and the users have to request new ones.
+UPDATE `reporter_accounts` SET `activate_token` = '' WHERE `activate_token` REGEXP '^[0-9]{6}$';
@@ -0,0 +1,6 @@
+-- drop column
+ALTER TABLE `users` DROP `tokens_revoked_at`;
+
+-- drop tables
+DROP TABLE IF EXISTS `revoked_tokens`;
+DROP TABLE IF EXISTS `refresh_tokens`;
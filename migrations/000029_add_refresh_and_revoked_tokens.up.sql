@@ -0,0 +1,31 @@
+-- add refresh tokens
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` int(10) unsigned NOT NULL,
+  `family_id` varchar(64) NOT NULL,
+  `token_hash` varchar(64) NOT NULL,
+  `access_token_jti` varchar(64) DEFAULT NULL,
+  `expires_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `replaced_at` timestamp NULL DEFAULT NULL,
+  `revoked_at` timestamp NULL DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_refresh_tokens_token_hash` (`token_hash`),
+  KEY `idx_refresh_tokens_family_id` (`family_id`),
+  KEY `fk_refresh_tokens_users_idx` (`user_id`),
+  CONSTRAINT `fk_refresh_tokens_users` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- add revoked tokens
+CREATE TABLE IF NOT EXISTS `revoked_tokens` (
+  `jti` varchar(64) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` int(10) unsigned NOT NULL,
+  `expires_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`jti`),
+  KEY `idx_revoked_tokens_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- id tokens issued before the time are rejected
+ALTER TABLE `users` ADD `tokens_revoked_at` timestamp NULL DEFAULT NULL;
@@ -0,0 +1,3 @@
+-- drop tables
+DROP TABLE IF EXISTS `email_link_requests`;
+DROP TABLE IF EXISTS `identity_audit_logs`;
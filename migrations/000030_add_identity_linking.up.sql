@@ -0,0 +1,27 @@
+-- add audit logs of linking and unlinking login methods
+CREATE TABLE IF NOT EXISTS `identity_audit_logs` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` int(10) unsigned NOT NULL,
+  `action` enum('link','unlink','auto_merge') NOT NULL,
+  `provider` varchar(20) NOT NULL,
+  `identity` varchar(191) DEFAULT NULL,
+  `ip` varchar(45) DEFAULT NULL,
+  `user_agent` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `fk_identity_audit_logs_users_idx` (`user_id`),
+  CONSTRAINT `fk_identity_audit_logs_users` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- add pending requests of linking email sign-in
+CREATE TABLE IF NOT EXISTS `email_link_requests` (
+  `user_id` int(10) unsigned NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `email` varchar(100) NOT NULL,
+  `otp_code_hash` varchar(64) NOT NULL,
+  `otp_exp_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `failed_attempts` int(10) unsigned NOT NULL DEFAULT 0,
+  PRIMARY KEY (`user_id`),
+  CONSTRAINT `fk_email_link_requests_users` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,2 @@
+ALTER TABLE `o_auth_accounts` DROP INDEX `idx_o_auth_accounts_type_a_id`;
+ALTER TABLE `o_auth_accounts` MODIFY COLUMN `type` varchar(10) DEFAULT NULL;
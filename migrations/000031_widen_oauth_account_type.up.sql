@@ -0,0 +1,3 @@
+-- o_auth_accounts.type stores the type of OIDC providers in config, e.g. Apple and LINE
+ALTER TABLE `o_auth_accounts` MODIFY COLUMN `type` varchar(20) DEFAULT NULL;
+ALTER TABLE `o_auth_accounts` ADD INDEX `idx_o_auth_accounts_type_a_id` (`type`, `a_id`(191));
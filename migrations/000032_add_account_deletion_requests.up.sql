@@ -0,0 +1,15 @@
+-- add requests of deleting accounts,
+-- the row is kept after the account is erased as the record of the erasure
+CREATE TABLE IF NOT EXISTS `account_deletion_requests` (
+  `user_id` int(10) unsigned NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `token_hash` varchar(64) NOT NULL,
+  `confirm_exp_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `confirmed_at` timestamp NULL DEFAULT NULL,
+  `scheduled_at` timestamp NULL DEFAULT NULL,
+  `completed_at` timestamp NULL DEFAULT NULL,
+  PRIMARY KEY (`user_id`),
+  KEY `idx_account_deletion_requests_scheduled_at` (`scheduled_at`),
+  CONSTRAINT `fk_account_deletion_requests_users` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
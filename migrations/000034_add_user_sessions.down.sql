@@ -0,0 +1,6 @@
+-- drop column
+ALTER TABLE `refresh_tokens` DROP KEY `idx_refresh_tokens_session_jti`;
+ALTER TABLE `refresh_tokens` DROP `session_jti`;
+
+-- drop table
+DROP TABLE IF EXISTS `user_sessions`;
@@ -0,0 +1,21 @@
+-- add the sessions identified by the jti claim of the id tokens
+CREATE TABLE IF NOT EXISTS `user_sessions` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` int(10) unsigned NOT NULL,
+  `jti` varchar(64) NOT NULL,
+  `user_agent` varchar(512) NOT NULL DEFAULT '',
+  `ip` varchar(45) NOT NULL DEFAULT '',
+  `last_seen_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expires_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `revoked_at` timestamp NULL DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_user_sessions_jti` (`jti`),
+  KEY `fk_user_sessions_users_idx` (`user_id`),
+  CONSTRAINT `fk_user_sessions_users` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- refresh tokens are revoked along with the session they are dispatched for
+ALTER TABLE `refresh_tokens` ADD `session_jti` varchar(64) DEFAULT NULL AFTER `access_token_jti`;
+ALTER TABLE `refresh_tokens` ADD KEY `idx_refresh_tokens_session_jti` (`session_jti`);
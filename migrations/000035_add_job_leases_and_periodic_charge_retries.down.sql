@@ -0,0 +1,6 @@
+-- drop columns
+ALTER TABLE `periodic_donations` DROP `next_retry_at`;
+ALTER TABLE `periodic_donations` DROP `charge_failures`;
+
+-- drop table
+DROP TABLE IF EXISTS `job_leases`;
@@ -0,0 +1,12 @@
+-- add leases of the jobs run by one of the replicas at a time
+CREATE TABLE IF NOT EXISTS `job_leases` (
+  `name` varchar(100) NOT NULL,
+  `holder` varchar(191) NOT NULL,
+  `expired_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- add retries of the failed recurring charges
+ALTER TABLE `periodic_donations` ADD `charge_failures` int(10) unsigned NOT NULL DEFAULT 0;
+ALTER TABLE `periodic_donations` ADD `next_retry_at` timestamp NULL DEFAULT NULL;
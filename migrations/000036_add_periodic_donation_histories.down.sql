@@ -0,0 +1,5 @@
+-- drop table
+DROP TABLE IF EXISTS `periodic_donation_histories`;
+
+-- drop column
+ALTER TABLE `periodic_donations` DROP `paused_until`;
@@ -0,0 +1,20 @@
+-- add the date until which a periodic donation is paused by the donor
+ALTER TABLE `periodic_donations` ADD `paused_until` timestamp NULL DEFAULT NULL;
+
+-- add history of the changes made by donors to their periodic donations
+CREATE TABLE IF NOT EXISTS `periodic_donation_histories` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `periodic_id` int(10) unsigned NOT NULL,
+  `user_id` int(10) unsigned NOT NULL,
+  `action` enum('pause','resume','change','cancel') NOT NULL,
+  `old_amount` int(10) unsigned DEFAULT NULL,
+  `new_amount` int(10) unsigned DEFAULT NULL,
+  `old_frequency` enum('monthly','yearly') DEFAULT NULL,
+  `new_frequency` enum('monthly','yearly') DEFAULT NULL,
+  `paused_until` timestamp NULL DEFAULT NULL,
+  `reason` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_periodic_donation_histories_periodic_id` (`periodic_id`),
+  CONSTRAINT `fk_periodic_donation_histories_periodic_id` FOREIGN KEY (`periodic_id`) REFERENCES `periodic_donations` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
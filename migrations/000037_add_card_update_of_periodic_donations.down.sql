@@ -0,0 +1,6 @@
+-- drop column
+ALTER TABLE `periodic_donations` DROP `card_expiry_reminded`;
+
+-- remove the replacement of cards from the history
+DELETE FROM `periodic_donation_histories` WHERE `action` = 'update_card';
+ALTER TABLE `periodic_donation_histories` MODIFY `action` enum('pause','resume','change','cancel') NOT NULL;
@@ -0,0 +1,5 @@
+-- add the replacement of cards to the history of periodic donations
+ALTER TABLE `periodic_donation_histories` MODIFY `action` enum('pause','resume','change','cancel','update_card') NOT NULL;
+
+-- add the expiry date of the card which the donor is reminded of
+ALTER TABLE `periodic_donations` ADD `card_expiry_reminded` varchar(6) NOT NULL DEFAULT '';
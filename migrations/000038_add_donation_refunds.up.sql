@@ -0,0 +1,22 @@
+-- add refunds of the donations made through tap pay
+CREATE TABLE IF NOT EXISTS `donation_refunds` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `donation_type` enum('prime','token') NOT NULL,
+  `donation_id` int(10) unsigned NOT NULL,
+  `order_number` varchar(50) NOT NULL,
+  `amount` int(10) unsigned NOT NULL,
+  `currency` varchar(3) NOT NULL DEFAULT 'TWD',
+  `reason` varchar(255) DEFAULT NULL,
+  `status` enum('refunding','refunded','fail') NOT NULL,
+  `operator_id` int(10) unsigned NOT NULL,
+  `tappay_refund_id` varchar(50) DEFAULT NULL,
+  `tappay_api_status` int(11) DEFAULT NULL,
+  `msg` varchar(100) DEFAULT NULL,
+  `voided_receipt_number` varchar(13) DEFAULT NULL,
+  `reissued_receipt_number` varchar(13) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_donation_refunds_donation` (`donation_type`, `donation_id`),
+  KEY `idx_donation_refunds_order_number` (`order_number`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,17 @@
+-- add idempotency keys of the requests creating donations
+-- the keys are case sensitive, while the trailing spaces are rejected by the api since they are ignored by ascii_bin
+CREATE TABLE IF NOT EXISTS `idempotency_keys` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` int(10) unsigned NOT NULL,
+  `route` varchar(100) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+  `idempotency_key` varchar(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+  `request_hash` char(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+  `status_code` int(11) DEFAULT NULL,
+  `response` mediumtext DEFAULT NULL,
+  `expires_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_idempotency_keys_key` (`user_id`, `route`, `idempotency_key`),
+  KEY `idx_idempotency_keys_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,2 @@
+-- drop table
+DROP TABLE IF EXISTS `line_pay_notifications`;
@@ -0,0 +1,13 @@
+-- add raw callbacks of the line pay notifications for disputes
+CREATE TABLE IF NOT EXISTS `line_pay_notifications` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `order_number` varchar(50) NOT NULL DEFAULT '',
+  `rec_trade_id` varchar(20) NOT NULL DEFAULT '',
+  `remote_ip` varchar(45) NOT NULL DEFAULT '',
+  `payload` text NOT NULL,
+  `response_status` int(11) NOT NULL,
+  `note` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_line_pay_notifications_order_number` (`order_number`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
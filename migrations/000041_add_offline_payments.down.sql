@@ -0,0 +1,11 @@
+-- drop columns
+RENAME TABLE `tap_pay_notifications` TO `line_pay_notifications`;
+
+ALTER TABLE `pay_by_prime_donations`
+DROP COLUMN `payment_code`,
+DROP COLUMN `payment_bank_code`,
+DROP COLUMN `payment_expires_at`;
+
+-- remove the offline payments
+DELETE FROM `pay_by_prime_donations` WHERE `pay_method` IN ('atm','cvs');
+ALTER TABLE `pay_by_prime_donations` MODIFY `pay_method` enum('credit_card','line','apple','google','samsung') NOT NULL;
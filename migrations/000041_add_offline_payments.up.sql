@@ -0,0 +1,9 @@
+-- add the ATM virtual account and the convenience store payment code to the prime donations
+ALTER TABLE `pay_by_prime_donations` MODIFY `pay_method` enum('credit_card','line','apple','google','samsung','atm','cvs') NOT NULL;
+ALTER TABLE `pay_by_prime_donations`
+ADD COLUMN `payment_code` varchar(30) DEFAULT NULL,
+ADD COLUMN `payment_bank_code` varchar(10) DEFAULT NULL,
+ADD COLUMN `payment_expires_at` timestamp NULL DEFAULT NULL;
+
+-- keep the notifications of the offline payments along with the ones of line pay
+RENAME TABLE `line_pay_notifications` TO `tap_pay_notifications`;
@@ -0,0 +1,12 @@
+-- drop columns
+ALTER TABLE `pay_by_prime_donations`
+DROP COLUMN `twd_amount`,
+DROP COLUMN `exchange_rate`;
+
+ALTER TABLE `pay_by_card_token_donations`
+DROP COLUMN `twd_amount`,
+DROP COLUMN `exchange_rate`;
+
+ALTER TABLE `periodic_donations`
+DROP COLUMN `twd_amount`,
+DROP COLUMN `exchange_rate`;
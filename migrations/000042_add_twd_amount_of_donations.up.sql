@@ -0,0 +1,17 @@
+-- add the TWD equivalent of the donations in foreign currencies for receipts and reporting
+ALTER TABLE `pay_by_prime_donations`
+ADD COLUMN `twd_amount` int(10) unsigned DEFAULT NULL,
+ADD COLUMN `exchange_rate` decimal(12,6) DEFAULT NULL;
+
+ALTER TABLE `pay_by_card_token_donations`
+ADD COLUMN `twd_amount` int(10) unsigned DEFAULT NULL,
+ADD COLUMN `exchange_rate` decimal(12,6) DEFAULT NULL;
+
+ALTER TABLE `periodic_donations`
+ADD COLUMN `twd_amount` int(10) unsigned DEFAULT NULL,
+ADD COLUMN `exchange_rate` decimal(12,6) DEFAULT NULL;
+
+-- the existing donations are all in TWD
+UPDATE `pay_by_prime_donations` SET `twd_amount` = `amount`, `exchange_rate` = 1;
+UPDATE `pay_by_card_token_donations` SET `twd_amount` = `amount`, `exchange_rate` = 1;
+UPDATE `periodic_donations` SET `twd_amount` = `amount`, `exchange_rate` = 1;
@@ -0,0 +1,11 @@
+-- drop columns
+ALTER TABLE `pay_by_prime_donations`
+DROP KEY `idx_pay_by_prime_donations_campaign_id`,
+DROP COLUMN `campaign_id`;
+
+ALTER TABLE `periodic_donations`
+DROP KEY `idx_periodic_donations_campaign_id`,
+DROP COLUMN `campaign_id`;
+
+-- drop table
+DROP TABLE IF EXISTS `donation_campaigns`;
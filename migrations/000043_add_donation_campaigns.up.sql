@@ -0,0 +1,23 @@
+-- add fundraising campaigns which the donations are earmarked for
+CREATE TABLE IF NOT EXISTS `donation_campaigns` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `deleted_at` timestamp NULL DEFAULT NULL,
+  `slug` varchar(100) NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `goal_amount` int(10) unsigned NOT NULL,
+  `start_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `end_at` timestamp NULL DEFAULT NULL,
+  `topic_slug` varchar(100) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_donation_campaigns_slug` (`slug`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE `pay_by_prime_donations`
+ADD COLUMN `campaign_id` int(10) unsigned DEFAULT NULL,
+ADD KEY `idx_pay_by_prime_donations_campaign_id` (`campaign_id`);
+
+ALTER TABLE `periodic_donations`
+ADD COLUMN `campaign_id` int(10) unsigned DEFAULT NULL,
+ADD KEY `idx_periodic_donations_campaign_id` (`campaign_id`);
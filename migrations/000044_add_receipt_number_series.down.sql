@@ -0,0 +1,21 @@
+-- drop table
+DROP TABLE IF EXISTS `receipt_number_logs`;
+
+-- restore columns
+ALTER TABLE `donation_refunds`
+MODIFY COLUMN `voided_receipt_number` varchar(13) DEFAULT NULL,
+MODIFY COLUMN `reissued_receipt_number` varchar(13) DEFAULT NULL;
+
+ALTER TABLE `pay_by_card_token_donations`
+MODIFY COLUMN `receipt_number` varchar(13) DEFAULT NULL;
+
+ALTER TABLE `pay_by_prime_donations`
+MODIFY COLUMN `receipt_number` varchar(13) DEFAULT NULL;
+
+-- only the series of the prime donations is kept
+DELETE FROM `receipt_serial_numbers` WHERE `prefix` <> 'A';
+
+ALTER TABLE `receipt_serial_numbers`
+DROP PRIMARY KEY,
+DROP COLUMN `prefix`,
+ADD PRIMARY KEY (`YYYYMM`);
@@ -0,0 +1,39 @@
+-- number the receipts of each donation type in its own series
+ALTER TABLE `receipt_serial_numbers`
+ADD COLUMN `prefix` varchar(3) NOT NULL DEFAULT 'A',
+DROP PRIMARY KEY,
+ADD PRIMARY KEY (`prefix`, `YYYYMM`);
+
+ALTER TABLE `pay_by_prime_donations`
+MODIFY COLUMN `receipt_number` varchar(20) DEFAULT NULL;
+
+ALTER TABLE `pay_by_card_token_donations`
+MODIFY COLUMN `receipt_number` varchar(20) DEFAULT NULL;
+
+ALTER TABLE `donation_refunds`
+MODIFY COLUMN `voided_receipt_number` varchar(20) DEFAULT NULL,
+MODIFY COLUMN `reissued_receipt_number` varchar(20) DEFAULT NULL;
+
+-- audit trail of the issued and voided receipt numbers
+CREATE TABLE IF NOT EXISTS `receipt_number_logs` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `receipt_number` varchar(20) NOT NULL,
+  `donation_type` enum('prime','token') NOT NULL,
+  `donation_id` int(10) unsigned NOT NULL,
+  `action` enum('issue','void') NOT NULL,
+  `reason` varchar(255) DEFAULT NULL,
+  `operator_id` int(10) unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_receipt_number_logs_receipt_number_action` (`receipt_number`, `action`),
+  KEY `idx_receipt_number_logs_donation` (`donation_type`, `donation_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- backfill the numbers issued and voided before
+INSERT IGNORE INTO `receipt_number_logs` (`created_at`, `receipt_number`, `donation_type`, `donation_id`, `action`)
+SELECT COALESCE(`transaction_time`, `updated_at`), `receipt_number`, 'prime', `id`, 'issue' FROM `pay_by_prime_donations`
+WHERE `receipt_number` IS NOT NULL;
+
+INSERT IGNORE INTO `receipt_number_logs` (`created_at`, `receipt_number`, `donation_type`, `donation_id`, `action`, `reason`, `operator_id`)
+SELECT `updated_at`, `voided_receipt_number`, `donation_type`, `donation_id`, 'void', `reason`, `operator_id` FROM `donation_refunds`
+WHERE `voided_receipt_number` IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE `refresh_tokens` DROP KEY `idx_refresh_tokens_expires_at`;
@@ -0,0 +1,2 @@
+-- the expired refresh tokens are deleted periodically
+ALTER TABLE `refresh_tokens` ADD KEY `idx_refresh_tokens_expires_at` (`expires_at`);
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	AccountDeletionStatusPendingConfirmation = "pending_confirmation"
+	AccountDeletionStatusScheduled           = "scheduled"
+	AccountDeletionStatusCompleted           = "completed"
+)
+
+// AccountDeletionRequest is a request of a user to delete the account.
+// The request is confirmed by the link sent to the email of the user,
+// and the account is erased once the grace period passes.
+type AccountDeletionRequest struct {
+	UserID         uint      `gorm:"primary_key;auto_increment:false" json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	TokenHash      string    `gorm:"size:64;not null" json:"-"`
+	ConfirmExpTime time.Time `json:"confirm_expired_at"`
+	ConfirmedAt    null.Time `json:"confirmed_at"`
+	ScheduledAt    null.Time `json:"scheduled_at"` // the account is erased after this time
+	CompletedAt    null.Time `json:"completed_at"`
+}
+
+// Status returns the status of the request
+func (r AccountDeletionRequest) Status() string {
+	switch {
+	case r.CompletedAt.Valid:
+		return AccountDeletionStatusCompleted
+	case r.ConfirmedAt.Valid:
+		return AccountDeletionStatusScheduled
+	default:
+		return AccountDeletionStatusPendingConfirmation
+	}
+}
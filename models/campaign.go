@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// DonationCampaign is a fundraising campaign, e.g. an investigative series or a year-end drive,
+// which the donations are earmarked for
+type DonationCampaign struct {
+	ID         uint        `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	DeletedAt  *time.Time  `json:"-"`
+	Slug       string      `gorm:"type:varchar(100);not null;unique_index:idx_donation_campaigns_slug" json:"slug"`
+	Name       string      `gorm:"type:varchar(100);not null" json:"name"`
+	GoalAmount uint        `gorm:"type:int(10) unsigned;not null" json:"goal_amount"` // in TWD
+	StartAt    time.Time   `gorm:"not null" json:"start_at"`
+	EndAt      null.Time   `json:"end_at"` // the campaign is open-ended if null
+	TopicSlug  null.String `gorm:"type:varchar(100)" json:"topic_slug"`
+}
+
+// IsOpen reports whether the campaign accepts donations at t
+func (c DonationCampaign) IsOpen(t time.Time) bool {
+	if t.Before(c.StartAt) {
+		return false
+	}
+	return !c.EndAt.Valid || t.Before(c.EndAt.Time)
+}
+
+// CampaignProgress is the amount in TWD raised by the paid donations of the campaign and their donors
+type CampaignProgress struct {
+	RaisedAmount uint `json:"raised_amount"`
+	DonorCount   uint `json:"donor_count"`
+}
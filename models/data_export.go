@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	DataExportStatusProcessing = "processing"
+	DataExportStatusReady      = "ready"
+	DataExportStatusFailed     = "failed"
+)
+
+// UserDataExport is the archive of the personal data exported for a user.
+// A user has one archive at most, which is generated asynchronously.
+type UserDataExport struct {
+	UserID    uint      `gorm:"primary_key;auto_increment:false" json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `gorm:"type:ENUM('processing','ready','failed');not null" json:"status"`
+	Archive   []byte    `gorm:"type:longblob" json:"-"`
+	ExpiresAt null.Time `json:"expires_at"`
+}
+
+// IsReady reports whether the archive could be downloaded at t
+func (e UserDataExport) IsReady(t time.Time) bool {
+	return e.Status == DataExportStatusReady && e.ExpiresAt.Valid && e.ExpiresAt.Time.After(t)
+}
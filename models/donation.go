@@ -69,6 +69,13 @@ type Receipt struct {
 	AddressZipCode null.String `gorm:"column:receipt_address_zip_code;type:varchar(10)" json:"address_zip_code"`
 }
 
+// TWDEquivalent is the amount of the donation converted into TWD by the exchange rate configured when it is made,
+// which is used by the receipts and the reports of the donations in foreign currencies
+type TWDEquivalent struct {
+	TWDAmount    null.Int   `gorm:"column:twd_amount;type:int(10) unsigned" json:"twd_amount"`
+	ExchangeRate null.Float `gorm:"type:decimal(12,6)" json:"exchange_rate"`
+}
+
 // https://docs.tappaysdk.com/tutorial/zh/back.html#request-body pay_info
 // masked_credit_card_number will be preprocessed and stored in the CardInfo.LastFour
 type PayInfo struct {
@@ -82,6 +89,7 @@ type PayByPrimeDonation struct {
 	Cardholder
 	TappayResp
 	Receipt
+	TWDEquivalent
 	PayInfo          `json:"pay_info"`
 	Amount           uint        `gorm:"not null" json:"amount"`
 	CreatedAt        time.Time   `json:"created_at"`
@@ -92,29 +100,36 @@ type PayByPrimeDonation struct {
 	MerchantID       string      `gorm:"type:varchar(30);not null" json:"merchant_id"`
 	Notes            string      `gorm:"type:varchar(100)" json:"notes"`
 	OrderNumber      string      `gorm:"type:varchar(50);not null" json:"order_number"`
-	PayMethod        string      `gorm:"type:ENUM('credit_card','line','apple','google','samsung');not null;index:idx_pay_by_prime_donations_cardholder_email_pay_method" json:"pay_method"`
+	PayMethod        string      `gorm:"type:ENUM('credit_card','line','apple','google','samsung','atm','cvs');not null;index:idx_pay_by_prime_donations_cardholder_email_pay_method" json:"pay_method"`
 	SendReceipt      string      `gorm:"type:ENUM('yearly', 'monthly', 'no', 'no_receipt', 'digital_receipt_by_month', 'digital_receipt_by_year', 'paperback_receipt_by_month', 'paperback_receipt_by_year');default:'no_receipt'" json:"send_receipt"`
 	Status           string      `gorm:"type:ENUM('paying','paid','fail','refunded');not null" json:"status"`
 	UpdatedAt        time.Time   `json:"updated_at"`
 	UserID           uint        `gorm:"type:int(10);unsigned;not null" json:"user_id"`
 	IsAnonymous      null.Bool   `gorm:"type:tinyint(1);default:0" json:"is_anonymous"`
 	AutoTaxDeduction null.Bool   `gorm:"type:tinyint(1)" json:"auto_tax_deduction"`
-	ReceiptNumber    null.String `gorm:"type:varchar(13)" json:"receipt_number"`
+	ReceiptNumber    null.String `gorm:"type:varchar(20)" json:"receipt_number"`
+	// virtual account number or payment code of convenience stores issued for the offline payment
+	PaymentCode      null.String `gorm:"type:varchar(30)" json:"payment_code"`
+	PaymentBankCode  null.String `gorm:"type:varchar(10)" json:"payment_bank_code"`
+	PaymentExpiresAt null.Time   `json:"payment_expires_at"`
+	CampaignID       null.Int    `gorm:"type:int(10) unsigned;index:idx_pay_by_prime_donations_campaign_id" json:"campaign_id"`
 }
 
 type PayByCardTokenDonation struct {
 	TappayResp
-	Amount      uint       `gorm:"not null;index:idx_pay_by_card_token_donations_amount" json:"amount"`
-	CreatedAt   time.Time  `json:"created_at"`
-	Currency    string     `gorm:"type:varchar(3);default:'TWD';not null" json:"currency"`
-	DeletedAt   *time.Time `json:"deleted_at"`
-	Details     string     `gorm:"type:varchar(50);not null" json:"details"`
-	ID          uint       `gorm:"primary_key" json:"id"`
-	MerchantID  string     `gorm:"type:varchar(30);not null" json:"merchant_id"`
-	OrderNumber string     `gorm:"type:varchar(50);not null" json:"order_number"`
-	PeriodicID  uint       `gorm:"not null;index:idx_pay_by_card_token_donations_periodic_id" json:"periodic_id"`
-	Status      string     `gorm:"type:ENUM('paying','paid','fail','refunded');not null" json:"status"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	TWDEquivalent
+	Amount        uint        `gorm:"not null;index:idx_pay_by_card_token_donations_amount" json:"amount"`
+	CreatedAt     time.Time   `json:"created_at"`
+	Currency      string      `gorm:"type:varchar(3);default:'TWD';not null" json:"currency"`
+	DeletedAt     *time.Time  `json:"deleted_at"`
+	Details       string      `gorm:"type:varchar(50);not null" json:"details"`
+	ID            uint        `gorm:"primary_key" json:"id"`
+	MerchantID    string      `gorm:"type:varchar(30);not null" json:"merchant_id"`
+	OrderNumber   string      `gorm:"type:varchar(50);not null" json:"order_number"`
+	PeriodicID    uint        `gorm:"not null;index:idx_pay_by_card_token_donations_periodic_id" json:"periodic_id"`
+	ReceiptNumber null.String `gorm:"type:varchar(20)" json:"receipt_number"`
+	Status        string      `gorm:"type:ENUM('paying','paid','fail','refunded');not null" json:"status"`
+	UpdatedAt     time.Time   `json:"updated_at"`
 }
 
 type PayByOtherMethodDonation struct {
@@ -145,6 +160,7 @@ type PeriodicDonation struct {
 	Cardholder
 	CardInfo
 	Receipt
+	TWDEquivalent
 	Amount           uint       `gorm:"type:int(10) unsigned;not null;index:idx_periodic_donations_amount" json:"amount"`
 	CardKey          string     `gorm:"type:tinyblob" json:"card_key"`
 	CardToken        string     `gorm:"type:tinyblob" json:"card_token"`
@@ -166,12 +182,36 @@ type PeriodicDonation struct {
 	IsAnonymous      null.Bool  `gorm:"type:tinyint(1);default:0" json:"is_anonymous"`
 	AutoTaxDeduction null.Bool  `gorm:"type:tinyint(1)" json:"auto_tax_deduction"`
 	PayMethod        string     `gorm:"type:ENUM('credit_card','line','apple','google','samsung')" json:"pay_method"`
+	ChargeFailures   uint       `gorm:"type:int(10) unsigned;not null;default:0" json:"-"` // consecutive failures of recurring charges
+	NextRetryAt      null.Time  `json:"-"`
+	PausedUntil      null.Time  `json:"paused_until"` // no charge is made until the date
+	// expiry date of the card which the donor is reminded of
+	ExpiryReminded string `gorm:"column:card_expiry_reminded;type:varchar(6);not null;default:''" json:"-"`
+	// campaign which the charges of the donation are earmarked for
+	CampaignID null.Int `gorm:"type:int(10) unsigned;index:idx_periodic_donations_campaign_id" json:"campaign_id"`
+}
+
+// PeriodicDonationHistory records a change made by the donor to the periodic donation
+type PeriodicDonationHistory struct {
+	ID           uint        `gorm:"primary_key" json:"id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	PeriodicID   uint        `gorm:"not null" json:"-"`
+	UserID       uint        `gorm:"not null" json:"-"`
+	Action       string      `gorm:"type:ENUM('pause','resume','change','cancel','update_card');not null" json:"action"`
+	OldAmount    null.Int    `json:"old_amount"`
+	NewAmount    null.Int    `json:"new_amount"`
+	OldFrequency null.String `gorm:"type:ENUM('monthly','yearly')" json:"old_frequency"`
+	NewFrequency null.String `gorm:"type:ENUM('monthly','yearly')" json:"new_frequency"`
+	PausedUntil  null.Time   `json:"paused_until"`
+	Reason       null.String `gorm:"size:255" json:"reason"`
 }
 
 type GeneralDonation struct {
 	ID                  uint        `json:"id"`
 	Type                string      `json:"type"`
 	Amount              uint        `json:"amount"`
+	Currency            string      `json:"currency,omitempty"`
+	TWDAmount           null.Int    `gorm:"column:twd_amount" json:"twd_amount,omitempty"`
 	CreatedAt           time.Time   `json:"created_at"`
 	OrderNumber         string      `json:"order_number"`
 	SendReceipt         string      `json:"send_receipt"`
@@ -199,9 +239,141 @@ type Payment struct {
 	Amount      uint      `json:"amount"`
 }
 
+// DonationAmount is the TWD equivalent of a paid donation of a user left after the partial refunds
+type DonationAmount struct {
+	TWDAmount       uint `gorm:"column:twd_amount"`
+	TransactionTime time.Time
+}
+
+// PeriodicDonationOverview is a periodic donation of a user along with the number of its paid charges
+type PeriodicDonationOverview struct {
+	OrderNumber   string
+	Amount        uint
+	Currency      string
+	Frequency     string
+	Status        string
+	LastSuccessAt null.Time
+	NextRetryAt   null.Time
+	PausedUntil   null.Time
+	MaxPaidTimes  uint
+	PaidTimes     uint
+}
+
+// DonationExportFilter filters the donations exported for the finance.
+// The empty fields do not filter the donations.
+type DonationExportFilter struct {
+	From         null.Time // donated at or after
+	To           null.Time // donated before
+	Types        []string  // prime, token or others
+	Statuses     []string
+	PayMethods   []string
+	MerchantIDs  []string
+	CampaignID   null.Int
+	SendReceipts []string
+}
+
+// DonationExportRow is a prime donation, a charge of the periodic donation or a donation paid by other methods
+// exported for the finance, along with the cardholder and the receipt
+type DonationExportRow struct {
+	DonationType          string
+	OrderNumber           string
+	Status                string
+	Amount                uint
+	Currency              string
+	TWDAmount             null.Int `gorm:"column:twd_amount"`
+	PayMethod             null.String
+	MerchantID            string
+	CampaignID            null.Int
+	SendReceipt           null.String
+	ReceiptNumber         null.String
+	DonatedAt             time.Time
+	UserID                uint
+	CardholderEmail       null.String
+	CardholderName        null.String
+	CardholderPhoneNumber null.String
+	CardholderNationalID  null.String
+	Receipt               Receipt `gorm:"embedded"`
+}
+
+// ReceiptItem is a paid prime donation or a paid charge of the periodic donation listed on the yearly receipt
+type ReceiptItem struct {
+	Receipt         Receipt     `gorm:"embedded"`
+	CardholderName  null.String `gorm:"column:cardholder_name"`
+	OrderNumber     string
+	ReceiptNumber   null.String
+	Amount          uint
+	TWDAmount       null.Int `gorm:"column:twd_amount"`
+	RefundedAmount  uint
+	PayMethod       string
+	TransactionTime null.Time
+}
+
+// TaxDeductionDonation is a paid prime donation or a paid charge of the periodic donation
+// whose donor opts in to the automatic tax deduction
+type TaxDeductionDonation struct {
+	DonationType        string
+	DonationID          uint
+	OrderNumber         string
+	UserID              uint
+	CardholderName      null.String
+	CardholderLegalName null.String
+	NationalID          null.String `gorm:"column:cardholder_national_id"`
+	SecurityID          null.String `gorm:"column:cardholder_security_id"`
+	Amount              uint
+	TWDAmount           null.Int `gorm:"column:twd_amount"`
+	RefundedAmount      uint
+	TransactionTime     null.Time
+}
+
 type ReceiptSerialNumber struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
-	YYYYMM       string    `gorm:"type:varchar(6)" json:"YYYYMM"`
+	Prefix       string    `gorm:"type:varchar(3);primary_key" json:"prefix"`
+	YYYYMM       string    `gorm:"type:varchar(6);primary_key" json:"YYYYMM"`
 	SerialNumber int       `gorm:"type:int(10)" json:"serial_number"`
 }
+
+// ReceiptNumberLog records a receipt number issued to or voided from the donation
+type ReceiptNumberLog struct {
+	ID            uint        `gorm:"primary_key" json:"id"`
+	CreatedAt     time.Time   `json:"created_at"`
+	ReceiptNumber string      `gorm:"type:varchar(20);not null" json:"receipt_number"`
+	DonationType  string      `gorm:"type:ENUM('prime','token');not null" json:"donation_type"`
+	DonationID    uint        `gorm:"not null" json:"donation_id"`
+	Action        string      `gorm:"type:ENUM('issue','void');not null" json:"action"`
+	Reason        null.String `gorm:"size:255" json:"reason"`
+	OperatorID    null.Int    `json:"operator_id"`
+}
+
+// DonationRefund is a full or partial refund of the donation made through tap pay
+type DonationRefund struct {
+	ID                    uint        `gorm:"primary_key" json:"id"`
+	CreatedAt             time.Time   `json:"created_at"`
+	UpdatedAt             time.Time   `json:"updated_at"`
+	DonationType          string      `gorm:"type:ENUM('prime','token');not null" json:"donation_type"`
+	DonationID            uint        `gorm:"not null" json:"-"`
+	OrderNumber           string      `gorm:"type:varchar(50);not null" json:"order_number"` // order number of the donation
+	Amount                uint        `gorm:"not null" json:"amount"`
+	Currency              string      `gorm:"type:varchar(3);default:'TWD';not null" json:"currency"`
+	Reason                null.String `gorm:"size:255" json:"reason"`
+	Status                string      `gorm:"type:ENUM('refunding','refunded','fail');not null" json:"status"`
+	OperatorID            uint        `gorm:"not null" json:"operator_id"`
+	TappayRefundID        null.String `gorm:"type:varchar(50)" json:"tappay_refund_id"`
+	TappayApiStatus       null.Int    `json:"tappay_api_status"`
+	Msg                   null.String `gorm:"type:varchar(100)" json:"msg"`
+	VoidedReceiptNumber   null.String `gorm:"type:varchar(20)" json:"voided_receipt_number"`
+	ReissuedReceiptNumber null.String `gorm:"type:varchar(20)" json:"reissued_receipt_number"`
+}
+
+// TapPayNotification is the raw callback of the line pay or offline payment transaction notified by tap pay,
+// which is kept along with how it is handled for disputes
+type TapPayNotification struct {
+	ID             uint      `gorm:"primary_key"`
+	CreatedAt      time.Time `gorm:"not null"`
+	OrderNumber    string    `gorm:"type:varchar(50);not null"`
+	RecTradeID     string    `gorm:"type:varchar(20);not null"`
+	RemoteIP       string    `gorm:"column:remote_ip;type:varchar(45);not null"`
+	Payload        string    `gorm:"type:text;not null"`
+	ResponseStatus int       `gorm:"not null"`
+	Note           string    `gorm:"type:varchar(255);not null"` // why the callback is rejected or ignored
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// IdempotencyKey records the request of the user sent with the Idempotency-Key header and its response,
+// so that the request retried with the same key is responded without being processed again
+type IdempotencyKey struct {
+	ID          uint        `gorm:"primary_key"`
+	CreatedAt   time.Time   `gorm:"not null"`
+	UpdatedAt   time.Time   `gorm:"not null"`
+	UserID      uint        `gorm:"not null"`
+	Route       string      `gorm:"type:varchar(100);not null"`
+	Key         string      `gorm:"column:idempotency_key;type:varchar(255);not null"`
+	RequestHash string      `gorm:"type:char(64);not null"`
+	StatusCode  null.Int    // null until the request is processed
+	Response    null.String `gorm:"type:mediumtext"`
+	ExpiresAt   time.Time   `gorm:"not null"`
+}
+
+// IsProcessed reports whether the response of the request is recorded
+func (k IdempotencyKey) IsProcessed() bool {
+	return k.StatusCode.Valid
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	// IdentityProviderEmail stands for the email sign-in, i.e. ReporterAccount
+	IdentityProviderEmail = "Email"
+
+	IdentityActionLink      = "link"
+	IdentityActionUnlink    = "unlink"
+	IdentityActionAutoMerge = "auto_merge"
+)
+
+// IdentityAuditLog records a change of the login methods linked to a user
+type IdentityAuditLog struct {
+	ID        uint        `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UserID    uint        `gorm:"not null" json:"user_id"`
+	Action    string      `gorm:"type:ENUM('link','unlink','auto_merge');not null" json:"action"`
+	Provider  string      `gorm:"size:20;not null" json:"provider"` // Google / Facebook / Email ...
+	Identity  null.String `gorm:"size:191" json:"identity"`         // the id returned by oauth services or the email
+	IP        null.String `gorm:"column:ip;size:45" json:"ip"`
+	UserAgent null.String `gorm:"size:255" json:"user_agent"`
+}
+
+// EmailLinkRequest is a pending request to link an email sign-in to a signed-in user.
+// The email is linked once the otp code sent to it is verified.
+type EmailLinkRequest struct {
+	UserID         uint      `gorm:"primary_key;auto_increment:false" json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Email          string    `gorm:"size:100;not null" json:"email"`
+	OtpCodeHash    string    `gorm:"size:64;not null" json:"-"`
+	OtpExpTime     time.Time `json:"expired_at"`
+	FailedAttempts uint      `gorm:"type:int(10);unsigned;not null;default:0" json:"-"`
+}
@@ -4,3 +4,9 @@ package models
 type RoleUpdateMessage struct {
 	Email string `json:"email"`
 }
+
+// UserDeletionMessage represents the message structure for the notifications of erased users
+type UserDeletionMessage struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+}
@@ -46,6 +46,7 @@ type User struct {
 	ReadPostsCount             int             `gorm:"type:int(10);unsigned" json:"read_posts_count"`
 	ReadPostsSec               int             `gorm:"type:int(10);unsigned" json:"read_posts_sec"`
 	ShouldMergeOfflineDonation bool            `gorm:"column:should_merge_offline_donation_by_identity;type:tinyint(1);default:0" json:"should_merge_offline_donation_by_identity"`
+	TokensRevokedAt            null.Time       `json:"-"` // tokens issued before are rejected
 }
 
 // Role represents a user role
@@ -66,7 +67,7 @@ type OAuthAccount struct {
 	UpdatedAt time.Time   `json:"updated_at"`
 	DeletedAt *time.Time  `json:"deleted_at"`
 	UserID    uint        `json:"user_id"`
-	Type      string      `gorm:"size:10" json:"type"`  // Facebook / Google ...
+	Type      string      `gorm:"size:20" json:"type"`  // Facebook / Google / OIDC providers ...
 	AId       null.String `gorm:"not null" json:"a_id"` // user ID returned by OAuth services
 	Email     null.String `gorm:"size:100" json:"email"`
 	Name      null.String `gorm:"size:80" json:"name"`
@@ -79,12 +80,21 @@ type OAuthAccount struct {
 
 // ReporterAccount ...
 type ReporterAccount struct {
-	UserID        uint       `json:"user_id"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
-	DeletedAt     *time.Time `json:"deleted_at"`
-	ID            uint       `gorm:"primary_key" json:"id"`
-	Email         string     `gorm:"size:100;unique_index;not null" json:"email"`
-	ActivateToken string     `gorm:"size:50" json:"activate_token"`
-	ActExpTime    time.Time  `json:"-"`
+	UserID         uint        `json:"user_id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	DeletedAt      *time.Time  `json:"deleted_at"`
+	ID             uint        `gorm:"primary_key" json:"id"`
+	Email          string      `gorm:"size:100;unique_index;not null" json:"email"`
+	ActivateToken  string      `gorm:"size:50" json:"activate_token"`
+	ActExpTime     time.Time   `json:"-"`
+	OtpCodeHash    null.String `gorm:"size:64" json:"-"` // HMAC of the 6-digit code, cleared once used
+	OtpExpTime     null.Time   `json:"-"`
+	FailedAttempts uint        `gorm:"type:int(10);unsigned;not null;default:0" json:"-"`
+	LockedUntil    null.Time   `json:"-"`
+}
+
+// IsLocked reports whether the account is locked out at t
+func (ra ReporterAccount) IsLocked(t time.Time) bool {
+	return ra.LockedUntil.Valid && ra.LockedUntil.Time.After(t)
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// UserSession is a device the user signs in, identified by the jti claim of the id token
+type UserSession struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"-"`
+	UserID     uint      `gorm:"not null" json:"user_id"`
+	JTI        string    `gorm:"column:jti;size:64;unique_index;not null" json:"-"`
+	UserAgent  string    `gorm:"size:512" json:"user_agent"`
+	IP         string    `gorm:"column:ip;size:45" json:"ip"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RevokedAt  null.Time `json:"-"`
+}
+
+// IsActive reports whether the session is neither revoked nor expired at t
+func (s UserSession) IsActive(t time.Time) bool {
+	return !s.RevokedAt.Valid && s.ExpiresAt.After(t)
+}
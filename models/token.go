@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// RefreshToken is a rotated token of a refresh token family.
+// Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID             uint      `gorm:"primary_key" json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	UserID         uint      `gorm:"not null" json:"user_id"`
+	FamilyID       string    `gorm:"size:64;not null" json:"family_id"`
+	TokenHash      string    `gorm:"size:64;unique_index;not null" json:"-"`
+	AccessTokenJTI string    `gorm:"column:access_token_jti;size:64" json:"-"`
+	SessionJTI     string    `gorm:"column:session_jti;size:64" json:"-"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	ReplacedAt     null.Time `json:"replaced_at"`
+	RevokedAt      null.Time `json:"revoked_at"`
+}
+
+// IsActive reports whether the token can be exchanged at t
+func (rt RefreshToken) IsActive(t time.Time) bool {
+	return !rt.ReplacedAt.Valid && !rt.RevokedAt.Valid && rt.ExpiresAt.After(t)
+}
+
+// RevokedToken is a revoked JWT identified by its jti claim
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primary_key;size:64" json:"jti"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/twreporter/go-api/controllers"
 	"github.com/twreporter/go-api/globals"
 	"github.com/twreporter/go-api/middlewares"
+	"github.com/twreporter/go-api/utils"
 )
 
 const (
@@ -36,6 +37,36 @@ func ginResponseWrapper(fn wrappedFn) func(c *gin.Context) {
 	}
 }
 
+// sessionSameSite allows the session cookie to be sent along with the cross-site POST from
+// the providers using response_mode=form_post, e.g. Sign in with Apple.
+// SameSite=None is only accepted by browsers along with Secure attribute.
+func sessionSameSite() http.SameSite {
+	if globals.Conf.Environment == "development" {
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteNoneMode
+}
+
+// registerOIDCRoutes registers the sign-in, callback and link endpoints of the OIDC provider.
+// The provider without client ID is skipped.
+func registerOIDCRoutes(group *gin.RouterGroup, cf *controllers.ControllerFactory, name string) {
+	oc, err := cf.GetOIDCController(name)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+
+	if oc == nil {
+		return
+	}
+
+	group.GET("/"+name, middlewares.SetCacheControl("no-store"), oc.BeginOAuth)
+	group.GET("/"+name+"/callback", middlewares.SetCacheControl("no-store"), oc.Authenticate)
+	// for response_mode=form_post
+	group.POST("/"+name+"/callback", middlewares.SetCacheControl("no-store"), oc.Authenticate)
+	group.GET("/"+name+"/link", middlewares.ValidateAuthentication(), middlewares.SetCacheControl("no-store"), oc.BeginLinkOAuth)
+}
+
 // SetupRouter ...
 func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	switch globals.Conf.Environment {
@@ -51,23 +82,15 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 
 	config := cors.DefaultConfig()
 
-	var allowOrigins = globals.Conf.Cors.AllowOrigins
-	if len(allowOrigins) > 0 {
+	if allowOrigins := utils.AllowedOrigins(); len(allowOrigins) > 0 {
 		config.AllowOrigins = allowOrigins
-	} else {
-		switch globals.Conf.Environment {
-		case globals.DevelopmentEnvironment:
-			config.AllowAllOrigins = true
-		case globals.StagingEnvironment:
-			config.AllowOrigins = []string{globals.MainSiteStagingOrigin, globals.SupportSiteStagingOrigin, globals.AccountsSiteStagingOrigin}
-		case globals.ProductionEnvironment:
-			config.AllowOrigins = []string{globals.MainSiteOrigin, globals.SupportSiteOrigin, globals.AccountsSiteOrigin}
-		default:
-			// omit intentionally
-		}
+	} else if globals.Conf.Environment == globals.DevelopmentEnvironment {
+		config.AllowAllOrigins = true
 	}
 
 	config.AddAllowHeaders("Authorization")
+	config.AddAllowHeaders("Idempotency-Key")
+	config.AddExposeHeaders("Idempotent-Replayed")
 	config.AddAllowMethods("DELETE")
 	config.AddAllowMethods("PATCH")
 
@@ -77,6 +100,9 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 
 	engine.Use(cors.New(config))
 
+	// public keys to verify the JWTs issued by go-api
+	engine.GET("/.well-known/jwks.json", middlewares.SetCacheControl("public,max-age=3600"), ginResponseWrapper(controllers.GetJSONWebKeySet))
+
 	v1Group := engine.Group("/v1")
 	{
 		menuitems := new(controllers.MenuItemsController)
@@ -87,6 +113,10 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	// membership service endpoints
 	// =============================
 	mc := cf.GetMembershipController()
+	// access tokens are checked against the revoked ones
+	middlewares.SetTokenRevocationStorage(mc.Storage)
+	// privileges of users are checked by admin endpoints
+	middlewares.SetUserPrivilegeStorage(mc.Storage)
 
 	// endpoints for bookmarks of users
 	v1Group.GET("/users/:userID/bookmarks", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetBookmarksOfAUser))
@@ -95,7 +125,7 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	v1Group.DELETE("/users/:userID/bookmarks/:bookmarkID", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.DeleteABookmarkOfAUser))
 
 	// endpoints for donation
-	v1Group.POST("/periodic-donations", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.ValidateUserIDInReqBody(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.CreateAPeriodicDonationOfAUser))
+	v1Group.POST("/periodic-donations", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.ValidateUserIDInReqBody(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.Idempotent(mc.CreateAPeriodicDonationOfAUser)))
 	v1Group.PATCH("/periodic-donations/orders/:order", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.ValidateUserIDInReqBody(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(func(c *gin.Context) (int, gin.H, error) {
 		return mc.PatchADonationOfAUser(c, globals.PeriodicDonationType)
 	}))
@@ -104,11 +134,31 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	}))
 	// get payments of target periodic donations
 	v1Group.GET("/periodic-donations/orders/:order/payments", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetPaymentsOfAPeriodicDonation))
-	v1Group.POST("/donations/prime", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.ValidateUserIDInReqBody(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.CreateADonationOfAUser))
+	// self-service of the donors on their periodic donations
+	v1Group.POST("/periodic-donations/orders/:order/pause", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.PausePeriodicDonation))
+	v1Group.POST("/periodic-donations/orders/:order/resume", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.ResumePeriodicDonation))
+	v1Group.POST("/periodic-donations/orders/:order/change", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.ChangePeriodicDonation))
+	v1Group.POST("/periodic-donations/orders/:order/cancel", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.CancelPeriodicDonation))
+	v1Group.PUT("/periodic-donations/orders/:order/card", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.UpdatePeriodicDonationCard))
+	v1Group.GET("/periodic-donations/orders/:order/histories", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.PassAuthUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetHistoriesOfAPeriodicDonation))
+	v1Group.POST("/donations/prime", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.ValidateUserIDInReqBody(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.Idempotent(mc.CreateADonationOfAUser)))
 	v1Group.PATCH("/donations/prime/orders/:order", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.ValidateUserIDInReqBody(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(func(c *gin.Context) (int, gin.H, error) {
 		return mc.PatchADonationOfAUser(c, globals.PrimeDonationType)
 	}))
+	// refunds of donations by admins
+	v1Group.POST("/admin/donations/prime/orders/:order/refunds", middlewares.ValidateAuthorization(), middlewares.ValidateAdmin(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(func(c *gin.Context) (int, gin.H, error) {
+		return mc.RefundADonation(c, globals.PrimeDonationType)
+	}))
+	v1Group.POST("/admin/donations/token/orders/:order/refunds", middlewares.ValidateAuthorization(), middlewares.ValidateAdmin(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(func(c *gin.Context) (int, gin.H, error) {
+		return mc.RefundADonation(c, globals.TokenDonationType)
+	}))
+	// yearly upload file of the automatic tax deduction and the report of the excluded donations
+	v1Group.GET("/admin/donations/tax-deductions/:year", middlewares.ValidateAuthorization(), middlewares.ValidateAdmin(), middlewares.SetCacheControl("no-store"), mc.ExportTaxDeductions)
+	v1Group.GET("/admin/donations/tax-deductions/:year/exclusions", middlewares.ValidateAuthorization(), middlewares.ValidateAdmin(), middlewares.SetCacheControl("no-store"), mc.ExportTaxDeductionExclusions)
+	// donations exported to the finance with filters
+	v1Group.GET("/admin/donations/export", middlewares.ValidateAuthorization(), middlewares.ValidateAdmin(), middlewares.SetCacheControl("no-store"), middlewares.ClearWriteDeadline(), mc.ExportDonations)
 	v1Group.GET("/users/:userID/donations", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), ginResponseWrapper(mc.GetDonationsOfAUser))
+	v1Group.GET("/users/:userID/donations/summary", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetDonationSummaryOfAUser))
 	// one-time donation including credit_card, line pay, apple pay, google pay and samsung pay
 	v1Group.GET("/donations/prime/orders/:order", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(func(c *gin.Context) (int, gin.H, error) {
 		return mc.GetADonationOfAUser(c, globals.PrimeDonationType)
@@ -118,6 +168,7 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	v1Group.GET("/donations/prime/orders/:order/transaction_verification", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetVerificationInfoOfADonation))
 
 	v1Group.POST("/donations/prime/line-notify", ginResponseWrapper(mc.PatchLinePayOfAUser))
+	v1Group.POST("/donations/prime/offline-notify", ginResponseWrapper(mc.PatchOfflinePaymentOfAUser))
 	v1Group.POST("/tappay_query", middlewares.ValidateAuthentication(), middlewares.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.QueryTappayServer))
 	// TODO
 	// donations derived from the periodic donation
@@ -130,6 +181,9 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 		return mc.GetADonationOfAUser(c, globals.OthersDonationType)
 	}))
 
+	// endpoints for donation campaigns
+	v1Group.GET("/campaigns/:slug", middlewares.SetCacheControl("public,max-age=60"), ginResponseWrapper(mc.GetACampaign))
+
 	// endpoints for web push subscriptions
 	v1Group.POST("/web-push/subscriptions" /*middlewares.ValidateAuthorization()*/, middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.SubscribeWebPush))
 	v1Group.GET("/web-push/subscriptions", middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.IsWebPushSubscribed))
@@ -147,6 +201,11 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	v1Group.POST(fmt.Sprintf("/%s", globals.SendRoleActiontakerRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendRoleActiontakerMail))
 	v1Group.POST(fmt.Sprintf("/%s", globals.SendRoleTrailblazerRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendRoleTrailblazerMail))
 	v1Group.POST(fmt.Sprintf("/%s", globals.SendRoleDowngradeRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendRoleDowngradeMail))
+	v1Group.POST(fmt.Sprintf("/%s", globals.SendAccountDeletionRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendAccountDeletion))
+	v1Group.POST(fmt.Sprintf("/%s", globals.SendDataExportRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendDataExport))
+	v1Group.POST(fmt.Sprintf("/%s", globals.SendPeriodicDonationChangeRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendPeriodicDonationChange))
+	v1Group.POST(fmt.Sprintf("/%s", globals.SendCardExpiryReminderRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendCardExpiryReminder))
+	v1Group.POST(fmt.Sprintf("/%s", globals.SendOfflinePaymentRoutePath), mailMiddleware.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mailContrl.SendOfflinePaymentInstructions))
 
 	// =============================
 	// v2 news endpoints
@@ -182,13 +241,19 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 
 	session := cf.GetMgoSession()
 	c := session.DB("go-api").C("sessions")
-	store := mongo.NewStore(c, maxAge, true, []byte("secret"))
+	// the key pairs are validated at start up
+	keyPairs, err := utils.SessionKeyPairs()
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+	store := mongo.NewStore(c, maxAge, true, keyPairs...)
 	v2AuthGroup.Use(sessions.Sessions("go-api-session", store))
 	store.Options(sessions.Options{
 		Domain:   globals.Conf.App.Domain,
 		MaxAge:   maxAge,
 		HttpOnly: true,
 		Secure:   globals.Conf.Environment != "development",
+		SameSite: sessionSameSite(),
 	})
 
 	ogc := cf.GetOAuthController(globals.GoogleOAuth)
@@ -198,6 +263,14 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	v2AuthGroup.GET("/facebook", middlewares.SetCacheControl("no-store"), ofc.BeginOAuth)
 	v2AuthGroup.GET("/facebook/callback", middlewares.SetCacheControl("no-store"), ofc.Authenticate)
 
+	// endpoints for linking oauth account to the signed-in user
+	v2AuthGroup.GET("/google/link", middlewares.ValidateAuthentication(), middlewares.SetCacheControl("no-store"), ogc.BeginLinkOAuth)
+	v2AuthGroup.GET("/facebook/link", middlewares.ValidateAuthentication(), middlewares.SetCacheControl("no-store"), ofc.BeginLinkOAuth)
+
+	// OpenID Connect providers configured in `oauth.oidc`
+	registerOIDCRoutes(v2AuthGroup, cf, "apple")
+	registerOIDCRoutes(v2AuthGroup, cf, "line")
+
 	// =============================
 	// v2 membership service endpoints
 	// =============================
@@ -205,11 +278,34 @@ func SetupRouter(cf *controllers.ControllerFactory) (engine *gin.Engine) {
 	v2AuthGroup.POST("/authenticate", middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.AuthenticateV2))
 	v2AuthGroup.GET("/activate", middlewares.SetCacheControl("no-store"), mc.ActivateV2)
 	v2AuthGroup.POST("/token", middlewares.ValidateAuthentication(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.TokenDispatch))
+	v2AuthGroup.POST("/token/refresh", middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.TokenRefresh))
 	v2AuthGroup.GET("/logout", mc.TokenInvalidate)
+	v2AuthGroup.POST("/logout-all", middlewares.ValidateAuthorization(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.TokenRevokeAll))
 	v2Group.POST("/onboarding/:userID", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.Onboarding))
 	v2Group.POST("/users/:userID", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.SetUser))
 	v2Group.GET("/users/:userID", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetUser))
 
+	// endpoints for account deletion of a user
+	v2Group.DELETE("/users/:userID", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.RequestAccountDeletionOfAUser))
+	v2Group.GET("/users/:userID/deletion", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetAccountDeletionOfAUser))
+	v2Group.DELETE("/users/:userID/deletion", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.CancelAccountDeletionOfAUser))
+	// the token sent to the email authenticates the confirmation
+	v2Group.POST("/users/:userID/deletion/confirm", middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.ConfirmAccountDeletionOfAUser))
+
+	// endpoints for login methods of a user
+	v2Group.GET("/users/:userID/identities", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetIdentitiesOfAUser))
+	v2Group.DELETE("/users/:userID/identities/:provider", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.UnlinkAnIdentityOfAUser))
+	v2Group.POST("/users/:userID/identities/email", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.RequestEmailLinkOfAUser))
+	v2Group.POST("/users/:userID/identities/email/verify", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.VerifyEmailLinkOfAUser))
+
+	// endpoints for sessions of a user
+	v2Group.GET("/users/:userID/sessions", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.GetSessionsOfAUser))
+	v2Group.DELETE("/users/:userID/sessions/:sessionID", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), ginResponseWrapper(mc.RevokeASessionOfAUser))
+
+	// endpoint for personal data export of a user
+	dec := cf.GetDataExportController()
+	v2Group.GET("/users/:userID/export", middlewares.ValidateAuthorization(), middlewares.ValidateUserID(), middlewares.SetCacheControl("no-store"), dec.ExportUserData)
+
 	// =============================
 	// user analytics service endpoints
 	// =============================
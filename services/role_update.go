@@ -46,3 +46,29 @@ func (rus *RoleUpdateService) PublishRoleUpdate(email string) error {
 
 	return nil
 }
+
+// PublishUserDeletion publishes the message of an erased user to the role update topic,
+// so that the subscribers could drop the roles of the user.
+// The message is distinguished from role updates by the event attribute.
+func (rus *RoleUpdateService) PublishUserDeletion(userID uint, email string) error {
+	if rus.pubSubService == nil {
+		return errors.New("pub/sub service is not available")
+	}
+
+	message := models.UserDeletionMessage{
+		UserID: userID,
+		Email:  email,
+	}
+
+	err := rus.pubSubService.Publish(rus.topicName, message, map[string]string{"event": "user_deletion"})
+	if err != nil {
+		return errors.Wrap(err, "failed to publish user deletion message")
+	}
+
+	log.WithFields(log.Fields{
+		"topic":   rus.topicName,
+		"user_id": userID,
+	}).Info("Successfully published user deletion message")
+
+	return nil
+}
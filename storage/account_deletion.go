@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// userDataTables are the tables whose rows belong to a user only,
+// and are removed along with the user
+var userDataTables = []string{
+	"o_auth_accounts",
+	"reporter_accounts",
+	"users_bookmarks",
+	"users_posts_reading_counts",
+	"users_posts_reading_times",
+	"users_posts_reading_footprints",
+	"web_push_subs",
+	"users_roles",
+	"identity_audit_logs",
+	"email_link_requests",
+	"user_sessions",
+	"user_data_exports",
+	"idempotency_keys",
+}
+
+// erasedUserColumns are the personal data of users
+var erasedUserColumns = map[string]interface{}{
+	"email":                nil,
+	"name":                 nil,
+	"first_name":           nil,
+	"last_name":            nil,
+	"nickname":             nil,
+	"security_id":          nil,
+	"passport_id":          nil,
+	"title":                nil,
+	"legal_name":           nil,
+	"city":                 nil,
+	"state":                nil,
+	"country":              nil,
+	"zip":                  nil,
+	"address":              nil,
+	"phone":                nil,
+	"birthday":             nil,
+	"gender":               nil,
+	"age_range":            nil,
+	"education":            nil,
+	"read_preference":      nil,
+	"words_for_twreporter": nil,
+}
+
+// erasedCardholderColumns are the personal data in models.Cardholder and models.CardInfo.
+// The amounts and models.Receipt of the donations are kept for legal retention.
+// See erasedTaxDeductionColumns for the data uploaded to the tax agency.
+var erasedCardholderColumns = map[string]interface{}{
+	"cardholder_email":                "",
+	"cardholder_first_name":           nil,
+	"cardholder_last_name":            nil,
+	"cardholder_nickname":             nil,
+	"cardholder_title":                nil,
+	"cardholder_gender":               nil,
+	"cardholder_age_range":            nil,
+	"cardholder_read_preference":      nil,
+	"cardholder_words_for_twreporter": nil,
+	"cardholder_phone_number":         nil,
+	"cardholder_zip_code":             nil,
+	"cardholder_address":              nil,
+	"cardholder_address_country":      nil,
+	"cardholder_address_state":        nil,
+	"cardholder_address_city":         nil,
+	"cardholder_address_detail":       nil,
+	"cardholder_address_zip_code":     nil,
+	"cardholder_donate_reason":        nil,
+	"card_info_bin_code":              nil,
+	"card_info_expiry_date":           nil,
+	"card_info_last_four":             nil,
+}
+
+// erasedTaxDeductionColumns are the personal data of the donors uploaded to the tax agency.
+// They are kept until the tax year of the donation is uploaded.
+var erasedTaxDeductionColumns = map[string]interface{}{
+	"cardholder_name":        nil,
+	"cardholder_legal_name":  nil,
+	"cardholder_national_id": nil,
+	"cardholder_security_id": nil,
+}
+
+// taxDeductionPrimeDonations are the prime donations which are not uploaded to the tax agency
+// if they are transacted since the given time
+const taxDeductionPrimeDonations = "status = 'paid' AND auto_tax_deduction = 1 AND transaction_time >= ?"
+
+// taxDeductionPeriodicDonations are the periodic donations with the charges which are not uploaded
+// to the tax agency if they are transacted since the given time
+const taxDeductionPeriodicDonations = `auto_tax_deduction = 1 AND EXISTS (
+	SELECT 1 FROM pay_by_card_token_donations AS t
+	WHERE t.periodic_id = periodic_donations.id AND t.status = 'paid' AND t.transaction_time >= ?
+)`
+
+// erasedOtherMethodDonationColumns are the personal data of models.PayByOtherMethodDonation
+var erasedOtherMethodDonationColumns = map[string]interface{}{
+	"email":        "",
+	"name":         "",
+	"national_id":  "",
+	"security_id":  "",
+	"phone_number": "",
+	"address":      "",
+	"zip_code":     "",
+}
+
+// CreateAccountDeletionRequest creates the deletion request of the user.
+// The previous unconfirmed request of the user is replaced.
+func (gs *GormStorage) CreateAccountDeletionRequest(req models.AccountDeletionRequest) error {
+	tx := gs.db.Begin()
+
+	if err := tx.Where("user_id = ?", req.UserID).Delete(&models.AccountDeletionRequest{}).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not delete account deletion request(user_id: %d)", req.UserID))
+	}
+
+	if err := tx.Create(&req).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create account deletion request(user_id: %d)", req.UserID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// GetAccountDeletionRequest gets the deletion request of the user
+func (gs *GormStorage) GetAccountDeletionRequest(userID uint) (models.AccountDeletionRequest, error) {
+	var req models.AccountDeletionRequest
+
+	err := gs.db.Where("user_id = ?", userID).First(&req).Error
+	if err != nil {
+		return req, errors.Wrap(err, fmt.Sprintf("can not get account deletion request(user_id: %d)", userID))
+	}
+
+	return req, nil
+}
+
+// ConfirmAccountDeletionRequest confirms the unexpired deletion request with the token hash,
+// and schedules the erasure at scheduledAt.
+// A not found error is returned if no such request is pending.
+func (gs *GormStorage) ConfirmAccountDeletionRequest(userID uint, tokenHash string, scheduledAt time.Time) error {
+	now := time.Now()
+
+	updates := gs.db.Model(&models.AccountDeletionRequest{}).
+		Where("user_id = ? AND token_hash = ? AND confirm_exp_time > ? AND confirmed_at IS NULL", userID, tokenHash, now).
+		UpdateColumns(map[string]interface{}{
+			"confirmed_at": now,
+			"scheduled_at": scheduledAt,
+			"token_hash":   "",
+			"updated_at":   now,
+		})
+	if err := updates.Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not confirm account deletion request(user_id: %d)", userID))
+	}
+
+	if updates.RowsAffected == 0 {
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("pending account deletion request(user_id: %d) is not found", userID))
+	}
+
+	return nil
+}
+
+// CancelAccountDeletionRequest removes the deletion request which is not completed yet.
+// A not found error is returned if no such request exists.
+func (gs *GormStorage) CancelAccountDeletionRequest(userID uint) error {
+	deletes := gs.db.Where("user_id = ? AND completed_at IS NULL", userID).Delete(&models.AccountDeletionRequest{})
+	if err := deletes.Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not cancel account deletion request(user_id: %d)", userID))
+	}
+
+	if deletes.RowsAffected == 0 {
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("account deletion request(user_id: %d) is not found", userID))
+	}
+
+	return nil
+}
+
+// GetDueAccountDeletionRequests gets the confirmed requests passing the grace period at t
+func (gs *GormStorage) GetDueAccountDeletionRequests(t time.Time, limit int) ([]models.AccountDeletionRequest, error) {
+	var reqs []models.AccountDeletionRequest
+
+	err := gs.db.Where("confirmed_at IS NOT NULL AND completed_at IS NULL AND scheduled_at <= ?", t).
+		Order("scheduled_at").Limit(limit).Find(&reqs).Error
+	if err != nil {
+		return reqs, errors.Wrap(err, "can not get due account deletion requests")
+	}
+
+	return reqs, nil
+}
+
+// EraseAccount anonymises the user of the due deletion request in a transaction.
+// The data belonging to the user only are removed, and the personal data of the donations are wiped,
+// while the amounts and the receipts of the donations are kept for legal retention.
+// The donors of the tax deductible donations not uploaded to the tax agency yet are kept
+// until EraseUploadedTaxDeductionData erases them.
+// The user before erasure is returned, so that the caller could notify other services.
+// A not found error is returned if the request is not due or is completed by others.
+func (gs *GormStorage) EraseAccount(userID uint) (models.User, error) {
+	var req models.AccountDeletionRequest
+	var user models.User
+	now := time.Now()
+
+	tx := gs.db.Begin()
+
+	// lock the request to prevent the replicas from erasing the same account
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("user_id = ? AND confirmed_at IS NOT NULL AND completed_at IS NULL AND scheduled_at <= ?", userID, now).
+		First(&req).Error
+	if err != nil {
+		tx.Rollback()
+		return user, errors.Wrap(err, fmt.Sprintf("can not get due account deletion request(user_id: %d)", userID))
+	}
+
+	if err = tx.Unscoped().Where("id = ?", userID).First(&user).Error; err != nil {
+		tx.Rollback()
+		return user, errors.Wrap(err, fmt.Sprintf("can not get user(id: %d)", userID))
+	}
+
+	if err = eraseAccountInTRX(tx, user, now); err != nil {
+		tx.Rollback()
+		return user, errors.Wrap(err, fmt.Sprintf("can not erase user(id: %d)", userID))
+	}
+
+	err = tx.Model(&req).UpdateColumns(map[string]interface{}{
+		"completed_at": now,
+		"updated_at":   now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return user, errors.Wrap(err, fmt.Sprintf("can not complete account deletion request(user_id: %d)", userID))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return user, errors.WithStack(err)
+	}
+
+	return user, nil
+}
+
+func eraseAccountInTRX(tx *gorm.DB, user models.User, now time.Time) error {
+	if err := revokeRefreshTokens(tx, "user_id = ?", user.ID); err != nil {
+		return err
+	}
+
+	for _, table := range userDataTables {
+		if err := tx.Exec(fmt.Sprintf("DELETE FROM `%s` WHERE user_id = ?", table), user.ID).Error; err != nil {
+			return errors.Wrap(err, fmt.Sprintf("can not delete %s", table))
+		}
+	}
+
+	// newsletter registrations could be made by email before signing up
+	err := tx.Exec("DELETE FROM `registrations` WHERE user_id = ? OR email = ?", user.ID, user.Email.String).Error
+	if err != nil {
+		return errors.Wrap(err, "can not delete registrations")
+	}
+
+	err = tx.Model(&models.PayByPrimeDonation{}).Unscoped().Where("user_id = ?", user.ID).UpdateColumns(erasedCardholderColumns).Error
+	if err != nil {
+		return errors.Wrap(err, "can not erase prime donations")
+	}
+
+	retainedSince, err := taxDeductionRetentionStart(now)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.PayByOtherMethodDonation{}).Unscoped().Where("user_id = ?", user.ID).UpdateColumns(erasedOtherMethodDonationColumns).Error
+	if err != nil {
+		return errors.Wrap(err, "can not erase other method donations")
+	}
+
+	// stop charging the card, and remove the card token which could charge the card
+	err = tx.Model(&models.PeriodicDonation{}).Unscoped().Where("user_id = ? AND status <> ?", user.ID, "invalid").UpdateColumn("status", "stopped").Error
+	if err != nil {
+		return errors.Wrap(err, "can not stop periodic donations")
+	}
+
+	periodicColumns := map[string]interface{}{
+		"card_token": "",
+		"card_key":   "",
+	}
+	for k, v := range erasedCardholderColumns {
+		periodicColumns[k] = v
+	}
+	err = tx.Model(&models.PeriodicDonation{}).Unscoped().Where("user_id = ?", user.ID).UpdateColumns(periodicColumns).Error
+	if err != nil {
+		return errors.Wrap(err, "can not erase periodic donations")
+	}
+
+	if err = eraseTaxDeductionData(tx, retainedSince, "user_id = ?", user.ID); err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.PeriodicDonationHistory{}).Where("user_id = ?", user.ID).UpdateColumn("reason", nil).Error
+	if err != nil {
+		return errors.Wrap(err, "can not erase histories of periodic donations")
+	}
+
+	userColumns := map[string]interface{}{
+		"tokens_revoked_at":     now,
+		"deleted_at":            now,
+		"agree_data_collection": false,
+	}
+	for k, v := range erasedUserColumns {
+		userColumns[k] = v
+	}
+	err = tx.Model(&models.User{}).Unscoped().Where("id = ?", user.ID).UpdateColumns(userColumns).Error
+	if err != nil {
+		return errors.Wrap(err, "can not anonymise user")
+	}
+
+	return nil
+}
+
+// EraseUploadedTaxDeductionData erases the donors of the tax deductible donations of the erased accounts
+// once the tax years of the donations are uploaded to the tax agency at t.
+func (gs *GormStorage) EraseUploadedTaxDeductionData(t time.Time) error {
+	retainedSince, err := taxDeductionRetentionStart(t)
+	if err != nil {
+		return err
+	}
+
+	err = eraseTaxDeductionData(gs.db, retainedSince, "user_id IN (SELECT user_id FROM account_deletion_requests WHERE completed_at IS NOT NULL)")
+	if err != nil {
+		return errors.Wrap(err, "can not erase uploaded tax deduction data of erased accounts")
+	}
+
+	return nil
+}
+
+// taxDeductionRetentionStart is the start of the earliest tax year not uploaded at t.
+// The donations of a year are uploaded to the tax agency in the following January.
+func taxDeductionRetentionStart(t time.Time) (time.Time, error) {
+	tz, err := time.LoadLocation(timezoneTPE)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	return time.Date(t.In(tz).Year()-1, time.January, 1, 0, 0, 0, 0, tz), nil
+}
+
+// eraseTaxDeductionData erases the donors uploaded to the tax agency from the donations matching the query,
+// except the tax deductible donations transacted since retainedSince
+func eraseTaxDeductionData(db *gorm.DB, retainedSince time.Time, query string, args ...interface{}) error {
+	err := db.Model(&models.PayByPrimeDonation{}).Unscoped().
+		Where(fmt.Sprintf("(%s) AND NOT IFNULL(%s, FALSE)", query, taxDeductionPrimeDonations), append(args, retainedSince)...).
+		UpdateColumns(erasedTaxDeductionColumns).Error
+	if err != nil {
+		return errors.Wrap(err, "can not erase tax deduction data of prime donations")
+	}
+
+	// the charges of the periodic donations are uploaded with the donors of the periodic donations
+	err = db.Model(&models.PeriodicDonation{}).Unscoped().
+		Where(fmt.Sprintf("(%s) AND NOT IFNULL(%s, FALSE)", query, taxDeductionPeriodicDonations), append(args, retainedSince)...).
+		UpdateColumns(erasedTaxDeductionColumns).Error
+	if err != nil {
+		return errors.Wrap(err, "can not erase tax deduction data of periodic donations")
+	}
+
+	return nil
+}
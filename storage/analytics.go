@@ -23,6 +23,7 @@ type AnalyticsGormStorage interface {
 	UpdateUserReadingPostTime(string, string, int) (error)
 	UpdateUserReadingFootprint(string, string) (bool, error)
 	GetFootprintsOfAUser(string, int, int) ([]respFootprint, int, error)
+	GetReadingTimesOfAUser(string, int, int) ([]models.UsersPostsReadingTime, int, error)
 }
 
 type AnalyticsMongoStorage interface {
@@ -237,3 +238,20 @@ func (ms *mongoDB) getMetaOfFootprints(ctx context.Context, stages []bson.D) <-c
 	}(ctx, stages)
 	return result
 }
+
+// GetReadingTimesOfAUser gets the reading time records of the user
+func (gs *gormDB) GetReadingTimesOfAUser(userID string, limit int, offset int) ([]models.UsersPostsReadingTime, int, error) {
+	var err error
+	var total int
+	var readingTimes []models.UsersPostsReadingTime
+
+	statement := gs.db.Model(&models.UsersPostsReadingTime{}).Where("user_id = ?", userID)
+	if err = statement.Limit(limit).Offset(offset).Order("created_at").Find(&readingTimes).Error; err != nil {
+		return nil, 0, err
+	}
+	if err = statement.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return readingTimes, total, nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// GetCampaignBySlug gets the donation campaign of the slug
+func (g *GormStorage) GetCampaignBySlug(slug string) (models.DonationCampaign, error) {
+	var c models.DonationCampaign
+
+	if err := g.db.Where("slug = ?", slug).First(&c).Error; err != nil {
+		return c, errors.Wrap(err, fmt.Sprintf("can not get campaign(slug: %s)", slug))
+	}
+
+	return c, nil
+}
+
+// GetCampaignProgress aggregates the paid prime donations and the paid charges of the periodic donations
+// earmarked for the campaign. The amounts left after the partial refunds are summed up by their TWD equivalents.
+func (g *GormStorage) GetCampaignProgress(campaignID uint) (models.CampaignProgress, error) {
+	var p models.CampaignProgress
+
+	const query = `SELECT COALESCE(SUM(ROUND(COALESCE(d.twd_amount, d.amount) * (d.amount - COALESCE(r.refunded_amount, 0)) / d.amount)), 0) AS raised_amount,
+		COUNT(DISTINCT d.user_id) AS donor_count FROM (
+		SELECT 'prime' AS donation_type, id AS donation_id, user_id, amount, twd_amount FROM pay_by_prime_donations
+		WHERE campaign_id = ? AND status = 'paid' AND amount > 0 AND deleted_at IS NULL
+		UNION ALL
+		SELECT 'token' AS donation_type, t.id AS donation_id, p.user_id, t.amount, t.twd_amount FROM pay_by_card_token_donations t
+		JOIN periodic_donations p ON p.id = t.periodic_id
+		WHERE p.campaign_id = ? AND t.status = 'paid' AND t.amount > 0 AND t.deleted_at IS NULL
+	) AS d
+	LEFT JOIN (
+		SELECT donation_type, donation_id, SUM(amount) AS refunded_amount FROM donation_refunds
+		WHERE status = 'refunded'
+		GROUP BY donation_type, donation_id
+	) AS r ON r.donation_type = d.donation_type AND r.donation_id = d.donation_id`
+
+	if err := g.db.Raw(query, campaignID, campaignID).Scan(&p).Error; err != nil {
+		return p, errors.Wrap(err, fmt.Sprintf("can not get progress of campaign(id: %d)", campaignID))
+	}
+
+	return p, nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// StartUserDataExport marks the data export of the user as processing.
+// It returns false if another export of the user is processing and is updated after staleBefore.
+func (gs *GormStorage) StartUserDataExport(userID uint, staleBefore time.Time) (bool, error) {
+	var export models.UserDataExport
+
+	tx := gs.db.Begin()
+
+	// lock the export to prevent the concurrent requests from generating the archive twice
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Select("user_id, status, updated_at").Where("user_id = ?", userID).First(&export).Error
+	switch {
+	case err == nil:
+		if export.Status == models.DataExportStatusProcessing && export.UpdatedAt.After(staleBefore) {
+			tx.Rollback()
+			return false, nil
+		}
+	case IsNotFound(err):
+	default:
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not get data export of user(id: %d)", userID))
+	}
+
+	if err = tx.Where("user_id = ?", userID).Delete(&models.UserDataExport{}).Error; err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not delete data export of user(id: %d)", userID))
+	}
+
+	if err = tx.Create(&models.UserDataExport{UserID: userID, Status: models.DataExportStatusProcessing}).Error; err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not create data export of user(id: %d)", userID))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+// GetUserDataExport gets the data export of the user along with the archive
+func (gs *GormStorage) GetUserDataExport(userID uint) (models.UserDataExport, error) {
+	var export models.UserDataExport
+
+	err := gs.db.Where("user_id = ?", userID).First(&export).Error
+	if err != nil {
+		return export, errors.Wrap(err, fmt.Sprintf("can not get data export of user(id: %d)", userID))
+	}
+
+	return export, nil
+}
+
+// CompleteUserDataExport stores the archive of the processing export, which is available until expiresAt
+func (gs *GormStorage) CompleteUserDataExport(userID uint, archive []byte, expiresAt time.Time) error {
+	err := gs.db.Model(&models.UserDataExport{}).Where("user_id = ? AND status = ?", userID, models.DataExportStatusProcessing).
+		Updates(map[string]interface{}{
+			"status":     models.DataExportStatusReady,
+			"archive":    archive,
+			"expires_at": expiresAt,
+		}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not complete data export of user(id: %d)", userID))
+	}
+
+	return nil
+}
+
+// ClearExpiredUserDataExports deletes the archives expired before t
+func (gs *GormStorage) ClearExpiredUserDataExports(t time.Time) error {
+	err := gs.db.Model(&models.UserDataExport{}).Where("expires_at <= ? AND archive IS NOT NULL", t).
+		UpdateColumn("archive", nil).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not clear data exports expired before %s", t))
+	}
+
+	return nil
+}
+
+// FailUserDataExport marks the processing export as failed, so that it could be requested again
+func (gs *GormStorage) FailUserDataExport(userID uint) error {
+	err := gs.db.Model(&models.UserDataExport{}).Where("user_id = ? AND status = ?", userID, models.DataExportStatusProcessing).
+		Update("status", models.DataExportStatusFailed).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not mark data export of user(id: %d) as failed", userID))
+	}
+
+	return nil
+}
@@ -5,9 +5,6 @@ import (
 	"fmt"
 	"time"
 
-	"gopkg.in/guregu/null.v3"
-
-	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 
 	"github.com/twreporter/go-api/internal/member_cms"
@@ -113,7 +110,7 @@ func (g *GormStorage) GetDonationsOfAUser(userID string, limit int, offset int)
 	var err error
 
 	// build query statement
-	defaultColumns := "id, amount, order_number, created_at, send_receipt, status, pay_method, cardholder_first_name, cardholder_last_name, receipt_header, receipt_address_country, receipt_address_state, receipt_address_city, receipt_address_detail, receipt_address_zip_code, card_info_bin_code, card_info_last_four, card_info_type, is_anonymous"
+	defaultColumns := "id, amount, currency, twd_amount, order_number, created_at, send_receipt, status, pay_method, cardholder_first_name, cardholder_last_name, receipt_header, receipt_address_country, receipt_address_state, receipt_address_city, receipt_address_detail, receipt_address_zip_code, card_info_bin_code, card_info_last_four, card_info_type, is_anonymous"
 	selectColumnsPrime := fmt.Sprintf("%s, %s", defaultColumns, "'prime' as type")
 	queryPrime := g.db.Table("pay_by_prime_donations").Select(selectColumnsPrime).Where("user_id = ?", userID).QueryExpr()
 	selectColumnsPeriodic := fmt.Sprintf("%s, %s", defaultColumns, "'periodic' as type")
@@ -241,56 +238,111 @@ func (g *GormStorage) CreateAPayByOtherMethodDonation(m models.PayByOtherMethodD
 	return nil
 }
 
-func (g *GormStorage) GenerateReceiptSerialNumber(primeID uint, transactionTime null.Time) (string, error) {
-	var emptyString string
-	needCreateSerial := false
-	// get transaction month
-	if transactionTime.IsZero() {
-		return emptyString, errors.New("transaction time should not be nil")
-	}
-	tz, err := time.LoadLocation(timezoneTPE)
-	if err != nil {
-		return emptyString, err
+// GetReceiptItemsOfAUser gets the paid prime donations and the paid charges of the periodic donations of the user
+// transacted within [from, to) along with the amounts refunded partially, ordered by the transaction time.
+// The donations refunded fully are left out.
+func (g *GormStorage) GetReceiptItemsOfAUser(userID uint, from time.Time, to time.Time) ([]models.ReceiptItem, error) {
+	var items []models.ReceiptItem
+
+	const query = `SELECT d.*, COALESCE(r.refunded_amount, 0) AS refunded_amount FROM (
+		SELECT 'prime' AS donation_type, id AS donation_id,
+			receipt_header, receipt_security_id, receipt_email, receipt_address_country, receipt_address_state, receipt_address_city, receipt_address_detail, receipt_address_zip_code, cardholder_name,
+			order_number, receipt_number, amount, twd_amount, pay_method, transaction_time FROM pay_by_prime_donations
+		WHERE user_id = ? AND status = 'paid' AND transaction_time >= ? AND transaction_time < ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT 'token' AS donation_type, t.id AS donation_id,
+			p.receipt_header, p.receipt_security_id, p.receipt_email, p.receipt_address_country, p.receipt_address_state, p.receipt_address_city, p.receipt_address_detail, p.receipt_address_zip_code, p.cardholder_name,
+			t.order_number, t.receipt_number, t.amount, t.twd_amount, p.pay_method, t.transaction_time FROM pay_by_card_token_donations t
+		JOIN periodic_donations p ON p.id = t.periodic_id
+		WHERE p.user_id = ? AND t.status = 'paid' AND t.transaction_time >= ? AND t.transaction_time < ? AND t.deleted_at IS NULL
+	) AS d
+	LEFT JOIN (
+		SELECT donation_type, donation_id, SUM(amount) AS refunded_amount FROM donation_refunds
+		WHERE status = 'refunded'
+		GROUP BY donation_type, donation_id
+	) AS r ON r.donation_type = d.donation_type AND r.donation_id = d.donation_id
+	WHERE d.amount > COALESCE(r.refunded_amount, 0)
+	ORDER BY d.transaction_time, d.order_number`
+
+	if err := g.db.Raw(query, userID, from, to, userID, from, to).Scan(&items).Error; err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not get receipt items of user(id: %d)", userID))
 	}
-	month := transactionTime.Time.In(tz).Format(YYYYMM)
 
-	// start transaction
-	var serialNumber models.ReceiptSerialNumber
-	tx := g.db.Begin()
+	return items, nil
+}
 
-	err = tx.Raw("Select `serial_number` From `receipt_serial_numbers` Where YYYYMM = ? for update", month).Scan(&serialNumber).Error
-	if nil != err && !gorm.IsRecordNotFoundError(err) {
-		tx.Rollback()
-		return emptyString, errors.Wrap(err, fmt.Sprintf("select receipt serial number failed. month: %s", month))
+// GetDonationAmountsOfAUser gets the TWD equivalents of the paid prime donations and the paid charges
+// of the periodic donations of the user left after the partial refunds, ordered by the transaction time
+func (g *GormStorage) GetDonationAmountsOfAUser(userID uint) ([]models.DonationAmount, error) {
+	var amounts []models.DonationAmount
+
+	const query = `SELECT d.transaction_time,
+		ROUND(COALESCE(d.twd_amount, d.amount) * (d.amount - COALESCE(r.refunded_amount, 0)) / d.amount) AS twd_amount FROM (
+		SELECT 'prime' AS donation_type, id AS donation_id, amount, twd_amount, COALESCE(transaction_time, created_at) AS transaction_time FROM pay_by_prime_donations
+		WHERE user_id = ? AND status = 'paid' AND amount > 0 AND deleted_at IS NULL
+		UNION ALL
+		SELECT 'token' AS donation_type, t.id AS donation_id, t.amount, t.twd_amount, COALESCE(t.transaction_time, t.created_at) AS transaction_time FROM pay_by_card_token_donations t
+		JOIN periodic_donations p ON p.id = t.periodic_id
+		WHERE p.user_id = ? AND t.status = 'paid' AND t.amount > 0 AND t.deleted_at IS NULL
+	) AS d
+	LEFT JOIN (
+		SELECT donation_type, donation_id, SUM(amount) AS refunded_amount FROM donation_refunds
+		WHERE status = 'refunded'
+		GROUP BY donation_type, donation_id
+	) AS r ON r.donation_type = d.donation_type AND r.donation_id = d.donation_id
+	ORDER BY d.transaction_time`
+
+	if err := g.db.Raw(query, userID, userID).Scan(&amounts).Error; err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not get donation amounts of user(id: %d)", userID))
 	}
-	needCreateSerial = gorm.IsRecordNotFoundError(err)
 
-	// generate receipt number
-	receiptNumber := fmt.Sprintf("A%s-%05d", month, serialNumber.SerialNumber+1)
+	return amounts, nil
+}
+
+// GetPeriodicDonationsOfAUser gets the periodic donations of the user along with the numbers of their paid charges,
+// ordered by the creation time descendingly
+func (g *GormStorage) GetPeriodicDonationsOfAUser(userID uint) ([]models.PeriodicDonationOverview, error) {
+	var donations []models.PeriodicDonationOverview
 
-	// update receipt number
-	err = tx.Table("pay_by_prime_donations").Where("id = ?", primeID).Update("receipt_number", receiptNumber).Error
-	if nil != err {
-		tx.Rollback()
-		return emptyString, errors.Wrap(err, fmt.Sprintf("update receipt number failed. primeID: %d, receipt number: %s", primeID, receiptNumber))
+	const query = `SELECT p.order_number, p.amount, p.currency, p.frequency, p.status, p.last_success_at, p.next_retry_at, p.paused_until, p.max_paid_times,
+		(SELECT COUNT(*) FROM pay_by_card_token_donations t WHERE t.periodic_id = p.id AND t.status = 'paid') AS paid_times
+		FROM periodic_donations p
+		WHERE p.user_id = ? AND p.deleted_at IS NULL
+		ORDER BY p.created_at DESC`
+
+	if err := g.db.Raw(query, userID).Scan(&donations).Error; err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not get periodic donations of user(id: %d)", userID))
 	}
 
-	// update serial number
-	if needCreateSerial {
-		serialNumber.YYYYMM = month
-		serialNumber.SerialNumber = 1
-		if err = tx.Create(&serialNumber).Error; nil != err {
-			tx.Rollback()
-			return emptyString, errors.Wrap(err, fmt.Sprintf("create receipt serail number failed. YYYYMM: %s", month))
-		}
-	} else {
-		err = tx.Model(&serialNumber).Where("YYYYMM = ?", month).UpdateColumn("serial_number", gorm.Expr("serial_number + ?", 1)).Error
-		if nil != err {
-			tx.Rollback()
-			return emptyString, errors.Wrap(err, fmt.Sprintf("update serial number failed. month: %s, serial number: %d", month, serialNumber.SerialNumber+1))
-		}
+	return donations, nil
+}
+
+// GetTaxDeductionDonations gets the paid prime donations and the paid charges of the periodic donations
+// transacted within [from, to) whose donors opt in to the automatic tax deduction,
+// along with the amounts refunded partially, ordered by the transaction time
+func (g *GormStorage) GetTaxDeductionDonations(from time.Time, to time.Time) ([]models.TaxDeductionDonation, error) {
+	var donations []models.TaxDeductionDonation
+
+	const query = `SELECT d.*, COALESCE(r.refunded_amount, 0) AS refunded_amount FROM (
+		SELECT 'prime' AS donation_type, id AS donation_id, order_number, user_id, cardholder_name, cardholder_legal_name, cardholder_national_id, cardholder_security_id,
+			amount, twd_amount, transaction_time FROM pay_by_prime_donations
+		WHERE status = 'paid' AND auto_tax_deduction = 1 AND transaction_time >= ? AND transaction_time < ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT 'token' AS donation_type, t.id AS donation_id, t.order_number, p.user_id, p.cardholder_name, p.cardholder_legal_name, p.cardholder_national_id, p.cardholder_security_id,
+			t.amount, t.twd_amount, t.transaction_time FROM pay_by_card_token_donations t
+		JOIN periodic_donations p ON p.id = t.periodic_id
+		WHERE t.status = 'paid' AND p.auto_tax_deduction = 1 AND t.transaction_time >= ? AND t.transaction_time < ? AND t.deleted_at IS NULL
+	) AS d
+	LEFT JOIN (
+		SELECT donation_type, donation_id, SUM(amount) AS refunded_amount FROM donation_refunds
+		WHERE status = 'refunded'
+		GROUP BY donation_type, donation_id
+	) AS r ON r.donation_type = d.donation_type AND r.donation_id = d.donation_id
+	ORDER BY d.transaction_time, d.order_number`
+
+	if err := g.db.Raw(query, from, to, from, to).Scan(&donations).Error; err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not get tax deduction donations from %s to %s", from, to))
 	}
-	tx.Commit()
 
-	return receiptNumber, nil
+	return donations, nil
 }
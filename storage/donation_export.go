@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// donationExportSource is how the exported columns are selected from a kind of the donations,
+// and the expressions of the columns filtered on. The empty expression means the kind does not have the column.
+type donationExportSource struct {
+	selects     string
+	donatedAt   string
+	status      string
+	payMethod   string
+	campaignID  string
+	sendReceipt string
+}
+
+// donationExportSources are the sources of the exported donations by donation type, in the order exported
+var donationExportSources = []struct {
+	donationType string
+	source       donationExportSource
+}{
+	{"prime", donationExportSource{
+		selects: `SELECT 'prime' AS donation_type, d.order_number, d.status, d.amount, d.currency, d.twd_amount, d.pay_method, d.merchant_id, d.campaign_id, d.send_receipt, d.receipt_number,
+			COALESCE(d.transaction_time, d.created_at) AS donated_at, d.user_id, d.cardholder_email, d.cardholder_name, d.cardholder_phone_number, d.cardholder_national_id,
+			d.receipt_header, d.receipt_security_id, d.receipt_email, d.receipt_address_country, d.receipt_address_state, d.receipt_address_city, d.receipt_address_detail, d.receipt_address_zip_code
+			FROM pay_by_prime_donations d`,
+		donatedAt:   "COALESCE(d.transaction_time, d.created_at)",
+		status:      "d.status",
+		payMethod:   "d.pay_method",
+		campaignID:  "d.campaign_id",
+		sendReceipt: "d.send_receipt",
+	}},
+	{"token", donationExportSource{
+		selects: `SELECT 'token' AS donation_type, d.order_number, d.status, d.amount, d.currency, d.twd_amount, p.pay_method, d.merchant_id, p.campaign_id, p.send_receipt, d.receipt_number,
+			COALESCE(d.transaction_time, d.created_at) AS donated_at, p.user_id, p.cardholder_email, p.cardholder_name, p.cardholder_phone_number, p.cardholder_national_id,
+			p.receipt_header, p.receipt_security_id, p.receipt_email, p.receipt_address_country, p.receipt_address_state, p.receipt_address_city, p.receipt_address_detail, p.receipt_address_zip_code
+			FROM pay_by_card_token_donations d JOIN periodic_donations p ON p.id = d.periodic_id`,
+		donatedAt:   "COALESCE(d.transaction_time, d.created_at)",
+		status:      "d.status",
+		payMethod:   "p.pay_method",
+		campaignID:  "p.campaign_id",
+		sendReceipt: "p.send_receipt",
+	}},
+	// the donations paid by other methods are recorded by hand after they are paid
+	{"others", donationExportSource{
+		selects: `SELECT 'others' AS donation_type, d.order_number, 'paid' AS status, d.amount, d.currency, NULL AS twd_amount, d.pay_method, d.merchant_id, NULL AS campaign_id, d.send_receipt, NULL AS receipt_number,
+			d.created_at AS donated_at, d.user_id, d.email AS cardholder_email, d.name AS cardholder_name, d.phone_number AS cardholder_phone_number, d.national_id AS cardholder_national_id,
+			d.receipt_header, d.security_id AS receipt_security_id, NULL AS receipt_email, NULL AS receipt_address_country, NULL AS receipt_address_state, NULL AS receipt_address_city, d.address AS receipt_address_detail, d.zip_code AS receipt_address_zip_code
+			FROM pay_by_other_method_donations d`,
+		donatedAt:   "d.created_at",
+		payMethod:   "d.pay_method",
+		sendReceipt: "d.send_receipt",
+	}},
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// donationExportQuery builds the statement selecting the donations of the source matching the filter.
+// false is returned if none of the donations of the source could match.
+func donationExportQuery(s donationExportSource, f models.DonationExportFilter) (string, []interface{}, bool) {
+	conditions := []string{"d.deleted_at IS NULL"}
+	var args []interface{}
+
+	if f.From.Valid {
+		conditions = append(conditions, s.donatedAt+" >= ?")
+		args = append(args, f.From.Time)
+	}
+	if f.To.Valid {
+		conditions = append(conditions, s.donatedAt+" < ?")
+		args = append(args, f.To.Time)
+	}
+
+	if len(f.Statuses) > 0 {
+		if s.status == "" {
+			// the donations without status are paid
+			if !containsString(f.Statuses, "paid") {
+				return "", nil, false
+			}
+		} else {
+			conditions = append(conditions, s.status+" IN (?)")
+			args = append(args, f.Statuses)
+		}
+	}
+
+	if f.CampaignID.Valid {
+		if s.campaignID == "" {
+			return "", nil, false
+		}
+		conditions = append(conditions, s.campaignID+" = ?")
+		args = append(args, f.CampaignID.Int64)
+	}
+
+	if len(f.PayMethods) > 0 {
+		conditions = append(conditions, s.payMethod+" IN (?)")
+		args = append(args, f.PayMethods)
+	}
+	if len(f.MerchantIDs) > 0 {
+		conditions = append(conditions, "d.merchant_id IN (?)")
+		args = append(args, f.MerchantIDs)
+	}
+	if len(f.SendReceipts) > 0 {
+		conditions = append(conditions, s.sendReceipt+" IN (?)")
+		args = append(args, f.SendReceipts)
+	}
+
+	return fmt.Sprintf("%s WHERE %s", s.selects, strings.Join(conditions, " AND ")), args, true
+}
+
+// ExportDonations reads the donations matching the filter ordered by when they are donated,
+// and passes them to fn one by one without loading all of them into memory.
+// Reading stops at the first error returned by fn.
+func (g *GormStorage) ExportDonations(f models.DonationExportFilter, fn func(models.DonationExportRow) error) error {
+	var queries []string
+	var args []interface{}
+	for _, s := range donationExportSources {
+		if len(f.Types) > 0 && !containsString(f.Types, s.donationType) {
+			continue
+		}
+
+		query, queryArgs, ok := donationExportQuery(s.source, f)
+		if !ok {
+			continue
+		}
+		queries = append(queries, query)
+		args = append(args, queryArgs...)
+	}
+
+	if len(queries) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("SELECT * FROM (%s) AS e ORDER BY e.donated_at, e.order_number", strings.Join(queries, " UNION ALL "))
+	rows, err := g.db.Raw(query, args...).Rows()
+	if err != nil {
+		return errors.Wrap(err, "can not query exported donations")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.DonationExportRow
+		if err = g.db.ScanRows(rows, &r); err != nil {
+			return errors.Wrap(err, "can not scan exported donation")
+		}
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "can not read exported donations")
+}
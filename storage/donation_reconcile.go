@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// GetStalePayingPrimeDonations gets the prime donations which are still paying since before,
+// except the offline payments which could still be paid before they expire
+func (g *GormStorage) GetStalePayingPrimeDonations(before time.Time, limit int) ([]models.PayByPrimeDonation, error) {
+	var donations []models.PayByPrimeDonation
+
+	err := g.db.Where("status = 'paying' AND created_at <= ? AND (payment_expires_at IS NULL OR payment_expires_at <= ?)", before, time.Now()).
+		Order("created_at").Limit(limit).Find(&donations).Error
+	if err != nil {
+		return donations, errors.Wrap(err, "can not get stale paying prime donations")
+	}
+
+	return donations, nil
+}
+
+// GetStalePayingTokenDonations gets the card token donations which are still paying since before
+func (g *GormStorage) GetStalePayingTokenDonations(before time.Time, limit int) ([]models.PayByCardTokenDonation, error) {
+	var donations []models.PayByCardTokenDonation
+
+	err := g.db.Where("status = 'paying' AND created_at <= ?", before).
+		Order("created_at").Limit(limit).Find(&donations).Error
+	if err != nil {
+		return donations, errors.Wrap(err, "can not get stale paying card token donations")
+	}
+
+	return donations, nil
+}
+
+// ReconcilePrimeDonation updates the prime donation by the record of tap pay if it is still paying.
+// It reports whether the donation is updated.
+func (g *GormStorage) ReconcilePrimeDonation(d models.PayByPrimeDonation) (bool, error) {
+	updates := g.db.Model(&models.PayByPrimeDonation{}).Where("id = ? AND status = 'paying'", d.ID).Updates(d)
+	if err := updates.Error; err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("can not reconcile prime donation(order: %s)", d.OrderNumber))
+	}
+
+	return updates.RowsAffected > 0, nil
+}
+
+// ReconcileTokenDonation updates the card token donation by the record of tap pay if it is still paying,
+// and the columns of its periodic donation if the periodic donation is left paying by the charge in a transaction.
+// It reports whether the card token donation is updated.
+func (g *GormStorage) ReconcileTokenDonation(td models.PayByCardTokenDonation, columns map[string]interface{}) (bool, error) {
+	tx := g.db.Begin()
+
+	updates := tx.Model(&models.PayByCardTokenDonation{}).Where("id = ? AND status = 'paying'", td.ID).Updates(td)
+	if err := updates.Error; err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not reconcile card token donation(order: %s)", td.OrderNumber))
+	}
+
+	if updates.RowsAffected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	err := tx.Model(&models.PeriodicDonation{}).Where("id = ? AND status = 'paying'", td.PeriodicID).UpdateColumns(columns).Error
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not update periodic donation(id: %d)", td.PeriodicID))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
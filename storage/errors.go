@@ -13,6 +13,12 @@ var ErrRecordNotFound = gorm.ErrRecordNotFound
 // ErrMgoNotFound record not found error when accessing MongoDB
 var ErrMgoNotFound = mgo.ErrNotFound
 
+// ErrLastLoginMethod happens when unlinking the only login method of a user
+var ErrLastLoginMethod = errors.New("the last login method could not be unlinked")
+
+// ErrRefundExceeded happens when the refunds of a donation exceed its amount
+var ErrRefundExceeded = errors.New("refund amount exceeds the refundable amount of the donation")
+
 func IsNotFound(err error) bool {
 	cause := errors.Cause(err)
 
@@ -45,3 +51,11 @@ func IsConflict(err error) bool {
 	}
 	return false
 }
+
+func IsLastLoginMethod(err error) bool {
+	return errors.Cause(err) == ErrLastLoginMethod
+}
+
+func IsRefundExceeded(err error) bool {
+	return errors.Cause(err) == ErrRefundExceeded
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// ClaimIdempotencyKey records the key for the request before it is processed.
+// If the key is already recorded and not expired, the key is not claimed and the recorded one is returned.
+func (g *GormStorage) ClaimIdempotencyKey(k *models.IdempotencyKey) (models.IdempotencyKey, bool, error) {
+	var existing models.IdempotencyKey
+
+	// the expired key could be reused
+	err := g.db.Where("user_id = ? AND route = ? AND idempotency_key = ? AND expires_at <= ?", k.UserID, k.Route, k.Key, time.Now()).
+		Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return existing, false, errors.Wrap(err, fmt.Sprintf("can not purge expired idempotency key(%s) of user(id: %d)", k.Key, k.UserID))
+	}
+
+	err = g.db.Create(k).Error
+	if err == nil {
+		return existing, true, nil
+	}
+
+	if !IsConflict(err) {
+		return existing, false, errors.Wrap(err, fmt.Sprintf("can not create idempotency key(%s) of user(id: %d)", k.Key, k.UserID))
+	}
+
+	err = g.db.Where("user_id = ? AND route = ? AND idempotency_key = ?", k.UserID, k.Route, k.Key).First(&existing).Error
+	if err != nil {
+		return existing, false, errors.Wrap(err, fmt.Sprintf("can not get idempotency key(%s) of user(id: %d)", k.Key, k.UserID))
+	}
+
+	return existing, false, nil
+}
+
+// CompleteIdempotencyKey records the response of the request sent with the key
+func (g *GormStorage) CompleteIdempotencyKey(id uint, statusCode int, response string) error {
+	err := g.db.Model(&models.IdempotencyKey{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"status_code": statusCode,
+		"response":    response,
+		"updated_at":  time.Now(),
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not complete idempotency key(id: %d)", id))
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes the key, so that the request could be sent with the key again
+func (g *GormStorage) ReleaseIdempotencyKey(id uint) error {
+	err := g.db.Where("id = ?", id).Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not release idempotency key(id: %d)", id))
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes the keys expired by t along with the recorded responses
+func (g *GormStorage) DeleteExpiredIdempotencyKeys(t time.Time) error {
+	err := g.db.Where("expires_at <= ?", t).Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not delete idempotency keys expired by %s", t))
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// GetOAuthAccountsOfUser gets the oauth accounts linked to the user
+func (gs *GormStorage) GetOAuthAccountsOfUser(userID uint) ([]models.OAuthAccount, error) {
+	var accounts []models.OAuthAccount
+
+	err := gs.db.Where("user_id = ?", userID).Order("created_at").Find(&accounts).Error
+	if err != nil {
+		return accounts, errors.Wrap(err, fmt.Sprintf("can not get oauth accounts of user(id: %d)", userID))
+	}
+
+	return accounts, nil
+}
+
+// GetReporterAccountOfUser gets the reporter account linked to the user
+func (gs *GormStorage) GetReporterAccountOfUser(userID uint) (models.ReporterAccount, error) {
+	var ra models.ReporterAccount
+
+	err := gs.db.Where("user_id = ?", userID).First(&ra).Error
+	if err != nil {
+		return ra, errors.Wrap(err, fmt.Sprintf("can not get reporter account of user(id: %d)", userID))
+	}
+
+	return ra, nil
+}
+
+// CreateIdentityAuditLog creates an audit log of the login methods
+func (gs *GormStorage) CreateIdentityAuditLog(log models.IdentityAuditLog) error {
+	err := gs.db.Create(&log).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not create identity audit log(user_id: %d)", log.UserID))
+	}
+
+	return nil
+}
+
+// LinkOAuthAccount links the oauth account to the user and keeps the audit log
+func (gs *GormStorage) LinkOAuthAccount(account models.OAuthAccount, log models.IdentityAuditLog) error {
+	tx := gs.db.Begin()
+
+	if err := tx.Create(&account).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not link oauth account to user(id: %d)", account.UserID))
+	}
+
+	if err := tx.Create(&log).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create identity audit log(user_id: %d)", log.UserID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// LinkReporterAccount links the email sign-in to the user,
+// removes the pending link request and keeps the audit log
+func (gs *GormStorage) LinkReporterAccount(ra models.ReporterAccount, log models.IdentityAuditLog) error {
+	tx := gs.db.Begin()
+
+	if err := tx.Create(&ra).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not link reporter account to user(id: %d)", ra.UserID))
+	}
+
+	if err := tx.Where("user_id = ?", ra.UserID).Delete(&models.EmailLinkRequest{}).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not delete email link request(user_id: %d)", ra.UserID))
+	}
+
+	if err := tx.Create(&log).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create identity audit log(user_id: %d)", log.UserID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// UnlinkIdentity removes the login methods of the provider from the user and keeps the audit log.
+// ErrLastLoginMethod is returned if no login method would remain.
+func (gs *GormStorage) UnlinkIdentity(userID uint, provider string, log models.IdentityAuditLog) error {
+	var oauthCount, targetCount, reporterCount int
+
+	tx := gs.db.Begin()
+
+	// lock the user to serialize the concurrent unlinks
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", userID).First(&models.User{}).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not get user(id: %d)", userID))
+	}
+
+	if err := tx.Model(&models.OAuthAccount{}).Where("user_id = ?", userID).Count(&oauthCount).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not count oauth accounts of user(id: %d)", userID))
+	}
+
+	if err := tx.Model(&models.ReporterAccount{}).Where("user_id = ?", userID).Count(&reporterCount).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not count reporter accounts of user(id: %d)", userID))
+	}
+
+	if provider == models.IdentityProviderEmail {
+		targetCount = reporterCount
+	} else if err := tx.Model(&models.OAuthAccount{}).Where("user_id = ? AND type = ?", userID, provider).Count(&targetCount).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not count oauth accounts of user(id: %d)", userID))
+	}
+
+	if targetCount == 0 {
+		tx.Rollback()
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("%s is not linked to user(id: %d)", provider, userID))
+	}
+
+	if oauthCount+reporterCount-targetCount == 0 {
+		tx.Rollback()
+		return errors.Wrap(ErrLastLoginMethod, fmt.Sprintf("can not unlink %s from user(id: %d)", provider, userID))
+	}
+
+	// hard delete, so that the identity could be linked again
+	var err error
+	if provider == models.IdentityProviderEmail {
+		err = tx.Unscoped().Where("user_id = ?", userID).Delete(&models.ReporterAccount{}).Error
+	} else {
+		err = tx.Unscoped().Where("user_id = ? AND type = ?", userID, provider).Delete(&models.OAuthAccount{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not unlink %s from user(id: %d)", provider, userID))
+	}
+
+	if err := tx.Create(&log).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create identity audit log(user_id: %d)", log.UserID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// CreateEmailLinkRequest creates the pending email link request of the user.
+// The previous request of the user is replaced.
+func (gs *GormStorage) CreateEmailLinkRequest(req models.EmailLinkRequest) error {
+	tx := gs.db.Begin()
+
+	if err := tx.Where("user_id = ?", req.UserID).Delete(&models.EmailLinkRequest{}).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not delete email link request(user_id: %d)", req.UserID))
+	}
+
+	if err := tx.Create(&req).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create email link request(user_id: %d)", req.UserID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// GetEmailLinkRequest gets the pending email link request of the user
+func (gs *GormStorage) GetEmailLinkRequest(userID uint) (models.EmailLinkRequest, error) {
+	var req models.EmailLinkRequest
+
+	err := gs.db.Where("user_id = ?", userID).First(&req).Error
+	if err != nil {
+		return req, errors.Wrap(err, fmt.Sprintf("can not get email link request(user_id: %d)", userID))
+	}
+
+	return req, nil
+}
+
+// IncreaseFailedAttemptsOfEmailLinkRequest increases the failed otp attempts of the pending email link request.
+// The request is removed once the attempts reach maxAttempts.
+func (gs *GormStorage) IncreaseFailedAttemptsOfEmailLinkRequest(userID uint, maxAttempts int) error {
+	err := gs.db.Model(&models.EmailLinkRequest{}).Where("user_id = ?", userID).UpdateColumn("failed_attempts", gorm.Expr("failed_attempts + ?", 1)).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not increase failed attempts of email link request(user_id: %d)", userID))
+	}
+
+	if maxAttempts <= 0 {
+		return nil
+	}
+
+	err = gs.db.Where("user_id = ? AND failed_attempts >= ?", userID, maxAttempts).Delete(&models.EmailLinkRequest{}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not delete email link request(user_id: %d)", userID))
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
@@ -37,6 +38,9 @@ type MembershipStorage interface {
 	InsertUserByReporterAccount(models.ReporterAccount) (models.User, error)
 	UpdateOAuthData(models.OAuthAccount) (models.OAuthAccount, error)
 	UpdateReporterAccount(models.ReporterAccount) error
+	ConsumeOtpCodeOfReporterAccount(string, string) error
+	IncreaseFailedAttemptsOfReporterAccount(uint, int, time.Time) (models.ReporterAccount, error)
+	ResetFailedAttemptsOfReporterAccount(uint) error
 	UpdateReadPreferenceOfUser(string, []string) error
 	UpdateUser(models.User) error
 	AssignRoleToUser(models.User, string) error
@@ -45,6 +49,49 @@ type MembershipStorage interface {
 	IsTrailblazer(email string) (bool, error)
 	IsPeriodicPatron(string) (bool, error)
 
+	/** Token methods **/
+	CreateRefreshToken(*models.RefreshToken) error
+	GetRefreshTokenByHash(string) (models.RefreshToken, error)
+	RotateRefreshToken(uint, *models.RefreshToken) error
+	RevokeRefreshTokenFamily(string) error
+	RevokeTokensOfUser(uint) error
+	IsTokenRevoked(string) (bool, error)
+	DeleteExpiredTokens(time.Time) error
+
+	/** Session methods **/
+	CreateUserSession(*models.UserSession) error
+	GetActiveUserSessions(uint, time.Time) ([]models.UserSession, error)
+	TouchUserSession(string, time.Time) error
+	RevokeUserSession(uint, uint) error
+	RevokeUserSessionByJTI(string) error
+
+	/** Identity methods **/
+	GetOAuthAccountsOfUser(uint) ([]models.OAuthAccount, error)
+	GetReporterAccountOfUser(uint) (models.ReporterAccount, error)
+	CreateIdentityAuditLog(models.IdentityAuditLog) error
+	LinkOAuthAccount(models.OAuthAccount, models.IdentityAuditLog) error
+	LinkReporterAccount(models.ReporterAccount, models.IdentityAuditLog) error
+	UnlinkIdentity(uint, string, models.IdentityAuditLog) error
+	CreateEmailLinkRequest(models.EmailLinkRequest) error
+	GetEmailLinkRequest(uint) (models.EmailLinkRequest, error)
+	IncreaseFailedAttemptsOfEmailLinkRequest(uint, int) error
+
+	/** Account deletion methods **/
+	CreateAccountDeletionRequest(models.AccountDeletionRequest) error
+	GetAccountDeletionRequest(uint) (models.AccountDeletionRequest, error)
+	ConfirmAccountDeletionRequest(uint, string, time.Time) error
+	CancelAccountDeletionRequest(uint) error
+	GetDueAccountDeletionRequests(time.Time, int) ([]models.AccountDeletionRequest, error)
+	EraseAccount(uint) (models.User, error)
+	EraseUploadedTaxDeductionData(time.Time) error
+
+	/** Data export methods **/
+	StartUserDataExport(uint, time.Time) (bool, error)
+	GetUserDataExport(uint) (models.UserDataExport, error)
+	CompleteUserDataExport(uint, []byte, time.Time) error
+	FailUserDataExport(uint) error
+	ClearExpiredUserDataExports(time.Time) error
+
 	/** Bookmark methods **/
 	GetABookmarkBySlug(string) (models.Bookmark, error)
 	GetABookmarkByID(string) (models.Bookmark, error)
@@ -56,6 +103,7 @@ type MembershipStorage interface {
 	/** Web Push Subscription methods **/
 	CreateAWebPushSubscription(models.WebPushSubscription) error
 	GetAWebPushSubscription(uint32, string) (models.WebPushSubscription, error)
+	GetWebPushSubscriptionsOfAUser(uint) ([]models.WebPushSubscription, error)
 
 	/** Donation methods **/
 	CreateAPeriodicDonation(*models.PeriodicDonation, *models.PayByCardTokenDonation) error
@@ -64,7 +112,56 @@ type MembershipStorage interface {
 	GetDonationsOfAUser(string, int, int) ([]models.GeneralDonation, int, error)
 	GetDonationsOfAUserFromMemberCMS(string, int, int, bool) ([]models.GeneralDonation, int, error)
 	GetPaymentsOfAPeriodicDonation(uint, int, int) ([]models.Payment, int, error)
-	GenerateReceiptSerialNumber(uint, null.Time) (string, error)
+	GetReceiptItemsOfAUser(uint, time.Time, time.Time) ([]models.ReceiptItem, error)
+	GetDonationAmountsOfAUser(uint) ([]models.DonationAmount, error)
+	GetPeriodicDonationsOfAUser(uint) ([]models.PeriodicDonationOverview, error)
+	ExportDonations(models.DonationExportFilter, func(models.DonationExportRow) error) error
+	GetTaxDeductionDonations(time.Time, time.Time) ([]models.TaxDeductionDonation, error)
+
+	/** Receipt number methods **/
+	IssueReceiptNumber(string, uint, string) (string, error)
+	VoidReceiptNumber(string, uint, null.String, null.Int) (string, error)
+	ReissueReceiptNumber(string, uint, string, null.String, null.Int) (string, string, error)
+
+	/** Periodic charge methods **/
+	GetDuePeriodicDonations(time.Time, int) ([]models.PeriodicDonation, error)
+	ClaimPeriodicDonationCharge(*models.PayByCardTokenDonation) error
+	UpdatePeriodicDonationCharge(models.PayByCardTokenDonation, map[string]interface{}) error
+
+	/** Periodic donation self-service methods **/
+	ChangePeriodicDonationInTRX(*models.PeriodicDonationHistory, map[string]interface{}) error
+	GetHistoriesOfAPeriodicDonation(uint, int, int) ([]models.PeriodicDonationHistory, int, error)
+
+	/** Periodic donation card methods **/
+	ClaimPeriodicDonationCardCharge(*models.PayByCardTokenDonation) error
+	ReplacePeriodicDonationCardInTRX(*models.PeriodicDonationHistory, string, map[string]interface{}, *models.PayByCardTokenDonation) error
+	GetPeriodicDonationsWithExpiringCards(string, int) ([]models.PeriodicDonation, error)
+	MarkCardExpiryReminded(uint, string) error
+
+	/** Refund methods **/
+	CreateDonationRefund(*models.DonationRefund, uint) error
+	CompleteDonationRefund(*models.DonationRefund, uint) (bool, error)
+	UpdateDonationRefund(models.DonationRefund) error
+	GetRefundedAmountOfADonation(string, uint) (uint, error)
+
+	/** Idempotency key methods **/
+	ClaimIdempotencyKey(*models.IdempotencyKey) (models.IdempotencyKey, bool, error)
+	CompleteIdempotencyKey(uint, int, string) error
+	ReleaseIdempotencyKey(uint) error
+	DeleteExpiredIdempotencyKeys(time.Time) error
+
+	/** Donation reconcile methods **/
+	GetStalePayingPrimeDonations(time.Time, int) ([]models.PayByPrimeDonation, error)
+	GetStalePayingTokenDonations(time.Time, int) ([]models.PayByCardTokenDonation, error)
+	ReconcilePrimeDonation(models.PayByPrimeDonation) (bool, error)
+	ReconcileTokenDonation(models.PayByCardTokenDonation, map[string]interface{}) (bool, error)
+
+	/** Tap pay notification methods **/
+	CreateTapPayNotification(*models.TapPayNotification) error
+
+	/** Donation campaign methods **/
+	GetCampaignBySlug(string) (models.DonationCampaign, error)
+	GetCampaignProgress(uint) (models.CampaignProgress, error)
 }
 
 // NewGormStorage initializes the storage connected to MySQL database by gorm library
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// ClaimPeriodicDonationCardCharge marks the failed or invalid periodic donation as paying,
+// and creates the draft card token donation charged by the new card of the donor in a transaction.
+// A not found error is returned if the donation is neither failed nor invalid.
+func (g *GormStorage) ClaimPeriodicDonationCardCharge(td *models.PayByCardTokenDonation) error {
+	return g.claimPeriodicDonation(td, "fail", "invalid")
+}
+
+// ReplacePeriodicDonationCardInTRX swaps the card token and the card info in columns of the periodic donation in status,
+// updates the card token donation charged by the new card if any, and records the change in the history in a transaction.
+// A not found error is returned if the donation is not in status.
+func (g *GormStorage) ReplacePeriodicDonationCardInTRX(h *models.PeriodicDonationHistory, status string, columns map[string]interface{}, td *models.PayByCardTokenDonation) error {
+	tx := g.db.Begin()
+
+	updates := tx.Model(&models.PeriodicDonation{}).
+		Where("id = ? AND status = ?", h.PeriodicID, status).
+		UpdateColumns(columns)
+	if err := updates.Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not replace card of periodic donation(id: %d)", h.PeriodicID))
+	}
+
+	if updates.RowsAffected == 0 {
+		tx.Rollback()
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("%s periodic donation(id: %d) is not found", status, h.PeriodicID))
+	}
+
+	if td != nil {
+		if err := tx.Model(&models.PayByCardTokenDonation{}).Where("id = ?", td.ID).Updates(*td).Error; err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("can not update card token donation(order: %s)", td.OrderNumber))
+		}
+	}
+
+	if err := tx.Create(h).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create history of periodic donation(id: %d)", h.PeriodicID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// GetPeriodicDonationsWithExpiringCards gets the active periodic donations charged by the cards expiring at expiryDate(YYYYMM),
+// whose donors are not reminded of the expiry yet
+func (g *GormStorage) GetPeriodicDonationsWithExpiringCards(expiryDate string, limit int) ([]models.PeriodicDonation, error) {
+	var donations []models.PeriodicDonation
+
+	err := g.db.
+		Where("status IN ('paid', 'fail') AND card_token IS NOT NULL AND card_token <> ''").
+		Where("card_info_expiry_date = ? AND card_expiry_reminded <> card_info_expiry_date", expiryDate).
+		Order("id").Limit(limit).Find(&donations).Error
+	if err != nil {
+		return donations, errors.Wrap(err, fmt.Sprintf("can not get periodic donations with cards expiring at %s", expiryDate))
+	}
+
+	return donations, nil
+}
+
+// MarkCardExpiryReminded records the donor of the periodic donation is reminded of the card expiring at expiryDate
+func (g *GormStorage) MarkCardExpiryReminded(periodicID uint, expiryDate string) error {
+	err := g.db.Model(&models.PeriodicDonation{}).Where("id = ?", periodicID).UpdateColumns(map[string]interface{}{
+		"card_expiry_reminded": expiryDate,
+		"updated_at":           time.Now(),
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not mark card expiry reminded of periodic donation(id: %d)", periodicID))
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// GetDuePeriodicDonations gets the periodic donations to charge at t.
+// A paid donation is due a month or a year after the last successful charge by its frequency,
+// and a failed donation is due at its retry time.
+// The donations which have been charged MaxPaidTimes or are paused by the donors are skipped.
+func (g *GormStorage) GetDuePeriodicDonations(t time.Time, limit int) ([]models.PeriodicDonation, error) {
+	var donations []models.PeriodicDonation
+
+	err := g.db.
+		Where("card_token IS NOT NULL AND card_token <> ''").
+		Where("paused_until IS NULL OR paused_until <= ?", t).
+		Where("(status = 'paid' AND charge_failures = 0 AND "+
+			"((frequency = 'monthly' AND last_success_at <= DATE_SUB(?, INTERVAL 1 MONTH)) OR "+
+			"(frequency = 'yearly' AND last_success_at <= DATE_SUB(?, INTERVAL 1 YEAR)))) OR "+
+			"(status = 'fail' AND charge_failures > 0 AND next_retry_at <= ?)", t, t, t).
+		Where("(SELECT COUNT(*) FROM pay_by_card_token_donations WHERE periodic_id = periodic_donations.id AND status = 'paid') < max_paid_times").
+		Order("last_success_at").Limit(limit).Find(&donations).Error
+	if err != nil {
+		return donations, errors.Wrap(err, "can not get due periodic donations")
+	}
+
+	return donations, nil
+}
+
+// ClaimPeriodicDonationCharge marks the due periodic donation as paying,
+// and creates the draft card token donation of the charge in a transaction.
+// A not found error is returned if the donation is claimed by others or is not chargeable.
+func (g *GormStorage) ClaimPeriodicDonationCharge(td *models.PayByCardTokenDonation) error {
+	return g.claimPeriodicDonation(td, "paid", "fail")
+}
+
+// claimPeriodicDonation marks the periodic donation in one of the statuses as paying,
+// and creates the draft card token donation of the charge in a transaction
+func (g *GormStorage) claimPeriodicDonation(td *models.PayByCardTokenDonation, statuses ...string) error {
+	tx := g.db.Begin()
+
+	updates := tx.Model(&models.PeriodicDonation{}).
+		Where("id = ? AND status IN (?)", td.PeriodicID, statuses).
+		UpdateColumns(map[string]interface{}{
+			"status":     "paying",
+			"updated_at": time.Now(),
+		})
+	if err := updates.Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not claim periodic donation(id: %d)", td.PeriodicID))
+	}
+
+	if updates.RowsAffected == 0 {
+		tx.Rollback()
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("chargeable periodic donation(id: %d) is not found", td.PeriodicID))
+	}
+
+	if err := tx.Create(td).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create card token donation(order: %s)", td.OrderNumber))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// UpdatePeriodicDonationCharge updates the card token donation of the charge,
+// and the columns of its periodic donation in a transaction
+func (g *GormStorage) UpdatePeriodicDonationCharge(td models.PayByCardTokenDonation, columns map[string]interface{}) error {
+	tx := g.db.Begin()
+
+	if err := tx.Model(&models.PayByCardTokenDonation{}).Where("id = ?", td.ID).Updates(td).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not update card token donation(order: %s)", td.OrderNumber))
+	}
+
+	if err := tx.Model(&models.PeriodicDonation{}).Where("id = ?", td.PeriodicID).UpdateColumns(columns).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not update periodic donation(id: %d)", td.PeriodicID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
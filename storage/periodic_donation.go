@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// ChangePeriodicDonationInTRX updates the columns of the active periodic donation,
+// and records the change in the history in a transaction.
+// A not found error is returned if the donation is not active, i.e. it is being charged, stopped or invalid.
+func (g *GormStorage) ChangePeriodicDonationInTRX(h *models.PeriodicDonationHistory, columns map[string]interface{}) error {
+	tx := g.db.Begin()
+
+	updates := tx.Model(&models.PeriodicDonation{}).
+		Where("id = ? AND status IN ('paid', 'fail')", h.PeriodicID).
+		UpdateColumns(columns)
+	if err := updates.Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not update periodic donation(id: %d)", h.PeriodicID))
+	}
+
+	if updates.RowsAffected == 0 {
+		tx.Rollback()
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("active periodic donation(id: %d) is not found", h.PeriodicID))
+	}
+
+	if err := tx.Create(h).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create history of periodic donation(id: %d)", h.PeriodicID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// GetHistoriesOfAPeriodicDonation gets the changes of the periodic donation, the latest first
+func (g *GormStorage) GetHistoriesOfAPeriodicDonation(periodicID uint, limit, offset int) ([]models.PeriodicDonationHistory, int, error) {
+	var histories []models.PeriodicDonationHistory
+	var total int
+
+	query := g.db.Model(&models.PeriodicDonationHistory{}).Where("periodic_id = ?", periodicID)
+
+	if err := query.Count(&total).Error; err != nil {
+		return histories, 0, errors.Wrap(err, fmt.Sprintf("can not count histories of periodic donation(id: %d)", periodicID))
+	}
+
+	if err := query.Order("created_at desc, id desc").Limit(limit).Offset(offset).Find(&histories).Error; err != nil {
+		return histories, 0, errors.Wrap(err, fmt.Sprintf("can not get histories of periodic donation(id: %d)", periodicID))
+	}
+
+	return histories, total, nil
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+)
+
+const (
+	receiptNumberActionIssue = "issue"
+	receiptNumberActionVoid  = "void"
+)
+
+// receiptNumberedTables are the tables of the donations numbered with receipts by donation type
+var receiptNumberedTables = map[string]string{
+	"prime": "pay_by_prime_donations",
+	"token": "pay_by_card_token_donations",
+}
+
+// numberedDonation is the donation locked to issue or void its receipt number
+type numberedDonation struct {
+	ID              uint
+	ReceiptNumber   null.String
+	TransactionTime null.Time
+}
+
+// inReceiptNumberTRX locks the donation and runs fn in a transaction,
+// so that the receipt number of the donation is changed by one at a time
+func (g *GormStorage) inReceiptNumberTRX(donationType string, donationID uint, fn func(*gorm.DB, numberedDonation) error) error {
+	table, ok := receiptNumberedTables[donationType]
+	if !ok {
+		return errors.New(fmt.Sprintf("donation type(%s) is not numbered with receipts", donationType))
+	}
+
+	tx := g.db.Begin()
+
+	var d numberedDonation
+	if err := tx.Raw(fmt.Sprintf("SELECT id, receipt_number, transaction_time FROM `%s` WHERE id = ? FOR UPDATE", table), donationID).Scan(&d).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not lock %s donation(id: %d)", donationType, donationID))
+	}
+
+	if err := fn(tx, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// issueReceiptNumber takes the next serial number of the series of prefix in the month of the transaction,
+// and numbers the donation with it.
+// The counter row stays locked until the transaction ends, and the serial number taken is given back
+// if the transaction is rolled back, so that the numbers of a series are neither duplicated nor skipped.
+func issueReceiptNumber(tx *gorm.DB, donationType string, d numberedDonation, prefix string) (string, error) {
+	if !d.TransactionTime.Valid {
+		return "", errors.New(fmt.Sprintf("transaction time of %s donation(id: %d) should not be nil", donationType, d.ID))
+	}
+	if prefix == "" {
+		return "", errors.New("prefix of the receipt number should not be empty")
+	}
+
+	tz, err := time.LoadLocation(timezoneTPE)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	month := d.TransactionTime.Time.In(tz).Format(YYYYMM)
+
+	// LAST_INSERT_ID(expr) keeps the serial number taken for the connection of the transaction
+	const upsert = "INSERT INTO `receipt_serial_numbers` (`prefix`, `YYYYMM`, `serial_number`) VALUES (?, ?, LAST_INSERT_ID(1)) " +
+		"ON DUPLICATE KEY UPDATE `serial_number` = LAST_INSERT_ID(`serial_number` + 1), `updated_at` = CURRENT_TIMESTAMP"
+	if err = tx.Exec(upsert, prefix, month).Error; err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("can not count receipt serial number. prefix: %s, month: %s", prefix, month))
+	}
+
+	var serial struct{ SerialNumber int }
+	if err = tx.Raw("SELECT LAST_INSERT_ID() AS serial_number").Scan(&serial).Error; err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("can not get receipt serial number. prefix: %s, month: %s", prefix, month))
+	}
+
+	receiptNumber := fmt.Sprintf("%s%s-%05d", prefix, month, serial.SerialNumber)
+
+	if err = tx.Table(receiptNumberedTables[donationType]).Where("id = ?", d.ID).UpdateColumn("receipt_number", receiptNumber).Error; err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("update receipt number failed. %s donation(id: %d), receipt number: %s", donationType, d.ID, receiptNumber))
+	}
+
+	l := models.ReceiptNumberLog{
+		ReceiptNumber: receiptNumber,
+		DonationType:  donationType,
+		DonationID:    d.ID,
+		Action:        receiptNumberActionIssue,
+	}
+	if err = tx.Create(&l).Error; err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("can not log receipt number(%s) issued", receiptNumber))
+	}
+
+	return receiptNumber, nil
+}
+
+// voidReceiptNumber removes the receipt number from the donation and logs why it is voided
+func voidReceiptNumber(tx *gorm.DB, donationType string, d numberedDonation, reason null.String, operatorID null.Int) error {
+	if err := tx.Table(receiptNumberedTables[donationType]).Where("id = ?", d.ID).UpdateColumn("receipt_number", nil).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not void receipt number of %s donation(id: %d)", donationType, d.ID))
+	}
+
+	l := models.ReceiptNumberLog{
+		ReceiptNumber: d.ReceiptNumber.String,
+		DonationType:  donationType,
+		DonationID:    d.ID,
+		Action:        receiptNumberActionVoid,
+		Reason:        reason,
+		OperatorID:    operatorID,
+	}
+	if err := tx.Create(&l).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not log receipt number(%s) voided", d.ReceiptNumber.String))
+	}
+
+	return nil
+}
+
+// IssueReceiptNumber numbers the donation in the series of prefix, and returns the receipt number.
+// The number of the donation numbered already is returned as it is.
+func (g *GormStorage) IssueReceiptNumber(donationType string, donationID uint, prefix string) (string, error) {
+	var receiptNumber string
+
+	err := g.inReceiptNumberTRX(donationType, donationID, func(tx *gorm.DB, d numberedDonation) error {
+		if d.ReceiptNumber.Valid {
+			receiptNumber = d.ReceiptNumber.String
+			return nil
+		}
+
+		var err error
+		receiptNumber, err = issueReceiptNumber(tx, donationType, d, prefix)
+		return err
+	})
+
+	return receiptNumber, err
+}
+
+// VoidReceiptNumber voids the receipt number of the donation, and returns the voided number.
+// An empty string is returned if the donation has not been numbered.
+func (g *GormStorage) VoidReceiptNumber(donationType string, donationID uint, reason null.String, operatorID null.Int) (string, error) {
+	var voided string
+
+	err := g.inReceiptNumberTRX(donationType, donationID, func(tx *gorm.DB, d numberedDonation) error {
+		if !d.ReceiptNumber.Valid {
+			return nil
+		}
+
+		voided = d.ReceiptNumber.String
+		return voidReceiptNumber(tx, donationType, d, reason, operatorID)
+	})
+
+	return voided, err
+}
+
+// ReissueReceiptNumber voids the receipt number of the donation and numbers it again in the series of prefix,
+// and returns both the voided and the reissued numbers
+func (g *GormStorage) ReissueReceiptNumber(donationType string, donationID uint, prefix string, reason null.String, operatorID null.Int) (string, string, error) {
+	var voided, reissued string
+
+	err := g.inReceiptNumberTRX(donationType, donationID, func(tx *gorm.DB, d numberedDonation) error {
+		if d.ReceiptNumber.Valid {
+			voided = d.ReceiptNumber.String
+			if err := voidReceiptNumber(tx, donationType, d, reason, operatorID); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		reissued, err = issueReceiptNumber(tx, donationType, d, prefix)
+		return err
+	})
+
+	return voided, reissued, err
+}
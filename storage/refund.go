@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// refundedDonationTables are the tables of the donations refunded by donation type
+var refundedDonationTables = map[string]string{
+	"prime": "pay_by_prime_donations",
+	"token": "pay_by_card_token_donations",
+}
+
+// refundedAmount sums the amounts of the refunds of the donation which are not failed
+func refundedAmount(tx *gorm.DB, donationType string, donationID uint) (uint, error) {
+	var amount uint
+
+	row := tx.Model(&models.DonationRefund{}).
+		Where("donation_type = ? AND donation_id = ? AND status IN ('refunding', 'refunded')", donationType, donationID).
+		Select("COALESCE(SUM(amount), 0)").Row()
+	if err := row.Scan(&amount); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("can not sum refunds of %s donation(id: %d)", donationType, donationID))
+	}
+
+	return amount, nil
+}
+
+// CreateDonationRefund creates the refund of the donation in a transaction.
+// The refund of zero amount refunds the rest of the donation.
+// ErrRefundExceeded is returned if the refunds exceed donationAmount.
+func (g *GormStorage) CreateDonationRefund(r *models.DonationRefund, donationAmount uint) error {
+	table, ok := refundedDonationTables[r.DonationType]
+	if !ok {
+		return errors.New(fmt.Sprintf("donation type(%s) is not refundable", r.DonationType))
+	}
+
+	tx := g.db.Begin()
+
+	// lock the donation, so that the concurrent refunds of it are serialised
+	var locked struct{ ID uint }
+	if err := tx.Raw(fmt.Sprintf("SELECT id FROM `%s` WHERE id = ? FOR UPDATE", table), r.DonationID).Scan(&locked).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not lock %s donation(id: %d)", r.DonationType, r.DonationID))
+	}
+
+	refunded, err := refundedAmount(tx, r.DonationType, r.DonationID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if r.Amount == 0 {
+		r.Amount = donationAmount - refunded
+	}
+
+	if r.Amount == 0 || refunded+r.Amount > donationAmount {
+		tx.Rollback()
+		return errors.Wrap(ErrRefundExceeded, fmt.Sprintf("%d of %s donation(id: %d) is refunded already", refunded, r.DonationType, r.DonationID))
+	}
+
+	if err = tx.Create(r).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create refund of %s donation(id: %d)", r.DonationType, r.DonationID))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// CompleteDonationRefund marks the refund as refunded in a transaction,
+// and reports whether the donation of donationAmount is fully refunded.
+// The fully refunded donation is marked as refunded.
+func (g *GormStorage) CompleteDonationRefund(r *models.DonationRefund, donationAmount uint) (bool, error) {
+	table := refundedDonationTables[r.DonationType]
+
+	tx := g.db.Begin()
+
+	r.Status = "refunded"
+	if err := tx.Model(&models.DonationRefund{}).Where("id = ?", r.ID).Updates(*r).Error; err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, fmt.Sprintf("can not update refund(id: %d)", r.ID))
+	}
+
+	refunded, err := refundedAmount(tx, r.DonationType, r.DonationID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	fully := refunded >= donationAmount
+	if fully {
+		columns := map[string]interface{}{
+			"status":     "refunded",
+			"updated_at": time.Now(),
+		}
+
+		if err = tx.Table(table).Where("id = ?", r.DonationID).UpdateColumns(columns).Error; err != nil {
+			tx.Rollback()
+			return false, errors.Wrap(err, fmt.Sprintf("can not mark %s donation(id: %d) as refunded", r.DonationType, r.DonationID))
+		}
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return fully, nil
+}
+
+// GetRefundedAmountOfADonation sums the amounts of the completed refunds of the donation
+func (g *GormStorage) GetRefundedAmountOfADonation(donationType string, donationID uint) (uint, error) {
+	var amount uint
+
+	row := g.db.Model(&models.DonationRefund{}).
+		Where("donation_type = ? AND donation_id = ? AND status = 'refunded'", donationType, donationID).
+		Select("COALESCE(SUM(amount), 0)").Row()
+	if err := row.Scan(&amount); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("can not sum refunded amount of %s donation(id: %d)", donationType, donationID))
+	}
+
+	return amount, nil
+}
+
+// UpdateDonationRefund updates the non-zero fields of the refund
+func (g *GormStorage) UpdateDonationRefund(r models.DonationRefund) error {
+	if err := g.db.Model(&models.DonationRefund{}).Where("id = ?", r.ID).Updates(r).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not update refund(id: %d)", r.ID))
+	}
+
+	return nil
+}
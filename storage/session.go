@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// CreateUserSession creates the session of the id token
+func (gs *GormStorage) CreateUserSession(s *models.UserSession) error {
+	err := gs.db.Create(s).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not create session of user(id: %d)", s.UserID))
+	}
+
+	return nil
+}
+
+// GetActiveUserSessions gets the sessions of the user which are neither revoked nor expired at t.
+// The recently seen sessions come first.
+func (gs *GormStorage) GetActiveUserSessions(userID uint, t time.Time) ([]models.UserSession, error) {
+	var sessions []models.UserSession
+
+	err := gs.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, t).
+		Order("last_seen_at desc").Find(&sessions).Error
+	if err != nil {
+		return sessions, errors.Wrap(err, fmt.Sprintf("can not get sessions of user(id: %d)", userID))
+	}
+
+	return sessions, nil
+}
+
+// TouchUserSession updates the last seen time of the active session
+func (gs *GormStorage) TouchUserSession(jti string, t time.Time) error {
+	err := gs.db.Model(&models.UserSession{}).
+		Where("jti = ? AND revoked_at IS NULL", jti).
+		UpdateColumns(map[string]interface{}{
+			"last_seen_at": t,
+			"updated_at":   t,
+		}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not update last seen time of session(jti: %s)", jti))
+	}
+
+	return nil
+}
+
+// RevokeUserSession revokes the active session of the user,
+// and the refresh tokens and the access tokens dispatched for it.
+// A not found error is returned if no such session is active.
+func (gs *GormStorage) RevokeUserSession(userID uint, sessionID uint) error {
+	var s models.UserSession
+
+	tx := gs.db.Begin()
+
+	err := tx.Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, time.Now()).First(&s).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not get active session(id: %d) of user(id: %d)", sessionID, userID))
+	}
+
+	if err = revokeSessionInTRX(tx, s.JTI); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not revoke session(id: %d)", sessionID))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// RevokeUserSessionByJTI revokes the session of the id token,
+// and the refresh tokens and the access tokens dispatched for it
+func (gs *GormStorage) RevokeUserSessionByJTI(jti string) error {
+	tx := gs.db.Begin()
+
+	if err := revokeSessionInTRX(tx, jti); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not revoke session(jti: %s)", jti))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func revokeSessionInTRX(tx *gorm.DB, jti string) error {
+	if err := revokeSessions(tx, "jti = ?", jti); err != nil {
+		return err
+	}
+
+	return revokeRefreshTokens(tx, "session_jti = ?", jti)
+}
+
+// revokeSessions revokes the sessions matching the condition in the transaction.
+// The jti of the unexpired ones are added to revoked_tokens, so that their id tokens are rejected.
+func revokeSessions(tx *gorm.DB, cond string, value interface{}) error {
+	now := time.Now()
+
+	err := tx.Exec(fmt.Sprintf("INSERT IGNORE INTO revoked_tokens (jti, created_at, user_id, expires_at) "+
+		"SELECT jti, ?, user_id, expires_at FROM user_sessions WHERE %s AND revoked_at IS NULL AND expires_at > ?", cond),
+		now, value, now).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.UserSession{}).Where(cond+" AND revoked_at IS NULL", value).UpdateColumn("revoked_at", now).Error
+}
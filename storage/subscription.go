@@ -30,3 +30,14 @@ func (g *GormStorage) GetAWebPushSubscription(crc32Endpoint uint32, endpoint str
 
 	return wpSub, nil
 }
+
+// GetWebPushSubscriptionsOfAUser - read the records of the user from persistent database
+func (g *GormStorage) GetWebPushSubscriptionsOfAUser(userID uint) ([]models.WebPushSubscription, error) {
+	var wpSubs []models.WebPushSubscription
+
+	if err := g.db.Where("user_id = ?", userID).Order("created_at").Find(&wpSubs).Error; err != nil {
+		return wpSubs, errors.Wrap(err, fmt.Sprintf("getting web push subscriptions of user(id: %d) occurs error", userID))
+	}
+
+	return wpSubs, nil
+}
@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/models"
+)
+
+// CreateTapPayNotification keeps the raw callback of the notification sent by tap pay
+func (g *GormStorage) CreateTapPayNotification(n *models.TapPayNotification) error {
+	if err := g.db.Create(n).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not create tap pay notification(order: %s)", n.OrderNumber))
+	}
+
+	return nil
+}
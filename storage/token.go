@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+// CreateRefreshToken creates a refresh token
+func (gs *GormStorage) CreateRefreshToken(rt *models.RefreshToken) error {
+	err := gs.db.Create(rt).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not create refresh token(family: %s)", rt.FamilyID))
+	}
+
+	return nil
+}
+
+// GetRefreshTokenByHash gets the refresh token by its hash
+func (gs *GormStorage) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	var rt models.RefreshToken
+
+	err := gs.db.Where("token_hash = ?", tokenHash).First(&rt).Error
+	if err != nil {
+		return rt, errors.Wrap(err, "can not get refresh token")
+	}
+
+	return rt, nil
+}
+
+// RotateRefreshToken marks the old token as replaced and creates the new one in the same family.
+// If the old token is already replaced or revoked by a concurrent request, a not found error is returned.
+func (gs *GormStorage) RotateRefreshToken(oldID uint, rt *models.RefreshToken) error {
+	tx := gs.db.Begin()
+
+	updates := tx.Model(&models.RefreshToken{}).
+		Where("id = ? AND replaced_at IS NULL AND revoked_at IS NULL", oldID).
+		UpdateColumn("replaced_at", time.Now())
+	if err := updates.Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not replace refresh token(id: %d)", oldID))
+	}
+
+	if updates.RowsAffected == 0 {
+		tx.Rollback()
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("active refresh token(id: %d) is not found", oldID))
+	}
+
+	if err := tx.Create(rt).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not create refresh token(family: %s)", rt.FamilyID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes the refresh tokens of the family
+// and the access tokens issued along with them
+func (gs *GormStorage) RevokeRefreshTokenFamily(familyID string) error {
+	tx := gs.db.Begin()
+
+	if err := revokeRefreshTokens(tx, "family_id = ?", familyID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not revoke refresh token family(family: %s)", familyID))
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// RevokeTokensOfUser revokes all the sessions, the refresh tokens and the access tokens of a user,
+// and rejects the id tokens issued before now
+func (gs *GormStorage) RevokeTokensOfUser(userID uint) error {
+	tx := gs.db.Begin()
+
+	if err := revokeRefreshTokens(tx, "user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not revoke tokens of user(id: %d)", userID))
+	}
+
+	if err := revokeSessions(tx, "user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not revoke sessions of user(id: %d)", userID))
+	}
+
+	err := tx.Model(&models.User{}).Where("id = ?", userID).UpdateColumn("tokens_revoked_at", time.Now()).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("can not update tokens_revoked_at of user(id: %d)", userID))
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether the JWT with jti is revoked
+func (gs *GormStorage) IsTokenRevoked(jti string) (bool, error) {
+	var count int
+
+	err := gs.db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("can not check revoked token(jti: %s)", jti))
+	}
+
+	return count > 0, nil
+}
+
+// revokeRefreshTokens revokes the refresh tokens matching the condition in the transaction.
+// The unexpired access tokens issued along with them are added to revoked_tokens.
+func revokeRefreshTokens(tx *gorm.DB, cond string, value interface{}) error {
+	now := time.Now()
+
+	err := tx.Exec(fmt.Sprintf("INSERT IGNORE INTO revoked_tokens (jti, created_at, user_id, expires_at) "+
+		"SELECT access_token_jti, ?, user_id, ? FROM refresh_tokens WHERE %s AND access_token_jti IS NOT NULL AND access_token_jti <> ''", cond),
+		now, now.Add(time.Second*time.Duration(globals.Conf.App.JwtAccessExpiration)), value).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.RefreshToken{}).Where(cond+" AND revoked_at IS NULL", value).UpdateColumn("revoked_at", now).Error
+}
+
+// DeleteExpiredTokens deletes the refresh tokens and the revoked access tokens expired before t,
+// which could not be exchanged or presented anymore
+func (gs *GormStorage) DeleteExpiredTokens(t time.Time) error {
+	if err := gs.db.Where("expires_at <= ?", t).Delete(&models.RefreshToken{}).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not delete refresh tokens expired before %s", t))
+	}
+
+	if err := gs.db.Where("expires_at <= ?", t).Delete(&models.RevokedToken{}).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not delete revoked tokens expired before %s", t))
+	}
+
+	return nil
+}
@@ -204,6 +204,77 @@ func (gs *GormStorage) UpdateReporterAccount(ra models.ReporterAccount) error {
 	return nil
 }
 
+// ConsumeOtpCodeOfReporterAccount invalidates the otp code of a reporter account if the code is valid.
+// The code is cleared in a single UPDATE statement, so only one of the concurrent requests could consume it.
+func (gs *GormStorage) ConsumeOtpCodeOfReporterAccount(email string, codeHash string) error {
+	updates := gs.db.Model(&models.ReporterAccount{}).
+		Where("email = ? AND otp_code_hash = ? AND otp_exp_time > ?", email, codeHash, time.Now()).
+		UpdateColumn("otp_code_hash", null.String{})
+
+	if err := updates.Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not consume otp code of reporter account(email: %s)", email))
+	}
+
+	if updates.RowsAffected == 0 {
+		return errors.Wrap(ErrRecordNotFound, fmt.Sprintf("valid otp code of reporter account(email: %s) is not found", email))
+	}
+
+	return nil
+}
+
+// IncreaseFailedAttemptsOfReporterAccount increases the failed otp attempts of a reporter account.
+// Once the attempts reach maxAttempts, the account is locked until lockedUntil and the attempts restart from zero.
+func (gs *GormStorage) IncreaseFailedAttemptsOfReporterAccount(raID uint, maxAttempts int, lockedUntil time.Time) (models.ReporterAccount, error) {
+	var ra models.ReporterAccount
+
+	tx := gs.db.Begin()
+
+	// UpdateColumn is used since Updates ignores the zero value fields
+	err := tx.Model(&models.ReporterAccount{}).Where("id = ?", raID).UpdateColumn("failed_attempts", gorm.Expr("failed_attempts + ?", 1)).Error
+	if err != nil {
+		tx.Rollback()
+		return ra, errors.Wrap(err, fmt.Sprintf("can not increase failed attempts of reporter account(id: %d)", raID))
+	}
+
+	if err = tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", raID).First(&ra).Error; err != nil {
+		tx.Rollback()
+		return ra, errors.Wrap(err, fmt.Sprintf("can not get reporter account(id: %d)", raID))
+	}
+
+	if maxAttempts > 0 && ra.FailedAttempts >= uint(maxAttempts) {
+		ra.FailedAttempts = 0
+		ra.LockedUntil = null.TimeFrom(lockedUntil)
+		err = tx.Model(&ra).UpdateColumns(map[string]interface{}{
+			"failed_attempts": ra.FailedAttempts,
+			"locked_until":    ra.LockedUntil,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return ra, errors.Wrap(err, fmt.Sprintf("can not lock reporter account(id: %d)", raID))
+		}
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return ra, errors.WithStack(err)
+	}
+
+	return ra, nil
+}
+
+// ResetFailedAttemptsOfReporterAccount clears the failed otp attempts and the lockout of a reporter account
+func (gs *GormStorage) ResetFailedAttemptsOfReporterAccount(raID uint) error {
+	err := gs.db.Model(&models.ReporterAccount{}).Where("id = ?", raID).UpdateColumns(map[string]interface{}{
+		"failed_attempts": 0,
+		"locked_until":    null.Time{},
+	}).Error
+
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can not reset failed attempts of reporter account(id: %d)", raID))
+	}
+
+	return nil
+}
+
 // UpdateUser update a user
 func (gs *GormStorage) UpdateUser(user models.User) error {
 	err := gs.db.Model(&user).Updates(&user).Error
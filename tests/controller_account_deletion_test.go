@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+func createAccountDeletionRequest(t *testing.T, userID uint, token string) {
+	as := storage.NewGormStorage(Globs.GormDB)
+	sum := sha256.Sum256([]byte(token))
+
+	err := as.CreateAccountDeletionRequest(models.AccountDeletionRequest{
+		UserID:         userID,
+		TokenHash:      hex.EncodeToString(sum[:]),
+		ConfirmExpTime: time.Now().Add(time.Hour),
+	})
+	assert.Nil(t, err)
+}
+
+func TestConfirmAndCancelAccountDeletion(t *testing.T) {
+	const token = "account-deletion-token"
+	user := createUser("account-deletion-cancel@twreporter.org")
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.AccountDeletionRequest{})
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(user))
+	path := fmt.Sprintf("/v2/users/%d/deletion", user.ID)
+
+	resp := serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	createAccountDeletionRequest(t, user.ID, token)
+
+	resp = serveHTTP(http.MethodPost, path+"/confirm", `{"token":"wrong-token"}`, "application/json", "")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = serveHTTP(http.MethodPost, path+"/confirm", fmt.Sprintf(`{"token":"%s"}`, token), "application/json", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the token is for single use
+	resp = serveHTTP(http.MethodPost, path+"/confirm", fmt.Sprintf(`{"token":"%s"}`, token), "application/json", "")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// the deletion could not be requested again once it is scheduled
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("/v2/users/%d", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = serveHTTP(http.MethodDelete, path, "", "", authorization)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	resp = serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestEraseAccount(t *testing.T) {
+	const email = "account-deletion-erase@twreporter.org"
+	const token = "account-deletion-token"
+	as := storage.NewGormStorage(Globs.GormDB)
+	user := createUser(email)
+	defer Globs.GormDB.Unscoped().Delete(&user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.AccountDeletionRequest{})
+
+	donation := models.PayByPrimeDonation{
+		Amount:      500,
+		Details:     "報導者小額捐款",
+		MerchantID:  "merchant-id",
+		OrderNumber: "account-deletion-order",
+		PayMethod:   "credit_card",
+		Status:      "paid",
+		UserID:      user.ID,
+	}
+	donation.Cardholder.Email = email
+	donation.Receipt.Header.SetValid("receipt header")
+	assert.Nil(t, Globs.GormDB.Create(&donation).Error)
+	defer Globs.GormDB.Unscoped().Delete(&donation)
+
+	createAccountDeletionRequest(t, user.ID, token)
+
+	// the request is not confirmed yet
+	_, err := as.EraseAccount(user.ID)
+	assert.True(t, storage.IsNotFound(err))
+
+	sum := sha256.Sum256([]byte(token))
+	assert.Nil(t, as.ConfirmAccountDeletionRequest(user.ID, hex.EncodeToString(sum[:]), time.Now().Add(-time.Second)))
+
+	erased, err := as.EraseAccount(user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, email, erased.Email.String)
+
+	// the request is completed
+	_, err = as.EraseAccount(user.ID)
+	assert.True(t, storage.IsNotFound(err))
+
+	var anonymised models.User
+	Globs.GormDB.Unscoped().Where("id = ?", user.ID).First(&anonymised)
+	assert.NotNil(t, anonymised.DeletedAt)
+	assert.False(t, anonymised.Email.Valid)
+	assert.True(t, anonymised.TokensRevokedAt.Valid)
+
+	_, err = as.GetReporterAccountOfUser(user.ID)
+	assert.True(t, storage.IsNotFound(err))
+
+	// the amount and the receipt are kept
+	var kept models.PayByPrimeDonation
+	Globs.GormDB.Where("id = ?", donation.ID).First(&kept)
+	assert.Equal(t, uint(500), kept.Amount)
+	assert.Equal(t, "", kept.Cardholder.Email)
+	assert.Equal(t, "receipt header", kept.Receipt.Header.String)
+
+	req, err := as.GetAccountDeletionRequest(user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, models.AccountDeletionStatusCompleted, req.Status())
+}
+
+func TestAccountDeletionPurgeJob(t *testing.T) {
+	const token = "account-deletion-token"
+	user := createUser("account-deletion-purge@twreporter.org")
+	defer Globs.GormDB.Unscoped().Delete(&user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.AccountDeletionRequest{})
+
+	createAccountDeletionRequest(t, user.ID, token)
+	sum := sha256.Sum256([]byte(token))
+	as := storage.NewGormStorage(Globs.GormDB)
+	assert.Nil(t, as.ConfirmAccountDeletionRequest(user.ID, hex.EncodeToString(sum[:]), time.Now().Add(-time.Second)))
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	assert.Nil(t, cf.GetMembershipController().AccountDeletionPurgeJob().Run(context.Background()))
+
+	// the account passing the grace period is erased
+	var erased models.User
+	Globs.GormDB.Unscoped().Where("id = ?", user.ID).First(&erased)
+	assert.NotNil(t, erased.DeletedAt)
+	assert.False(t, erased.Email.Valid)
+}
+
+func TestEraseAccountKeepsTaxDeductionData(t *testing.T) {
+	const email = "account-deletion-tax@twreporter.org"
+	const token = "account-deletion-token"
+	as := storage.NewGormStorage(Globs.GormDB)
+	user := createUser(email)
+	defer Globs.GormDB.Unscoped().Delete(&user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.AccountDeletionRequest{})
+
+	now := time.Now()
+	createDonation := func(orderNumber string, transactionTime time.Time) models.PayByPrimeDonation {
+		donation := models.PayByPrimeDonation{
+			Amount:           500,
+			Details:          "報導者小額捐款",
+			MerchantID:       "merchant-id",
+			OrderNumber:      orderNumber,
+			PayMethod:        "credit_card",
+			Status:           "paid",
+			UserID:           user.ID,
+			AutoTaxDeduction: null.BoolFrom(true),
+		}
+		donation.TransactionTime = null.TimeFrom(transactionTime)
+		donation.Cardholder.Email = email
+		donation.Cardholder.Name = null.StringFrom("王小明")
+		donation.Cardholder.NationalID = null.StringFrom("A123456789")
+		assert.Nil(t, Globs.GormDB.Create(&donation).Error)
+		return donation
+	}
+	pending := createDonation("account-deletion-tax-pending", now)
+	defer Globs.GormDB.Unscoped().Delete(&pending)
+	uploaded := createDonation("account-deletion-tax-uploaded", now.AddDate(-3, 0, 0))
+	defer Globs.GormDB.Unscoped().Delete(&uploaded)
+
+	createAccountDeletionRequest(t, user.ID, token)
+	sum := sha256.Sum256([]byte(token))
+	assert.Nil(t, as.ConfirmAccountDeletionRequest(user.ID, hex.EncodeToString(sum[:]), now.Add(-time.Second)))
+	_, err := as.EraseAccount(user.ID)
+	assert.Nil(t, err)
+
+	getDonation := func(id uint) models.PayByPrimeDonation {
+		var d models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", id).First(&d)
+		return d
+	}
+
+	// the donor of the tax year not uploaded yet is kept for the tax agency
+	kept := getDonation(pending.ID)
+	assert.Equal(t, "", kept.Cardholder.Email)
+	assert.Equal(t, "王小明", kept.Cardholder.Name.String)
+	assert.Equal(t, "A123456789", kept.Cardholder.NationalID.String)
+
+	erased := getDonation(uploaded.ID)
+	assert.False(t, erased.Cardholder.Name.Valid)
+	assert.False(t, erased.Cardholder.NationalID.Valid)
+
+	// the donor is erased once the tax year is uploaded
+	assert.Nil(t, as.EraseUploadedTaxDeductionData(now.AddDate(2, 0, 0)))
+	erased = getDonation(pending.ID)
+	assert.False(t, erased.Cardholder.Name.Valid)
+	assert.False(t, erased.Cardholder.NationalID.Valid)
+}
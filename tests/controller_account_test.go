@@ -8,9 +8,12 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
 
+	"github.com/twreporter/go-api/globals"
 	"github.com/twreporter/go-api/models"
 	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
 )
 
 func TestSignIn(t *testing.T) {
@@ -59,3 +62,85 @@ func TestActivate(t *testing.T) {
 	// END - test activate endpoint v2//
 
 }
+
+func TestActivateV3Lockout(t *testing.T) {
+	const email = "otp-lockout@twreporter.org"
+	const otpCode = "123456"
+
+	createUser(email)
+	ra := getReporterAccount(email)
+
+	as := storage.NewGormStorage(Globs.GormDB)
+	if err := as.UpdateReporterAccount(models.ReporterAccount{
+		ID:          ra.ID,
+		OtpCodeHash: null.StringFrom(utils.HashOTPCode(email, otpCode)),
+		OtpExpTime:  null.TimeFrom(time.Now().Add(time.Duration(15) * time.Minute)),
+	}); nil != err {
+		fmt.Println(err.Error())
+	}
+
+	maxAttempts := globals.Conf.RateLimit.OtpMaxFailedAttempts
+	wrongCodeBody := fmt.Sprintf(`{"email":"%s","otp_code":"000000"}`, email)
+
+	// the failed attempts before lockout
+	for i := 1; i < maxAttempts; i++ {
+		resp := serveHTTP("POST", "/v3/auth/activate", wrongCodeBody, "application/json", "")
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	}
+
+	// the last failed attempt locks the account
+	resp := serveHTTP("POST", "/v3/auth/activate", wrongCodeBody, "application/json", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	// the correct code is rejected during the lockout
+	resp = serveHTTP("POST", "/v3/auth/activate", fmt.Sprintf(`{"email":"%s","otp_code":"%s"}`, email, otpCode), "application/json", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+	// no more code is sent during the lockout
+	resp = serveHTTP("POST", "/v3/auth/signin", fmt.Sprintf(`{"email":"%s"}`, email), "application/json", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+	ra = getReporterAccount(email)
+	assert.True(t, ra.IsLocked(time.Now()))
+	assert.Equal(t, uint(0), ra.FailedAttempts)
+
+	// lift the lockout
+	if err := as.ResetFailedAttemptsOfReporterAccount(ra.ID); nil != err {
+		fmt.Println(err.Error())
+	}
+
+	resp = serveHTTP("POST", "/v3/auth/activate", fmt.Sprintf(`{"email":"%s","otp_code":"%s"}`, email, otpCode), "application/json", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestActivateV3SingleUse(t *testing.T) {
+	const email = "otp-single-use@twreporter.org"
+	const otpCode = "654321"
+
+	createUser(email)
+	ra := getReporterAccount(email)
+
+	as := storage.NewGormStorage(Globs.GormDB)
+	if err := as.UpdateReporterAccount(models.ReporterAccount{
+		ID:          ra.ID,
+		OtpCodeHash: null.StringFrom(utils.HashOTPCode(email, otpCode)),
+		OtpExpTime:  null.TimeFrom(time.Now().Add(time.Duration(15) * time.Minute)),
+	}); nil != err {
+		fmt.Println(err.Error())
+	}
+
+	// the code is not stored in plaintext
+	ra = getReporterAccount(email)
+	assert.NotEqual(t, otpCode, ra.OtpCodeHash.String)
+	assert.NotEqual(t, otpCode, ra.ActivateToken)
+
+	body := fmt.Sprintf(`{"email":"%s","otp_code":"%s"}`, email, otpCode)
+	resp := serveHTTP("POST", "/v3/auth/activate", body, "application/json", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the replayed request fails
+	resp = serveHTTP("POST", "/v3/auth/activate", body, "application/json", "")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.False(t, getReporterAccount(email).OtpCodeHash.Valid)
+}
@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+func TestDonationCampaign(t *testing.T) {
+	const (
+		donorEmail         = "campaign-donor@twreporter.org"
+		periodicDonorEmail = "campaign-periodic-donor@twreporter.org"
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":0,"msg":"Success","rec_trade_id":"D20260101campaign","bank_transaction_id":"TP20260101campaign","transaction_time_millis":1767225600000,"card_info":{"bin_code":"424242","last_four":"4242","expiry_date":"203012","type":1}}`))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayURL = server.URL
+
+	open := models.DonationCampaign{
+		Slug:       "campaign-open",
+		Name:       "年終募款",
+		GoalAmount: 100000,
+		StartAt:    time.Now().AddDate(0, -1, 0),
+		EndAt:      null.TimeFrom(time.Now().AddDate(0, 1, 0)),
+		TopicSlug:  null.StringFrom("a-topic"),
+	}
+	closed := models.DonationCampaign{
+		Slug:       "campaign-closed",
+		Name:       "已結束的募款",
+		GoalAmount: 100000,
+		StartAt:    time.Now().AddDate(0, -2, 0),
+		EndAt:      null.TimeFrom(time.Now().AddDate(0, -1, 0)),
+	}
+	assert.Nil(t, Globs.GormDB.Create(&open).Error)
+	assert.Nil(t, Globs.GormDB.Create(&closed).Error)
+	defer Globs.GormDB.Unscoped().Delete(&open)
+	defer Globs.GormDB.Unscoped().Delete(&closed)
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+	authorization, cookie := helperSetupAuth(user)
+
+	reqBody := func(campaign string) string {
+		b, _ := json.Marshal(struct {
+			requestBody
+			Campaign string `json:"campaign"`
+		}{
+			requestBody{
+				Amount: testAmount,
+				Cardholder: models.Cardholder{
+					Email: donorEmail,
+					Name:  null.StringFrom(testName),
+				},
+				Details:   testDetails,
+				PayMethod: creditCardPayMethod,
+				Prime:     testCreditCardPrime,
+				UserID:    user.ID,
+			},
+			campaign,
+		})
+		return string(b)
+	}
+	create := func(campaign string) *httptest.ResponseRecorder {
+		return serveHTTPWithCookies(http.MethodPost, "/v1/donations/prime", reqBody(campaign), "application/json", authorization, cookie)
+	}
+
+	resp := create("campaign-unknown")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = create(closed.Slug)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = create(open.Slug)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var res responseBody
+	json.Unmarshal(resp.Body.Bytes(), &res)
+
+	var d models.PayByPrimeDonation
+	Globs.GormDB.Where("order_number = ?", res.Data.OrderNumber).First(&d)
+	assert.Equal(t, int64(open.ID), d.CampaignID.ValueOrZero())
+
+	// the charges of the periodic donation of another donor are attributed to the campaign
+	periodicDonor := createUser(periodicDonorEmail)
+	defer deleteUser(periodicDonor)
+
+	pd := models.PeriodicDonation{
+		Amount:      300,
+		CampaignID:  null.IntFrom(int64(open.ID)),
+		Currency:    testCurrency,
+		Details:     testDetails,
+		Frequency:   "monthly",
+		OrderNumber: "campaign-periodic",
+		Status:      statusPaid,
+		UserID:      periodicDonor.ID,
+	}
+	pd.Cardholder.Email = periodicDonorEmail
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PayByCardTokenDonation{})
+
+	for i, status := range []string{statusPaid, statusPaid, statusFail} {
+		td := models.PayByCardTokenDonation{
+			Amount:      300,
+			Currency:    testCurrency,
+			Details:     testDetails,
+			MerchantID:  testCreditCardMerchant,
+			OrderNumber: "campaign-token-" + string('a'+rune(i)),
+			PeriodicID:  pd.ID,
+			Status:      status,
+		}
+		assert.Nil(t, Globs.GormDB.Create(&td).Error)
+	}
+
+	type campaignResponse struct {
+		Data struct {
+			Slug         string `json:"slug"`
+			TopicSlug    string `json:"topic_slug"`
+			GoalAmount   uint   `json:"goal_amount"`
+			RaisedAmount uint   `json:"raised_amount"`
+			DonorCount   uint   `json:"donor_count"`
+		} `json:"data"`
+	}
+	getCampaign := func() campaignResponse {
+		resp := serveHTTP(http.MethodGet, "/v1/campaigns/"+open.Slug, "", "", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var c campaignResponse
+		json.Unmarshal(resp.Body.Bytes(), &c)
+		return c
+	}
+
+	c := getCampaign()
+	assert.Equal(t, open.Slug, c.Data.Slug)
+	assert.Equal(t, "a-topic", c.Data.TopicSlug)
+	assert.Equal(t, uint(100000), c.Data.GoalAmount)
+	assert.Equal(t, uint(testAmount+600), c.Data.RaisedAmount)
+	assert.Equal(t, uint(2), c.Data.DonorCount)
+
+	// the progress is served from the cache
+	resp = create(open.Slug)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, uint(testAmount+600), getCampaign().Data.RaisedAmount)
+
+	resp = serveHTTP(http.MethodGet, "/v1/campaigns/campaign-unknown", "", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestCampaignProgressOfPartiallyRefundedDonation(t *testing.T) {
+	campaign := models.DonationCampaign{
+		Slug:       "campaign-refunded",
+		Name:       "部分退款的募款",
+		GoalAmount: 100000,
+		StartAt:    time.Now().AddDate(0, -1, 0),
+	}
+	assert.Nil(t, Globs.GormDB.Create(&campaign).Error)
+	defer Globs.GormDB.Unscoped().Delete(&campaign)
+
+	user := createUser("campaign-refunded-donor@twreporter.org")
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+
+	create := func(orderNumber string, amount uint, twdAmount null.Int) models.PayByPrimeDonation {
+		d := models.PayByPrimeDonation{
+			Amount:        amount,
+			CampaignID:    null.IntFrom(int64(campaign.ID)),
+			Currency:      testCurrency,
+			Details:       testDetails,
+			MerchantID:    testCreditCardMerchant,
+			OrderNumber:   orderNumber,
+			PayMethod:     creditCardPayMethod,
+			Status:        statusPaid,
+			UserID:        user.ID,
+			TWDEquivalent: models.TWDEquivalent{TWDAmount: twdAmount},
+		}
+		d.Cardholder.Email = user.Email.ValueOrZero()
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+		return d
+	}
+	twd := create("campaign-refunded-twd", 500, null.Int{})
+	usd := create("campaign-refunded-usd", 100, null.IntFrom(3150))
+	defer Globs.GormDB.Where("order_number IN (?)", []string{twd.OrderNumber, usd.OrderNumber}).Delete(&models.DonationRefund{})
+
+	refund := func(d models.PayByPrimeDonation, amount uint, status string) {
+		r := models.DonationRefund{
+			DonationType: globals.PrimeDonationType,
+			DonationID:   d.ID,
+			OrderNumber:  d.OrderNumber,
+			Amount:       amount,
+			Currency:     d.Currency,
+			Status:       status,
+			OperatorID:   user.ID,
+		}
+		assert.Nil(t, Globs.GormDB.Create(&r).Error)
+	}
+	refund(twd, 200, "refunded")
+	refund(twd, 100, "fail")
+	refund(usd, 20, "refunded")
+
+	// 300 TWD and 80% of 3150 TWD are left after the refunds
+	p, err := storage.NewGormStorage(Globs.GormDB).GetCampaignProgress(campaign.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(300+2520), p.RaisedAmount)
+	assert.Equal(t, uint(1), p.DonorCount)
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestCreateADonationInForeignCurrency(t *testing.T) {
+	const (
+		donorEmail  = "usd-donor@twreporter.org"
+		usdMerchant = "twreporter_USD"
+	)
+
+	var merchantID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MerchantID string `json:"merchant_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		merchantID = body.MerchantID
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":0,"msg":"Success","rec_trade_id":"D20260101usd","bank_transaction_id":"TP20260101usd","transaction_time_millis":1767225600000,"card_info":{"bin_code":"424242","last_four":"4242","expiry_date":"203012","type":1}}`))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayURL = server.URL
+	globals.Conf.Donation.Currencies = map[string]configs.CurrencyConfig{
+		"TWD": {TWDRate: 1, MinAmount: 1},
+		"USD": {TWDRate: 31.5, MinAmount: 1, MaxAmount: 1000, MerchantIDs: map[string]string{"credit_card": usdMerchant}},
+	}
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+	authorization, cookie := helperSetupAuth(user)
+
+	reqBodyWithMerchant := func(amount uint, currency, payMethod, merchantID string) string {
+		b, _ := json.Marshal(requestBody{
+			Amount: amount,
+			Cardholder: models.Cardholder{
+				Email: donorEmail,
+				Name:  null.StringFrom(testName),
+			},
+			Currency:   currency,
+			Details:    testDetails,
+			MerchantID: merchantID,
+			PayMethod:  payMethod,
+			Prime:      testCreditCardPrime,
+			UserID:     user.ID,
+		})
+		return string(b)
+	}
+	reqBody := func(amount uint, currency, payMethod string) string {
+		return reqBodyWithMerchant(amount, currency, payMethod, "")
+	}
+	create := func(body string) *httptest.ResponseRecorder {
+		return serveHTTPWithCookies(http.MethodPost, "/v1/donations/prime", body, "application/json", authorization, cookie)
+	}
+
+	// the currency is not configured
+	resp := create(reqBody(10, "JPY", creditCardPayMethod))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// no line pay merchant charges in USD
+	resp = create(reqBody(10, "USD", linePayMethod))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// the amount exceeds the limit of USD
+	resp = create(reqBody(1001, "USD", creditCardPayMethod))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// the merchant given by the client does not charge in USD
+	merchantID = ""
+	resp = create(reqBodyWithMerchant(10, "USD", creditCardPayMethod, testCreditCardMerchant))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), "req.Body.merchant_id")
+	assert.Equal(t, "", merchantID)
+
+	resp = create(reqBody(10, "usd", creditCardPayMethod))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, usdMerchant, merchantID)
+
+	var res responseBody
+	json.Unmarshal(resp.Body.Bytes(), &res)
+
+	var d models.PayByPrimeDonation
+	Globs.GormDB.Where("order_number = ?", res.Data.OrderNumber).First(&d)
+	assert.Equal(t, "USD", d.Currency)
+	assert.Equal(t, int64(315), d.TWDAmount.ValueOrZero())
+	assert.Equal(t, 31.5, d.ExchangeRate.ValueOrZero())
+
+	// TWD is charged by the default merchant, which could be given by the client as well
+	resp = create(reqBodyWithMerchant(testAmount, "", creditCardPayMethod, testCreditCardMerchant))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, testCreditCardMerchant, merchantID)
+
+	// the donations are listed with both the amount and its TWD equivalent
+	resp = serveHTTP(http.MethodGet, fmt.Sprintf("/v1/users/%d/donations", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var list responseBodyForList
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	assert.Equal(t, 2, len(list.Records))
+	for _, r := range list.Records {
+		if r.OrderNumber == d.OrderNumber {
+			assert.Equal(t, uint(10), r.Amount)
+			assert.Equal(t, "USD", r.Currency)
+			assert.Equal(t, int64(315), r.TWDAmount.ValueOrZero())
+		} else {
+			assert.Equal(t, "TWD", r.Currency)
+			assert.Equal(t, int64(testAmount), r.TWDAmount.ValueOrZero())
+		}
+	}
+}
+
+func TestCreateADonationWithMerchantOfClient(t *testing.T) {
+	const (
+		donorEmail     = "client-merchant-donor@twreporter.org"
+		clientMerchant = "twreporter_CTBC"
+	)
+
+	var merchantID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MerchantID string `json:"merchant_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		merchantID = body.MerchantID
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":0,"msg":"Success","rec_trade_id":"D20260101client","bank_transaction_id":"TP20260101client","transaction_time_millis":1767225600000,"card_info":{"bin_code":"424242","last_four":"4242","expiry_date":"203012","type":1}}`))
+	}))
+	defer server.Close()
+
+	defaultConf, err := configs.LoadDefaultConf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayURL = server.URL
+	globals.Conf.Donation.Currencies = defaultConf.Donation.Currencies
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+	authorization, cookie := helperSetupAuth(user)
+
+	reqBody, _ := json.Marshal(requestBody{
+		Amount: testAmount,
+		Cardholder: models.Cardholder{
+			Email: donorEmail,
+			Name:  null.StringFrom(testName),
+		},
+		Details:    testDetails,
+		MerchantID: clientMerchant,
+		PayMethod:  creditCardPayMethod,
+		Prime:      testCreditCardPrime,
+		UserID:     user.ID,
+	})
+
+	// no TWD merchant is configured by default, so the merchant of the client is charged
+	resp := serveHTTPWithCookies(http.MethodPost, "/v1/donations/prime", string(reqBody), "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, clientMerchant, merchantID)
+}
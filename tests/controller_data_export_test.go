@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+func TestExportUserData(t *testing.T) {
+	as := storage.NewGormStorage(Globs.GormDB)
+	user := createUser("data-export@twreporter.org")
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.UserDataExport{})
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(user))
+	path := fmt.Sprintf("/v2/users/%d/export", user.ID)
+
+	// the export is being generated
+	started, err := as.StartUserDataExport(user.ID, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, started)
+
+	resp := serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+
+	// the processing export is not generated again
+	started, err = as.StartUserDataExport(user.ID, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, started)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("profile.json")
+	f.Write([]byte(`{"id":1}`))
+	zw.Close()
+	assert.Nil(t, as.CompleteUserDataExport(user.ID, buf.Bytes(), time.Now().Add(time.Hour)))
+
+	resp = serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/zip", resp.Header().Get("Content-Type"))
+	assert.Equal(t, buf.Bytes(), resp.Body.Bytes())
+
+	// others could not export the data of the user
+	other := createUser("data-export-other@twreporter.org")
+	defer deleteUser(other)
+	resp = serveHTTP(http.MethodGet, path, "", "", fmt.Sprintf("Bearer %s", generateIDToken(other)))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestDataExportPurgeJob(t *testing.T) {
+	as := storage.NewGormStorage(Globs.GormDB)
+	expired := createUser("data-export-expired@twreporter.org")
+	defer deleteUser(expired)
+	ready := createUser("data-export-ready@twreporter.org")
+	defer deleteUser(ready)
+	defer Globs.GormDB.Where("user_id IN (?)", []uint{expired.ID, ready.ID}).Delete(&models.UserDataExport{})
+
+	for user, expiresAt := range map[uint]time.Time{expired.ID: time.Now().Add(-time.Minute), ready.ID: time.Now().Add(time.Hour)} {
+		started, err := as.StartUserDataExport(user, time.Now().Add(-time.Hour))
+		assert.Nil(t, err)
+		assert.True(t, started)
+		assert.Nil(t, as.CompleteUserDataExport(user, []byte("archive"), expiresAt))
+	}
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	assert.Nil(t, cf.GetDataExportController().DataExportPurgeJob().Run(context.Background()))
+
+	// only the archive passing the expiration is cleared
+	export, err := as.GetUserDataExport(expired.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, export.Archive)
+
+	export, err = as.GetUserDataExport(ready.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("archive"), export.Archive)
+}
+
+func TestExportUserDataArchive(t *testing.T) {
+	const email = "data-export-archive@twreporter.org"
+
+	features := globals.Conf.Features
+	defer func() { globals.Conf.Features = features }()
+	globals.Conf.Features.MemberCMS = false
+
+	as := storage.NewGormStorage(Globs.GormDB)
+	user := createUser(email)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.UserDataExport{})
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(user))
+	path := fmt.Sprintf("/v2/users/%d/export", user.ID)
+	userID := fmt.Sprint(user.ID)
+
+	assert.Nil(t, as.InsertOAuthAccount(models.OAuthAccount{
+		UserID: user.ID,
+		Type:   globals.GoogleOAuth,
+		AId:    null.StringFrom("data-export-archive-google-id"),
+		Email:  null.StringFrom(email),
+	}))
+	defer Globs.GormDB.Unscoped().Delete(&models.OAuthAccount{}, "user_id = ?", user.ID)
+
+	bookmark, err := as.CreateABookmarkOfAUser(userID, models.Bookmark{
+		Slug:      "data-export-archive-slug",
+		Title:     "data export archive",
+		Host:      "www.twreporter.org",
+		Thumbnail: "https://www.twreporter.org/thumbnail.jpg",
+		PostID:    "data-export-archive-bookmark",
+	})
+	assert.Nil(t, err)
+	defer Globs.GormDB.Unscoped().Delete(&bookmark)
+	defer Globs.GormDB.Exec("DELETE FROM users_bookmarks WHERE user_id = ?", user.ID)
+
+	assert.Nil(t, Globs.GormDB.Create(&models.UsersPostsReadingFootprint{UserID: int(user.ID), PostID: "data-export-archive-footprint"}).Error)
+	defer Globs.GormDB.Unscoped().Delete(&models.UsersPostsReadingFootprint{}, "user_id = ?", user.ID)
+	assert.Nil(t, Globs.GormDB.Create(&models.UsersPostsReadingTime{UserID: int(user.ID), PostID: "data-export-archive-reading-time", Seconds: 30}).Error)
+	defer Globs.GormDB.Unscoped().Delete(&models.UsersPostsReadingTime{}, "user_id = ?", user.ID)
+
+	subscriber := user.ID
+	assert.Nil(t, as.CreateAWebPushSubscription(models.WebPushSubscription{
+		Endpoint: "https://push.example.com/data-export-archive",
+		Keys:     "{}",
+		UserID:   &subscriber,
+	}))
+	defer Globs.GormDB.Delete(&models.WebPushSubscription{}, "user_id = ?", user.ID)
+
+	pd := models.PeriodicDonation{
+		Amount:      300,
+		Currency:    testCurrency,
+		Details:     testDetails,
+		Frequency:   "monthly",
+		OrderNumber: "data-export-archive-periodic",
+		Status:      statusPaid,
+		UserID:      user.ID,
+	}
+	pd.Cardholder.Email = email
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+
+	td := models.PayByCardTokenDonation{
+		Amount:      300,
+		Currency:    testCurrency,
+		Details:     testDetails,
+		MerchantID:  testCreditCardMerchant,
+		OrderNumber: "data-export-archive-token",
+		PeriodicID:  pd.ID,
+		Status:      statusPaid,
+	}
+	assert.Nil(t, Globs.GormDB.Create(&td).Error)
+	defer Globs.GormDB.Unscoped().Delete(&td)
+
+	// the archive is generated asynchronously
+	resp := serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+
+	var export models.UserDataExport
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if export, err = as.GetUserDataExport(user.ID); err != nil || export.Status != models.DataExportStatusProcessing {
+			break
+		}
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, models.DataExportStatusReady, export.Status)
+
+	resp = serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	zr, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.Nil(t, err)
+		files[f.Name], _ = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+
+	var profile struct {
+		ID    uint   `json:"id"`
+		Email string `json:"email"`
+	}
+	assert.Nil(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.Equal(t, user.ID, profile.ID)
+	assert.Equal(t, email, profile.Email)
+
+	assert.Contains(t, string(files["roles.json"]), constants.RoleExplorer)
+	assert.Contains(t, string(files["oauth_accounts.json"]), "data-export-archive-google-id")
+	assert.Contains(t, string(files["bookmarks.json"]), "data-export-archive-slug")
+	assert.Contains(t, string(files["bookmarks.csv"]), "data-export-archive-slug")
+	assert.Contains(t, string(files["reading_footprints.csv"]), "data-export-archive-footprint")
+	assert.Contains(t, string(files["reading_times.csv"]), "data-export-archive-reading-time,30,")
+	assert.Contains(t, string(files["web_push_subscriptions.json"]), "https://push.example.com/data-export-archive")
+
+	var donations struct {
+		Donations []struct {
+			OrderNumber string `json:"order_number"`
+		} `json:"donations"`
+		Payments map[string][]struct {
+			OrderNumber string `json:"order_number"`
+		} `json:"payments"`
+	}
+	assert.Nil(t, json.Unmarshal(files["donations.json"], &donations))
+	if assert.Equal(t, 1, len(donations.Donations)) {
+		assert.Equal(t, pd.OrderNumber, donations.Donations[0].OrderNumber)
+	}
+	if assert.Equal(t, 1, len(donations.Payments[pd.OrderNumber])) {
+		assert.Equal(t, td.OrderNumber, donations.Payments[pd.OrderNumber][0].OrderNumber)
+	}
+	assert.Contains(t, string(files["donations.csv"]), pd.OrderNumber)
+	assert.Contains(t, string(files["payments.csv"]), fmt.Sprintf("%s,%s,300,%s", pd.OrderNumber, td.OrderNumber, statusPaid))
+}
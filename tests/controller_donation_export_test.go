@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/middlewares"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestExportDonations(t *testing.T) {
+	const (
+		donorEmail = "donation-export-donor@twreporter.org"
+		merchantID = "donation-export-merchant"
+	)
+
+	donor := createUser(donorEmail)
+	defer deleteUser(donor)
+	admin := createUser("donation-export-admin@twreporter.org")
+	defer deleteUser(admin)
+	Globs.GormDB.Model(&admin).UpdateColumn("privilege", constants.PrivilegeAdmin)
+
+	paidAt := time.Now().Add(-time.Hour)
+
+	for i, status := range []string{statusPaid, statusFail} {
+		d := models.PayByPrimeDonation{
+			Amount:      testAmount,
+			Currency:    testCurrency,
+			Details:     testDetails,
+			MerchantID:  merchantID,
+			OrderNumber: fmt.Sprintf("donation-export-prime-%d", i),
+			PayMethod:   creditCardPayMethod,
+			Status:      status,
+			UserID:      donor.ID,
+		}
+		d.TransactionTime = null.TimeFrom(paidAt)
+		d.Cardholder.Email = donorEmail
+		d.Cardholder.Name = null.StringFrom("王小明")
+		d.Cardholder.NationalID = null.StringFrom("A123456789")
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+		defer Globs.GormDB.Unscoped().Delete(&d)
+	}
+
+	o := models.PayByOtherMethodDonation{
+		Amount:      testAmount,
+		Currency:    testCurrency,
+		Details:     testDetails,
+		Email:       donorEmail,
+		MerchantID:  merchantID,
+		OrderNumber: "donation-export-others",
+		PayMethod:   "bank_transfer",
+		SendReceipt: "no_receipt",
+		UserID:      donor.ID,
+	}
+	assert.Nil(t, Globs.GormDB.Create(&o).Error)
+	defer Globs.GormDB.Unscoped().Delete(&o)
+
+	path := "/v1/admin/donations/export?merchant_id=" + merchantID
+
+	// only admins could export
+	resp := serveHTTP(http.MethodGet, path, "", "", fmt.Sprintf("Bearer %s", generateIDToken(donor)))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(admin))
+
+	for _, query := range []string{"&format=xml", "&mask=maybe", "&status=stopped", "&from=2025/01/01"} {
+		resp = serveHTTP(http.MethodGet, path+query, "", "", authorization)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+
+	readCSV := func(query string) [][]string {
+		resp := serveHTTP(http.MethodGet, path+query, "", "", authorization)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		assert.Nil(t, err)
+		return records
+	}
+
+	records := readCSV("")
+	if assert.Equal(t, 4, len(records)) {
+		assert.Equal(t, "donation_type", records[0][0])
+		assert.Equal(t, "王小明", records[1][14])
+	}
+
+	// the donations paid by other methods are always paid
+	records = readCSV("&status=paid")
+	assert.Equal(t, 3, len(records))
+
+	records = readCSV("&status=fail&type=prime")
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "donation-export-prime-1", records[1][1])
+	}
+
+	records = readCSV("&type=others&pay_method=bank_transfer")
+	assert.Equal(t, 2, len(records))
+
+	records = readCSV(fmt.Sprintf("&to=%s", paidAt.AddDate(0, 0, -2).Format("2006-01-02")))
+	assert.Equal(t, 1, len(records))
+
+	records = readCSV("&type=prime&mask=true")
+	if assert.Equal(t, 3, len(records)) {
+		assert.Equal(t, "d"+strings.Repeat("*", 20)+"@twreporter.org", records[1][13])
+		assert.Equal(t, "王*明", records[1][14])
+		assert.Equal(t, "A12*****89", records[1][16])
+	}
+
+	resp = serveHTTP(http.MethodGet, path+"&format=xlsx", "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", resp.Header().Get("Content-Type"))
+	_, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	assert.Nil(t, err)
+}
+
+func TestExportOutlastsWriteTimeout(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+
+	engine := gin.New()
+	slow := func(c *gin.Context) {
+		time.Sleep(3 * writeTimeout)
+		c.String(http.StatusOK, "exported")
+	}
+	engine.GET("/slow", slow)
+	engine.GET("/export", middlewares.ClearWriteDeadline(), slow)
+
+	server := httptest.NewUnstartedServer(engine)
+	server.Config.WriteTimeout = writeTimeout
+	server.Config.ConnContext = middlewares.ConnContext
+	server.Start()
+	defer server.Close()
+
+	// the response written after the write timeout is cut off
+	if resp, err := http.Get(server.URL + "/slow"); err == nil {
+		resp.Body.Close()
+		t.Errorf("response of the slow route = %d, want cut off", resp.StatusCode)
+	}
+
+	resp, err := http.Get(server.URL + "/export")
+	if assert.Nil(t, err) {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "exported", string(body))
+	}
+}
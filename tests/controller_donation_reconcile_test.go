@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestReconcilePayingDonations(t *testing.T) {
+	// record status of the orders on tap pay, the orders without records are not found on tap pay
+	recordStatuses := map[string]int{
+		"reconcile-prime-paid":    1,
+		"reconcile-prime-pending": 4,
+		"reconcile-token-paid":    1,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filters struct {
+				OrderNumber string `json:"order_number"`
+			} `json:"filters"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		w.Header().Set("Content-Type", "application/json")
+		status, ok := recordStatuses[body.Filters.OrderNumber]
+		if !ok {
+			w.Write([]byte(`{"status":0,"msg":"Success","trade_records":[]}`))
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"status":0,"msg":"Success","trade_records":[{"record_status":%d,"rec_trade_id":"D20260101reconcile","order_number":"%s","amount":500,"currency":"TWD","time":1767225600000}]}`, status, body.Filters.OrderNumber)))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	reconcilerConf := globals.Conf.DonationReconciler
+	defer func() {
+		globals.Conf.Donation = donationConf
+		globals.Conf.DonationReconciler = reconcilerConf
+	}()
+	globals.Conf.Donation.TapPayRecordURL = server.URL
+	globals.Conf.DonationReconciler.StaleAfter = time.Hour
+	globals.Conf.DonationReconciler.BatchSize = 100
+
+	const donorEmail = "reconcile-donor@twreporter.org"
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+
+	stale := time.Now().Add(-2 * time.Hour)
+	createPrimeDonation := func(orderNumber string, createdAt time.Time) models.PayByPrimeDonation {
+		d := models.PayByPrimeDonation{
+			Amount:      500,
+			CreatedAt:   createdAt,
+			Currency:    "TWD",
+			Details:     "一般線上單筆捐款",
+			MerchantID:  "twreporter_CTBC",
+			OrderNumber: orderNumber,
+			PayMethod:   "credit_card",
+			Status:      "paying",
+			UserID:      user.ID,
+		}
+		d.Cardholder.Email = donorEmail
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+		return d
+	}
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+
+	paid := createPrimeDonation("reconcile-prime-paid", stale)
+	pending := createPrimeDonation("reconcile-prime-pending", stale)
+	lost := createPrimeDonation("reconcile-prime-lost", stale)
+	recent := createPrimeDonation("reconcile-prime-recent", time.Now())
+
+	pd := models.PeriodicDonation{
+		Amount:        500,
+		Currency:      "TWD",
+		Details:       "一般線上定期定額捐款",
+		Frequency:     "monthly",
+		LastSuccessAt: null.TimeFrom(stale.AddDate(0, -1, 0)),
+		OrderNumber:   "reconcile-periodic",
+		Status:        "paying",
+		UserID:        user.ID,
+	}
+	pd.Cardholder.Email = donorEmail
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+
+	td := models.PayByCardTokenDonation{
+		Amount:      500,
+		CreatedAt:   stale,
+		Currency:    "TWD",
+		Details:     "一般線上定期定額捐款",
+		MerchantID:  "twreporter_CTBC",
+		OrderNumber: "reconcile-token-paid",
+		PeriodicID:  pd.ID,
+		Status:      "paying",
+	}
+	assert.Nil(t, Globs.GormDB.Create(&td).Error)
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PayByCardTokenDonation{})
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	assert.Nil(t, cf.GetMembershipController().ReconcilePayingDonations(context.Background()))
+
+	primeStatus := func(d models.PayByPrimeDonation) models.PayByPrimeDonation {
+		var r models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", d.ID).First(&r)
+		return r
+	}
+
+	p := primeStatus(paid)
+	assert.Equal(t, "paid", p.Status)
+	assert.Equal(t, "D20260101reconcile", p.RecTradeID)
+	assert.Equal(t, "paying", primeStatus(pending).Status)
+	assert.Equal(t, "fail", primeStatus(lost).Status)
+	assert.Equal(t, "paying", primeStatus(recent).Status)
+
+	var token models.PayByCardTokenDonation
+	Globs.GormDB.Where("id = ?", td.ID).First(&token)
+	assert.Equal(t, "paid", token.Status)
+
+	var periodic models.PeriodicDonation
+	Globs.GormDB.Where("id = ?", pd.ID).First(&periodic)
+	assert.Equal(t, "paid", periodic.Status)
+	assert.Equal(t, uint(0), periodic.ChargeFailures)
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+)
+
+func TestGetDonationSummaryOfAUser(t *testing.T) {
+	const donorEmail = "donation-summary-donor@twreporter.org"
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	authorization, _ := helperSetupAuth(user)
+
+	paidAt := time.Now().Add(-time.Hour)
+
+	// the prime donation refunded partially
+	d := models.PayByPrimeDonation{
+		Amount:      testAmount,
+		Currency:    testCurrency,
+		Details:     testDetails,
+		MerchantID:  testCreditCardMerchant,
+		OrderNumber: "donation-summary-prime",
+		PayMethod:   creditCardPayMethod,
+		Status:      statusPaid,
+		UserID:      user.ID,
+	}
+	d.TransactionTime = null.TimeFrom(paidAt)
+	d.Cardholder.Email = donorEmail
+	assert.Nil(t, Globs.GormDB.Create(&d).Error)
+	defer Globs.GormDB.Unscoped().Delete(&d)
+
+	r := models.DonationRefund{
+		DonationType: "prime",
+		DonationID:   d.ID,
+		OrderNumber:  d.OrderNumber,
+		Amount:       100,
+		Status:       "refunded",
+		OperatorID:   user.ID,
+	}
+	assert.Nil(t, Globs.GormDB.Create(&r).Error)
+	defer Globs.GormDB.Delete(&r)
+
+	pd := models.PeriodicDonation{
+		Amount:        300,
+		Currency:      testCurrency,
+		Details:       testDetails,
+		Frequency:     "monthly",
+		LastSuccessAt: null.TimeFrom(paidAt),
+		OrderNumber:   "donation-summary-periodic",
+		Status:        statusPaid,
+		UserID:        user.ID,
+	}
+	pd.Cardholder.Email = donorEmail
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PayByCardTokenDonation{})
+
+	for i, status := range []string{statusPaid, statusFail} {
+		td := models.PayByCardTokenDonation{
+			Amount:      300,
+			Currency:    testCurrency,
+			Details:     testDetails,
+			MerchantID:  testCreditCardMerchant,
+			OrderNumber: fmt.Sprintf("donation-summary-token-%d", i),
+			PeriodicID:  pd.ID,
+			Status:      status,
+		}
+		td.TransactionTime = null.TimeFrom(paidAt)
+		assert.Nil(t, Globs.GormDB.Create(&td).Error)
+	}
+
+	// only the user could read the summary
+	resp := serveHTTP(http.MethodGet, fmt.Sprintf("/v1/users/%d/donations/summary", user.ID+1), "", "", authorization)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = serveHTTP(http.MethodGet, fmt.Sprintf("/v1/users/%d/donations/summary", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var res struct {
+		Data struct {
+			Lifetime struct {
+				Amount uint `json:"amount"`
+				Count  int  `json:"count"`
+			} `json:"lifetime"`
+			Years []struct {
+				Year             int  `json:"year"`
+				Amount           uint `json:"amount"`
+				Count            int  `json:"count"`
+				ReceiptAvailable bool `json:"receipt_available"`
+			} `json:"years"`
+			PeriodicDonations []struct {
+				OrderNumber  string    `json:"order_number"`
+				NextChargeAt null.Time `json:"next_charge_at"`
+			} `json:"periodic_donations"`
+			NextRole *struct {
+				Key string `json:"key"`
+			} `json:"next_role"`
+			OfflineMerged bool `json:"offline_merged"`
+		} `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &res))
+
+	assert.Equal(t, uint(testAmount-100+300), res.Data.Lifetime.Amount)
+	assert.Equal(t, 2, res.Data.Lifetime.Count)
+	if assert.Equal(t, 1, len(res.Data.Years)) {
+		assert.Equal(t, uint(testAmount-100+300), res.Data.Years[0].Amount)
+		assert.True(t, res.Data.Years[0].ReceiptAvailable)
+	}
+	if assert.Equal(t, 1, len(res.Data.PeriodicDonations)) {
+		assert.Equal(t, pd.OrderNumber, res.Data.PeriodicDonations[0].OrderNumber)
+		assert.WithinDuration(t, paidAt.AddDate(0, 1, 0), res.Data.PeriodicDonations[0].NextChargeAt.Time, time.Second)
+	}
+	assert.NotNil(t, res.Data.NextRole)
+	assert.False(t, res.Data.OfflineMerged)
+}
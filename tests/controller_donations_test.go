@@ -1166,6 +1166,16 @@ func TestLinePayNotify(t *testing.T) {
 	startTransactionTime := time.Now()
 	endTransactionTime := startTransactionTime.Add(30 * time.Second)
 
+	// the notification is verified against the record of tap pay
+	recordStatus := 1
+	server := newTapPayRecordServer(func() string {
+		return fmt.Sprintf(`{"record_status":%d,"rec_trade_id":"%s","order_number":"%s","amount":%d,"currency":"TWD"}`, recordStatus, testRecTradeID, testOrderNumber, testAmount)
+	})
+	defer server.Close()
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayRecordURL = server.URL
+
 	user := createUser(testDonorEmail)
 	defer func() { deleteUser(user) }()
 	record := models.PayByPrimeDonation{
@@ -1347,6 +1357,11 @@ func TestLinePayNotify(t *testing.T) {
 
 			reqBodyInBytes, _ = json.Marshal(&c.reqBody)
 
+			recordStatus = 1
+			if c.reqBody.Status != 0 {
+				recordStatus = -1
+			}
+
 			resp := serveHTTP("POST", notifyPath, string(reqBodyInBytes), "application/json", "")
 
 			assert.Equal(t, c.resultCode, resp.Code)
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func serveHTTPWithIdempotencyKey(path, body, key, authorization string, cookie http.Cookie) *httptest.ResponseRecorder {
+	req := requestWithBody(http.MethodPost, path, body)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", authorization)
+	req.Header.Add("Idempotency-Key", key)
+	req.AddCookie(&cookie)
+
+	resp := httptest.NewRecorder()
+	Globs.GinEngine.ServeHTTP(resp, req)
+
+	return resp
+}
+
+func TestCreateADonationWithIdempotencyKey(t *testing.T) {
+	var charges int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		charges++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"status":0,"msg":"Success","rec_trade_id":"D2026010100%d","bank_transaction_id":"TP2026010100%d","transaction_time_millis":1767225600000,"card_info":{"bin_code":"424242","last_four":"4242","expiry_date":"203012","type":1}}`, charges, charges)))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayURL = server.URL
+
+	const donorEmail = "idempotency-donor@twreporter.org"
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.IdempotencyKey{})
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+	authorization, cookie := helperSetupAuth(user)
+
+	const path = "/v1/donations/prime"
+	reqBody := func(amount uint) string {
+		b, _ := json.Marshal(requestBody{
+			Amount: amount,
+			Cardholder: models.Cardholder{
+				Email: donorEmail,
+				Name:  null.StringFrom(testName),
+			},
+			Details:    testDetails,
+			MerchantID: testCreditCardMerchant,
+			PayMethod:  creditCardPayMethod,
+			Prime:      testCreditCardPrime,
+			UserID:     user.ID,
+		})
+		return string(b)
+	}
+	orderNumber := func(resp *httptest.ResponseRecorder) string {
+		var res responseBody
+		json.Unmarshal(resp.Body.Bytes(), &res)
+		return res.Data.OrderNumber
+	}
+
+	resp := serveHTTPWithIdempotencyKey(path, reqBody(testAmount), "", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, 1, charges)
+
+	resp = serveHTTPWithIdempotencyKey(path, reqBody(testAmount), "donation-key-1", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, 2, charges)
+	order := orderNumber(resp)
+
+	// the retried request is responded without being charged again
+	resp = serveHTTPWithIdempotencyKey(path, reqBody(testAmount), "donation-key-1", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "true", resp.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, order, orderNumber(resp))
+	assert.Equal(t, 2, charges)
+
+	// the key could not be used by a different request
+	resp = serveHTTPWithIdempotencyKey(path, reqBody(testAmount+100), "donation-key-1", authorization, cookie)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.Equal(t, 2, charges)
+
+	// the key of the rejected request could be used again
+	resp = serveHTTPWithIdempotencyKey(path, `{"user_id":`+fmt.Sprint(user.ID)+`}`, "donation-key-2", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = serveHTTPWithIdempotencyKey(path, reqBody(testAmount), "donation-key-2", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.NotEqual(t, order, orderNumber(resp))
+	assert.Equal(t, 3, charges)
+
+	// the keys are case sensitive
+	resp = serveHTTPWithIdempotencyKey(path, reqBody(testAmount), "DONATION-KEY-1", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.NotEqual(t, order, orderNumber(resp))
+	assert.Equal(t, 4, charges)
+
+	// the trailing spaces are not allowed
+	resp = serveHTTPWithIdempotencyKey(path, reqBody(testAmount), "donation-key-1 ", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, 4, charges)
+}
+
+func TestIdempotencyKeyPurgeJob(t *testing.T) {
+	user := createUser("idempotency-key-purge@twreporter.org")
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.IdempotencyKey{})
+
+	for key, expiresAt := range map[string]time.Time{"expired": time.Now().Add(-time.Minute), "valid": time.Now().Add(time.Hour)} {
+		k := models.IdempotencyKey{
+			UserID:      user.ID,
+			Route:       "/v1/donations/prime",
+			Key:         key,
+			RequestHash: "hash",
+			ExpiresAt:   expiresAt,
+		}
+		assert.Nil(t, Globs.GormDB.Create(&k).Error)
+	}
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	assert.Nil(t, cf.GetMembershipController().IdempotencyKeyPurgeJob().Run(context.Background()))
+
+	// only the key passing the expiration is deleted
+	var keys []models.IdempotencyKey
+	assert.Nil(t, Globs.GormDB.Where("user_id = ?", user.ID).Find(&keys).Error)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "valid", keys[0].Key)
+	}
+}
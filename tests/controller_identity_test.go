@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+type identitiesResBody struct {
+	Status string `json:"status"`
+	Data   struct {
+		UserID     uint `json:"user_id"`
+		Identities []struct {
+			Provider string      `json:"provider"`
+			Email    null.String `json:"email"`
+		} `json:"identities"`
+	} `json:"data"`
+}
+
+func getIdentities(t *testing.T, user models.User) identitiesResBody {
+	var resBody identitiesResBody
+
+	resp := serveHTTP(http.MethodGet, fmt.Sprintf("/v2/users/%d/identities", user.ID), "", "", fmt.Sprintf("Bearer %s", generateIDToken(user)))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resBodyInBytes, _ := ioutil.ReadAll(resp.Result().Body)
+	json.Unmarshal(resBodyInBytes, &resBody)
+
+	return resBody
+}
+
+func countIdentityAuditLogs(userID uint, action string) (count int) {
+	Globs.GormDB.Model(&models.IdentityAuditLog{}).Where("user_id = ? AND action = ?", userID, action).Count(&count)
+	return
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	const email = "identity-unlink@twreporter.org"
+	as := storage.NewGormStorage(Globs.GormDB)
+	user := createUser(email)
+	defer deleteUser(user)
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(user))
+
+	resBody := getIdentities(t, user)
+	assert.Equal(t, user.ID, resBody.Data.UserID)
+	assert.Len(t, resBody.Data.Identities, 1)
+	assert.Equal(t, "email", resBody.Data.Identities[0].Provider)
+
+	// the last login method
+	resp := serveHTTP(http.MethodDelete, fmt.Sprintf("/v2/users/%d/identities/email", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	// not linked
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("/v2/users/%d/identities/facebook", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// unknown provider
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("/v2/users/%d/identities/twitter", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	err := as.InsertOAuthAccount(models.OAuthAccount{
+		UserID: user.ID,
+		Type:   globals.GoogleOAuth,
+		AId:    null.StringFrom("identity-unlink-google-id"),
+		Email:  null.StringFrom(email),
+	})
+	assert.Nil(t, err)
+	assert.Len(t, getIdentities(t, user).Data.Identities, 2)
+
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("/v2/users/%d/identities/email", user.ID), "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resBody = getIdentities(t, user)
+	assert.Len(t, resBody.Data.Identities, 1)
+	assert.Equal(t, "google", resBody.Data.Identities[0].Provider)
+	assert.Equal(t, 1, countIdentityAuditLogs(user.ID, models.IdentityActionUnlink))
+
+	// another user is not allowed
+	other := createUser("identity-unlink-other@twreporter.org")
+	defer deleteUser(other)
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("/v2/users/%d/identities/google", user.ID), "", "", fmt.Sprintf("Bearer %s", generateIDToken(other)))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestVerifyEmailLink(t *testing.T) {
+	const email = "identity-link@twreporter.org"
+	const otpCode = "123456"
+	as := storage.NewGormStorage(Globs.GormDB)
+
+	user, err := as.InsertUserByOAuth(models.OAuthAccount{
+		Type:  globals.FacebookOAuth,
+		AId:   null.StringFrom("identity-link-facebook-id"),
+		Email: null.StringFrom("identity-link-fb@twreporter.org"),
+	})
+	assert.Nil(t, err)
+	defer Globs.GormDB.Unscoped().Delete(&models.ReporterAccount{}, "email = ?", email)
+	defer Globs.GormDB.Unscoped().Delete(&user)
+	defer Globs.GormDB.Unscoped().Delete(&models.OAuthAccount{}, "user_id = ?", user.ID)
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(user))
+	path := fmt.Sprintf("/v2/users/%d/identities/email/verify", user.ID)
+
+	// no pending request
+	resp := serveHTTP(http.MethodPost, path, fmt.Sprintf(`{"email":"%s","otp_code":"%s"}`, email, otpCode), "application/json", authorization)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	err = as.CreateEmailLinkRequest(models.EmailLinkRequest{
+		UserID:      user.ID,
+		Email:       email,
+		OtpCodeHash: utils.HashOTPCode(email, otpCode),
+		OtpExpTime:  time.Now().Add(time.Duration(15) * time.Minute),
+	})
+	assert.Nil(t, err)
+
+	resp = serveHTTP(http.MethodPost, path, fmt.Sprintf(`{"email":"%s","otp_code":"000000"}`, email), "application/json", authorization)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = serveHTTP(http.MethodPost, path, fmt.Sprintf(`{"email":"%s","otp_code":"%s"}`, email, otpCode), "application/json", authorization)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, user.ID, getReporterAccount(email).UserID)
+	assert.Equal(t, 1, countIdentityAuditLogs(user.ID, models.IdentityActionLink))
+	assert.Len(t, getIdentities(t, user).Data.Identities, 2)
+
+	// the request is consumed
+	resp = serveHTTP(http.MethodPost, path, fmt.Sprintf(`{"email":"%s","otp_code":"%s"}`, email, otpCode), "application/json", authorization)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+// newTapPayRecordServer stubs the record api of tap pay with the trade record returned by record,
+// and no record is found if it returns empty string
+func newTapPayRecordServer(record func() string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if tr := record(); tr != "" {
+			w.Write([]byte(fmt.Sprintf(`{"status":0,"msg":"Success","trade_records":[%s]}`, tr)))
+			return
+		}
+		w.Write([]byte(`{"status":0,"msg":"Success","trade_records":[]}`))
+	}))
+}
+
+func TestLinePayNotifyVerification(t *testing.T) {
+	const (
+		donorEmail        = "line-notify@twreporter.org"
+		orderNumber       = "line-notify-order"
+		recTradeID        = "LN20260101verify"
+		bankTransactionID = "TP20260101verify"
+		notifyPath        = "/v1/donations/prime/line-notify"
+	)
+
+	tradeRecord := ""
+	server := newTapPayRecordServer(func() string { return tradeRecord })
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayRecordURL = server.URL
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+
+	d := models.PayByPrimeDonation{
+		Amount:      testAmount,
+		Currency:    testCurrency,
+		Details:     testDetails,
+		MerchantID:  testLineMerchant,
+		OrderNumber: orderNumber,
+		PayMethod:   linePayMethod,
+		Status:      statusPaying,
+		UserID:      user.ID,
+		TappayResp: models.TappayResp{
+			RecTradeID:        recTradeID,
+			BankTransactionID: bankTransactionID,
+		},
+	}
+	d.Cardholder.Email = donorEmail
+	assert.Nil(t, Globs.GormDB.Create(&d).Error)
+	defer Globs.GormDB.Unscoped().Delete(&d)
+	defer Globs.GormDB.Where("order_number = ?", orderNumber).Delete(&models.TapPayNotification{})
+
+	reqBody, _ := json.Marshal(tapPayRequestBody{
+		RecTradeID:        recTradeID,
+		BankTransactionID: bankTransactionID,
+		OrderNumber:       orderNumber,
+		Amount:            testAmount,
+		PayInfo: models.PayInfo{
+			Method:                 null.StringFrom("CREDIT_CARD"),
+			MaskedCreditCardNumber: null.StringFrom("************5566"),
+		},
+	})
+	status := func() string {
+		var r models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", d.ID).First(&r)
+		return r.Status
+	}
+
+	// the forged callback without the record of tap pay is rejected
+	resp := serveHTTP(http.MethodPost, notifyPath, string(reqBody), "application/json", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal(t, statusPaying, status())
+
+	// the callback from the IP not allowed is rejected
+	tradeRecord = fmt.Sprintf(`{"record_status":1,"rec_trade_id":"%s","order_number":"%s","amount":%d,"currency":"TWD"}`, recTradeID, orderNumber, testAmount)
+	globals.Conf.Donation.TapPayNotifyAllowedIPs = []string{"203.0.113.0/24"}
+	resp = serveHTTP(http.MethodPost, notifyPath, string(reqBody), "application/json", "")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, statusPaying, status())
+
+	notify := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := requestWithBody(http.MethodPost, notifyPath, string(reqBody))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("X-Forwarded-For", forwardedFor)
+		req.RemoteAddr = remoteAddr
+
+		resp := httptest.NewRecorder()
+		Globs.GinEngine.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// the forwarded IP given by the untrusted peer is ignored
+	resp = notify("198.51.100.1:443", "203.0.113.10")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, statusPaying, status())
+
+	// the forwarded IP is taken from the trusted proxy
+	globals.Conf.Donation.TapPayNotifyTrustedProxies = []string{"198.51.100.0/24"}
+	defer func() { globals.Conf.Donation.TapPayNotifyTrustedProxies = nil }()
+	resp = notify("198.51.100.1:443", "192.0.2.1, 203.0.113.10")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, statusPaid, status())
+
+	// the replayed callback takes no effect on the finalised donation
+	tradeRecord = fmt.Sprintf(`{"record_status":-1,"rec_trade_id":"%s","order_number":"%s","amount":%d,"currency":"TWD"}`, recTradeID, orderNumber, testAmount)
+	resp = notify("198.51.100.1:443", "203.0.113.10")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, statusPaid, status())
+	globals.Conf.Donation.TapPayNotifyAllowedIPs = nil
+
+	var notifications []models.TapPayNotification
+	Globs.GormDB.Where("order_number = ?", orderNumber).Order("id").Find(&notifications)
+	if assert.Equal(t, 3, len(notifications)) {
+		assert.Equal(t, http.StatusUnprocessableEntity, notifications[0].ResponseStatus)
+		assert.Equal(t, string(reqBody), notifications[0].Payload)
+		assert.Equal(t, "203.0.113.10", notifications[1].RemoteIP)
+		assert.Equal(t, "donation is already paid", notifications[2].Note)
+	}
+}
+
+func TestValidateTapPayNotifyIPs(t *testing.T) {
+	environment := globals.Conf.Environment
+	donationConf := globals.Conf.Donation
+	defer func() {
+		globals.Conf.Environment = environment
+		globals.Conf.Donation = donationConf
+	}()
+
+	globals.Conf.Donation.TapPayNotifyAllowedIPs = nil
+	globals.Conf.Environment = "development"
+	assert.Nil(t, controllers.ValidateTapPayNotifyIPs())
+
+	// the notifications are not accepted from any IP outside development
+	globals.Conf.Environment = "production"
+	assert.NotNil(t, controllers.ValidateTapPayNotifyIPs())
+
+	globals.Conf.Donation.TapPayNotifyAllowedIPs = []string{"203.0.113.0/24", "198.51.100.1"}
+	assert.Nil(t, controllers.ValidateTapPayNotifyIPs())
+
+	globals.Conf.Donation.TapPayNotifyTrustedProxies = []string{"proxy.internal"}
+	assert.NotNil(t, controllers.ValidateTapPayNotifyIPs())
+}
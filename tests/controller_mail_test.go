@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/twreporter/go-api/globals"
@@ -38,6 +40,32 @@ func TestSendActivation(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, resp.Code)
 	})
 
+	// the token issued by the replica of the last release during the rolling deploy
+	t.Run("StatusCode=StatusNoContent with legacy subject", func(t *testing.T) {
+		legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Second * time.Duration(expire)).Unix(),
+			Issuer:    globals.Conf.App.JwtIssuer,
+			Audience:  globals.Conf.App.JwtAudience,
+			Subject:   utils.AccessTokenSubject,
+		}).SignedString([]byte(globals.MailServiceJWTPrefix + globals.Conf.App.JwtSecret))
+
+		reqBody["email"] = Globs.Defaults.Account
+		reqBody["activate_link"] = "test-activate-link"
+		bodyBytes, _ = json.Marshal(reqBody)
+		resp = serveHTTP("POST", fmt.Sprintf("/v1/%s", globals.SendActivationRoutePath), string(bodyBytes), "application/json", fmt.Sprintf("Bearer %s", legacy))
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		// the user tokens are not signed by the secret of the mail service
+		user, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Second * time.Duration(expire)).Unix(),
+			Issuer:    globals.Conf.App.JwtIssuer,
+			Audience:  globals.Conf.App.JwtAudience,
+			Subject:   utils.AccessTokenSubject,
+		}).SignedString([]byte(globals.Conf.App.JwtSecret))
+		resp = serveHTTP("POST", fmt.Sprintf("/v1/%s", globals.SendActivationRoutePath), string(bodyBytes), "application/json", fmt.Sprintf("Bearer %s", user))
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
 	t.Run("StatusCode=StatusBadRequest", func(t *testing.T) {
 		// =====================================
 		// Error situation:
@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs"
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestCreateAnOfflinePaymentDonation(t *testing.T) {
+	const (
+		donorEmail        = "offline-donor@twreporter.org"
+		recTradeID        = "D20260101offline"
+		bankTransactionID = "TP20260101offline"
+		paymentCode       = "99012345678901"
+		bankCode          = "812"
+		notifyPath        = "/v1/donations/prime/offline-notify"
+	)
+
+	expiresAt := time.Now().Add(72 * time.Hour).Truncate(time.Second)
+	var payReq map[string]interface{}
+	payServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payReq)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"status":0,"msg":"Success","rec_trade_id":"%s","bank_transaction_id":"%s","transaction_time_millis":1767225600000,"payment_code":"%s","bank_code":"%s","expire_time_millis":%d}`,
+			recTradeID, bankTransactionID, paymentCode, bankCode, expiresAt.Unix()*1000)))
+	}))
+	defer payServer.Close()
+
+	var orderNumber string
+	tradeRecord := func() string {
+		return fmt.Sprintf(`{"record_status":1,"rec_trade_id":"%s","order_number":"%s","amount":%d,"currency":"TWD","time":1767225600000}`, recTradeID, orderNumber, testAmount)
+	}
+	recordServer := newTapPayRecordServer(tradeRecord)
+	defer recordServer.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayURL = payServer.URL
+	globals.Conf.Donation.TapPayRecordURL = recordServer.URL
+	globals.Conf.Donation.Currencies = nil
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+	authorization, cookie := helperSetupAuth(user)
+
+	reqBody, _ := json.Marshal(requestBody{
+		Amount: testAmount,
+		Cardholder: models.Cardholder{
+			Email: donorEmail,
+			Name:  null.StringFrom(testName),
+		},
+		Details:   testDetails,
+		PayMethod: "atm",
+		Prime:     testCreditCardPrime,
+		UserID:    user.ID,
+	})
+
+	// no merchant of atm is configured
+	resp := serveHTTPWithCookies(http.MethodPost, "/v1/donations/prime", string(reqBody), "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	globals.Conf.Donation.Currencies = map[string]configs.CurrencyConfig{
+		"TWD": {TWDRate: 1, MinAmount: 1, MerchantIDs: map[string]string{"atm": "twreporter_ATM"}},
+	}
+	resp = serveHTTPWithCookies(http.MethodPost, "/v1/donations/prime", string(reqBody), "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "twreporter_ATM", payReq["merchant_id"])
+
+	var res struct {
+		Data struct {
+			OrderNumber      string    `json:"order_number"`
+			PayMethod        string    `json:"pay_method"`
+			PaymentCode      string    `json:"payment_code"`
+			PaymentBankCode  string    `json:"payment_bank_code"`
+			PaymentExpiresAt time.Time `json:"payment_expires_at"`
+		} `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &res)
+	orderNumber = res.Data.OrderNumber
+	assert.Equal(t, "atm", res.Data.PayMethod)
+	assert.Equal(t, paymentCode, res.Data.PaymentCode)
+	assert.Equal(t, bankCode, res.Data.PaymentBankCode)
+	assert.True(t, expiresAt.Equal(res.Data.PaymentExpiresAt))
+
+	// tap pay is told where to notify the payment and when the payment code expires
+	resultURL, _ := payReq["result_url"].(map[string]interface{})
+	assert.Contains(t, resultURL["backend_notify_url"], notifyPath)
+	assert.NotZero(t, payReq["expire_time_millis"])
+
+	var d models.PayByPrimeDonation
+	Globs.GormDB.Where("order_number = ?", orderNumber).First(&d)
+	defer Globs.GormDB.Where("order_number = ?", orderNumber).Delete(&models.TapPayNotification{})
+	assert.Equal(t, statusPaying, d.Status)
+	assert.Equal(t, paymentCode, d.PaymentCode.ValueOrZero())
+	assert.True(t, d.PaymentExpiresAt.Valid)
+
+	notification, _ := json.Marshal(tapPayRequestBody{
+		RecTradeID:        recTradeID,
+		BankTransactionID: bankTransactionID,
+		OrderNumber:       orderNumber,
+		Amount:            testAmount,
+	})
+
+	// the offline payment is not finalised through the line pay notification
+	resp = serveHTTP(http.MethodPost, "/v1/donations/prime/line-notify", string(notification), "application/json", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+	resp = serveHTTP(http.MethodPost, notifyPath, string(notification), "application/json", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	Globs.GormDB.Where("id = ?", d.ID).First(&d)
+	assert.Equal(t, statusPaid, d.Status)
+}
+
+func TestReconcileExpiredOfflinePayments(t *testing.T) {
+	server := newTapPayRecordServer(func() string {
+		// the payment code is never paid
+		return `{"record_status":4,"rec_trade_id":"D20260101expired","amount":500,"currency":"TWD"}`
+	})
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	reconcilerConf := globals.Conf.DonationReconciler
+	defer func() {
+		globals.Conf.Donation = donationConf
+		globals.Conf.DonationReconciler = reconcilerConf
+	}()
+	globals.Conf.Donation.TapPayRecordURL = server.URL
+	globals.Conf.DonationReconciler.StaleAfter = time.Hour
+	globals.Conf.DonationReconciler.BatchSize = 100
+
+	const donorEmail = "offline-expired@twreporter.org"
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+
+	stale := time.Now().Add(-96 * time.Hour)
+	createOfflineDonation := func(orderNumber string, expiresAt time.Time) models.PayByPrimeDonation {
+		d := models.PayByPrimeDonation{
+			Amount:           testAmount,
+			CreatedAt:        stale,
+			Currency:         testCurrency,
+			Details:          testDetails,
+			MerchantID:       "twreporter_CVS",
+			OrderNumber:      orderNumber,
+			PayMethod:        "cvs",
+			Status:           statusPaying,
+			UserID:           user.ID,
+			PaymentCode:      null.StringFrom("LLL12345678"),
+			PaymentExpiresAt: null.TimeFrom(expiresAt),
+		}
+		d.Cardholder.Email = donorEmail
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+		return d
+	}
+
+	expired := createOfflineDonation("offline-expired", time.Now().Add(-time.Hour))
+	unexpired := createOfflineDonation("offline-unexpired", time.Now().Add(time.Hour))
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	assert.Nil(t, cf.GetMembershipController().ReconcilePayingDonations(context.Background()))
+
+	status := func(d models.PayByPrimeDonation) string {
+		var r models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", d.ID).First(&r)
+		return r.Status
+	}
+
+	assert.Equal(t, statusFail, status(expired))
+	assert.Equal(t, statusPaying, status(unexpired))
+}
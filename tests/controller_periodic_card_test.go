@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestUpdatePeriodicDonationCard(t *testing.T) {
+	tapPayResp := `{"status":0,"msg":"Success","rec_trade_id":"D20260101abcdef","bank_transaction_id":"TP20260101abcdef","card_secret":{"card_token":"new-card-token","card_key":"new-card-key"},"card_info":{"bin_code":"424242","last_four":"4242","expiry_date":"203012","type":1}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(tapPayResp))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayURL = server.URL
+	globals.Conf.Donation.TapPayBindCardURL = server.URL
+
+	const donorEmail = "periodic-card@twreporter.org"
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	authorization, cookie := helperSetupAuth(user)
+
+	pd := models.PeriodicDonation{
+		Amount:        300,
+		CardToken:     "encrypted-card-token-of-the-donor",
+		CardKey:       "encrypted-card-key-of-the-donor",
+		Currency:      "TWD",
+		Details:       "一般線上定期定額捐款",
+		Frequency:     "monthly",
+		LastSuccessAt: null.TimeFrom(time.Now()),
+		OrderNumber:   "periodic-card-order",
+		Status:        "paid",
+		UserID:        user.ID,
+	}
+	pd.Cardholder.Email = donorEmail
+	pd.CardInfo.LastFour = null.StringFrom("1111")
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PayByCardTokenDonation{})
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PeriodicDonationHistory{})
+
+	path := periodicOrderPathPrefix + pd.OrderNumber + "/card"
+	reload := func() models.PeriodicDonation {
+		var d models.PeriodicDonation
+		Globs.GormDB.Where("id = ?", pd.ID).First(&d)
+		return d
+	}
+	countCharges := func(status string) int {
+		var count int
+		Globs.GormDB.Model(&models.PayByCardTokenDonation{}).Where("periodic_id = ? AND status = ?", pd.ID, status).Count(&count)
+		return count
+	}
+
+	resp := serveHTTPWithCookies(http.MethodPut, path, `{}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// the card of the paid donation is bound without charge
+	resp = serveHTTPWithCookies(http.MethodPut, path, `{"prime":"test-prime"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	d := reload()
+	assert.Equal(t, "4242", d.CardInfo.LastFour.ValueOrZero())
+	assert.Equal(t, "203012", d.CardInfo.ExpiryDate.ValueOrZero())
+	assert.NotEqual(t, pd.CardToken, d.CardToken)
+	assert.Equal(t, 0, countCharges("paid"))
+
+	// the invalid donation is charged by the new card at once
+	Globs.GormDB.Model(&pd).UpdateColumns(map[string]interface{}{"status": "invalid", "card_info_last_four": "1111"})
+	resp = serveHTTPWithCookies(http.MethodPut, path, `{"prime":"test-prime"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	d = reload()
+	assert.Equal(t, "paid", d.Status)
+	assert.Equal(t, "4242", d.CardInfo.LastFour.ValueOrZero())
+	assert.Equal(t, 1, countCharges("paid"))
+
+	// the card is kept if the charge fails
+	tapPayResp = `{"status":10003,"msg":"Card Error"}`
+	Globs.GormDB.Model(&pd).UpdateColumns(map[string]interface{}{"status": "fail", "card_info_last_four": "1111"})
+	resp = serveHTTPWithCookies(http.MethodPut, path, `{"prime":"test-prime"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	d = reload()
+	assert.Equal(t, "fail", d.Status)
+	assert.Equal(t, "1111", d.CardInfo.LastFour.ValueOrZero())
+	assert.Equal(t, 1, countCharges("fail"))
+
+	// the stopped donation could not be updated
+	Globs.GormDB.Model(&pd).UpdateColumn("status", "stopped")
+	resp = serveHTTPWithCookies(http.MethodPut, path, `{"prime":"test-prime"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	var histories int
+	Globs.GormDB.Model(&models.PeriodicDonationHistory{}).Where("periodic_id = ? AND action = ?", pd.ID, "update_card").Count(&histories)
+	assert.Equal(t, 2, histories)
+}
+
+func TestRemindExpiringCards(t *testing.T) {
+	reminderConf := globals.Conf.CardExpiryReminder
+	defer func() { globals.Conf.CardExpiryReminder = reminderConf }()
+	globals.Conf.CardExpiryReminder.BatchSize = 1
+
+	const donorEmail = "card-expiry@twreporter.org"
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+
+	location, _ := time.LoadLocation("Asia/Taipei")
+	now := time.Now().In(location)
+	nextMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location).AddDate(0, 1, 0).Format("200601")
+
+	var donations []models.PeriodicDonation
+	for i, expiryDate := range []string{nextMonth, nextMonth, "209912"} {
+		pd := models.PeriodicDonation{
+			Amount:      300,
+			CardToken:   "encrypted-card-token-of-the-donor",
+			CardKey:     "encrypted-card-key-of-the-donor",
+			Currency:    "TWD",
+			Details:     "一般線上定期定額捐款",
+			Frequency:   "monthly",
+			OrderNumber: "card-expiry-order-" + string(rune('a'+i)),
+			Status:      "paid",
+			UserID:      user.ID,
+		}
+		pd.Cardholder.Email = donorEmail
+		pd.CardInfo.ExpiryDate = null.StringFrom(expiryDate)
+		assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+		defer Globs.GormDB.Unscoped().Delete(&pd)
+		donations = append(donations, pd)
+	}
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	mc := cf.GetMembershipController()
+
+	assert.Nil(t, mc.RemindExpiringCards(context.Background()))
+
+	reminded := func(pd models.PeriodicDonation) string {
+		var d models.PeriodicDonation
+		Globs.GormDB.Where("id = ?", pd.ID).First(&d)
+		return d.ExpiryReminded
+	}
+	assert.Equal(t, nextMonth, reminded(donations[0]))
+	assert.Equal(t, nextMonth, reminded(donations[1]))
+	assert.Equal(t, "", reminded(donations[2]))
+
+	// the donors are reminded once for a card
+	as := mc.Storage
+	expiring, err := as.GetPeriodicDonationsWithExpiringCards(nextMonth, 10)
+	assert.Nil(t, err)
+	for _, d := range expiring {
+		assert.NotEqual(t, user.ID, d.UserID)
+	}
+
+	// the default batch size is used if it is not configured
+	globals.Conf.CardExpiryReminder.BatchSize = 0
+	done := make(chan error, 1)
+	go func() { done <- mc.RemindExpiringCards(context.Background()) }()
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("RemindExpiringCards does not return with batch size 0")
+	}
+}
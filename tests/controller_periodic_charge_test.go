@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestChargeDuePeriodicDonations(t *testing.T) {
+	tapPayResp := `{"status":0,"msg":"Success","rec_trade_id":"D20260101abcdef","bank_transaction_id":"TP20260101abcdef","auth_code":"123456","acquirer":"TW_CTBC"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(tapPayResp))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	chargeConf := globals.Conf.PeriodicCharge
+	defer func() {
+		globals.Conf.Donation = donationConf
+		globals.Conf.PeriodicCharge = chargeConf
+	}()
+	globals.Conf.Donation.TapPayTokenURL = server.URL
+	globals.Conf.PeriodicCharge.BatchSize = 100
+	globals.Conf.PeriodicCharge.RetryBackoff = time.Hour
+	globals.Conf.PeriodicCharge.MaxFailures = 2
+
+	user := createUser("periodic-charge@twreporter.org")
+	defer deleteUser(user)
+
+	pd := models.PeriodicDonation{
+		Amount:        300,
+		CardToken:     "encrypted-card-token-of-the-donor",
+		CardKey:       "encrypted-card-key-of-the-donor",
+		Currency:      "TWD",
+		Details:       "一般線上定期定額捐款",
+		Frequency:     "monthly",
+		LastSuccessAt: null.TimeFrom(time.Now().AddDate(0, -1, -1)),
+		OrderNumber:   "periodic-charge-order",
+		Status:        "paid",
+		UserID:        user.ID,
+	}
+	pd.Cardholder.Email = "periodic-charge@twreporter.org"
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PayByCardTokenDonation{})
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	mc := cf.GetMembershipController()
+
+	countCharges := func(status string) int {
+		var count int
+		Globs.GormDB.Model(&models.PayByCardTokenDonation{}).Where("periodic_id = ? AND status = ?", pd.ID, status).Count(&count)
+		return count
+	}
+	reload := func() models.PeriodicDonation {
+		var d models.PeriodicDonation
+		Globs.GormDB.Where("id = ?", pd.ID).First(&d)
+		return d
+	}
+
+	assert.Nil(t, mc.ChargeDuePeriodicDonations(context.Background()))
+	assert.Equal(t, 1, countCharges("paid"))
+	d := reload()
+	assert.Equal(t, "paid", d.Status)
+	assert.True(t, d.LastSuccessAt.Time.After(time.Now().Add(-time.Minute)))
+
+	// the donation is not due until next month
+	assert.Nil(t, mc.ChargeDuePeriodicDonations(context.Background()))
+	assert.Equal(t, 1, countCharges("paid"))
+
+	// the card error is retried after the backoff
+	tapPayResp = `{"status":10003,"msg":"Card Error"}`
+	Globs.GormDB.Model(&pd).UpdateColumn("last_success_at", time.Now().AddDate(0, -1, -1))
+	assert.Nil(t, mc.ChargeDuePeriodicDonations(context.Background()))
+	d = reload()
+	assert.Equal(t, "fail", d.Status)
+	assert.Equal(t, uint(1), d.ChargeFailures)
+	assert.True(t, d.NextRetryAt.Time.After(time.Now()))
+	assert.Equal(t, 1, countCharges("fail"))
+
+	assert.Nil(t, mc.ChargeDuePeriodicDonations(context.Background()))
+	assert.Equal(t, 1, countCharges("fail"))
+
+	// the donation is stopped after repeated failures
+	Globs.GormDB.Model(&pd).UpdateColumn("next_retry_at", time.Now().Add(-time.Second))
+	assert.Nil(t, mc.ChargeDuePeriodicDonations(context.Background()))
+	d = reload()
+	assert.Equal(t, "stopped", d.Status)
+	assert.Equal(t, uint(2), d.ChargeFailures)
+	assert.Equal(t, 2, countCharges("fail"))
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+)
+
+func TestPeriodicDonationSelfService(t *testing.T) {
+	const donorEmail = "periodic-self-service@twreporter.org"
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	authorization, cookie := helperSetupAuth(user)
+
+	pd := models.PeriodicDonation{
+		Amount:        300,
+		CardToken:     "encrypted-card-token-of-the-donor",
+		CardKey:       "encrypted-card-key-of-the-donor",
+		Currency:      "TWD",
+		Details:       "一般線上定期定額捐款",
+		Frequency:     "monthly",
+		LastSuccessAt: null.TimeFrom(time.Now()),
+		OrderNumber:   "periodic-self-service-order",
+		Status:        "paid",
+		UserID:        user.ID,
+	}
+	pd.Cardholder.Email = donorEmail
+	assert.Nil(t, Globs.GormDB.Create(&pd).Error)
+	defer Globs.GormDB.Unscoped().Delete(&pd)
+	defer Globs.GormDB.Where("periodic_id = ?", pd.ID).Delete(&models.PeriodicDonationHistory{})
+
+	path := periodicOrderPathPrefix + pd.OrderNumber
+	reload := func() models.PeriodicDonation {
+		var d models.PeriodicDonation
+		Globs.GormDB.Where("id = ?", pd.ID).First(&d)
+		return d
+	}
+
+	// pause
+	resp := serveHTTPWithCookies(http.MethodPost, path+"/pause", `{"until":"2000-01-01T00:00:00Z"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	until := time.Now().AddDate(0, 2, 0).UTC().Format(time.RFC3339)
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/pause", fmt.Sprintf(`{"until":"%s","reason":"預算調整"}`, until), "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, reload().PausedUntil.Valid)
+
+	// resume
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/resume", "", "", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.False(t, reload().PausedUntil.Valid)
+
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/resume", "", "", authorization, cookie)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	// change
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/change", `{"amount":300}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/change", `{"amount":1000,"frequency":"yearly"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	d := reload()
+	assert.Equal(t, uint(1000), d.Amount)
+	assert.Equal(t, "yearly", d.Frequency)
+
+	// cancel
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/cancel", `{}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/cancel", `{"reason":"暫時無法負擔"}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	d = reload()
+	assert.Equal(t, "stopped", d.Status)
+	assert.Equal(t, "", d.CardToken)
+
+	// the cancelled donation could not be changed anymore
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/change", `{"amount":500}`, "application/json", authorization, cookie)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	// histories
+	resp = serveHTTPWithCookies(http.MethodGet, path+"/histories", "", "", authorization, cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var res struct {
+		Records []models.PeriodicDonationHistory `json:"records"`
+		Meta    models.MetaOfResponse            `json:"meta"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &res)
+	assert.Equal(t, 4, res.Meta.Total)
+	if assert.Equal(t, 4, len(res.Records)) {
+		assert.Equal(t, "cancel", res.Records[0].Action)
+		assert.Equal(t, "暫時無法負擔", res.Records[0].Reason.ValueOrZero())
+		assert.Equal(t, int64(300), res.Records[1].OldAmount.ValueOrZero())
+		assert.Equal(t, int64(1000), res.Records[1].NewAmount.ValueOrZero())
+		assert.Equal(t, "resume", res.Records[2].Action)
+		assert.Equal(t, "pause", res.Records[3].Action)
+	}
+
+	// the donation of others is not found
+	other := createUser("periodic-self-service-other@twreporter.org")
+	defer deleteUser(other)
+	otherAuthorization, otherCookie := helperSetupAuth(other)
+	resp = serveHTTPWithCookies(http.MethodPost, path+"/resume", "", "", otherAuthorization, otherCookie)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+func TestRenderReceiptsWithoutMemberCMS(t *testing.T) {
+	const (
+		donorEmail = "native-receipt-donor@twreporter.org"
+		fontPath   = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+	)
+
+	if _, err := os.Stat(fontPath); err != nil {
+		t.Skipf("font %s is not available: %v", fontPath, err)
+	}
+
+	receiptConf := globals.Conf.Receipt
+	memberCMS := globals.Conf.Features.MemberCMS
+	defer func() {
+		globals.Conf.Receipt = receiptConf
+		globals.Conf.Features.MemberCMS = memberCMS
+	}()
+	globals.Conf.Receipt.FontPath = fontPath
+	globals.Conf.Features.MemberCMS = false
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	mc := cf.GetMembershipController()
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+
+	paidAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	createDonation := func(orderNumber, status string) models.PayByPrimeDonation {
+		d := models.PayByPrimeDonation{
+			Amount:      testAmount,
+			Currency:    testCurrency,
+			Details:     testDetails,
+			MerchantID:  testCreditCardMerchant,
+			OrderNumber: orderNumber,
+			PayMethod:   creditCardPayMethod,
+			Status:      status,
+			UserID:      user.ID,
+		}
+		d.Cardholder.Email = donorEmail
+		d.Cardholder.Name = null.StringFrom(testName)
+		d.Receipt.Header = null.StringFrom("報導者讀者")
+		if status == statusPaid {
+			d.TransactionTime = null.TimeFrom(paidAt)
+		}
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+		return d
+	}
+
+	paid := createDonation("native-receipt-paid", statusPaid)
+	paying := createDonation("native-receipt-paying", statusPaying)
+
+	get := func(handler gin.HandlerFunc, path string, params gin.Params) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		c.Request = req.WithContext(context.WithValue(req.Context(), globals.AuthUserIDProperty, user.ID))
+		c.Params = params
+		handler(c)
+		return w
+	}
+	getPrimeReceipt := func(orderNumber string) *httptest.ResponseRecorder {
+		return get(mc.GetPrimeDonationReceipt, "/v1/donations/prime/receipt?order="+orderNumber, nil)
+	}
+
+	resp := getPrimeReceipt(paid.OrderNumber)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/pdf", resp.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(resp.Body.Bytes(), []byte("%PDF-")))
+
+	// the donation is numbered from the receipt serial numbers once
+	var d models.PayByPrimeDonation
+	Globs.GormDB.Where("id = ?", paid.ID).First(&d)
+	assert.True(t, d.ReceiptNumber.Valid)
+
+	// the same receipt is rendered on the later downloads
+	assert.Equal(t, resp.Body.Bytes(), getPrimeReceipt(paid.OrderNumber).Body.Bytes())
+
+	resp = getPrimeReceipt(paying.OrderNumber)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	year := strconv.Itoa(paidAt.Year())
+	resp = get(mc.GetYearlyDonationReceipt, fmt.Sprintf("/v1/donations/receipt/%s?email=%s", year, donorEmail), gin.Params{{Key: "year", Value: year}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/pdf", resp.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(resp.Body.Bytes(), []byte("%PDF-")))
+}
+
+func TestRenderReceiptsOfPartiallyRefundedDonation(t *testing.T) {
+	const (
+		donorEmail = "refunded-receipt-donor@twreporter.org"
+		fontPath   = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+	)
+
+	user := createUser(donorEmail)
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.PayByPrimeDonation{})
+
+	paidAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	d := models.PayByPrimeDonation{
+		Amount:        100,
+		Currency:      "USD",
+		Details:       testDetails,
+		MerchantID:    testCreditCardMerchant,
+		OrderNumber:   "refunded-receipt-paid",
+		PayMethod:     creditCardPayMethod,
+		Status:        statusPaid,
+		UserID:        user.ID,
+		TWDEquivalent: models.TWDEquivalent{TWDAmount: null.IntFrom(3150), ExchangeRate: null.FloatFrom(31.5)},
+	}
+	d.Cardholder.Email = donorEmail
+	d.Cardholder.Name = null.StringFrom(testName)
+	d.TransactionTime = null.TimeFrom(paidAt)
+	assert.Nil(t, Globs.GormDB.Create(&d).Error)
+	defer Globs.GormDB.Where("order_number = ?", d.OrderNumber).Delete(&models.DonationRefund{})
+	defer Globs.GormDB.Where("donation_type = 'prime' AND donation_id = ?", d.ID).Delete(&models.ReceiptNumberLog{})
+
+	// only the completed refunds are deducted from the receipt
+	for status, amount := range map[string]uint{"refunded": 20, "fail": 30} {
+		r := models.DonationRefund{
+			DonationType: globals.PrimeDonationType,
+			DonationID:   d.ID,
+			OrderNumber:  d.OrderNumber,
+			Amount:       amount,
+			Currency:     d.Currency,
+			Status:       status,
+			OperatorID:   user.ID,
+		}
+		assert.Nil(t, Globs.GormDB.Create(&r).Error)
+	}
+
+	as := storage.NewGormStorage(Globs.GormDB)
+	items, err := as.GetReceiptItemsOfAUser(user.ID, paidAt.Add(-time.Minute), paidAt.Add(time.Minute))
+	assert.Nil(t, err)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, uint(100), items[0].Amount)
+		assert.Equal(t, uint(20), items[0].RefundedAmount)
+	}
+
+	if _, err := os.Stat(fontPath); err != nil {
+		t.Skipf("font %s is not available: %v", fontPath, err)
+	}
+
+	receiptConf := globals.Conf.Receipt
+	memberCMS := globals.Conf.Features.MemberCMS
+	defer func() {
+		globals.Conf.Receipt = receiptConf
+		globals.Conf.Features.MemberCMS = memberCMS
+	}()
+	globals.Conf.Receipt.FontPath = fontPath
+	globals.Conf.Features.MemberCMS = false
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	mc := cf.GetMembershipController()
+
+	get := func(handler gin.HandlerFunc, path string, params gin.Params) []byte {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		c.Request = req.WithContext(context.WithValue(req.Context(), globals.AuthUserIDProperty, user.ID))
+		c.Params = params
+		handler(c)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Body.Bytes()
+	}
+	year := strconv.Itoa(paidAt.Year())
+	render := func() ([]byte, []byte) {
+		return get(mc.GetPrimeDonationReceipt, "/v1/donations/prime/receipt?order="+d.OrderNumber, nil),
+			get(mc.GetYearlyDonationReceipt, fmt.Sprintf("/v1/donations/receipt/%s?email=%s", year, donorEmail), gin.Params{{Key: "year", Value: year}})
+	}
+
+	refundedPrime, refundedYearly := render()
+
+	// the receipts are the same as the ones of the donation of the amount left, 80 USD in 2520 TWD
+	Globs.GormDB.Where("order_number = ?", d.OrderNumber).Delete(&models.DonationRefund{})
+	assert.Nil(t, Globs.GormDB.Model(&models.PayByPrimeDonation{}).Where("id = ?", d.ID).
+		UpdateColumns(map[string]interface{}{"amount": 80, "twd_amount": 2520}).Error)
+
+	netPrime, netYearly := render()
+	assert.Equal(t, netPrime, refundedPrime)
+	assert.Equal(t, netYearly, refundedYearly)
+}
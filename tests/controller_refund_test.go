@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestRefundADonation(t *testing.T) {
+	var refundReqs []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		refundReqs = append(refundReqs, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"status":0,"msg":"Success","refund_id":"R2026010100%d","refund_amount":%v}`, len(refundReqs), body["amount"])))
+	}))
+	defer server.Close()
+
+	donationConf := globals.Conf.Donation
+	defer func() { globals.Conf.Donation = donationConf }()
+	globals.Conf.Donation.TapPayRefundURL = server.URL
+
+	donor := createUser("refund-donor@twreporter.org")
+	defer deleteUser(donor)
+	admin := createUser("refund-admin@twreporter.org")
+	defer deleteUser(admin)
+	Globs.GormDB.Model(&admin).UpdateColumn("privilege", constants.PrivilegeAdmin)
+
+	d := models.PayByPrimeDonation{
+		Amount:        500,
+		Currency:      "TWD",
+		Details:       "一般線上單筆捐款",
+		MerchantID:    "twreporter_CTBC",
+		OrderNumber:   "refund-prime-order",
+		PayMethod:     "credit_card",
+		ReceiptNumber: null.StringFrom("A202601-00001"),
+		Status:        "paid",
+		UserID:        donor.ID,
+	}
+	d.RecTradeID = "D20260101refund"
+	d.TransactionTime = null.TimeFrom(time.Now())
+	d.Cardholder.Email = "refund-donor@twreporter.org"
+	assert.Nil(t, Globs.GormDB.Create(&d).Error)
+	defer Globs.GormDB.Unscoped().Delete(&d)
+	defer Globs.GormDB.Where("order_number = ?", d.OrderNumber).Delete(&models.DonationRefund{})
+	defer Globs.GormDB.Where("donation_type = 'prime' AND donation_id = ?", d.ID).Delete(&models.ReceiptNumberLog{})
+
+	path := fmt.Sprintf("/v1/admin/donations/prime/orders/%s/refunds", d.OrderNumber)
+	reload := func() models.PayByPrimeDonation {
+		var pd models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", d.ID).First(&pd)
+		return pd
+	}
+
+	// only admins could refund
+	resp := serveHTTP(http.MethodPost, path, `{"amount":100,"reason":"重複捐款"}`, "application/json", fmt.Sprintf("Bearer %s", generateIDToken(donor)))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(admin))
+
+	resp = serveHTTP(http.MethodPost, path, `{"amount":100}`, "application/json", authorization)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// partial refund reissues the receipt
+	resp = serveHTTP(http.MethodPost, path, `{"amount":100,"reason":"重複捐款"}`, "application/json", authorization)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var res struct {
+		Data models.DonationRefund `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &res)
+	assert.Equal(t, uint(100), res.Data.Amount)
+	assert.Equal(t, "refunded", res.Data.Status)
+	assert.Equal(t, admin.ID, res.Data.OperatorID)
+	assert.Equal(t, "A202601-00001", res.Data.VoidedReceiptNumber.ValueOrZero())
+	assert.True(t, res.Data.ReissuedReceiptNumber.Valid)
+	assert.Equal(t, "D20260101refund", refundReqs[0]["rec_trade_id"])
+
+	pd := reload()
+	assert.Equal(t, "paid", pd.Status)
+	assert.Equal(t, res.Data.ReissuedReceiptNumber.ValueOrZero(), pd.ReceiptNumber.ValueOrZero())
+
+	resp = serveHTTP(http.MethodPost, path, `{"amount":401,"reason":"退款"}`, "application/json", authorization)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// the rest is refunded without amount, and the receipt is voided
+	resp = serveHTTP(http.MethodPost, path, `{"reason":"退款"}`, "application/json", authorization)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &res)
+	assert.Equal(t, uint(400), res.Data.Amount)
+	assert.False(t, res.Data.ReissuedReceiptNumber.Valid)
+
+	pd = reload()
+	assert.Equal(t, "refunded", pd.Status)
+	assert.False(t, pd.ReceiptNumber.Valid)
+
+	// both the voided numbers are kept in the audit trail
+	var voids int
+	Globs.GormDB.Model(&models.ReceiptNumberLog{}).Where("donation_type = 'prime' AND donation_id = ? AND action = 'void'", d.ID).Count(&voids)
+	assert.Equal(t, 2, voids)
+
+	resp = serveHTTP(http.MethodPost, path, `{"reason":"退款"}`, "application/json", authorization)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.Equal(t, 2, len(refundReqs))
+}
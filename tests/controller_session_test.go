@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+	"github.com/twreporter/go-api/utils"
+)
+
+func TestGetAndRevokeSessions(t *testing.T) {
+	const jti = "session-test-jti"
+	as := storage.NewGormStorage(Globs.GormDB)
+	user := createUser("session@twreporter.org")
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.RefreshToken{})
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.RevokedToken{})
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.UserSession{})
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(user))
+	path := fmt.Sprintf("/v2/users/%d/sessions", user.ID)
+
+	now := time.Now()
+	session := models.UserSession{
+		UserID:     user.ID,
+		JTI:        jti,
+		UserAgent:  "session-test-agent",
+		IP:         "203.0.113.1",
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	assert.Nil(t, as.CreateUserSession(&session))
+
+	idToken, _ := utils.RetrieveV2IDToken(user.ID, user.Email.ValueOrZero(), user.FirstName.ValueOrZero(), user.LastName.ValueOrZero(), 3600, jti)
+	cookie := http.Cookie{Name: "id_token", Value: idToken}
+
+	resp := serveHTTPWithCookies(http.MethodPost, "/v2/auth/token", "", "", "", cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var res struct {
+		Data []models.UserSession `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &res)
+	assert.Equal(t, 1, len(res.Data))
+	assert.Equal(t, session.ID, res.Data[0].ID)
+	assert.Equal(t, "session-test-agent", res.Data[0].UserAgent)
+
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("%s/%d", path, session.ID), "", "", authorization)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	// the id token of the revoked session is rejected
+	resp = serveHTTPWithCookies(http.MethodPost, "/v2/auth/token", "", "", "", cookie)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// the refresh tokens dispatched for the session are revoked
+	var count int
+	Globs.GormDB.Model(&models.RefreshToken{}).Where("session_jti = ? AND revoked_at IS NULL", jti).Count(&count)
+	assert.Equal(t, 0, count)
+
+	resp = serveHTTP(http.MethodGet, path, "", "", authorization)
+	json.Unmarshal(resp.Body.Bytes(), &res)
+	assert.Equal(t, 0, len(res.Data))
+
+	resp = serveHTTP(http.MethodDelete, fmt.Sprintf("%s/%d", path, session.ID), "", "", authorization)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
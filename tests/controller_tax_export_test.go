@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/configs/constants"
+	"github.com/twreporter/go-api/globals"
+	"github.com/twreporter/go-api/models"
+)
+
+func TestExportTaxDeductions(t *testing.T) {
+	const (
+		donorEmail  = "tax-export-donor@twreporter.org"
+		issuerTaxID = "12345678"
+	)
+
+	receiptConf := globals.Conf.Receipt
+	defer func() { globals.Conf.Receipt = receiptConf }()
+	globals.Conf.Receipt.IssuerTaxID = issuerTaxID
+
+	donor := createUser(donorEmail)
+	defer deleteUser(donor)
+	defer Globs.GormDB.Where("user_id = ?", donor.ID).Delete(&models.PayByPrimeDonation{})
+	admin := createUser("tax-export-admin@twreporter.org")
+	defer deleteUser(admin)
+	Globs.GormDB.Model(&admin).UpdateColumn("privilege", constants.PrivilegeAdmin)
+
+	paidAt := time.Now().Add(-time.Hour)
+	createDonation := func(orderNumber, nationalID string) {
+		d := models.PayByPrimeDonation{
+			Amount:           testAmount,
+			AutoTaxDeduction: null.BoolFrom(true),
+			Currency:         testCurrency,
+			Details:          testDetails,
+			MerchantID:       testCreditCardMerchant,
+			OrderNumber:      orderNumber,
+			PayMethod:        creditCardPayMethod,
+			Status:           statusPaid,
+			UserID:           donor.ID,
+		}
+		d.TransactionTime = null.TimeFrom(paidAt)
+		d.Cardholder.Email = donorEmail
+		d.Cardholder.Name = null.StringFrom(testName)
+		d.Cardholder.NationalID = null.StringFrom(nationalID)
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+	}
+	createDonation("tax-export-valid-1", "A123456789")
+	createDonation("tax-export-valid-2", "a123456789")
+	createDonation("tax-export-invalid", "A123456788")
+
+	path := fmt.Sprintf("/v1/admin/donations/tax-deductions/%d", paidAt.Year())
+
+	// only admins could export
+	resp := serveHTTP(http.MethodGet, path, "", "", fmt.Sprintf("Bearer %s", generateIDToken(donor)))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	authorization := fmt.Sprintf("Bearer %s", generateIDToken(admin))
+
+	resp = serveHTTP(http.MethodGet, path+"?format=xml", "", "", authorization)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = serveHTTP(http.MethodGet, fmt.Sprintf("/v1/admin/donations/tax-deductions/%d", time.Now().Year()+1), "", "", authorization)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// the donations of the donor are aggregated by the national id
+	resp = serveHTTP(http.MethodGet, path, "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), fmt.Sprintf("%03d%s%s%012d\r\n", paidAt.Year()-1911, issuerTaxID, "A123456789", testAmount*2))
+
+	resp = serveHTTP(http.MethodGet, path+"?format=csv", "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), fmt.Sprintf("A123456789,%s,%d,2", testName, testAmount*2))
+
+	resp = serveHTTP(http.MethodGet, path+"/exclusions", "", "", authorization)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), fmt.Sprintf("prime,tax-export-invalid,%d,national id fails the check digit", donor.ID))
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/twreporter/go-api/controllers"
+	"github.com/twreporter/go-api/models"
+)
+
+type tokenResBody struct {
+	Status string `json:"status"`
+	Data   struct {
+		JWT          string `json:"jwt"`
+		RefreshToken string `json:"refresh_token"`
+	} `json:"data"`
+}
+
+func dispatchTokens(t *testing.T, idToken string) (int, tokenResBody) {
+	var resBody tokenResBody
+
+	cookie := http.Cookie{
+		HttpOnly: true,
+		MaxAge:   3600,
+		Name:     "id_token",
+		Secure:   false,
+		Value:    idToken,
+	}
+	resp := serveHTTPWithCookies(http.MethodPost, "/v2/auth/token", "", "", "", cookie)
+	resBodyInBytes, _ := ioutil.ReadAll(resp.Result().Body)
+	json.Unmarshal(resBodyInBytes, &resBody)
+
+	return resp.Code, resBody
+}
+
+func refreshTokens(refreshToken string) (int, tokenResBody) {
+	var resBody tokenResBody
+
+	resp := serveHTTP(http.MethodPost, "/v2/auth/token/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, refreshToken), "application/json", "")
+	resBodyInBytes, _ := ioutil.ReadAll(resp.Result().Body)
+	json.Unmarshal(resBodyInBytes, &resBody)
+
+	return resp.Code, resBody
+}
+
+func TestTokenRefreshRotation(t *testing.T) {
+	user := createUser("token-rotation@twreporter.org")
+
+	code, dispatched := dispatchTokens(t, generateIDToken(user))
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, dispatched.Data.JWT)
+	assert.NotEmpty(t, dispatched.Data.RefreshToken)
+
+	// exchange the refresh token
+	code, refreshed := refreshTokens(dispatched.Data.RefreshToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEqual(t, dispatched.Data.RefreshToken, refreshed.Data.RefreshToken)
+
+	userPath := fmt.Sprintf("/v2/users/%d", user.ID)
+	resp := serveHTTP(http.MethodGet, userPath, "", "", fmt.Sprintf("Bearer %s", refreshed.Data.JWT))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// reuse the exchanged refresh token
+	code, _ = refreshTokens(dispatched.Data.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// the whole family is revoked
+	code, _ = refreshTokens(refreshed.Data.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	resp = serveHTTP(http.MethodGet, userPath, "", "", fmt.Sprintf("Bearer %s", refreshed.Data.JWT))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// unknown refresh token
+	code, _ = refreshTokens("unknown")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTokenRevokeAll(t *testing.T) {
+	user := createUser("token-revoke-all@twreporter.org")
+	idToken := generateIDToken(user)
+
+	_, first := dispatchTokens(t, idToken)
+	_, second := dispatchTokens(t, idToken)
+
+	resp := serveHTTP(http.MethodPost, "/v2/auth/logout-all", "", "", fmt.Sprintf("Bearer %s", first.Data.JWT))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// access tokens of all the devices are revoked
+	userPath := fmt.Sprintf("/v2/users/%d", user.ID)
+	for _, accessToken := range []string{first.Data.JWT, second.Data.JWT} {
+		resp = serveHTTP(http.MethodGet, userPath, "", "", fmt.Sprintf("Bearer %s", accessToken))
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	// refresh tokens of all the devices are revoked
+	for _, refreshToken := range []string{first.Data.RefreshToken, second.Data.RefreshToken} {
+		code, _ := refreshTokens(refreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	// id token issued before could not dispatch tokens
+	code, _ := dispatchTokens(t, idToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTokenPurgeJob(t *testing.T) {
+	user := createUser("token-purge@twreporter.org")
+	defer deleteUser(user)
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.RefreshToken{})
+	defer Globs.GormDB.Where("user_id = ?", user.ID).Delete(&models.RevokedToken{})
+
+	now := time.Now()
+	for i, expiresAt := range []time.Time{now.Add(-time.Hour), now.Add(time.Hour)} {
+		assert.Nil(t, Globs.GormDB.Create(&models.RefreshToken{
+			UserID:    user.ID,
+			FamilyID:  "token-purge",
+			TokenHash: fmt.Sprintf("token-purge-%d", i),
+			ExpiresAt: expiresAt,
+		}).Error)
+		assert.Nil(t, Globs.GormDB.Create(&models.RevokedToken{
+			JTI:       fmt.Sprintf("token-purge-%d", i),
+			UserID:    user.ID,
+			ExpiresAt: expiresAt,
+		}).Error)
+	}
+
+	cf := controllers.NewControllerFactory(Globs.GormDB, Globs.MgoDB, mockMailStrategy{}, testMongoClient, mockIndexSearcher{})
+	assert.Nil(t, cf.GetMembershipController().TokenPurgeJob().Run(context.Background()))
+
+	// only the unexpired tokens are left
+	var rts []models.RefreshToken
+	Globs.GormDB.Where("user_id = ?", user.ID).Find(&rts)
+	if assert.Equal(t, 1, len(rts)) {
+		assert.Equal(t, "token-purge-1", rts[0].TokenHash)
+	}
+
+	var jtis []models.RevokedToken
+	Globs.GormDB.Where("user_id = ?", user.ID).Find(&jtis)
+	if assert.Equal(t, 1, len(jtis)) {
+		assert.Equal(t, "token-purge-1", jtis[0].JTI)
+	}
+}
@@ -53,7 +53,7 @@ func requestWithBody(method, path, body string) (req *http.Request) {
 }
 
 func generateIDToken(user models.User) (jwt string) {
-	jwt, _ = utils.RetrieveV2IDToken(user.ID, user.Email.ValueOrZero(), user.FirstName.ValueOrZero(), user.LastName.ValueOrZero(), 3600)
+	jwt, _ = utils.RetrieveV2IDToken(user.ID, user.Email.ValueOrZero(), user.FirstName.ValueOrZero(), user.LastName.ValueOrZero(), 3600, "")
 	return
 }
 
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/twreporter/go-api/models"
+	"github.com/twreporter/go-api/storage"
+)
+
+const testReceiptPrefix = "TST"
+
+// createNumberedDonations creates the paid prime donations transacted in January 2001 (Asia/Taipei),
+// the month not taken by the other tests, along with the cleanup of them and the series of testReceiptPrefix
+func createNumberedDonations(t *testing.T, user models.User, n int) ([]models.PayByPrimeDonation, func()) {
+	transactionTime := time.Date(2001, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	Globs.GormDB.Where("prefix = ?", testReceiptPrefix).Delete(&models.ReceiptSerialNumber{})
+
+	donations := make([]models.PayByPrimeDonation, n)
+	ids := make([]uint, n)
+	for i := range donations {
+		d := models.PayByPrimeDonation{
+			Amount:      testAmount,
+			Currency:    testCurrency,
+			Details:     testDetails,
+			MerchantID:  testCreditCardMerchant,
+			OrderNumber: fmt.Sprintf("receipt-number-order-%d", i),
+			PayMethod:   creditCardPayMethod,
+			Status:      statusPaid,
+			UserID:      user.ID,
+		}
+		d.TransactionTime = null.TimeFrom(transactionTime)
+		d.Cardholder.Email = user.Email.ValueOrZero()
+		assert.Nil(t, Globs.GormDB.Create(&d).Error)
+		donations[i] = d
+		ids[i] = d.ID
+	}
+
+	return donations, func() {
+		Globs.GormDB.Where("donation_type = 'prime' AND donation_id IN (?)", ids).Delete(&models.ReceiptNumberLog{})
+		Globs.GormDB.Unscoped().Where("id IN (?)", ids).Delete(&models.PayByPrimeDonation{})
+		Globs.GormDB.Where("prefix = ?", testReceiptPrefix).Delete(&models.ReceiptSerialNumber{})
+	}
+}
+
+func TestIssueReceiptNumberConcurrently(t *testing.T) {
+	const n = 20
+	s := storage.NewGormStorage(Globs.GormDB)
+	user := createUser("receipt-number-concurrent@twreporter.org")
+	defer deleteUser(user)
+	donations, cleanup := createNumberedDonations(t, user, n)
+	defer cleanup()
+
+	// every donation is numbered twice at the same time
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	issued := make(map[uint][]string)
+	for i := 0; i < 2; i++ {
+		for _, d := range donations {
+			wg.Add(1)
+			go func(id uint) {
+				defer wg.Done()
+				receiptNumber, err := s.IssueReceiptNumber("prime", id, testReceiptPrefix)
+				assert.Nil(t, err)
+				mu.Lock()
+				issued[id] = append(issued[id], receiptNumber)
+				mu.Unlock()
+			}(d.ID)
+		}
+	}
+	wg.Wait()
+
+	// each donation gets a single number, and the numbers run from 1 to n without duplicates or gaps
+	var numbers []string
+	for _, d := range donations {
+		assert.Equal(t, 2, len(issued[d.ID]))
+		assert.Equal(t, issued[d.ID][0], issued[d.ID][1])
+		numbers = append(numbers, issued[d.ID][0])
+
+		var pd models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", d.ID).First(&pd)
+		assert.Equal(t, issued[d.ID][0], pd.ReceiptNumber.ValueOrZero())
+	}
+	sort.Strings(numbers)
+	for i, number := range numbers {
+		assert.Equal(t, fmt.Sprintf("%s200101-%05d", testReceiptPrefix, i+1), number)
+	}
+
+	var serialNumber models.ReceiptSerialNumber
+	Globs.GormDB.Where("prefix = ? AND YYYYMM = ?", testReceiptPrefix, "200101").First(&serialNumber)
+	assert.Equal(t, n, serialNumber.SerialNumber)
+
+	var logs int
+	Globs.GormDB.Model(&models.ReceiptNumberLog{}).Where("receipt_number LIKE ? AND action = 'issue'", testReceiptPrefix+"%").Count(&logs)
+	assert.Equal(t, n, logs)
+}
+
+func TestReissueReceiptNumberConcurrently(t *testing.T) {
+	const n = 10
+	s := storage.NewGormStorage(Globs.GormDB)
+	user := createUser("receipt-number-reissue@twreporter.org")
+	defer deleteUser(user)
+	donations, cleanup := createNumberedDonations(t, user, n)
+	defer cleanup()
+
+	for _, d := range donations {
+		_, err := s.IssueReceiptNumber("prime", d.ID, testReceiptPrefix)
+		assert.Nil(t, err)
+	}
+
+	// the numbers are reissued while the donations are voided at the same time
+	var wg sync.WaitGroup
+	for i, d := range donations {
+		wg.Add(1)
+		go func(i int, id uint) {
+			defer wg.Done()
+			if i%2 == 0 {
+				voided, reissued, err := s.ReissueReceiptNumber("prime", id, testReceiptPrefix, null.StringFrom("部分退款"), null.IntFrom(int64(user.ID)))
+				assert.Nil(t, err)
+				assert.NotEmpty(t, voided)
+				assert.NotEmpty(t, reissued)
+			} else {
+				voided, err := s.VoidReceiptNumber("prime", id, null.StringFrom("全額退款"), null.IntFrom(int64(user.ID)))
+				assert.Nil(t, err)
+				assert.NotEmpty(t, voided)
+			}
+		}(i, d.ID)
+	}
+	wg.Wait()
+
+	// the reissued numbers follow the issued ones
+	var reissued []string
+	for i, d := range donations {
+		var pd models.PayByPrimeDonation
+		Globs.GormDB.Where("id = ?", d.ID).First(&pd)
+		if i%2 == 0 {
+			reissued = append(reissued, pd.ReceiptNumber.ValueOrZero())
+		} else {
+			assert.False(t, pd.ReceiptNumber.Valid)
+		}
+
+		var logs []models.ReceiptNumberLog
+		Globs.GormDB.Where("donation_type = 'prime' AND donation_id = ?", d.ID).Order("id").Find(&logs)
+		if i%2 == 0 {
+			assert.Equal(t, 3, len(logs))
+		} else {
+			assert.Equal(t, 2, len(logs))
+		}
+		assert.Equal(t, "issue", logs[0].Action)
+		assert.Equal(t, "void", logs[1].Action)
+		assert.Equal(t, logs[0].ReceiptNumber, logs[1].ReceiptNumber)
+		assert.Equal(t, int64(user.ID), logs[1].OperatorID.ValueOrZero())
+	}
+	sort.Strings(reissued)
+	for i, number := range reissued {
+		assert.Equal(t, fmt.Sprintf("%s200101-%05d", testReceiptPrefix, n+i+1), number)
+	}
+
+	// the voided donation is not voided twice
+	voided, err := s.VoidReceiptNumber("prime", donations[1].ID, null.String{}, null.Int{})
+	assert.Nil(t, err)
+	assert.Empty(t, voided)
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+// SigningKey is an asymmetric key identified by kid.
+// Retired keys could be configured with the public key only,
+// they are kept to verify the tokens issued before the rotation.
+type SigningKey struct {
+	ID         string
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// KeySet holds the keys to sign and to verify JWTs.
+// For HS256, tokens are signed by the shared secret without kid header.
+// For RS256 and ES256, tokens are signed by the first key, and verified by the key matching kid header.
+type KeySet struct {
+	method      jwt.SigningMethod
+	keys        []SigningKey
+	acceptHS256 bool
+}
+
+// JSONWebKey is the public part of a SigningKey described in RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var (
+	keySetOnce sync.Once
+	keySet     *KeySet
+	keySetErr  error
+)
+
+// GetKeySet returns the key set loaded from the app config
+func GetKeySet() (*KeySet, error) {
+	keySetOnce.Do(func() {
+		app := globals.Conf.App
+		keySet, keySetErr = NewKeySet(app.JwtSigningMethod, app.JwtSigningKeys, app.JwtAcceptHS256)
+	})
+	return keySet, keySetErr
+}
+
+// NewKeySet loads the keys of the signing method.
+// Each entry is in the form of `kid:path/to/key.pem`, and the first entry must hold a private key.
+func NewKeySet(method string, entries []string, acceptHS256 bool) (*KeySet, error) {
+	ks := &KeySet{acceptHS256: acceptHS256}
+
+	switch method {
+	case "", jwt.SigningMethodHS256.Alg():
+		ks.method = jwt.SigningMethodHS256
+		return ks, nil
+	case jwt.SigningMethodRS256.Alg():
+		ks.method = jwt.SigningMethodRS256
+	case jwt.SigningMethodES256.Alg():
+		ks.method = jwt.SigningMethodES256
+	default:
+		return nil, errors.Errorf("unsupported jwt signing method: %s", method)
+	}
+
+	for _, entry := range entries {
+		s := strings.SplitN(entry, ":", 2)
+		if len(s) != 2 || s[0] == "" || s[1] == "" {
+			return nil, errors.Errorf("jwt signing key should be in the form of `kid:path`, but got: %s", entry)
+		}
+
+		pem, err := ioutil.ReadFile(s[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("can not read jwt signing key(kid: %s)", s[0]))
+		}
+
+		key, err := ks.parseKey(s[0], pem)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys = append(ks.keys, key)
+	}
+
+	if len(ks.keys) == 0 || ks.keys[0].PrivateKey == nil {
+		return nil, errors.Errorf("the first jwt signing key of %s should hold a private key", method)
+	}
+
+	return ks, nil
+}
+
+func (ks *KeySet) parseKey(kid string, pem []byte) (SigningKey, error) {
+	key := SigningKey{ID: kid}
+
+	switch ks.method {
+	case jwt.SigningMethodRS256:
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+			key.PrivateKey, key.PublicKey = private, &private.PublicKey
+		} else if public, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			key.PublicKey = public
+		} else {
+			return key, errors.Wrap(err, fmt.Sprintf("invalid RSA key(kid: %s)", kid))
+		}
+	case jwt.SigningMethodES256:
+		var public *ecdsa.PublicKey
+		if private, err := jwt.ParseECPrivateKeyFromPEM(pem); err == nil {
+			key.PrivateKey, public = private, &private.PublicKey
+		} else if public, err = jwt.ParseECPublicKeyFromPEM(pem); err != nil {
+			return key, errors.Wrap(err, fmt.Sprintf("invalid EC key(kid: %s)", kid))
+		}
+		if public.Curve != elliptic.P256() {
+			return key, errors.Errorf("ES256 key(kid: %s) should be on P-256 curve", kid)
+		}
+		key.PublicKey = public
+	}
+
+	return key, nil
+}
+
+// Method returns the signing method of the key set
+func (ks *KeySet) Method() jwt.SigningMethod {
+	return ks.method
+}
+
+// Sign signs the claims with the current key.
+// secret is only used for HS256.
+func (ks *KeySet) Sign(claims jwt.Claims, secret string) (string, error) {
+	if ks.method == jwt.SigningMethodHS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	}
+
+	key := ks.keys[0]
+	token := jwt.NewWithClaims(ks.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// VerificationKey returns the key to verify the token.
+// The algorithm is checked against the key set to prevent from algorithm confusion.
+// secret is only used for HS256 tokens.
+func (ks *KeySet) VerificationKey(token *jwt.Token, secret string) (interface{}, error) {
+	alg := token.Method.Alg()
+
+	if alg == jwt.SigningMethodHS256.Alg() {
+		if ks.method == jwt.SigningMethodHS256 || ks.acceptHS256 {
+			return []byte(secret), nil
+		}
+		return nil, errors.New("HS256 tokens are no longer accepted")
+	}
+
+	if alg != ks.method.Alg() {
+		return nil, errors.Errorf("unexpected signing method: %s", alg)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key.PublicKey, nil
+		}
+	}
+
+	return nil, errors.Errorf("unknown kid: %s", kid)
+}
+
+// JSONWebKeys returns the public keys for the JWKS endpoint.
+// No key is exposed for HS256.
+func (ks *KeySet) JSONWebKeys() []JSONWebKey {
+	jwks := make([]JSONWebKey, 0, len(ks.keys))
+
+	for _, key := range ks.keys {
+		jwk := JSONWebKey{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: ks.method.Alg(),
+		}
+
+		switch public := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(public.X.Bytes(), size))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(public.Y.Bytes(), size))
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+// padBytes left pads b with zeros to size
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
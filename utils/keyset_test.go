@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testSecret = "secret_token"
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testClaims() jwt.StandardClaims {
+	return jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Subject:   AccessTokenSubject,
+	}
+}
+
+func parse(ks *KeySet, tokenString string) error {
+	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return ks.VerificationKey(token, testSecret)
+	})
+	return err
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	oldPrivate := writePEM(t, dir, "old.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(oldKey))
+	newPrivate := writePEM(t, dir, "new.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(newKey))
+	oldPublicDER, _ := x509.MarshalPKIXPublicKey(&oldKey.PublicKey)
+	oldPublic := writePEM(t, dir, "old.pub.pem", "PUBLIC KEY", oldPublicDER)
+
+	before, err := NewKeySet("RS256", []string{"old:" + oldPrivate}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(testClaims(), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate to the new key, and keep the public part of the old key
+	after, err := NewKeySet("RS256", []string{"new:" + newPrivate, "old:" + oldPublic}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, _ := after.Sign(testClaims(), testSecret)
+
+	if err := parse(after, oldToken); err != nil {
+		t.Errorf("token signed by the retired key should be verified: %v", err)
+	}
+	if err := parse(after, newToken); err != nil {
+		t.Errorf("token signed by the current key should be verified: %v", err)
+	}
+	if err := parse(before, newToken); err == nil {
+		t.Errorf("token with unknown kid should be rejected")
+	}
+
+	// HS256 tokens signed by the shared secret
+	hsToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte(testSecret))
+	if err := parse(after, hsToken); err == nil {
+		t.Errorf("HS256 token should be rejected")
+	}
+	accepting, _ := NewKeySet("RS256", []string{"new:" + newPrivate}, true)
+	if err := parse(accepting, hsToken); err != nil {
+		t.Errorf("HS256 token should be accepted during migration: %v", err)
+	}
+
+	jwks := after.JSONWebKeys()
+	if len(jwks) != 2 || jwks[0].Kid != "new" || jwks[0].Kty != "RSA" || jwks[0].E != "AQAB" {
+		t.Errorf("unexpected jwks: %+v", jwks)
+	}
+
+	// the current key should be able to sign
+	if _, err := NewKeySet("RS256", []string{"old:" + oldPublic}, false); err == nil {
+		t.Errorf("key set without private signing key should be rejected")
+	}
+}
+
+func TestKeySetES256(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	path := writePEM(t, dir, "ec.pem", "EC PRIVATE KEY", der)
+
+	ks, err := NewKeySet("ES256", []string{"ec:" + path}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := ks.Sign(testClaims(), testSecret)
+	if err := parse(ks, token); err != nil {
+		t.Errorf("ES256 token should be verified: %v", err)
+	}
+
+	jwks := ks.JSONWebKeys()
+	if len(jwks) != 1 || jwks[0].Crv != "P-256" || len(jwks[0].X) != 43 || len(jwks[0].Y) != 43 {
+		t.Errorf("unexpected jwks: %+v", jwks)
+	}
+}
+
+func TestKeySetHS256(t *testing.T) {
+	ks, err := NewKeySet("", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := ks.Sign(testClaims(), testSecret)
+	if err := parse(ks, token); err != nil {
+		t.Errorf("HS256 token should be verified: %v", err)
+	}
+	if len(ks.JSONWebKeys()) != 0 {
+		t.Errorf("no key should be exposed for HS256")
+	}
+
+	// algorithm confusion
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsToken, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims()).SignedString(rsaKey)
+	if err := parse(ks, rsToken); err == nil {
+		t.Errorf("RS256 token should be rejected by HS256 key set")
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+// AllowedOrigins returns the origins allowed by CORS and as the redirect destinations.
+// The sites of the environment are allowed if cors.allow_origins is not configured.
+// Nil is returned for the other environments, in which CORS allows all origins but the redirects are not allowed.
+func AllowedOrigins() []string {
+	if len(globals.Conf.Cors.AllowOrigins) > 0 {
+		return globals.Conf.Cors.AllowOrigins
+	}
+
+	switch globals.Conf.Environment {
+	case globals.StagingEnvironment:
+		return []string{globals.MainSiteStagingOrigin, globals.SupportSiteStagingOrigin, globals.AccountsSiteStagingOrigin}
+	case globals.ProductionEnvironment:
+		return []string{globals.MainSiteOrigin, globals.SupportSiteOrigin, globals.AccountsSiteOrigin}
+	default:
+		return nil
+	}
+}
+
+// IsAllowedRedirectURL reports whether the url is an absolute http(s) url
+// whose origin is exactly one of the allowed origins,
+// so that it is safe to redirect users to.
+func IsAllowedRedirectURL(rawURL string, allowOrigins []string) bool {
+	if rawURL == "" {
+		return false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User != nil || u.Host == "" || u.Opaque != "" {
+		return false
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+
+	// backslashes are treated as slashes by some browsers
+	if strings.Contains(rawURL, "\\") {
+		return false
+	}
+
+	origin := u.Scheme + "://" + strings.ToLower(u.Host)
+	for _, allowed := range allowOrigins {
+		if origin == strings.ToLower(strings.TrimSuffix(allowed, "/")) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+func TestIsAllowedRedirectURL(t *testing.T) {
+	allowOrigins := []string{"https://www.twreporter.org", "https://accounts.twreporter.org/", "http://localhost:3000"}
+
+	cases := map[string]bool{
+		"https://www.twreporter.org/a/some-article?utm=1": true,
+		"https://ACCOUNTS.twreporter.org/settings":        true,
+		"http://localhost:3000":                           true,
+		"":                                                false,
+		"/relative/path":                                  false,
+		"//evil.example.com":                              false,
+		"https://evil.example.com":                        false,
+		"https://www.twreporter.org.evil.example.com":     false,
+		"https://www.twreporter.org@evil.example.com":     false,
+		"http://www.twreporter.org":                       false,
+		"http://localhost:3001":                           false,
+		"javascript:alert(1)":                             false,
+		"https://www.twreporter.org\\@evil.example.com":   false,
+	}
+
+	for rawURL, expected := range cases {
+		if got := IsAllowedRedirectURL(rawURL, allowOrigins); got != expected {
+			t.Errorf("IsAllowedRedirectURL(%q) = %v, expected %v", rawURL, got, expected)
+		}
+	}
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	conf := globals.Conf
+	defer func() { globals.Conf = conf }()
+
+	// the sites of production are allowed without cors.allow_origins
+	globals.Conf.Environment = globals.ProductionEnvironment
+	globals.Conf.Cors.AllowOrigins = nil
+
+	for _, rawURL := range []string{
+		"https://www.twreporter.org/a/some-article",
+		"https://support.twreporter.org/contribute",
+		"https://accounts.twreporter.org/onboarding",
+	} {
+		if !IsAllowedRedirectURL(rawURL, AllowedOrigins()) {
+			t.Errorf("%s should be allowed in production", rawURL)
+		}
+	}
+	if IsAllowedRedirectURL("https://staging.twreporter.org", AllowedOrigins()) {
+		t.Errorf("the site of staging should not be allowed in production")
+	}
+
+	// the configured origins take precedence
+	globals.Conf.Cors.AllowOrigins = []string{"https://preview.twreporter.org"}
+	if IsAllowedRedirectURL("https://www.twreporter.org", AllowedOrigins()) || !IsAllowedRedirectURL("https://preview.twreporter.org/a", AllowedOrigins()) {
+		t.Errorf("cors.allow_origins should take precedence")
+	}
+
+	// no redirects are allowed in development without cors.allow_origins
+	globals.Conf.Environment = globals.DevelopmentEnvironment
+	globals.Conf.Cors.AllowOrigins = nil
+	if origins := AllowedOrigins(); origins != nil {
+		t.Errorf("AllowedOrigins() = %v in development, expected nil", origins)
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/twreporter/go-api/globals"
+)
+
+// developmentSessionKey keeps the local development working without session keys
+const developmentSessionKey = "secret"
+
+// SessionKeyPairs returns the authentication and encryption key pairs of the session cookie from the app config
+func SessionKeyPairs() ([][]byte, error) {
+	return ParseSessionKeyPairs(globals.Conf.App.SessionKeys, globals.Conf.Environment == "development")
+}
+
+// ParseSessionKeyPairs parses the key pairs in the form of `base64(authentication key):base64(encryption key)`.
+// The authentication key should be at least 32 bytes, and the encryption key should be 16, 24 or 32 bytes for AES.
+// The first pair encodes the sessions, and the others are kept to decode the sessions encoded before rotation.
+func ParseSessionKeyPairs(entries []string, development bool) ([][]byte, error) {
+	if len(entries) == 0 {
+		if development {
+			return [][]byte{[]byte(developmentSessionKey), nil}, nil
+		}
+		return nil, errors.New("session keys are not configured")
+	}
+
+	pairs := make([][]byte, 0, len(entries)*2)
+	for i, entry := range entries {
+		s := strings.SplitN(entry, ":", 2)
+		if len(s) != 2 {
+			return nil, errors.Errorf("session key pair #%d should be in the form of `authentication key:encryption key`", i)
+		}
+
+		authKey, err := base64.StdEncoding.DecodeString(s[0])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("session authentication key #%d is not base64 encoded", i))
+		}
+		if len(authKey) < 32 {
+			return nil, errors.Errorf("session authentication key #%d should be at least 32 bytes", i)
+		}
+
+		encryptionKey, err := base64.StdEncoding.DecodeString(s[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("session encryption key #%d is not base64 encoded", i))
+		}
+		switch len(encryptionKey) {
+		case 16, 24, 32:
+		default:
+			return nil, errors.Errorf("session encryption key #%d should be 16, 24 or 32 bytes", i)
+		}
+
+		pairs = append(pairs, authKey, encryptionKey)
+	}
+
+	return pairs, nil
+}
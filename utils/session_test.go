@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseSessionKeyPairs(t *testing.T) {
+	authKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 64)))
+	encryptionKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32)))
+	retiredAuthKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("r", 32)))
+	retiredEncryptionKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 16)))
+
+	pairs, err := ParseSessionKeyPairs([]string{authKey + ":" + encryptionKey, retiredAuthKey + ":" + retiredEncryptionKey}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 4 || string(pairs[0]) != strings.Repeat("a", 64) || string(pairs[3]) != strings.Repeat("s", 16) {
+		t.Errorf("unexpected key pairs: %q", pairs)
+	}
+
+	if _, err := ParseSessionKeyPairs(nil, false); err == nil {
+		t.Errorf("session keys should be required except for development")
+	}
+	if pairs, err := ParseSessionKeyPairs(nil, true); err != nil || len(pairs) != 2 {
+		t.Errorf("development session key should be used: %v", err)
+	}
+
+	invalid := []string{
+		authKey,
+		"not-base64:" + encryptionKey,
+		base64.StdEncoding.EncodeToString([]byte("short")) + ":" + encryptionKey,
+		authKey + ":" + base64.StdEncoding.EncodeToString([]byte("15-bytes-length")),
+	}
+	for _, entry := range invalid {
+		if _, err := ParseSessionKeyPairs([]string{entry}, false); err == nil {
+			t.Errorf("session key pair %s should be rejected", entry)
+		}
+	}
+}
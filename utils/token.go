@@ -21,6 +21,9 @@ const (
 const (
 	IDTokenSubject     = "ID_TOKEN"
 	AccessTokenSubject = "ACCESS_TOKEN"
+	// MailServiceSubject distinguishes the mail service tokens from the user tokens,
+	// since both of them could be signed by the same asymmetric key
+	MailServiceSubject = "MAIL_SERVICE"
 )
 
 // ReporterJWTClaims JWT claims we used
@@ -77,13 +80,16 @@ func (idc IDTokenJWTClaims) Valid() error {
 	return nil
 }
 
-func RetrieveV2IDToken(userID uint, email, firstName, lastName string, expiration int) (string, error) {
+// RetrieveV2IDToken generates the id token identified by jti,
+// so that the session of it could be revoked before it expires
+func RetrieveV2IDToken(userID uint, email, firstName, lastName string, expiration int, jti string) (string, error) {
 	claims := IDTokenJWTClaims{
 		userID,
 		email,
 		firstName,
 		lastName,
 		jwt.StandardClaims{
+			Id:        jti,
 			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: time.Now().Add(time.Second * time.Duration(expiration)).Unix(),
 			Issuer:    globals.Conf.App.JwtIssuer,
@@ -94,13 +100,16 @@ func RetrieveV2IDToken(userID uint, email, firstName, lastName string, expiratio
 	return genToken(claims, globals.Conf.App.JwtSecret)
 }
 
-func RetrieveV2AccessToken(userID uint, email string, roles []map[string]interface{}, activated *time.Time, expiration int) (string, error) {
+// RetrieveV2AccessToken generates the access token identified by jti,
+// so that it could be revoked before it expires
+func RetrieveV2AccessToken(userID uint, email string, roles []map[string]interface{}, activated *time.Time, expiration int, jti string) (string, error) {
 	claims := AccessTokenJWTClaims{
 		userID,
 		email,
 		roles,
 		activated,
 		jwt.StandardClaims{
+			Id:        jti,
 			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: time.Now().Add(time.Second * time.Duration(expiration)).Unix(),
 			Issuer:    globals.Conf.App.JwtIssuer,
@@ -119,24 +128,36 @@ func RetrieveMailServiceAccessToken(expiration int) (string, error) {
 		ExpiresAt: time.Now().Add(time.Second * time.Duration(expiration)).Unix(),
 		Issuer:    globals.Conf.App.JwtIssuer,
 		Audience:  globals.Conf.App.JwtAudience,
-		Subject:   AccessTokenSubject,
+		Subject:   MailServiceSubject,
 	}
 
 	return genToken(claims, secret)
 }
 
+// GetVerificationKey returns the key to verify the token by the configured key set.
+// secret is used if the token is signed by HS256.
+func GetVerificationKey(token *jwt.Token, secret string) (interface{}, error) {
+	ks, err := GetKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	return ks.VerificationKey(token, secret)
+}
+
 // genToken - generate jwt token according to user's info
+// secret is used if the configured signing method is HS256
 func genToken(claims jwt.Claims, secret string) (string, error) {
-	const errorWhere = "RetrieveToken"
 	var err error
-	var token *jwt.Token
+	var ks *KeySet
 	var tokenString string
 
-	// create the token
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if ks, err = GetKeySet(); err != nil {
+		return "", errors.Wrap(err, "internal server error: fail to load signing keys")
+	}
 
-	/* Sign the token with our secret */
-	tokenString, err = token.SignedString([]byte(secret))
+	/* Sign the token with our secret or the current signing key */
+	tokenString, err = ks.Sign(claims, secret)
 
 	if err != nil {
 		return "", errors.Wrap(err, "internal server error: fail to generate token")
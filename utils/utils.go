@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"math/big"
 	"reflect"
 	"runtime"
 	"strings"
@@ -44,6 +48,26 @@ func GenerateEncryptedPassword(password []byte) (string, error) {
 	return fmt.Sprintf("%x", key), errors.WithStack(err)
 }
 
+// GenerateOTPCode returns a securely generated numeric code with n digits.
+func GenerateOTPCode(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	code, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return fmt.Sprintf("%0*d", n, code), nil
+}
+
+// HashOTPCode returns the hex encoded HMAC-SHA256 of the code
+// issued to the email, keyed by the encrypt salt.
+// Binding the email prevents the hash from being replayed on other accounts.
+func HashOTPCode(email string, code string) string {
+	mac := hmac.New(sha256.New, []byte(globals.Conf.Encrypt.Salt))
+	mac.Write([]byte(strings.ToLower(email) + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // GetProjectRoot returns absolute path of current project root.
 func GetProjectRoot() string {
 	type emptyStruct struct{}